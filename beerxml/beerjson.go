@@ -0,0 +1,284 @@
+// Copyright (C) 2019 Antoine Tenart <antoine.tenart@ack.tf>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package beerxml
+
+import (
+	"encoding/json"
+	"io"
+	"strings"
+)
+
+// BeerJSON (version 1.0) representation of recipes, for export only. Only the
+// elements having a BeerXML counterpart are exported. See
+// https://github.com/beerjson/beerjson
+type beerJSON struct {
+	BeerJSON struct {
+		Version float64          `json:"version"`
+		Recipes []beerJSONRecipe `json:"recipes"`
+	} `json:"beerjson"`
+}
+
+// A value and its unit.
+type beerJSONUnit struct {
+	Unit  string  `json:"unit"`
+	Value float64 `json:"value"`
+}
+
+type beerJSONStyle struct {
+	Name           string `json:"name"`
+	Category       string `json:"category"`
+	CategoryNumber string `json:"category_number,omitempty"`
+	StyleLetter    string `json:"style_letter,omitempty"`
+	StyleGuide     string `json:"style_guide"`
+	Type           string `json:"type"`
+}
+
+type beerJSONTiming struct {
+	Use      string        `json:"use"`
+	Duration *beerJSONUnit `json:"duration,omitempty"`
+}
+
+type beerJSONFermentable struct {
+	Name     string       `json:"name"`
+	Type     string       `json:"type"`
+	Origin   string       `json:"origin,omitempty"`
+	Producer string       `json:"producer,omitempty"`
+	Yield    struct {
+		FineGrind beerJSONUnit `json:"fine_grind"`
+	} `json:"yield"`
+	Color    beerJSONUnit `json:"color"`
+	Amount   beerJSONUnit `json:"amount"`
+}
+
+type beerJSONHop struct {
+	Name      string         `json:"name"`
+	Origin    string         `json:"origin,omitempty"`
+	Form      string         `json:"form,omitempty"`
+	AlphaAcid beerJSONUnit   `json:"alpha_acid"`
+	BetaAcid  beerJSONUnit   `json:"beta_acid"`
+	Timing    beerJSONTiming `json:"timing"`
+	Amount    beerJSONUnit   `json:"amount"`
+}
+
+type beerJSONCulture struct {
+	Name        string        `json:"name"`
+	Type        string        `json:"type"`
+	Form        string        `json:"form"`
+	Producer    string        `json:"producer,omitempty"`
+	ProductId   string        `json:"product_id,omitempty"`
+	Attenuation *beerJSONUnit `json:"attenuation,omitempty"`
+	Amount      beerJSONUnit  `json:"amount"`
+}
+
+type beerJSONMisc struct {
+	Name   string         `json:"name"`
+	Type   string         `json:"type"`
+	Timing beerJSONTiming `json:"timing"`
+	Amount beerJSONUnit   `json:"amount"`
+}
+
+type beerJSONMashStep struct {
+	Name            string       `json:"name"`
+	Type            string       `json:"type"`
+	StepTemperature beerJSONUnit `json:"step_temperature"`
+	StepTime        beerJSONUnit `json:"step_time"`
+}
+
+type beerJSONMash struct {
+	Name             string             `json:"name"`
+	GrainTemperature beerJSONUnit       `json:"grain_temperature"`
+	MashSteps        []beerJSONMashStep `json:"mash_steps"`
+}
+
+type beerJSONRecipe struct {
+	Name        string        `json:"name"`
+	Type        string        `json:"type"`
+	Author      string        `json:"author"`
+	BatchSize   beerJSONUnit  `json:"batch_size"`
+	Efficiency  struct {
+		Brewhouse beerJSONUnit `json:"brewhouse"`
+	} `json:"efficiency"`
+	Style       *beerJSONStyle `json:"style,omitempty"`
+	Ingredients struct {
+		Fermentables []beerJSONFermentable `json:"fermentable_additions"`
+		Hops         []beerJSONHop         `json:"hop_additions,omitempty"`
+		Cultures     []beerJSONCulture     `json:"culture_additions,omitempty"`
+		Miscs        []beerJSONMisc        `json:"miscellaneous_additions,omitempty"`
+	} `json:"ingredients"`
+	Mash        *beerJSONMash `json:"mash,omitempty"`
+	Boil        struct {
+		BoilTime beerJSONUnit `json:"boil_time"`
+	} `json:"boil"`
+	OG          beerJSONUnit  `json:"original_gravity"`
+	FG          beerJSONUnit  `json:"final_gravity"`
+	ABV         beerJSONUnit  `json:"alcohol_by_volume"`
+	IBU         struct {
+		Method string `json:"method"`
+	} `json:"ibu_estimate"`
+	Color       beerJSONUnit  `json:"color_estimate"`
+	Notes       string        `json:"notes,omitempty"`
+}
+
+// Convert a BeerXML enumeration value into a BeerJSON one, given the BeerJSON
+// values differing from the lower case BeerXML one.
+func beerJSONEnum(value string, exceptions map[string]string) string {
+	value = strings.ToLower(value)
+	if v, ok := exceptions[value]; ok {
+		return v
+	}
+	return value
+}
+
+// Convert a BeerXML ingredient use into a BeerJSON timing.
+func beerJSONTimingOf(use string, time float64) beerJSONTiming {
+	timing := beerJSONTiming{ Use: "add_to_boil" }
+	switch strings.ToLower(use) {
+	case "mash":
+		timing.Use = "add_to_mash"
+	case "dry hop", "primary", "secondary":
+		timing.Use = "add_to_fermentation"
+	case "bottling":
+		timing.Use = "add_to_package"
+	}
+
+	if time != 0 {
+		timing.Duration = &beerJSONUnit{ "min", time }
+	}
+	return timing
+}
+
+// Convert a BeerXML amount into a BeerJSON one (kg or l).
+func beerJSONAmount(amount float64, isWeight bool) beerJSONUnit {
+	if isWeight {
+		return beerJSONUnit{ "kg", amount }
+	}
+	return beerJSONUnit{ "l", amount }
+}
+
+// Convert a BeerXML recipe into its BeerJSON representation.
+func toBeerJSON(r *Recipe) beerJSONRecipe {
+	j := beerJSONRecipe{
+		Name:      r.Name,
+		Type:      strings.ToLower(r.Type),
+		Author:    r.Brewer,
+		BatchSize: beerJSONUnit{ "l", r.BatchSize },
+		OG:        beerJSONUnit{ "sg", r.EstOG },
+		FG:        beerJSONUnit{ "sg", r.EstFG },
+		ABV:       beerJSONUnit{ "%", r.EstABV },
+		Color:     beerJSONUnit{ "SRM", r.EstColor },
+		Notes:     r.Notes,
+	}
+	if j.Type == "" {
+		j.Type = "all grain"
+	}
+	j.Efficiency.Brewhouse = beerJSONUnit{ "%", r.Efficiency }
+	j.Boil.BoilTime = beerJSONUnit{ "min", r.BoilTime }
+	j.IBU.Method = "Tinseth"
+
+	if r.Style.Name != "" {
+		j.Style = &beerJSONStyle{
+			Name:           r.Style.Name,
+			Category:       r.Style.Category,
+			CategoryNumber: r.Style.CategoryNumber,
+			StyleLetter:    r.Style.StyleLetter,
+			StyleGuide:     r.Style.StyleGuide,
+			Type:           beerJSONEnum(r.Style.Type, map[string]string{
+				"lager": "beer", "ale": "beer", "wheat": "beer", "mixed": "beer",
+			}),
+		}
+	}
+
+	// BeerJSON requires the fermentables list, even empty.
+	j.Ingredients.Fermentables = []beerJSONFermentable{}
+	for _, f := range r.Fermentables {
+		fermentable := beerJSONFermentable{
+			Name:     f.Name,
+			Type:     beerJSONEnum(f.Type, map[string]string{ "adjunct": "other" }),
+			Origin:   f.Origin,
+			Producer: f.Supplier,
+			Color:    beerJSONUnit{ "Lovi", f.Color },
+			Amount:   beerJSONUnit{ "kg", f.Amount },
+		}
+		fermentable.Yield.FineGrind = beerJSONUnit{ "%", f.Yield }
+		j.Ingredients.Fermentables = append(j.Ingredients.Fermentables, fermentable)
+	}
+
+	for _, h := range r.Hops {
+		j.Ingredients.Hops = append(j.Ingredients.Hops, beerJSONHop{
+			Name:      h.Name,
+			Origin:    h.Origin,
+			Form:      strings.ToLower(h.Form),
+			AlphaAcid: beerJSONUnit{ "%", h.Alpha },
+			BetaAcid:  beerJSONUnit{ "%", h.Beta },
+			Timing:    beerJSONTimingOf(h.Use, h.Time),
+			Amount:    beerJSONUnit{ "kg", h.Amount },
+		})
+	}
+
+	for _, y := range r.Yeasts {
+		culture := beerJSONCulture{
+			Name:      y.Name,
+			Type:      beerJSONEnum(y.Type, map[string]string{ "wheat": "ale" }),
+			Form:      strings.ToLower(y.Form),
+			Producer:  y.Laboratory,
+			ProductId: y.ProductId,
+			Amount:    beerJSONAmount(y.Amount, y.AmountIsWeight),
+		}
+		if y.Attenuation != 0 {
+			culture.Attenuation = &beerJSONUnit{ "%", y.Attenuation }
+		}
+		j.Ingredients.Cultures = append(j.Ingredients.Cultures, culture)
+	}
+
+	for _, m := range r.Miscs {
+		j.Ingredients.Miscs = append(j.Ingredients.Miscs, beerJSONMisc{
+			Name:   m.Name,
+			Type:   strings.ToLower(m.Type),
+			Timing: beerJSONTimingOf(m.Use, m.Time),
+			Amount: beerJSONAmount(m.Amount, m.AmountIsWeight),
+		})
+	}
+
+	if len(r.Mash.MashSteps) > 0 {
+		j.Mash = &beerJSONMash{
+			Name:             r.Mash.Name,
+			GrainTemperature: beerJSONUnit{ "C", r.Mash.GrainTemp },
+		}
+		for _, s := range r.Mash.MashSteps {
+			j.Mash.MashSteps = append(j.Mash.MashSteps, beerJSONMashStep{
+				Name:            s.Name,
+				Type:            strings.ToLower(s.Type),
+				StepTemperature: beerJSONUnit{ "C", s.StepTemp },
+				StepTime:        beerJSONUnit{ "min", s.StepTime },
+			})
+		}
+	}
+
+	return j
+}
+
+// Export recipes in the BeerJSON format.
+func ExportBeerJSON(recipes []Recipe, w io.Writer) error {
+	var j beerJSON
+	j.BeerJSON.Version = 1.0
+	for k := range recipes {
+		j.BeerJSON.Recipes = append(j.BeerJSON.Recipes, toBeerJSON(&recipes[k]))
+	}
+
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(&j)
+}
@@ -169,3 +169,53 @@ func (r *Recipe) CalcIbuRe() float64 {
 
 	return r.CalcIBU() / ((0.1808 * og) + (0.8192 * fg))
 }
+
+// Compute the apparent attenuation (%) given a gravity reading. The real OG is
+// used when known, the estimated one otherwise.
+func (r *Recipe) CalcApparentAttenuation(sg float64) float64 {
+	og := r.OG
+	if og == 0 {
+		og = r.EstOG
+	}
+
+	if og <= 1 || sg == 0 {
+		return 0
+	}
+	return (og - sg) / (og - 1) * 100
+}
+
+// Hop packagings, and how much they slow down the alpha acids degradation
+// compared to hops stored in the open.
+var HopPackagings = map[string]float64{
+	"":         1,
+	"vacuum":   0.5,
+	"nitrogen": 0.4,
+}
+
+// Hop Storage Index (%) used when a hop HSI is unknown.
+const DefaultHsi = 35
+
+// Estimate the alpha acids (%) of a hop after being stored for a number of days
+// at a given temperature (°C) and packaging. The HSI is the percentage of alpha
+// acids lost after 6 months at 20°C in the open; the degradation rate is
+// considered to halve for every 15°C of temperature drop.
+func (h *Hop) CalcStoredAlpha(days, temp float64, packaging string) float64 {
+	if days <= 0 {
+		return h.Alpha
+	}
+
+	hsi := h.Hsi
+	if hsi <= 0 || hsi >= 100 {
+		hsi = DefaultHsi
+	}
+
+	factor, ok := HopPackagings[packaging]
+	if !ok {
+		factor = 1
+	}
+
+	rate := -math.Log(1 - hsi / 100) / 182.5
+	rate *= math.Pow(2, (temp - 20) / 15) * factor
+
+	return h.Alpha * math.Exp(-rate * days)
+}
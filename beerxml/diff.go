@@ -0,0 +1,252 @@
+// Copyright (C) 2019 Antoine Tenart <antoine.tenart@ack.tf>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package beerxml
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// Kinds of changes between two recipes.
+const (
+	ChangeAdded    = "added"
+	ChangeRemoved  = "removed"
+	ChangeModified = "modified"
+)
+
+// Represents a single difference between two recipes.
+type Change struct {
+	Kind    string
+	Section string
+	Name    string
+	Field   string
+	Old     string
+	New     string
+}
+
+// A named field of an element, used to compare elements.
+type field struct {
+	name  string
+	value string
+}
+
+// A comparable representation of a recipe element.
+type element struct {
+	key    string
+	name   string
+	fields []field
+}
+
+// Format a float for display in a diff.
+func ftoa(f float64) string {
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
+
+// Compute the field-level differences between two recipes.
+func Diff(from, to *Recipe) []Change {
+	var changes []Change
+
+	// General parameters.
+	changes = append(changes, diffFields("Recipe", "", recipeFields(from), recipeFields(to))...)
+
+	// Ingredients and mash steps.
+	changes = append(changes, diffElements("Fermentable", fermentableElements(from), fermentableElements(to))...)
+	changes = append(changes, diffElements("Hop", hopElements(from), hopElements(to))...)
+	changes = append(changes, diffElements("Yeast", yeastElements(from), yeastElements(to))...)
+	changes = append(changes, diffElements("Misc", miscElements(from), miscElements(to))...)
+	changes = append(changes, diffElements("Mash step", mashStepElements(from), mashStepElements(to))...)
+
+	// Estimations.
+	changes = append(changes, diffFields("Estimates", "", estimateFields(from), estimateFields(to))...)
+
+	return changes
+}
+
+// Compare two lists of fields.
+func diffFields(section, name string, from, to []field) []Change {
+	var changes []Change
+	for i := range from {
+		if from[i].value == to[i].value {
+			continue
+		}
+
+		changes = append(changes, Change{
+			Kind:    ChangeModified,
+			Section: section,
+			Name:    name,
+			Field:   from[i].name,
+			Old:     from[i].value,
+			New:     to[i].value,
+		})
+	}
+	return changes
+}
+
+// Compare two lists of elements. Elements are matched using their key; when
+// multiple elements share the same key they are matched in order.
+func diffElements(section string, from, to []element) []Change {
+	var changes []Change
+
+	// Index the new elements.
+	seen := make(map[string][]int)
+	for i, e := range to {
+		seen[e.key] = append(seen[e.key], i)
+	}
+
+	matched := make([]bool, len(to))
+	for _, o := range from {
+		idx, ok := seen[o.key]
+		if !ok || len(idx) == 0 {
+			changes = append(changes, Change{
+				Kind:    ChangeRemoved,
+				Section: section,
+				Name:    o.name,
+			})
+			continue
+		}
+
+		n := to[idx[0]]
+		seen[o.key] = idx[1:]
+		matched[idx[0]] = true
+
+		changes = append(changes, diffFields(section, o.name, o.fields, n.fields)...)
+	}
+
+	for i, n := range to {
+		if matched[i] {
+			continue
+		}
+
+		changes = append(changes, Change{
+			Kind:    ChangeAdded,
+			Section: section,
+			Name:    n.name,
+		})
+	}
+
+	return changes
+}
+
+func recipeFields(r *Recipe) []field {
+	return []field{
+		{"Name", r.Name},
+		{"Type", r.Type},
+		{"Style", r.Style.Name},
+		{"Batch size", ftoa(r.BatchSize)},
+		{"Boil time", ftoa(r.BoilTime)},
+		{"Efficiency", ftoa(r.Efficiency)},
+		{"Primary age", ftoa(r.PrimaryAge)},
+		{"Primary temp.", ftoa(r.PrimaryTemp)},
+		{"Secondary age", ftoa(r.SecondaryAge)},
+		{"Secondary temp.", ftoa(r.SecondaryTemp)},
+		{"Tertiary age", ftoa(r.TertiaryAge)},
+		{"Tertiary temp.", ftoa(r.TertiaryTemp)},
+		{"Age", ftoa(r.Age)},
+		{"Age temp.", ftoa(r.AgeTemp)},
+		{"Notes", r.Notes},
+	}
+}
+
+func estimateFields(r *Recipe) []field {
+	return []field{
+		{"OG", ftoa(r.EstOG)},
+		{"FG", ftoa(r.EstFG)},
+		{"ABV", ftoa(r.EstABV)},
+		{"IBU", ftoa(r.IBU)},
+		{"Color", ftoa(r.EstColor)},
+	}
+}
+
+func fermentableElements(r *Recipe) []element {
+	var elements []element
+	for _, f := range r.Fermentables {
+		elements = append(elements, element{
+			key:  f.Name,
+			name: f.Name,
+			fields: []field{
+				{"Type", f.Type},
+				{"Amount", ftoa(f.Amount)},
+				{"Yield", ftoa(f.Yield)},
+				{"Color", ftoa(f.Color)},
+			},
+		})
+	}
+	return elements
+}
+
+func hopElements(r *Recipe) []element {
+	var elements []element
+	for _, h := range r.Hops {
+		elements = append(elements, element{
+			key:  fmt.Sprintf("%s/%s/%s", h.Name, h.Use, ftoa(h.Time)),
+			name: fmt.Sprintf("%s (%s - %sm)", h.Name, h.Use, ftoa(h.Time)),
+			fields: []field{
+				{"Form", h.Form},
+				{"Amount", ftoa(h.Amount)},
+				{"Alpha", ftoa(h.Alpha)},
+			},
+		})
+	}
+	return elements
+}
+
+func yeastElements(r *Recipe) []element {
+	var elements []element
+	for _, y := range r.Yeasts {
+		elements = append(elements, element{
+			key:  y.Name,
+			name: y.Name,
+			fields: []field{
+				{"Form", y.Form},
+				{"Amount", ftoa(y.Amount)},
+				{"Attenuation", ftoa(y.Attenuation)},
+			},
+		})
+	}
+	return elements
+}
+
+func miscElements(r *Recipe) []element {
+	var elements []element
+	for _, m := range r.Miscs {
+		elements = append(elements, element{
+			key:  fmt.Sprintf("%s/%s", m.Name, m.Use),
+			name: fmt.Sprintf("%s (%s)", m.Name, m.Use),
+			fields: []field{
+				{"Type", m.Type},
+				{"Amount", ftoa(m.Amount)},
+				{"Time", ftoa(m.Time)},
+			},
+		})
+	}
+	return elements
+}
+
+func mashStepElements(r *Recipe) []element {
+	var elements []element
+	for _, s := range r.Mash.MashSteps {
+		elements = append(elements, element{
+			key:  s.Name,
+			name: s.Name,
+			fields: []field{
+				{"Type", s.Type},
+				{"Temperature", ftoa(s.StepTemp)},
+				{"Time", ftoa(s.StepTime)},
+			},
+		})
+	}
+	return elements
+}
@@ -0,0 +1,124 @@
+// Copyright (C) 2019 Antoine Tenart <antoine.tenart@ack.tf>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package beerxml
+
+import (
+	"math"
+	"strings"
+	"unicode"
+)
+
+// Hop characteristics compared to find substitutes, and the typical range of
+// their values used to normalize them.
+var hopTraits = []struct{
+	value func(h *Hop) float64
+	scale float64
+}{
+	{ func(h *Hop) float64 { return h.Alpha }, 20 },
+	{ func(h *Hop) float64 { return h.Beta }, 10 },
+	{ func(h *Hop) float64 { return h.Cohumulone }, 50 },
+	{ func(h *Hop) float64 { return h.Myrcene }, 70 },
+	{ func(h *Hop) float64 { return h.Humulene }, 50 },
+	{ func(h *Hop) float64 { return h.Caryophyllene }, 20 },
+}
+
+// Compute how similar two hops are (0-100), given their alpha and beta acids
+// and their oils composition. Only the characteristics known for both hops are
+// compared.
+func (h *Hop) Similarity(o *Hop) float64 {
+	var dist float64
+	var n int
+	for _, t := range hopTraits {
+		a, b := t.value(h), t.value(o)
+		if a <= 0 || b <= 0 {
+			continue
+		}
+
+		dist += math.Pow((a - b) / t.scale, 2)
+		n++
+	}
+
+	if n == 0 {
+		return 0
+	}
+	return math.Max(0, 1 - math.Sqrt(dist / float64(n))) * 100
+}
+
+// Origins which can be given before or after a hop name, as "US Saaz" or
+// "Saaz (Czech)", while still naming the same hop.
+var hopOrigins = map[string]bool{
+	"us": true, "usa": true, "american": true,
+	"uk": true, "english": true, "british": true,
+	"nz": true, "new zealand": true,
+	"au": true, "aus": true, "australian": true,
+	"de": true, "german": true, "germany": true,
+	"cz": true, "czech": true,
+	"si": true, "slovenian": true,
+	"fr": true, "french": true,
+	"pl": true, "polish": true,
+	"jp": true, "japanese": true,
+}
+
+// Normalize a hop name for comparison: lowercase words, without punctuation
+// nor an origin given before or after the name.
+func normalizeHopName(name string) string {
+	words := strings.FieldsFunc(strings.ToLower(name), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	name = strings.Join(words, " ")
+
+	for origin := range hopOrigins {
+		if n := strings.TrimPrefix(name, origin + " "); n != name {
+			name = n
+			break
+		}
+	}
+	for origin := range hopOrigins {
+		if n := strings.TrimSuffix(name, " " + origin); n != name {
+			name = n
+			break
+		}
+	}
+	return name
+}
+
+// Check if a hop is listed in the substitutes of another hop. Names must match
+// as a whole, so "Ella" doesn't match "Stella".
+func (h *Hop) ListsSubstitute(name string) bool {
+	name = normalizeHopName(name)
+	if name == "" {
+		return false
+	}
+
+	subs := strings.Replace(strings.ToLower(h.Substitutes), " and ", ",", -1)
+	for _, sub := range strings.FieldsFunc(subs, func(r rune) bool {
+		return r == ',' || r == ';' || r == '/' || r == '\n'
+	}) {
+		if normalizeHopName(sub) == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Compute the amount of a substitute hop giving the same bitterness as a hop.
+// The amount is unchanged for hops not contributing to the bitterness.
+func (h *Hop) SubstituteAmount(o *Hop) float64 {
+	if h.Use == "Dry hop" || h.Use == "Aroma" || o.Alpha <= 0 {
+		return h.Amount
+	}
+	return h.Amount * h.Alpha / o.Alpha
+}
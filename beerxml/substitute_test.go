@@ -0,0 +1,49 @@
+// Copyright (C) 2019 Antoine Tenart <antoine.tenart@ack.tf>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+
+package beerxml
+
+import (
+	"testing"
+)
+
+func TestListsSubstitute(t *testing.T) {
+	tests := []struct {
+		substitutes string
+		name        string
+		want        bool
+	}{
+		{ "Galaxy, Ella", "Ella", true },
+		{ "Galaxy, Ella", "Stella", false },
+		{ "Stella", "Ella", false },
+		{ "Saaz", "Saazer", false },
+		{ "Saaz (US); Tettnang", "saaz", true },
+		{ "Czech Saaz and Lublin", "Saaz", true },
+		{ "Saaz", "Saaz (Czech)", true },
+		{ "Nelson Sauvin", "NZ Nelson Sauvin", true },
+		{ "Nelson Sauvin", "Nelson", false },
+		{ "Hallertau Mittelfrüh / Tettnang", "Hallertau Mittelfrüh", true },
+		{ "Hallertau Mittelfrüh", "Hallertau", false },
+		{ "", "Saaz", false },
+		{ "Saaz", "", false },
+	}
+	for _, tt := range tests {
+		h := &Hop{ Substitutes: tt.substitutes }
+		if got := h.ListsSubstitute(tt.name); got != tt.want {
+			t.Errorf("%q ListsSubstitute(%q) = %v, want %v", tt.substitutes, tt.name, got, tt.want)
+		}
+	}
+}
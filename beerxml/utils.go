@@ -55,6 +55,18 @@ func ExportFile(data interface{}, file string) error {
 	return Export(data, f)
 }
 
+// Copy a recipe, so the copy can be modified without altering the original.
+func CopyRecipe(r *Recipe) *Recipe {
+	c := *r
+	c.Hops = append([]Hop(nil), r.Hops...)
+	c.Fermentables = append([]Fermentable(nil), r.Fermentables...)
+	c.Miscs = append([]Misc(nil), r.Miscs...)
+	c.Yeasts = append([]Yeast(nil), r.Yeasts...)
+	c.Waters = append([]Water(nil), r.Waters...)
+	c.Mash.MashSteps = append([]MashStep(nil), r.Mash.MashSteps...)
+	return &c
+}
+
 // Insert an element into a BeerXML object.
 func InsertToXML(xml *BeerXML, e interface{}) error {
 	switch elmt := e.(type) {
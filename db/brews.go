@@ -16,18 +16,23 @@
 package db
 
 import (
+	"errors"
 	"os"
 	"path"
 
 	"github.com/atenart/bubbles/beerxml"
 )
 
+// Scan a brew from a row, without importing its XML file.
+func scanBrew(row interface{ Scan(...interface{}) error }, b *Brew) error {
+	return row.Scan(&b.Id, &b.UserId, &b.RecipeId, &b.Step, &b.File, &b.OrganizationId,
+			&b.Name, &b.Date)
+}
+
 // Retrieve a single brew given its id.
 func (db *DB) GetBrew(id int64) (*Brew, error) {
 	var b Brew
-	err := db.QueryRow("SELECT * FROM brews WHERE id == $1", id).
-	       Scan(&b.Id, &b.UserId, &b.RecipeId, &b.Step, &b.File)
-	if err != nil {
+	if err := scanBrew(db.QueryRow("SELECT * FROM brews WHERE id == $1", id), &b); err != nil {
 		return nil, err
 	}
 
@@ -38,22 +43,23 @@ func (db *DB) GetBrew(id int64) (*Brew, error) {
 	return &b, nil
 }
 
-// Retrieve all brews for a given user.
-func (db *DB) GetUserBrews(uid int64) ([]*Brew, error) {
-	row, err := db.Query("SELECT * FROM brews WHERE user_id == ?", uid)
+// Retrieve all brews of a given owner, without importing their XML file.
+func (db *DB) GetBrews(o Owner) ([]*Brew, error) {
+	row, err := db.Query("SELECT * FROM brews WHERE user_id == ? AND organization_id == ?",
+			     o.UserId, o.OrganizationId)
 	if err != nil {
 		return nil, err
 	}
+	defer row.Close()
 
 	var brews []*Brew
 	for row.Next() {
 		var b Brew
-		row.Scan(&b.Id, &b.UserId, &b.RecipeId, &b.Step, &b.File)
-
-		brews = append(brews, &b)
-		if err := db.importXML(b.File, &b.XML); err != nil {
+		if err := scanBrew(row, &b); err != nil {
 			return nil, err
 		}
+
+		brews = append(brews, &b)
 	}
 
 	return brews, nil
@@ -66,9 +72,11 @@ func (db *DB) AddBrew(b *Brew) (int64, error) {
 		return -1, err
 	}
 
+	b.Name, b.Date = b.XML.Name, b.XML.Date
 	result, err := db.Exec(`
-INSERT INTO brews (user_id, recipe_id, step, file)
-VALUES (?, ?, ?, ?)`, b.UserId, b.RecipeId, b.Step, b.File)
+INSERT INTO brews (user_id, recipe_id, step, file, organization_id, name, date)
+VALUES (?, ?, ?, ?, ?, ?, ?)`, b.UserId, b.RecipeId, b.Step, b.File, b.OrganizationId, b.Name,
+		b.Date)
 	if err != nil {
 		os.Remove(b.File)
 		return -1, err
@@ -89,24 +97,83 @@ VALUES (?, ?, ?, ?)`, b.UserId, b.RecipeId, b.Step, b.File)
 
 // Update a brew.
 func (db *DB) UpdateBrew(b *Brew) error {
+	b.Name, b.Date = b.XML.Name, b.XML.Date
 	_, err := db.Exec(`
-REPLACE INTO brews (id, user_id, recipe_id, step, file)
-VALUES (?, ?, ?, ?, ?)`, b.Id, b.UserId, b.RecipeId, b.Step, b.File)
+REPLACE INTO brews (id, user_id, recipe_id, step, file, organization_id, name, date)
+VALUES (?, ?, ?, ?, ?, ?, ?, ?)`, b.Id, b.UserId, b.RecipeId, b.Step, b.File, b.OrganizationId,
+		b.Name, b.Date)
+	if err != nil {
+		return err
+	}
+
+	// The cached document is outdated.
+	db.docs.forget(b.File)
+
+	return beerxml.ExportFile(b.XML, path.Join(db.rootdir, b.File))
+}
+
+// Error returned when starting a brew which already started.
+var ErrBrewStarted = errors.New("The brew already started.")
+
+// Start a brew: move it from the preparation step to the next one and deduct
+// its ingredients from the inventory, at once. Fails with ErrBrewStarted if it
+// left the preparation step meanwhile, so ingredients are only deducted once.
+func (db *DB) StartBrew(b *Brew, deductions []*Deduction) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+
+	b.Name, b.Date = b.XML.Name, b.XML.Date
+	result, err := tx.Exec("UPDATE brews SET step = ?, name = ?, date = ? WHERE id == ? AND step == ?",
+			       StepMash, b.Name, b.Date, b.Id, StepPrepare)
 	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	if n, err := result.RowsAffected(); err != nil || n == 0 {
+		tx.Rollback()
+		if err == nil {
+			err = ErrBrewStarted
+		}
+		return err
+	}
+
+	if err := deduct(tx, deductions); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
 		return err
 	}
+	b.Step = StepMash
+
+	// The cached document is outdated.
+	db.docs.forget(b.File)
 
 	return beerxml.ExportFile(b.XML, path.Join(db.rootdir, b.File))
 }
 
 // Delete a brew.
 func (db *DB) DeleteBrew(b *Brew) error {
-	// First, remove the db entry.
+	// First, remove the fermentation log.
+	if err := db.DeleteBrewReadings(b.Id); err != nil {
+		return err
+	}
+
+	// Forget about the ingredients it consumed.
+	if err := db.DeleteBrewDeductions(b.Id); err != nil {
+		return err
+	}
+
+	// Then, remove the db entry.
 	if _, err := db.Exec("DELETE FROM brews WHERE id == ?", b.Id); err != nil {
 		return err
 	}
 
 	// Then, remove the recipe XML file.
+	db.docs.forget(b.File)
 	if err := os.Remove(path.Join(db.rootdir, b.File)); err != nil {
 		return err
 	}
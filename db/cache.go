@@ -0,0 +1,164 @@
+// Copyright (C) 2019 Antoine Tenart <antoine.tenart@ack.tf>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+
+package db
+
+import (
+	"os"
+	"path"
+	"sync"
+	"time"
+
+	"github.com/atenart/bubbles/beerxml"
+)
+
+// Maximum number of parsed documents kept in the cache.
+const maxCachedDocs = 4096
+
+// Cache of the parsed BeerXML files associated with DB entries. An entry is
+// only used while its file keeps the same modification time and size, and is
+// dropped when its DB entry is updated or deleted. Cached documents are never
+// handed out: callers get copies they are free to modify.
+type docCache struct {
+	sync.Mutex
+	docs map[string]cachedDoc
+}
+
+type cachedDoc struct {
+	modTime time.Time
+	size    int64
+	doc     interface{}
+}
+
+func newDocCache() *docCache {
+	return &docCache{ docs: make(map[string]cachedDoc) }
+}
+
+// Copy a parsed document into a new one of the same type. Returns nil for
+// documents which can't be cached.
+func copyDoc(doc interface{}) interface{} {
+	switch d := doc.(type) {
+	case **beerxml.Recipe:
+		r := beerxml.CopyRecipe(*d)
+		return &r
+	case *beerxml.Fermentable:
+		f := *d
+		return &f
+	case *beerxml.Hop:
+		h := *d
+		return &h
+	case *beerxml.Yeast:
+		y := *d
+		return &y
+	case *beerxml.Misc:
+		m := *d
+		return &m
+	}
+	return nil
+}
+
+// Copy a cached document into the one given, if of the same type.
+func assignDoc(dst, src interface{}) bool {
+	switch d := dst.(type) {
+	case **beerxml.Recipe:
+		if s, ok := src.(**beerxml.Recipe); ok {
+			*d = beerxml.CopyRecipe(*s)
+			return true
+		}
+	case *beerxml.Fermentable:
+		if s, ok := src.(*beerxml.Fermentable); ok {
+			*d = *s
+			return true
+		}
+	case *beerxml.Hop:
+		if s, ok := src.(*beerxml.Hop); ok {
+			*d = *s
+			return true
+		}
+	case *beerxml.Yeast:
+		if s, ok := src.(*beerxml.Yeast); ok {
+			*d = *s
+			return true
+		}
+	case *beerxml.Misc:
+		if s, ok := src.(*beerxml.Misc); ok {
+			*d = *s
+			return true
+		}
+	}
+	return false
+}
+
+// Retrieve a parsed document, given its file info.
+func (c *docCache) get(file string, info os.FileInfo, doc interface{}) bool {
+	c.Lock()
+	defer c.Unlock()
+
+	cached, ok := c.docs[file]
+	if !ok || !cached.modTime.Equal(info.ModTime()) || cached.size != info.Size() {
+		return false
+	}
+	return assignDoc(doc, cached.doc)
+}
+
+// Store a parsed document, given its file info.
+func (c *docCache) put(file string, info os.FileInfo, doc interface{}) {
+	stored := copyDoc(doc)
+	if stored == nil {
+		return
+	}
+
+	c.Lock()
+	defer c.Unlock()
+
+	// Make room, dropping arbitrary entries.
+	for name := range c.docs {
+		if len(c.docs) < maxCachedDocs {
+			break
+		}
+		delete(c.docs, name)
+	}
+
+	c.docs[file] = cachedDoc{ info.ModTime(), info.Size(), stored }
+}
+
+// Drop the parsed document of a file.
+func (c *docCache) forget(file string) {
+	c.Lock()
+	defer c.Unlock()
+
+	delete(c.docs, file)
+}
+
+// Import a BeerXML file associated with a DB entry, using the cache when
+// possible.
+func (db *DB) importXML(file string, XML interface{}) error {
+	info, err := os.Stat(path.Join(db.rootdir, file))
+	if err != nil {
+		return err
+	}
+
+	if db.docs.get(file, info, XML) {
+		return nil
+	}
+
+	if err := beerxml.ImportFile(path.Join(db.rootdir, file), XML); err != nil {
+		return err
+	}
+
+	db.docs.put(file, info, XML)
+	return nil
+}
@@ -0,0 +1,98 @@
+// Copyright (C) 2019 Antoine Tenart <antoine.tenart@ack.tf>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package db
+
+import (
+	"io/ioutil"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/atenart/bubbles/beerxml"
+)
+
+// The instance-wide, read-only, ingredients catalog. It is made of all the
+// BeerXML files found in $rootdir/catalog/ and is reloaded when those files
+// change.
+type catalog struct {
+	sync.Mutex
+	files map[string]time.Time
+	xml   *beerxml.BeerXML
+}
+
+// Retrieve the ingredients catalog, reloading it if its files changed.
+func (db *DB) Catalog() (*beerxml.BeerXML, error) {
+	db.catalog.Lock()
+	defer db.catalog.Unlock()
+
+	dir := path.Join(db.rootdir, "catalog")
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		// No catalog.
+		return &beerxml.BeerXML{}, nil
+	}
+
+	files := make(map[string]time.Time)
+	for _, info := range infos {
+		if info.IsDir() || !strings.HasSuffix(strings.ToLower(info.Name()), ".xml") {
+			continue
+		}
+		files[info.Name()] = info.ModTime()
+	}
+
+	if db.catalog.xml != nil && sameFiles(files, db.catalog.files) {
+		return db.catalog.xml, nil
+	}
+
+	var names []string
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var xml beerxml.BeerXML
+	for _, name := range names {
+		var f beerxml.BeerXML
+		if err := beerxml.ImportFile(path.Join(dir, name), &f); err != nil {
+			return nil, err
+		}
+
+		xml.Fermentables = append(xml.Fermentables, f.Fermentables...)
+		xml.Hops = append(xml.Hops, f.Hops...)
+		xml.Yeasts = append(xml.Yeasts, f.Yeasts...)
+		xml.Miscs = append(xml.Miscs, f.Miscs...)
+	}
+
+	db.catalog.files = files
+	db.catalog.xml = &xml
+
+	return &xml, nil
+}
+
+// Check if two lists of files, with their modification time, are the same.
+func sameFiles(a, b map[string]time.Time) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for name, t := range a {
+		if u, ok := b[name]; !ok || !t.Equal(u) {
+			return false
+		}
+	}
+	return true
+}
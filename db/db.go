@@ -17,9 +17,11 @@ package db
 
 import (
 	"database/sql"
+	"fmt"
 	"math/rand"
 	"os"
 	"path"
+	"runtime"
 	"time"
 
 	_ "github.com/mattn/go-sqlite3"
@@ -27,11 +29,19 @@ import (
 	"github.com/atenart/bubbles/beerxml"
 )
 
+// Format of the dates stored as text, in UTC, comparable as strings. This is
+// also the format of CURRENT_TIMESTAMP.
+const TimeFormat = "2006-01-02 15:04:05"
+
 type DB struct {
 	*sql.DB
 	Styles  *[]beerxml.Style
 	rootdir string
 	salt    []byte
+	catalog *catalog
+	docs    *docCache
+	hashes  chan struct{} // Password hashes in progress.
+	fts     bool          // Full-text search is available.
 }
 
 var structure = []string{
@@ -78,6 +88,254 @@ CREATE TABLE IF NOT EXISTS brews (
 	file TEXT NOT NULL
 )
 `,
+	`
+CREATE TABLE IF NOT EXISTS readings (
+	id INTEGER PRIMARY KEY,
+	brew_id INTEGER NOT NULL,
+	date TIMESTAMP NOT NULL,
+	gravity REAL DEFAULT 0,
+	temperature REAL DEFAULT 0,
+	ph REAL DEFAULT 0,
+	note TEXT DEFAULT ""
+)
+`,
+	`
+CREATE TABLE IF NOT EXISTS deductions (
+	id INTEGER PRIMARY KEY,
+	brew_id INTEGER NOT NULL,
+	ingredient_id INTEGER NOT NULL,
+	amount REAL NOT NULL
+)
+`,
+	`
+CREATE TABLE IF NOT EXISTS lots (
+	id INTEGER PRIMARY KEY,
+	ingredient_id INTEGER NOT NULL,
+	amount REAL DEFAULT 0,
+	code TEXT DEFAULT "",
+	purchase_date TEXT DEFAULT "",
+	best_before TEXT DEFAULT "",
+	alpha REAL DEFAULT 0,
+	color REAL DEFAULT 0,
+	yield REAL DEFAULT 0
+)
+`,
+	`
+CREATE TABLE IF NOT EXISTS prices (
+	id INTEGER PRIMARY KEY,
+	ingredient_id INTEGER NOT NULL,
+	date TIMESTAMP DEFAULT CURRENT_TIMESTAMP NOT NULL,
+	price REAL NOT NULL,
+	package REAL NOT NULL
+)
+`,
+	`
+CREATE TABLE IF NOT EXISTS organizations (
+	id INTEGER PRIMARY KEY,
+	name TEXT NOT NULL
+)
+`,
+	`
+CREATE TABLE IF NOT EXISTS members (
+	id INTEGER PRIMARY KEY,
+	organization_id INTEGER NOT NULL,
+	user_id INTEGER NOT NULL,
+	role TEXT NOT NULL,
+	--
+	CONSTRAINT tuple UNIQUE (organization_id, user_id)
+)
+`,
+	`
+CREATE TABLE IF NOT EXISTS recipe_tags (
+	id INTEGER PRIMARY KEY,
+	recipe_id INTEGER NOT NULL,
+	tag TEXT NOT NULL,
+	--
+	CONSTRAINT tuple UNIQUE (recipe_id, tag)
+)
+`,
+	`
+CREATE TABLE IF NOT EXISTS revisions (
+	id INTEGER PRIMARY KEY,
+	recipe_id INTEGER NOT NULL,
+	date TIMESTAMP DEFAULT CURRENT_TIMESTAMP NOT NULL,
+	action TEXT NOT NULL,
+	file TEXT NOT NULL
+)
+`,
+	`
+CREATE TABLE IF NOT EXISTS tokens (
+	id INTEGER PRIMARY KEY,
+	user_id INTEGER NOT NULL,
+	name TEXT NOT NULL,
+	hash TEXT NOT NULL,
+	scopes TEXT NOT NULL,
+	created TIMESTAMP DEFAULT CURRENT_TIMESTAMP NOT NULL,
+	expires TEXT DEFAULT "",
+	last_used TEXT DEFAULT "",
+	--
+	CONSTRAINT hash UNIQUE (hash)
+)
+`,
+	`
+CREATE TABLE IF NOT EXISTS webhooks (
+	id INTEGER PRIMARY KEY,
+	user_id INTEGER NOT NULL,
+	organization_id INTEGER DEFAULT 0,
+	url TEXT NOT NULL,
+	secret TEXT NOT NULL,
+	events TEXT NOT NULL
+)
+`,
+	`
+CREATE TABLE IF NOT EXISTS deliveries (
+	id INTEGER PRIMARY KEY,
+	webhook_id INTEGER NOT NULL,
+	event TEXT NOT NULL,
+	payload TEXT NOT NULL,
+	created TEXT NOT NULL,
+	status TEXT NOT NULL,
+	attempts INTEGER DEFAULT 0,
+	next_attempt TEXT NOT NULL,
+	response INTEGER DEFAULT 0,
+	error TEXT DEFAULT ""
+)
+`,
+	`
+CREATE TABLE IF NOT EXISTS devices (
+	id INTEGER PRIMARY KEY,
+	user_id INTEGER NOT NULL,
+	organization_id INTEGER DEFAULT 0,
+	name TEXT NOT NULL,
+	kind TEXT NOT NULL,
+	hash TEXT NOT NULL,
+	calibration TEXT DEFAULT "",
+	brew_id INTEGER DEFAULT 0,
+	stable_days REAL DEFAULT 0,
+	stable_delta REAL DEFAULT 0.001,
+	alerted_brew INTEGER DEFAULT 0,
+	last_seen TEXT DEFAULT "",
+	battery REAL DEFAULT 0,
+	--
+	CONSTRAINT hash UNIQUE (hash)
+)
+`,
+	`
+CREATE TABLE IF NOT EXISTS sessions (
+	id INTEGER PRIMARY KEY,
+	user_id INTEGER NOT NULL,
+	hash TEXT NOT NULL,
+	user_agent TEXT DEFAULT "",
+	ip TEXT DEFAULT "",
+	created TEXT NOT NULL,
+	last_seen TEXT NOT NULL,
+	--
+	CONSTRAINT hash UNIQUE (hash)
+)
+`,
+	`
+CREATE TABLE IF NOT EXISTS identities (
+	id INTEGER PRIMARY KEY,
+	user_id INTEGER NOT NULL,
+	issuer TEXT NOT NULL,
+	subject TEXT NOT NULL,
+	--
+	CONSTRAINT subject UNIQUE (issuer, subject)
+)
+`,
+	`
+CREATE TABLE IF NOT EXISTS resets (
+	id INTEGER PRIMARY KEY,
+	user_id INTEGER NOT NULL,
+	hash TEXT NOT NULL,
+	expires TEXT NOT NULL,
+	--
+	CONSTRAINT hash UNIQUE (hash)
+)
+`,
+}
+
+// Changes to the structure above, for databases created before them. Each
+// migration is applied once, in order: the number of migrations already applied
+// is stored in the database user_version.
+var migrations = []string{
+	`ALTER TABLE ingredients ADD COLUMN stock REAL DEFAULT 0`,
+	`ALTER TABLE ingredients ADD COLUMN unit TEXT DEFAULT "kg"`,
+	`ALTER TABLE ingredients ADD COLUMN price REAL DEFAULT 0`,
+	`ALTER TABLE ingredients ADD COLUMN package REAL DEFAULT 0`,
+	`ALTER TABLE users ADD COLUMN currency TEXT DEFAULT "€"`,
+	`ALTER TABLE users ADD COLUMN water_price REAL DEFAULT 0`,
+	`ALTER TABLE users ADD COLUMN energy_price REAL DEFAULT 0`,
+	`ALTER TABLE users ADD COLUMN energy_use REAL DEFAULT 0`,
+	`INSERT INTO lots (ingredient_id, amount) SELECT id, stock FROM ingredients WHERE stock != 0`,
+	`ALTER TABLE deductions ADD COLUMN lot_id INTEGER DEFAULT 0`,
+	`ALTER TABLE lots ADD COLUMN harvest_date TEXT DEFAULT ""`,
+	`ALTER TABLE lots ADD COLUMN storage_temp REAL DEFAULT 20`,
+	`ALTER TABLE lots ADD COLUMN packaging TEXT DEFAULT ""`,
+	`ALTER TABLE recipes ADD COLUMN slug TEXT DEFAULT ""`,
+	`ALTER TABLE recipes ADD COLUMN parent_id INTEGER DEFAULT 0`,
+	`ALTER TABLE recipes ADD COLUMN parent_author TEXT DEFAULT ""`,
+	`ALTER TABLE users ADD COLUMN workspace INTEGER DEFAULT 0`,
+	`ALTER TABLE recipes ADD COLUMN organization_id INTEGER DEFAULT 0`,
+	`ALTER TABLE brews ADD COLUMN organization_id INTEGER DEFAULT 0`,
+	// Ingredient names are unique per owner: rebuild the table to change its
+	// constraint.
+	`
+CREATE TABLE ingredients_owned (
+	id INTEGER PRIMARY KEY,
+	user_id INTEGER NOT NULL,
+	name TEXT NOT NULL,
+	type TEXT NOT NULL,
+	link TEXT DEFAULT "",
+	file TEXT NOT NULL,
+	stock REAL DEFAULT 0,
+	unit TEXT DEFAULT "kg",
+	price REAL DEFAULT 0,
+	package REAL DEFAULT 0,
+	organization_id INTEGER DEFAULT 0,
+	--
+	CONSTRAINT tuple UNIQUE (user_id, organization_id, name, type)
+);
+INSERT INTO ingredients_owned SELECT *, 0 FROM ingredients;
+DROP TABLE ingredients;
+ALTER TABLE ingredients_owned RENAME TO ingredients
+`,
+	`ALTER TABLE recipes ADD COLUMN folder TEXT DEFAULT ""`,
+	// Summary columns. The style of recipes, and the name of brews, are
+	// NULL until summarized.
+	`ALTER TABLE recipes ADD COLUMN style TEXT`,
+	`ALTER TABLE recipes ADD COLUMN type TEXT DEFAULT ""`,
+	`ALTER TABLE recipes ADD COLUMN version INTEGER DEFAULT 0`,
+	`ALTER TABLE recipes ADD COLUMN og REAL DEFAULT 0`,
+	`ALTER TABLE recipes ADD COLUMN fg REAL DEFAULT 0`,
+	`ALTER TABLE recipes ADD COLUMN abv REAL DEFAULT 0`,
+	`ALTER TABLE recipes ADD COLUMN ibu REAL DEFAULT 0`,
+	`ALTER TABLE recipes ADD COLUMN color REAL DEFAULT 0`,
+	`ALTER TABLE brews ADD COLUMN name TEXT`,
+	`ALTER TABLE brews ADD COLUMN date TEXT DEFAULT ""`,
+	`ALTER TABLE readings ADD COLUMN battery REAL DEFAULT 0`,
+	`ALTER TABLE readings ADD COLUMN angle REAL DEFAULT 0`,
+	`ALTER TABLE readings ADD COLUMN device_id INTEGER DEFAULT 0`,
+	`ALTER TABLE users ADD COLUMN totp_secret TEXT DEFAULT ""`,
+	`ALTER TABLE users ADD COLUMN totp_step INTEGER DEFAULT 0`,
+	`ALTER TABLE users ADD COLUMN recovery_codes TEXT DEFAULT ""`,
+	// Revision summaries, so the history doesn't need their XML. The
+	// changes are NULL until computed.
+	`ALTER TABLE revisions ADD COLUMN changes TEXT`,
+	`ALTER TABLE revisions ADD COLUMN og REAL DEFAULT 0`,
+	`ALTER TABLE revisions ADD COLUMN fg REAL DEFAULT 0`,
+	`ALTER TABLE revisions ADD COLUMN abv REAL DEFAULT 0`,
+	`ALTER TABLE revisions ADD COLUMN ibu REAL DEFAULT 0`,
+	`ALTER TABLE revisions ADD COLUMN color REAL DEFAULT 0`,
+	// Missing temperatures were stored as 0 °C, and are now NULL.
+	`UPDATE readings SET temperature = NULL WHERE temperature == 0`,
+	// Calibrations were expected to give specific gravities, and gravities
+	// of uncalibrated iSpindels to be in °P.
+	`ALTER TABLE devices ADD COLUMN gravity_unit TEXT DEFAULT "sg"`,
+	`UPDATE devices SET gravity_unit = "plato" WHERE kind == "ispindel" AND calibration == ""`,
+	`ALTER TABLE lots ADD COLUMN supplier TEXT DEFAULT ""`,
+	`ALTER TABLE recipes ADD COLUMN parent_user_id INTEGER DEFAULT 0`,
+	`ALTER TABLE recipes ADD COLUMN parent_organization_id INTEGER DEFAULT 0`,
 }
 
 // Open a database, and create it if it does not exists.
@@ -95,6 +353,19 @@ func Open(rootdir string) (*DB, error) {
 		}
 	}
 
+	// Apply the migrations not applied yet.
+	var version int
+	if err := db.QueryRow("PRAGMA user_version").Scan(&version); err != nil {
+		db.Close()
+		return nil, err
+	}
+	for ; version < len(migrations); version++ {
+		if err := migrate(db, version); err != nil {
+			db.Close()
+			return nil, err
+		}
+	}
+
 	// Seed the rand source for token generation.
 	rand.Seed(time.Now().UnixNano())
 
@@ -104,12 +375,116 @@ func Open(rootdir string) (*DB, error) {
 		return nil, err
 	}
 
-	d := &DB{ db, &xml.Styles, rootdir, nil }
+	d := &DB{ db, &xml.Styles, rootdir, nil, &catalog{}, newDocCache(),
+		  make(chan struct{}, runtime.NumCPU()), false }
 	d.salt = d.LoadKey("salt.db", 32)
 
+	// Summarize the recipes, brews and revisions created before summary
+	// columns were introduced.
+	if err := d.summarize(); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	// Create the full-text search index when SQLite supports it, and index
+	// the recipes not in it yet (e.g. the ones created before it was
+	// introduced).
+	if err := d.openFTS(); err != nil {
+		db.Close()
+		return nil, err
+	}
+	if err := d.indexRecipes(); err != nil {
+		db.Close()
+		return nil, err
+	}
+
 	return d, nil
 }
 
+// Apply a migration, and record it in the database user_version. Both are
+// done in a single transaction, so an interrupted migration is applied again
+// as a whole.
+func migrate(db *sql.DB, version int) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(migrations[version]); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(fmt.Sprintf("PRAGMA user_version = %d", version + 1)); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Fill the summary columns of the recipes, brews and revisions not summarized
+// yet.
+func (db *DB) summarize() error {
+	recipes, err := db.getFiles("SELECT id, file FROM recipes WHERE style IS NULL")
+	if err != nil {
+		return err
+	}
+	for id, file := range recipes {
+		r := Recipe{ Id: id }
+		if err := db.importXML(file, &r.XML); err != nil {
+			return err
+		}
+
+		r.summarize()
+		_, err := db.Exec(`
+UPDATE recipes SET style = ?, type = ?, version = ?, og = ?, fg = ?, abv = ?, ibu = ?, color = ?
+WHERE id == ?`, r.Style, r.Type, r.Version, r.OG, r.FG, r.ABV, r.IBU, r.Color, r.Id)
+		if err != nil {
+			return err
+		}
+	}
+
+	brews, err := db.getFiles("SELECT id, file FROM brews WHERE name IS NULL")
+	if err != nil {
+		return err
+	}
+	for id, file := range brews {
+		var xml *beerxml.Recipe
+		if err := db.importXML(file, &xml); err != nil {
+			return err
+		}
+
+		_, err := db.Exec("UPDATE brews SET name = ?, date = ? WHERE id == ?",
+				  xml.Name, xml.Date, id)
+		if err != nil {
+			return err
+		}
+	}
+
+	return db.summarizeRevisions()
+}
+
+// Retrieve a list of (id, file) pairs.
+func (db *DB) getFiles(query string, args ...interface{}) (map[int64]string, error) {
+	row, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer row.Close()
+
+	files := make(map[int64]string)
+	for row.Next() {
+		var id int64
+		var file string
+		if err := row.Scan(&id, &file); err != nil {
+			return nil, err
+		}
+
+		files[id] = file
+	}
+
+	return files, nil
+}
+
 // Generate a new uniq filename, and create it in $rootdir/
 func (db *DB) newUniqFile() (string, error) {
 	var file, subdir string
@@ -145,8 +520,3 @@ func GenToken(sz int) string {
 	}
 	return string(token)
 }
-
-// Import a BeerXML file associated with a DB entry.
-func (db *DB) importXML(file string, XML interface{}) error {
-	return beerxml.ImportFile(path.Join(db.rootdir, file), XML)
-}
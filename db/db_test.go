@@ -0,0 +1,59 @@
+// Copyright (C) 2019 Antoine Tenart <antoine.tenart@ack.tf>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+
+package db
+
+import (
+	"os"
+	"path"
+	"testing"
+)
+
+// Open a new database in a temporary directory.
+func openTestDB(t *testing.T) *DB {
+	t.Helper()
+
+	dir := t.TempDir()
+	styles := `<?xml version="1.0" encoding="UTF-8"?><BEERXML><STYLES></STYLES></BEERXML>`
+	if err := os.WriteFile(path.Join(dir, "styles.xml"), []byte(styles), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	db, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func TestOpenMigrations(t *testing.T) {
+	db := openTestDB(t)
+
+	var version int
+	if err := db.QueryRow("PRAGMA user_version").Scan(&version); err != nil {
+		t.Fatal(err)
+	}
+	if version != len(migrations) {
+		t.Errorf("user_version = %d, want %d", version, len(migrations))
+	}
+
+	// Opening again must not apply the migrations twice.
+	db.Close()
+	if _, err := Open(db.rootdir); err != nil {
+		t.Fatal(err)
+	}
+}
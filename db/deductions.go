@@ -0,0 +1,214 @@
+// Copyright (C) 2019 Antoine Tenart <antoine.tenart@ack.tf>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package db
+
+import (
+	"database/sql"
+	"math"
+)
+
+// Retrieve the deductions made from the inventory for a given brew.
+func (db *DB) GetBrewDeductions(bid int64) ([]*Deduction, error) {
+	row, err := db.Query("SELECT * FROM deductions WHERE brew_id == ?", bid)
+	if err != nil {
+		return nil, err
+	}
+	defer row.Close()
+
+	var deductions []*Deduction
+	for row.Next() {
+		var d Deduction
+		if err := row.Scan(&d.Id, &d.BrewId, &d.IngredientId, &d.Amount, &d.LotId); err != nil {
+			return nil, err
+		}
+
+		deductions = append(deductions, &d)
+	}
+
+	return deductions, nil
+}
+
+// Deduct amounts of ingredients from the inventory for a brew. Lots are picked
+// first in, first out; when the stock is insufficient the most recent lot goes
+// negative. The deductions are recorded, per lot, so they can be reverted later
+// on.
+func (db *DB) DeductIngredients(deductions []*Deduction) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+
+	if err := deduct(tx, deductions); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Deduct amounts of ingredients from the inventory, within a transaction.
+func deduct(tx *sql.Tx, deductions []*Deduction) error {
+	for _, d := range deductions {
+		if err := deductLots(tx, d); err != nil {
+			return err
+		}
+
+		if err := updateStock(tx, d.IngredientId); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Retrieve the lots of an ingredient, within a transaction.
+func txIngredientLots(tx *sql.Tx, iid int64) ([]*Lot, error) {
+	row, err := tx.Query("SELECT * FROM lots WHERE ingredient_id == ? " + lotsFIFO, iid)
+	if err != nil {
+		return nil, err
+	}
+	defer row.Close()
+
+	var lots []*Lot
+	for row.Next() {
+		var l Lot
+		if err := scanLot(row, &l); err != nil {
+			return nil, err
+		}
+		lots = append(lots, &l)
+	}
+
+	return lots, nil
+}
+
+// Deduct an amount of ingredient from its lots, or from the given ones.
+func deductLots(tx *sql.Tx, d *Deduction) error {
+	lots, err := txIngredientLots(tx, d.IngredientId)
+	if err != nil {
+		return err
+	}
+
+	if len(d.Lots) > 0 {
+		var selected []*Lot
+		for _, l := range lots {
+			for _, id := range d.Lots {
+				if l.Id == id {
+					selected = append(selected, l)
+				}
+			}
+		}
+
+		// The lots were deleted meanwhile: use the others.
+		if len(selected) > 0 {
+			lots = selected
+		}
+	}
+
+	// Make sure there is at least one lot to deduct from.
+	if len(lots) == 0 {
+		l := &Lot{ IngredientId: d.IngredientId }
+		if err := insertLot(tx, l); err != nil {
+			return err
+		}
+		lots = append(lots, l)
+	}
+
+	left := d.Amount
+	for k, l := range lots {
+		amount := math.Min(left, math.Max(l.Amount, 0))
+		if k == len(lots) - 1 {
+			amount = left
+		}
+		if amount <= 0 {
+			continue
+		}
+
+		if _, err := tx.Exec("UPDATE lots SET amount = amount - ? WHERE id == ?",
+				     amount, l.Id); err != nil {
+			return err
+		}
+
+		_, err = tx.Exec(`
+INSERT INTO deductions (brew_id, ingredient_id, amount, lot_id)
+VALUES (?, ?, ?, ?)`, d.BrewId, d.IngredientId, amount, l.Id)
+		if err != nil {
+			return err
+		}
+
+		left -= amount
+	}
+
+	return nil
+}
+
+// Revert all the deductions made for a brew: the amounts are added back to the
+// lots they were taken from (if the ingredients still exist). Deductions made
+// before lots were introduced are added back to the most recent lot.
+func (db *DB) RevertBrewDeductions(bid int64) error {
+	deductions, err := db.GetBrewDeductions(bid)
+	if err != nil {
+		return err
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+
+	for _, d := range deductions {
+		if err := revertDeduction(tx, d); err != nil {
+			tx.Rollback()
+			return err
+		}
+
+		if err := updateStock(tx, d.IngredientId); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	if _, err := tx.Exec("DELETE FROM deductions WHERE brew_id == ?", bid); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Add a deducted amount back to its lot.
+func revertDeduction(tx *sql.Tx, d *Deduction) error {
+	lid := d.LotId
+	if lid == 0 {
+		lots, err := txIngredientLots(tx, d.IngredientId)
+		if err != nil {
+			return err
+		}
+
+		// The ingredient was removed.
+		if len(lots) == 0 {
+			return nil
+		}
+		lid = lots[len(lots) - 1].Id
+	}
+
+	_, err := tx.Exec("UPDATE lots SET amount = amount + ? WHERE id == ?", d.Amount, lid)
+	return err
+}
+
+// Forget the deductions made for a brew, without reverting them.
+func (db *DB) DeleteBrewDeductions(bid int64) error {
+	_, err := db.Exec("DELETE FROM deductions WHERE brew_id == ?", bid)
+	return err
+}
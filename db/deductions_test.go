@@ -0,0 +1,194 @@
+// Copyright (C) 2019 Antoine Tenart <antoine.tenart@ack.tf>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+
+package db
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/atenart/bubbles/beerxml"
+)
+
+func TestDeductIngredients(t *testing.T) {
+	db := openTestDB(t)
+
+	tests := []struct {
+		name   string
+		lots   []float64 // Oldest first.
+		only   []int     // Indexes of the lots to deduct from, if not all.
+		amount float64
+		want   []float64
+		revert []float64 // Lots once reverted.
+	}{
+		{ "first lot", []float64{ 2, 3 }, nil, 1, []float64{ 1, 3 }, []float64{ 2, 3 } },
+		{ "whole lot", []float64{ 2, 3 }, nil, 2, []float64{ 0, 3 }, []float64{ 2, 3 } },
+		{ "across lots", []float64{ 2, 3 }, nil, 4, []float64{ 0, 1 }, []float64{ 2, 3 } },
+		{ "insufficient", []float64{ 2, 3 }, nil, 6, []float64{ 0, -1 }, []float64{ 2, 3 } },
+		{ "negative lot", []float64{ -1, 3 }, nil, 2, []float64{ -1, 1 }, []float64{ -1, 3 } },
+		{ "no lot", nil, nil, 1, []float64{ -1 }, []float64{ 0 } },
+		{ "given lot", []float64{ 2, 3, 4 }, []int{ 1 }, 2, []float64{ 2, 1, 4 }, []float64{ 2, 3, 4 } },
+		{ "given lots", []float64{ 2, 3, 4 }, []int{ 0, 2 }, 8, []float64{ 0, 3, -2 },
+		  []float64{ 2, 3, 4 } },
+	}
+
+	for k, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			i := &Ingredient{
+				UserId: 1,
+				Name:   tt.name,
+				Type:   "hop",
+				XML:    &beerxml.Hop{ Name: tt.name },
+			}
+			if err := db.AddIngredient(i); err != nil {
+				t.Fatal(err)
+			}
+			var ids []int64
+			for n, amount := range tt.lots {
+				lot := &Lot{
+					IngredientId: i.Id,
+					Amount:       amount,
+					PurchaseDate: fmt.Sprintf("2020-01-%02d", n + 1),
+				}
+				if err := db.AddLot(lot); err != nil {
+					t.Fatal(err)
+				}
+				ids = append(ids, lot.Id)
+			}
+
+			var only []int64
+			for _, n := range tt.only {
+				only = append(only, ids[n])
+			}
+
+			brew := int64(k + 1)
+			err := db.DeductIngredients([]*Deduction{
+				{ BrewId: brew, IngredientId: i.Id, Amount: tt.amount, Lots: only },
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkLots(t, db, i.Id, tt.want)
+
+			if err := db.RevertBrewDeductions(brew); err != nil {
+				t.Fatal(err)
+			}
+			checkLots(t, db, i.Id, tt.revert)
+
+			deductions, err := db.GetBrewDeductions(brew)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(deductions) != 0 {
+				t.Errorf("%d deductions left after the revert", len(deductions))
+			}
+		})
+	}
+}
+
+// Check the amounts of the lots of an ingredient, and its stock.
+func checkLots(t *testing.T, db *DB, iid int64, want []float64) {
+	t.Helper()
+
+	lots, err := db.GetIngredientLots(iid)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var amounts []float64
+	var sum float64
+	for _, l := range lots {
+		amounts = append(amounts, l.Amount)
+		sum += l.Amount
+	}
+	if !reflect.DeepEqual(amounts, want) {
+		t.Errorf("lots = %v, want %v", amounts, want)
+	}
+
+	i, err := db.GetIngredient(iid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if i.Stock != sum {
+		t.Errorf("stock = %g, want %g", i.Stock, sum)
+	}
+}
+
+func TestStartBrew(t *testing.T) {
+	db := openTestDB(t)
+
+	i := &Ingredient{
+		UserId: 1,
+		Name:   "Saaz",
+		Type:   "hop",
+		XML:    &beerxml.Hop{ Name: "Saaz" },
+	}
+	if err := db.AddIngredient(i); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.AddLot(&Lot{ IngredientId: i.Id, Amount: 10 }); err != nil {
+		t.Fatal(err)
+	}
+
+	id, err := db.AddBrew(&Brew{ UserId: 1, XML: &beerxml.Recipe{ Name: "Pils" } })
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Start the same brew several times at once, as concurrent or retried
+	// submissions would: the ingredients must only be deducted once.
+	results := make(chan error)
+	for n := 0; n < 4; n++ {
+		go func() {
+			b, err := db.GetBrew(id)
+			if err == nil {
+				b.XML = &beerxml.Recipe{ Name: "Pils" }
+				err = db.StartBrew(b, []*Deduction{
+					{ BrewId: id, IngredientId: i.Id, Amount: 3 },
+				})
+			}
+			results <- err
+		}()
+	}
+
+	started := 0
+	for n := 0; n < 4; n++ {
+		if err := <-results; err == nil {
+			started++
+		}
+	}
+	if started != 1 {
+		t.Errorf("brew started %d times, want once", started)
+	}
+	checkLots(t, db, i.Id, []float64{ 7 })
+
+	// The brew moved to the next step.
+	b, err := db.GetBrew(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b.Step != StepMash {
+		t.Errorf("step = %d, want %d", b.Step, StepMash)
+	}
+
+	b.XML = &beerxml.Recipe{ Name: "Pils" }
+	b.Step = StepPrepare
+	if err := db.StartBrew(b, []*Deduction{ { BrewId: id, IngredientId: i.Id, Amount: 3 } }); err != ErrBrewStarted {
+		t.Errorf("second start: %v, want %v", err, ErrBrewStarted)
+	}
+	checkLots(t, db, i.Id, []float64{ 7 })
+}
@@ -0,0 +1,152 @@
+// Copyright (C) 2019 Antoine Tenart <antoine.tenart@ack.tf>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+
+package db
+
+import (
+	"database/sql"
+	"strconv"
+	"strings"
+)
+
+// Prefix of the hydrometer tokens.
+const devicePrefix = "bbd_"
+
+// Owner of a device.
+func (d *Device) Owner() Owner {
+	return Owner{ d.UserId, d.OrganizationId }
+}
+
+// Retrieve a list of devices.
+func (db *DB) getDevices(query string, args ...interface{}) ([]*Device, error) {
+	row, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer row.Close()
+
+	var devices []*Device
+	for row.Next() {
+		var d Device
+		var calibration string
+		err := row.Scan(&d.Id, &d.UserId, &d.OrganizationId, &d.Name, &d.Kind, &d.Hash,
+				&calibration, &d.BrewId, &d.StableDays, &d.StableDelta,
+				&d.AlertedBrew, &d.LastSeen, &d.Battery, &d.GravityUnit)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, c := range strings.Fields(calibration) {
+			f, err := strconv.ParseFloat(c, 64)
+			if err != nil {
+				return nil, err
+			}
+			d.Calibration = append(d.Calibration, f)
+		}
+		devices = append(devices, &d)
+	}
+
+	return devices, nil
+}
+
+// Retrieve the devices of a given owner.
+func (db *DB) GetDevices(o Owner) ([]*Device, error) {
+	return db.getDevices("SELECT * FROM devices WHERE user_id == ? AND organization_id == ? ORDER BY id",
+			     o.UserId, o.OrganizationId)
+}
+
+// Retrieve a device given its id.
+func (db *DB) GetDevice(id int64) (*Device, error) {
+	devices, err := db.getDevices("SELECT * FROM devices WHERE id == ?", id)
+	if err != nil {
+		return nil, err
+	} else if len(devices) == 0 {
+		return nil, sql.ErrNoRows
+	}
+	return devices[0], nil
+}
+
+// Retrieve a device given its plaintext token.
+func (db *DB) GetDeviceBySecret(secret string) (*Device, error) {
+	devices, err := db.getDevices("SELECT * FROM devices WHERE hash == ?", HashToken(secret))
+	if err != nil {
+		return nil, err
+	} else if len(devices) == 0 {
+		return nil, sql.ErrNoRows
+	}
+	return devices[0], nil
+}
+
+// Add a new device. Returns its plaintext token, which is not stored and can't
+// be retrieved later.
+func (db *DB) AddDevice(d *Device) (string, error) {
+	secret, err := genSecret(devicePrefix)
+	if err != nil {
+		return "", err
+	}
+
+	d.Hash = HashToken(secret)
+	result, err := db.Exec(`
+INSERT INTO devices (user_id, organization_id, name, kind, hash, calibration, brew_id, stable_days,
+		     stable_delta, gravity_unit)
+VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`, d.UserId, d.OrganizationId, d.Name, d.Kind, d.Hash,
+		d.calibration(), d.BrewId, d.StableDays, d.StableDelta, d.GravityUnit)
+	if err != nil {
+		return "", err
+	}
+
+	d.Id, err = result.LastInsertId()
+	return secret, err
+}
+
+// Update a device.
+func (db *DB) UpdateDevice(d *Device) error {
+	_, err := db.Exec(`
+UPDATE devices SET name = ?, calibration = ?, brew_id = ?, stable_days = ?, stable_delta = ?,
+		   alerted_brew = ?, last_seen = ?, battery = ?, gravity_unit = ?
+WHERE id == ?`, d.Name, d.calibration(), d.BrewId, d.StableDays, d.StableDelta, d.AlertedBrew,
+		d.LastSeen, d.Battery, d.GravityUnit, d.Id)
+	return err
+}
+
+// Delete a device. Its readings are kept.
+func (db *DB) DeleteDevice(id int64) error {
+	_, err := db.Exec("DELETE FROM devices WHERE id == ?", id)
+	return err
+}
+
+// Serialize the calibration polynomial of a device.
+func (d *Device) calibration() string {
+	var coeffs []string
+	for _, c := range d.Calibration {
+		coeffs = append(coeffs, strconv.FormatFloat(c, 'g', -1, 64))
+	}
+	return strings.Join(coeffs, " ")
+}
+
+// Evaluate the calibration polynomial of a device for a given input. Devices
+// without calibration return their input as-is.
+func (d *Device) Calibrate(x float64) float64 {
+	if len(d.Calibration) == 0 {
+		return x
+	}
+
+	var y float64
+	for _, c := range d.Calibration {
+		y = y*x + c
+	}
+	return y
+}
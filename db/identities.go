@@ -0,0 +1,40 @@
+// Copyright (C) 2019 Antoine Tenart <antoine.tenart@ack.tf>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+
+package db
+
+// Retrieve an identity given its provider and subject.
+func (db *DB) GetIdentity(issuer, subject string) (*Identity, error) {
+	var i Identity
+	err := db.QueryRow("SELECT * FROM identities WHERE issuer == ? AND subject == ?", issuer, subject).
+		Scan(&i.Id, &i.UserId, &i.Issuer, &i.Subject)
+	if err != nil {
+		return nil, err
+	}
+	return &i, nil
+}
+
+// Link an identity to an user.
+func (db *DB) AddIdentity(i *Identity) error {
+	result, err := db.Exec("INSERT INTO identities (user_id, issuer, subject) VALUES (?, ?, ?)",
+			       i.UserId, i.Issuer, i.Subject)
+	if err != nil {
+		return err
+	}
+
+	i.Id, err = result.LastInsertId()
+	return err
+}
@@ -23,35 +23,41 @@ import (
 	"github.com/atenart/bubbles/beerxml"
 )
 
-// Retrieve a single ingredient given its id.
-func (db *DB) GetIngredient(id int64) (*Ingredient, error) {
-	var i Ingredient
-	err := db.QueryRow("SELECT * FROM ingredients WHERE id == $1", id).
-		Scan(&i.Id, &i.UserId, &i.Name, &i.Type, &i.Link, &i.File)
+// Scan an ingredient from a row, and import its XML file.
+func (db *DB) scanIngredient(row interface{ Scan(...interface{}) error }, i *Ingredient) error {
+	err := row.Scan(&i.Id, &i.UserId, &i.Name, &i.Type, &i.Link, &i.File, &i.Stock, &i.Unit,
+			&i.Price, &i.Package, &i.OrganizationId)
 	if err != nil {
-		return nil, err
+		return err
 	}
 
-	if err := db.importIngredientXML(&i); err != nil {
+	return db.importIngredientXML(i)
+}
+
+// Retrieve a single ingredient given its id.
+func (db *DB) GetIngredient(id int64) (*Ingredient, error) {
+	var i Ingredient
+	row := db.QueryRow("SELECT * FROM ingredients WHERE id == $1", id)
+	if err := db.scanIngredient(row, &i); err != nil {
 		return nil, err
 	}
 
 	return &i, nil
 }
 
-// Retrieve the ingredients for a given user.
-func (db *DB) GetUserIngredients(uid int64) ([]*Ingredient, error) {
-	row, err := db.Query("SELECT * FROM ingredients WHERE user_id == ?", uid)
+// Retrieve the ingredients of a given owner.
+func (db *DB) GetIngredients(o Owner) ([]*Ingredient, error) {
+	row, err := db.Query("SELECT * FROM ingredients WHERE user_id == ? AND organization_id == ?",
+			     o.UserId, o.OrganizationId)
 	if err != nil {
 		return nil, err
 	}
+	defer row.Close()
 
 	var ingredients []*Ingredient
 	for row.Next() {
 		var i Ingredient
-		row.Scan(&i.Id, &i.UserId, &i.Name, &i.Type, &i.Link, &i.File)
-
-		if err := db.importIngredientXML(&i); err != nil {
+		if err := db.scanIngredient(row, &i); err != nil {
 			return nil, err
 		}
 
@@ -91,41 +97,72 @@ func (db *DB) importIngredientXML(i *Ingredient) error {
 // Add a new ingredient.
 func (db *DB) AddIngredient(i *Ingredient) error {
 	var err error
+	if i.Unit == "" {
+		i.Unit = "kg"
+	}
+
 	if i.File, err = db.newUniqFile(); err != nil {
 		return err
 	}
 
-	_, err = db.Exec(`
-INSERT INTO ingredients (user_id, name, type, link, file)
-VALUES (?, ?, ?, ?, ?)`, i.UserId, i.Name, i.Type, i.Link, i.File)
+	result, err := db.Exec(`
+INSERT INTO ingredients (user_id, name, type, link, file, stock, unit, price, package,
+			 organization_id)
+VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`, i.UserId, i.Name, i.Type, i.Link, i.File, i.Stock, i.Unit,
+		i.Price, i.Package, i.OrganizationId)
 	if err != nil {
 		os.Remove(i.File)
 		return err
 	}
 
+	if i.Id, err = result.LastInsertId(); err != nil {
+		return err
+	}
+
+	// The initial stock makes the first lot.
+	if i.Stock != 0 {
+		if err := db.AddLot(&Lot{ IngredientId: i.Id, Amount: i.Stock }); err != nil {
+			return err
+		}
+	}
+
 	return beerxml.ExportFile(i.XML, path.Join(db.rootdir, i.File))
 }
 
 // Update an ingredient.
 func (db *DB) UpdateIngredient(i *Ingredient) error {
 	_, err := db.Exec(`
-REPLACE INTO ingredients (id, user_id, name, type, link, file)
-VALUES (?, ?, ?, ?, ?, ?)`, i.Id, i.UserId, i.Name, i.Type, i.Link, i.File)
+REPLACE INTO ingredients (id, user_id, name, type, link, file, stock, unit, price, package,
+			  organization_id)
+VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`, i.Id, i.UserId, i.Name, i.Type, i.Link, i.File,
+		i.Stock, i.Unit, i.Price, i.Package, i.OrganizationId)
 	if err != nil {
 		return err
 	}
 
+	// The cached document is outdated.
+	db.docs.forget(i.File)
+
 	return beerxml.ExportFile(i.XML, path.Join(db.rootdir, i.File))
 }
 
 // Delete an ingredient.
 func (db *DB) DeleteIngredient(i *Ingredient) error {
-	// First, remove the db entry
+	// First, remove the price history and the lots.
+	if err := db.DeleteIngredientPrices(i.Id); err != nil {
+		return err
+	}
+	if err := db.DeleteIngredientLots(i.Id); err != nil {
+		return err
+	}
+
+	// Then, remove the db entry.
 	if _, err := db.Exec("DELETE FROM ingredients WHERE id == ?", i.Id); err != nil {
 		return err
 	}
 
 	// Then, remove the recipe XML file.
+	db.docs.forget(i.File)
 	if err := os.Remove(path.Join(db.rootdir, i.File)); err != nil {
 		return err
 	}
@@ -0,0 +1,166 @@
+// Copyright (C) 2019 Antoine Tenart <antoine.tenart@ack.tf>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package db
+
+import (
+	"database/sql"
+)
+
+// Order in which lots are used: first in, first out.
+const lotsFIFO = "ORDER BY purchase_date, id"
+
+// Scan a lot from a row.
+func scanLot(row interface{ Scan(...interface{}) error }, l *Lot) error {
+	return row.Scan(&l.Id, &l.IngredientId, &l.Amount, &l.Code, &l.PurchaseDate,
+			&l.BestBefore, &l.Alpha, &l.Color, &l.Yield, &l.HarvestDate,
+			&l.StorageTemp, &l.Packaging, &l.Supplier)
+}
+
+// Retrieve a list of lots.
+func (db *DB) getLots(query string, args ...interface{}) ([]*Lot, error) {
+	row, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer row.Close()
+
+	var lots []*Lot
+	for row.Next() {
+		var l Lot
+		if err := scanLot(row, &l); err != nil {
+			return nil, err
+		}
+
+		lots = append(lots, &l)
+	}
+
+	return lots, nil
+}
+
+// Retrieve a single lot given its id.
+func (db *DB) GetLot(id int64) (*Lot, error) {
+	var l Lot
+	if err := scanLot(db.QueryRow("SELECT * FROM lots WHERE id == $1", id), &l); err != nil {
+		return nil, err
+	}
+
+	return &l, nil
+}
+
+// Retrieve the lots of a given ingredient, in the order they are used.
+func (db *DB) GetIngredientLots(iid int64) ([]*Lot, error) {
+	return db.getLots("SELECT * FROM lots WHERE ingredient_id == ? " + lotsFIFO, iid)
+}
+
+// Retrieve the lots of all the ingredients of a given owner, in the order they
+// are used.
+func (db *DB) GetLots(o Owner) ([]*Lot, error) {
+	return db.getLots(`
+SELECT lots.* FROM lots
+JOIN ingredients ON ingredients.id == lots.ingredient_id
+WHERE ingredients.user_id == ? AND ingredients.organization_id == ? ` + lotsFIFO,
+			  o.UserId, o.OrganizationId)
+}
+
+// Retrieve the lots of a given owner, still in stock, reaching their
+// best-before date before a given date (YYYY-MM-DD).
+func (db *DB) GetExpiringLots(o Owner, date string) ([]*Lot, error) {
+	return db.getLots(`
+SELECT lots.* FROM lots
+JOIN ingredients ON ingredients.id == lots.ingredient_id
+WHERE ingredients.user_id == ? AND ingredients.organization_id == ? AND lots.amount > 0 AND
+      lots.best_before != "" AND lots.best_before <= ?
+ORDER BY lots.best_before, lots.id`, o.UserId, o.OrganizationId, date)
+}
+
+// Update the stock of an ingredient, given its lots.
+func updateStock(tx *sql.Tx, iid int64) error {
+	_, err := tx.Exec(`
+UPDATE ingredients SET stock = (
+	SELECT IFNULL(SUM(amount), 0) FROM lots WHERE ingredient_id == ?
+) WHERE id == ?`, iid, iid)
+	return err
+}
+
+// Run a function in a transaction, then update the stock of an ingredient.
+func (db *DB) withStock(iid int64, f func(tx *sql.Tx) error) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+
+	if err := f(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if err := updateStock(tx, iid); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Insert a new lot.
+func insertLot(tx *sql.Tx, l *Lot) error {
+	result, err := tx.Exec(`
+INSERT INTO lots (ingredient_id, amount, code, purchase_date, best_before, alpha, color, yield,
+		  harvest_date, storage_temp, packaging, supplier)
+VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`, l.IngredientId, l.Amount, l.Code, l.PurchaseDate,
+		l.BestBefore, l.Alpha, l.Color, l.Yield, l.HarvestDate, l.StorageTemp, l.Packaging,
+		l.Supplier)
+	if err != nil {
+		return err
+	}
+
+	l.Id, err = result.LastInsertId()
+	return err
+}
+
+// Add a new lot.
+func (db *DB) AddLot(l *Lot) error {
+	return db.withStock(l.IngredientId, func(tx *sql.Tx) error {
+		return insertLot(tx, l)
+	})
+}
+
+// Update a lot.
+func (db *DB) UpdateLot(l *Lot) error {
+	return db.withStock(l.IngredientId, func(tx *sql.Tx) error {
+		_, err := tx.Exec(`
+REPLACE INTO lots (id, ingredient_id, amount, code, purchase_date, best_before, alpha, color, yield,
+		   harvest_date, storage_temp, packaging, supplier)
+VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`, l.Id, l.IngredientId, l.Amount, l.Code,
+			l.PurchaseDate, l.BestBefore, l.Alpha, l.Color, l.Yield, l.HarvestDate,
+			l.StorageTemp, l.Packaging, l.Supplier)
+		return err
+	})
+}
+
+// Delete a lot.
+func (db *DB) DeleteLot(l *Lot) error {
+	return db.withStock(l.IngredientId, func(tx *sql.Tx) error {
+		_, err := tx.Exec("DELETE FROM lots WHERE id == ?", l.Id)
+		return err
+	})
+}
+
+// Delete all the lots of a given ingredient.
+func (db *DB) DeleteIngredientLots(iid int64) error {
+	_, err := db.Exec("DELETE FROM lots WHERE ingredient_id == ?", iid)
+	return err
+}
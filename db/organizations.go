@@ -0,0 +1,228 @@
+// Copyright (C) 2019 Antoine Tenart <antoine.tenart@ack.tf>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package db
+
+// Owner of a recipe.
+func (r *Recipe) Owner() Owner {
+	return Owner{ r.UserId, r.OrganizationId }
+}
+
+// Owner of an ingredient.
+func (i *Ingredient) Owner() Owner {
+	return Owner{ i.UserId, i.OrganizationId }
+}
+
+// Owner of a brew.
+func (b *Brew) Owner() Owner {
+	return Owner{ b.UserId, b.OrganizationId }
+}
+
+// Retrieve a single organization given its id.
+func (db *DB) GetOrganization(id int64) (*Organization, error) {
+	var o Organization
+	err := db.QueryRow("SELECT * FROM organizations WHERE id == $1", id).Scan(&o.Id, &o.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	return &o, nil
+}
+
+// Add a new organization, owned by a given user.
+func (db *DB) AddOrganization(o *Organization, uid int64) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+
+	result, err := tx.Exec("INSERT INTO organizations (name) VALUES (?)", o.Name)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if o.Id, err = result.LastInsertId(); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	_, err = tx.Exec("INSERT INTO members (organization_id, user_id, role) VALUES (?, ?, ?)",
+			 o.Id, uid, RoleOwner)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Update an organization.
+func (db *DB) UpdateOrganization(o *Organization) error {
+	_, err := db.Exec("UPDATE organizations SET name = ? WHERE id == ?", o.Name, o.Id)
+	return err
+}
+
+// Delete an organization, with all its recipes, ingredients and brews.
+func (db *DB) DeleteOrganization(o *Organization) error {
+	owner := Owner{ OrganizationId: o.Id }
+
+	brews, err := db.GetBrews(owner)
+	if err != nil {
+		return err
+	}
+	for _, b := range brews {
+		if err := db.DeleteBrew(b); err != nil {
+			return err
+		}
+	}
+
+	recipes, err := db.GetRecipes(owner)
+	if err != nil {
+		return err
+	}
+	for _, r := range recipes {
+		if err := db.DeleteRecipe(r); err != nil {
+			return err
+		}
+	}
+
+	ingredients, err := db.GetIngredients(owner)
+	if err != nil {
+		return err
+	}
+	for _, i := range ingredients {
+		if err := db.DeleteIngredient(i); err != nil {
+			return err
+		}
+	}
+
+	// Members working in the organization go back to their personal space.
+	_, err = db.Exec("UPDATE users SET workspace = 0 WHERE workspace == ?", o.Id)
+	if err != nil {
+		return err
+	}
+	if _, err := db.Exec("DELETE FROM members WHERE organization_id == ?", o.Id); err != nil {
+		return err
+	}
+	if err := db.deleteWebhooks("organization_id == ?", o.Id); err != nil {
+		return err
+	}
+	if _, err := db.Exec("DELETE FROM devices WHERE organization_id == ?", o.Id); err != nil {
+		return err
+	}
+
+	_, err = db.Exec("DELETE FROM organizations WHERE id == ?", o.Id)
+	return err
+}
+
+// Retrieve a list of memberships.
+func (db *DB) getMembers(query string, args ...interface{}) ([]*Member, error) {
+	row, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer row.Close()
+
+	var members []*Member
+	for row.Next() {
+		var m Member
+		err := row.Scan(&m.Id, &m.OrganizationId, &m.UserId, &m.Role, &m.Email,
+				&m.Organization)
+		if err != nil {
+			return nil, err
+		}
+
+		members = append(members, &m)
+	}
+
+	return members, nil
+}
+
+const membersQuery = `
+SELECT members.*, users.email, organizations.name FROM members
+JOIN users ON users.id == members.user_id
+JOIN organizations ON organizations.id == members.organization_id`
+
+// Retrieve the membership of an user in an organization. Returns
+// sql.ErrNoRows if the user is not a member.
+func (db *DB) GetMember(oid, uid int64) (*Member, error) {
+	var m Member
+	err := db.QueryRow(membersQuery + " WHERE members.organization_id == ? AND members.user_id == ?",
+			   oid, uid).
+		Scan(&m.Id, &m.OrganizationId, &m.UserId, &m.Role, &m.Email, &m.Organization)
+	if err != nil {
+		return nil, err
+	}
+
+	return &m, nil
+}
+
+// Retrieve the memberships of a given user.
+func (db *DB) GetUserMemberships(uid int64) ([]*Member, error) {
+	return db.getMembers(membersQuery + `
+WHERE members.user_id == ? ORDER BY organizations.name`, uid)
+}
+
+// Retrieve the members of a given organization.
+func (db *DB) GetOrganizationMembers(oid int64) ([]*Member, error) {
+	return db.getMembers(membersQuery + `
+WHERE members.organization_id == ? ORDER BY users.email`, oid)
+}
+
+// Add a new member to an organization.
+func (db *DB) AddMember(m *Member) error {
+	result, err := db.Exec(`
+INSERT INTO members (organization_id, user_id, role)
+VALUES (?, ?, ?)`, m.OrganizationId, m.UserId, m.Role)
+	if err != nil {
+		return err
+	}
+
+	m.Id, err = result.LastInsertId()
+	return err
+}
+
+// Update the role of a member. Viewers can't have webhooks on the
+// organization, those of a member becoming one are deleted.
+func (db *DB) UpdateMember(m *Member) error {
+	if _, err := db.Exec("UPDATE members SET role = ? WHERE id == ?", m.Role, m.Id); err != nil {
+		return err
+	}
+
+	if m.Role != RoleViewer {
+		return nil
+	}
+	return db.deleteWebhooks("user_id == ? AND organization_id == ?", m.UserId, m.OrganizationId)
+}
+
+// Remove a member from an organization.
+func (db *DB) DeleteMember(m *Member) error {
+	if _, err := db.Exec("DELETE FROM members WHERE id == ?", m.Id); err != nil {
+		return err
+	}
+
+	// Its webhooks on the organization are not allowed anymore.
+	err := db.deleteWebhooks("user_id == ? AND organization_id == ?", m.UserId, m.OrganizationId)
+	if err != nil {
+		return err
+	}
+
+	// If the user was working in the organization, go back to its personal
+	// space.
+	_, err = db.Exec("UPDATE users SET workspace = 0 WHERE id == ? AND workspace == ?",
+			  m.UserId, m.OrganizationId)
+	return err
+}
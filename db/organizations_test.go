@@ -0,0 +1,78 @@
+// Copyright (C) 2019 Antoine Tenart <antoine.tenart@ack.tf>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+
+package db
+
+import (
+	"testing"
+)
+
+func TestUpdateMemberWebhooks(t *testing.T) {
+	db := openTestDB(t)
+
+	var users []*User
+	for _, email := range []string{ "owner@example.com", "brewer@example.com" } {
+		if err := db.AddUser(email, "", GenToken(32), false); err != nil {
+			t.Fatal(err)
+		}
+		u, err := db.GetUserByEmail(email)
+		if err != nil {
+			t.Fatal(err)
+		}
+		users = append(users, u)
+	}
+	owner, brewer := users[0], users[1]
+
+	o := &Organization{ Name: "Brewery" }
+	if err := db.AddOrganization(o, owner.Id); err != nil {
+		t.Fatal(err)
+	}
+	m := &Member{ OrganizationId: o.Id, UserId: brewer.Id, Role: RoleBrewer }
+	if err := db.AddMember(m); err != nil {
+		t.Fatal(err)
+	}
+
+	webhooks := []*Webhook{
+		{ UserId: brewer.Id, OrganizationId: o.Id, URL: "https://example.com/org" },
+		{ UserId: brewer.Id, URL: "https://example.com/own" },
+	}
+	for _, w := range webhooks {
+		if err := db.AddWebhook(w); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Owners and brewers keep their webhooks.
+	m.Role = RoleOwner
+	if err := db.UpdateMember(m); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.GetWebhook(webhooks[0].Id); err != nil {
+		t.Errorf("webhook of an owner deleted: %v", err)
+	}
+
+	// Viewers don't, but only those on the organization are deleted.
+	m.Role = RoleViewer
+	if err := db.UpdateMember(m); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.GetWebhook(webhooks[0].Id); err == nil {
+		t.Error("webhook of a viewer on the organization kept")
+	}
+	if _, err := db.GetWebhook(webhooks[1].Id); err != nil {
+		t.Errorf("personal webhook deleted: %v", err)
+	}
+}
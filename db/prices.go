@@ -0,0 +1,49 @@
+// Copyright (C) 2019 Antoine Tenart <antoine.tenart@ack.tf>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package db
+
+// Retrieve the price history of a given ingredient, most recent first.
+func (db *DB) GetIngredientPrices(iid int64) ([]*Price, error) {
+	row, err := db.Query("SELECT * FROM prices WHERE ingredient_id == ? ORDER BY date DESC, id DESC", iid)
+	if err != nil {
+		return nil, err
+	}
+	defer row.Close()
+
+	var prices []*Price
+	for row.Next() {
+		var p Price
+		row.Scan(&p.Id, &p.IngredientId, &p.Date, &p.Price, &p.Package)
+
+		prices = append(prices, &p)
+	}
+
+	return prices, nil
+}
+
+// Add a new price to an ingredient history.
+func (db *DB) AddPrice(p *Price) error {
+	_, err := db.Exec(`
+INSERT INTO prices (ingredient_id, price, package)
+VALUES (?, ?, ?)`, p.IngredientId, p.Price, p.Package)
+	return err
+}
+
+// Delete the price history of a given ingredient.
+func (db *DB) DeleteIngredientPrices(iid int64) error {
+	_, err := db.Exec("DELETE FROM prices WHERE ingredient_id == ?", iid)
+	return err
+}
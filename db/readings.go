@@ -0,0 +1,77 @@
+// Copyright (C) 2019 Antoine Tenart <antoine.tenart@ack.tf>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package db
+
+// Retrieve a single reading given its id.
+func (db *DB) GetReading(id int64) (*Reading, error) {
+	var r Reading
+	err := db.QueryRow("SELECT * FROM readings WHERE id == $1", id).
+		Scan(&r.Id, &r.BrewId, &r.Date, &r.Gravity, &r.Temperature, &r.Ph, &r.Note,
+		     &r.Battery, &r.Angle, &r.DeviceId)
+	if err != nil {
+		return nil, err
+	}
+
+	return &r, nil
+}
+
+// Retrieve all the readings of a given brew, in chronological order.
+func (db *DB) GetBrewReadings(bid int64) ([]*Reading, error) {
+	row, err := db.Query("SELECT * FROM readings WHERE brew_id == ? ORDER BY date, id", bid)
+	if err != nil {
+		return nil, err
+	}
+	defer row.Close()
+
+	var readings []*Reading
+	for row.Next() {
+		var r Reading
+		err := row.Scan(&r.Id, &r.BrewId, &r.Date, &r.Gravity, &r.Temperature, &r.Ph, &r.Note,
+				&r.Battery, &r.Angle, &r.DeviceId)
+		if err != nil {
+			return nil, err
+		}
+
+		readings = append(readings, &r)
+	}
+
+	return readings, nil
+}
+
+// Add a new reading.
+func (db *DB) AddReading(r *Reading) (int64, error) {
+	result, err := db.Exec(`
+INSERT INTO readings (brew_id, date, gravity, temperature, ph, note, battery, angle, device_id)
+VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`, r.BrewId, r.Date, r.Gravity, r.Temperature, r.Ph, r.Note,
+		r.Battery, r.Angle, r.DeviceId)
+	if err != nil {
+		return -1, err
+	}
+
+	return result.LastInsertId()
+}
+
+// Delete a reading.
+func (db *DB) DeleteReading(r *Reading) error {
+	_, err := db.Exec("DELETE FROM readings WHERE id == ?", r.Id)
+	return err
+}
+
+// Delete all the readings of a given brew.
+func (db *DB) DeleteBrewReadings(bid int64) error {
+	_, err := db.Exec("DELETE FROM readings WHERE brew_id == ?", bid)
+	return err
+}
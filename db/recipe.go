@@ -16,42 +16,75 @@
 package db
 
 import (
+	"fmt"
+	"math"
 	"os"
 	"path"
+	"strings"
 
 	"github.com/atenart/bubbles/beerxml"
 	_ "github.com/mattn/go-sqlite3"
 )
 
-// Retrives a recipe given its id.
-func (db *DB) GetRecipe(id int64) (*Recipe, error) {
-	var r Recipe
-	err := db.QueryRow("SELECT * FROM recipes WHERE id == $1", id).
-		Scan(&r.Id, &r.UserId, &r.Name, &r.File, &r.Public)
-	if err != nil {
-		return nil, err
+// Scan a recipe from a row, without importing its XML file.
+func scanRecipeSummary(row interface{ Scan(...interface{}) error }, r *Recipe) error {
+	return row.Scan(&r.Id, &r.UserId, &r.Name, &r.File, &r.Public, &r.Slug, &r.ParentId,
+			&r.ParentAuthor, &r.OrganizationId, &r.Folder, &r.Style, &r.Type,
+			&r.Version, &r.OG, &r.FG, &r.ABV, &r.IBU, &r.Color, &r.ParentOwner.UserId,
+			&r.ParentOwner.OrganizationId)
+}
+
+// Scan a recipe from a row, retrieve its tags and import its XML file.
+func (db *DB) scanRecipe(row interface{ Scan(...interface{}) error }, r *Recipe) error {
+	if err := scanRecipeSummary(row, r); err != nil {
+		return err
 	}
 
-	if err := db.importXML(r.File, &r.XML); err != nil {
-		return nil, err
+	var err error
+	if r.Tags, err = db.GetRecipeTags(r.Id); err != nil {
+		return err
 	}
 
-	return &r, nil
+	return db.importXML(r.File, &r.XML)
 }
 
-// Retrieves all recipes for a given user.
-func (db *DB) GetUserRecipes(uid int64) ([]*Recipe, error) {
-	row, err := db.Query("SELECT * FROM recipes WHERE user_id == ? ORDER BY id DESC", uid)
+// Retrieve a list of recipes, with their tags but without importing their XML
+// file.
+func (db *DB) getRecipeSummaries(query string, args ...interface{}) ([]*Recipe, error) {
+	row, err := db.Query(query, args...)
 	if err != nil {
 		return nil, err
 	}
+	defer row.Close()
 
 	var recipes []*Recipe
 	for row.Next() {
 		var r Recipe
-		row.Scan(&r.Id, &r.UserId, &r.Name, &r.File, &r.Public)
+		if err := scanRecipeSummary(row, &r); err != nil {
+			return nil, err
+		}
 
 		recipes = append(recipes, &r)
+	}
+	row.Close()
+
+	for _, r := range recipes {
+		if r.Tags, err = db.GetRecipeTags(r.Id); err != nil {
+			return nil, err
+		}
+	}
+
+	return recipes, nil
+}
+
+// Retrieve a list of recipes.
+func (db *DB) getRecipes(query string, args ...interface{}) ([]*Recipe, error) {
+	recipes, err := db.getRecipeSummaries(query, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, r := range recipes {
 		if err := db.importXML(r.File, &r.XML); err != nil {
 			return nil, err
 		}
@@ -60,6 +93,73 @@ func (db *DB) GetUserRecipes(uid int64) ([]*Recipe, error) {
 	return recipes, nil
 }
 
+// Fill the summary of a recipe, given its XML.
+func (r *Recipe) summarize() {
+	r.Style = r.XML.Style.Name
+	r.Type = r.XML.Type
+	r.Version = r.XML.Version
+	r.OG = finite(r.XML.EstOG)
+	r.FG = finite(r.XML.EstFG)
+	r.ABV = finite(r.XML.EstABV)
+	r.IBU = finite(r.XML.IBU)
+	r.Color = finite(r.XML.EstColor)
+}
+
+// Estimations can't be computed for incomplete recipes (e.g. the FG without
+// yeasts) and are then NaN, which SQLite stores as NULL: use 0 instead.
+func finite(v float64) float64 {
+	if math.IsNaN(v) || math.IsInf(v, 0) {
+		return 0
+	}
+	return v
+}
+
+// Retrives a recipe given its id.
+func (db *DB) GetRecipe(id int64) (*Recipe, error) {
+	var r Recipe
+	if err := db.scanRecipe(db.QueryRow("SELECT * FROM recipes WHERE id == $1", id), &r); err != nil {
+		return nil, err
+	}
+
+	return &r, nil
+}
+
+// Retrieves all recipes of a given owner.
+func (db *DB) GetRecipes(o Owner) ([]*Recipe, error) {
+	return db.getRecipes(`
+SELECT * FROM recipes WHERE user_id == ? AND organization_id == ?
+ORDER BY id DESC`, o.UserId, o.OrganizationId)
+}
+
+// Retrieves a public recipe given its slug.
+func (db *DB) GetPublicRecipe(slug string) (*Recipe, error) {
+	var r Recipe
+	row := db.QueryRow("SELECT * FROM recipes WHERE slug == ? AND public == 1", slug)
+	if err := db.scanRecipe(row, &r); err != nil {
+		return nil, err
+	}
+
+	return &r, nil
+}
+
+// Retrieves all public recipes, without importing their XML file.
+func (db *DB) GetPublicRecipeSummaries() ([]*Recipe, error) {
+	return db.getRecipeSummaries("SELECT * FROM recipes WHERE public == 1 ORDER BY id DESC")
+}
+
+// Retrieves all public recipes, excluding the ones of a given owner (an empty
+// owner retrieves them all).
+func (db *DB) GetPublicRecipes(o Owner) ([]*Recipe, error) {
+	return db.getRecipes(`
+SELECT * FROM recipes WHERE public == 1 AND NOT (user_id == ? AND organization_id == ?)
+ORDER BY id DESC`, o.UserId, o.OrganizationId)
+}
+
+// Retrieves the recipes forked from a given recipe.
+func (db *DB) GetRecipeForks(id int64) ([]*Recipe, error) {
+	return db.getRecipes("SELECT * FROM recipes WHERE parent_id == ? ORDER BY id", id)
+}
+
 // Add a new recipe.
 func (db *DB) AddRecipe(r *Recipe) (int64, error) {
 	var err error
@@ -67,9 +167,21 @@ func (db *DB) AddRecipe(r *Recipe) (int64, error) {
 		return -1, err
 	}
 
+	if r.Public && r.Slug == "" {
+		if r.Slug, err = db.newUniqSlug(r.Name); err != nil {
+			return -1, err
+		}
+	}
+
+	r.summarize()
 	result, err := db.Exec(`
-INSERT INTO recipes (user_id, name, file, public)
-VALUES (?, ?, ?, ?)`, r.UserId, r.Name, r.File, r.Public)
+INSERT INTO recipes (user_id, name, file, public, slug, parent_id, parent_author, organization_id,
+		     folder, style, type, version, og, fg, abv, ibu, color, parent_user_id,
+		     parent_organization_id)
+VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`, r.UserId, r.Name, r.File, r.Public,
+		r.Slug, r.ParentId, r.ParentAuthor, r.OrganizationId, r.Folder, r.Style, r.Type,
+		r.Version, r.OG, r.FG, r.ABV, r.IBU, r.Color, r.ParentOwner.UserId,
+		r.ParentOwner.OrganizationId)
 	if err != nil {
 		os.Remove(r.File)
 		return -1, err
@@ -81,33 +193,112 @@ VALUES (?, ?, ?, ?)`, r.UserId, r.Name, r.File, r.Public)
 		return -1, err
 	}
 
+	if err := db.SetRecipeTags(id, r.Tags); err != nil {
+		return -1, err
+	}
+
 	if err := beerxml.ExportFile(r.XML, path.Join(db.rootdir, r.File)); err != nil {
 		return -1, err
 	}
 
+	if err := db.indexRecipe(id, r.XML); err != nil {
+		return -1, err
+	}
+
 	return id, nil
 }
 
 // Update a recipe.
 func (db *DB) UpdateRecipe(r *Recipe) error {
+	// Public recipes are given a slug once, so their URL does not change.
+	if r.Public && r.Slug == "" {
+		var err error
+		if r.Slug, err = db.newUniqSlug(r.Name); err != nil {
+			return err
+		}
+	}
+
+	r.summarize()
 	_, err := db.Exec(`
-REPLACE INTO recipes (id, user_id, name, file, public)
-VALUES (?, ?, ?, ?, ?)`, r.Id, r.UserId, r.Name, r.File, r.Public)
+REPLACE INTO recipes (id, user_id, name, file, public, slug, parent_id, parent_author,
+		     organization_id, folder, style, type, version, og, fg, abv, ibu, color,
+		     parent_user_id, parent_organization_id)
+VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`, r.Id, r.UserId, r.Name, r.File,
+		r.Public, r.Slug, r.ParentId, r.ParentAuthor, r.OrganizationId, r.Folder, r.Style,
+		r.Type, r.Version, r.OG, r.FG, r.ABV, r.IBU, r.Color, r.ParentOwner.UserId,
+		r.ParentOwner.OrganizationId)
 	if err != nil {
 		return err
 	}
 
-	return beerxml.ExportFile(&r.XML, path.Join(db.rootdir, r.File))
+	// The cached document is outdated.
+	db.docs.forget(r.File)
+
+	if err := db.SetRecipeTags(r.Id, r.Tags); err != nil {
+		return err
+	}
+
+	if err := beerxml.ExportFile(&r.XML, path.Join(db.rootdir, r.File)); err != nil {
+		return err
+	}
+
+	return db.indexRecipe(r.Id, r.XML)
+}
+
+// Generates a slug, unique among recipes, given a recipe name.
+func (db *DB) newUniqSlug(name string) (string, error) {
+	var slug []byte
+	for _, c := range strings.ToLower(name) {
+		if (c >= 'a' && c <= 'z') || (c >= '0' && c <= '9') {
+			slug = append(slug, byte(c))
+		} else if len(slug) > 0 && slug[len(slug)-1] != '-' {
+			slug = append(slug, '-')
+		}
+	}
+	base := strings.Trim(string(slug), "-")
+	if base == "" {
+		base = "recipe"
+	}
+
+	candidate := base
+	for n := 2; ; n++ {
+		var count int
+		err := db.QueryRow("SELECT COUNT(*) FROM recipes WHERE slug == ?", candidate).Scan(&count)
+		if err != nil {
+			return "", err
+		}
+		if count == 0 {
+			return candidate, nil
+		}
+		candidate = fmt.Sprintf("%s-%d", base, n)
+	}
 }
 
 // Delete a recipe.
 func (db *DB) DeleteRecipe(r *Recipe) error {
-	// First, remove the db entry.
+	// First, remove the recipe history.
+	if err := db.DeleteRecipeRevisions(r.Id); err != nil {
+		return err
+	}
+
+	// Then, remove the db entry, its tags and its search index entry. Its
+	// forks keep track of their deleted parent.
 	if _, err := db.Exec("DELETE FROM recipes WHERE id == ?", r.Id); err != nil {
 		return err
 	}
+	if _, err := db.Exec("DELETE FROM recipe_tags WHERE recipe_id == ?", r.Id); err != nil {
+		return err
+	}
+	if err := db.unindexRecipe(r.Id); err != nil {
+		return err
+	}
+	_, err := db.Exec("UPDATE recipes SET parent_id = ? WHERE parent_id == ?", DeletedParent, r.Id)
+	if err != nil {
+		return err
+	}
 
 	// Then, remove the recipe XML file.
+	db.docs.forget(r.File)
 	if err := os.Remove(path.Join(db.rootdir, r.File)); err != nil {
 		return err
 	}
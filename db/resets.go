@@ -0,0 +1,60 @@
+// Copyright (C) 2019 Antoine Tenart <antoine.tenart@ack.tf>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+
+package db
+
+// Add a new password reset request. Returns its plaintext token, which is only
+// sent to the user.
+func (db *DB) AddReset(r *Reset) (string, error) {
+	secret, err := randomHex(32)
+	if err != nil {
+		return "", err
+	}
+
+	r.Hash = HashToken(secret)
+	result, err := db.Exec("INSERT INTO resets (user_id, hash, expires) VALUES (?, ?, ?)",
+			       r.UserId, r.Hash, r.Expires)
+	if err != nil {
+		return "", err
+	}
+
+	r.Id, err = result.LastInsertId()
+	return secret, err
+}
+
+// Retrieve a password reset request given its plaintext token, if it did not
+// expire at a given date (YYYY-MM-DD HH:MM:SS, UTC).
+func (db *DB) GetResetBySecret(secret, now string) (*Reset, error) {
+	var r Reset
+	err := db.QueryRow("SELECT * FROM resets WHERE hash == ? AND expires > ?", HashToken(secret), now).
+		Scan(&r.Id, &r.UserId, &r.Hash, &r.Expires)
+	if err != nil {
+		return nil, err
+	}
+	return &r, nil
+}
+
+// Delete all the password reset requests of an user, once one was used.
+func (db *DB) DeleteUserResets(uid int64) error {
+	_, err := db.Exec("DELETE FROM resets WHERE user_id == ?", uid)
+	return err
+}
+
+// Delete the password reset requests expired at a given date.
+func (db *DB) PruneResets(now string) error {
+	_, err := db.Exec("DELETE FROM resets WHERE expires <= ?", now)
+	return err
+}
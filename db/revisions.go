@@ -0,0 +1,237 @@
+// Copyright (C) 2019 Antoine Tenart <antoine.tenart@ack.tf>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package db
+
+import (
+	"database/sql"
+	"encoding/json"
+	"os"
+	"path"
+
+	"github.com/atenart/bubbles/beerxml"
+)
+
+// Columns of the revisions, in the order of the Revision fields.
+const revisionColumns = "id, recipe_id, date, action, file, changes, og, fg, abv, ibu, color"
+
+// Scan a revision, without its XML file.
+func scanRevision(row interface{ Scan(...interface{}) error }, r *Revision) error {
+	var changes sql.NullString
+	err := row.Scan(&r.Id, &r.RecipeId, &r.Date, &r.Action, &r.File, &changes,
+			&r.OG, &r.FG, &r.ABV, &r.IBU, &r.Color)
+	if err != nil || !changes.Valid {
+		return err
+	}
+	return json.Unmarshal([]byte(changes.String), &r.Changes)
+}
+
+// Retrieve a single revision given its id.
+func (db *DB) GetRevision(id int64) (*Revision, error) {
+	var r Revision
+	row := db.QueryRow("SELECT " + revisionColumns + " FROM revisions WHERE id == $1", id)
+	if err := scanRevision(row, &r); err != nil {
+		return nil, err
+	}
+
+	if err := db.importXML(r.File, &r.XML); err != nil {
+		return nil, err
+	}
+
+	return &r, nil
+}
+
+// Retrieve all the revisions of a given recipe, latest first, without their XML
+// file.
+func (db *DB) GetRecipeRevisions(rid int64) ([]*Revision, error) {
+	row, err := db.Query("SELECT " + revisionColumns + " FROM revisions WHERE recipe_id == ? ORDER BY id DESC", rid)
+	if err != nil {
+		return nil, err
+	}
+	defer row.Close()
+
+	var revisions []*Revision
+	for row.Next() {
+		var r Revision
+		if err := scanRevision(row, &r); err != nil {
+			return nil, err
+		}
+
+		revisions = append(revisions, &r)
+	}
+
+	return revisions, row.Err()
+}
+
+// Count the revisions of a given recipe.
+func (db *DB) CountRecipeRevisions(rid int64) (int64, error) {
+	var count int64
+	err := db.QueryRow("SELECT COUNT(*) FROM revisions WHERE recipe_id == ?", rid).
+		Scan(&count)
+	return count, err
+}
+
+// Fill the summary of a revision given its XML, and its changes compared to the
+// previous revision, if any.
+func (r *Revision) summarize(previous *beerxml.Recipe) {
+	r.OG = finite(r.XML.EstOG)
+	r.FG = finite(r.XML.EstFG)
+	r.ABV = finite(r.XML.EstABV)
+	r.IBU = finite(r.XML.IBU)
+	r.Color = finite(r.XML.EstColor)
+
+	r.Changes = nil
+	if previous != nil {
+		r.Changes = beerxml.Diff(previous, r.XML)
+	}
+}
+
+// Encode the changes of a revision to be stored.
+func (r *Revision) encodeChanges() (string, error) {
+	changes := r.Changes
+	if changes == nil {
+		changes = []beerxml.Change{}
+	}
+
+	b, err := json.Marshal(changes)
+	return string(b), err
+}
+
+// Add a new revision. Its changes are computed against the latest revision of
+// the recipe.
+func (db *DB) AddRevision(r *Revision) (int64, error) {
+	var previous *beerxml.Recipe
+	var file string
+	err := db.QueryRow("SELECT file FROM revisions WHERE recipe_id == ? ORDER BY id DESC LIMIT 1",
+			   r.RecipeId).Scan(&file)
+	if err == nil {
+		if err := db.importXML(file, &previous); err != nil {
+			return -1, err
+		}
+	} else if err != sql.ErrNoRows {
+		return -1, err
+	}
+
+	r.summarize(previous)
+	changes, err := r.encodeChanges()
+	if err != nil {
+		return -1, err
+	}
+
+	if r.File, err = db.newUniqFile(); err != nil {
+		return -1, err
+	}
+
+	result, err := db.Exec(`
+INSERT INTO revisions (recipe_id, action, file, changes, og, fg, abv, ibu, color)
+VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`, r.RecipeId, r.Action, r.File, changes,
+		r.OG, r.FG, r.ABV, r.IBU, r.Color)
+	if err != nil {
+		os.Remove(path.Join(db.rootdir, r.File))
+		return -1, err
+	}
+
+	// Get the generated id in response to the previous command.
+	id, err := result.LastInsertId()
+	if err != nil {
+		return -1, err
+	}
+
+	if err := beerxml.ExportFile(r.XML, path.Join(db.rootdir, r.File)); err != nil {
+		return -1, err
+	}
+
+	return id, nil
+}
+
+// Fill the summary and changes of the revisions recorded before they were
+// stored.
+func (db *DB) summarizeRevisions() error {
+	row, err := db.Query("SELECT DISTINCT recipe_id FROM revisions WHERE changes IS NULL")
+	if err != nil {
+		return err
+	}
+	defer row.Close()
+
+	var recipes []int64
+	for row.Next() {
+		var id int64
+		if err := row.Scan(&id); err != nil {
+			return err
+		}
+		recipes = append(recipes, id)
+	}
+	if err := row.Err(); err != nil {
+		return err
+	}
+	row.Close()
+
+	for _, rid := range recipes {
+		revisions, err := db.GetRecipeRevisions(rid)
+		if err != nil {
+			return err
+		}
+
+		// Revisions are listed latest first.
+		var previous *beerxml.Recipe
+		for i := len(revisions) - 1; i >= 0; i-- {
+			r := revisions[i]
+			if err := db.importXML(r.File, &r.XML); err != nil {
+				return err
+			}
+
+			r.summarize(previous)
+			previous = r.XML
+
+			changes, err := r.encodeChanges()
+			if err != nil {
+				return err
+			}
+			_, err = db.Exec(`
+UPDATE revisions SET changes = ?, og = ?, fg = ?, abv = ?, ibu = ?, color = ?
+WHERE id == ?`, changes, r.OG, r.FG, r.ABV, r.IBU, r.Color, r.Id)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// Delete all the revisions of a given recipe.
+func (db *DB) DeleteRecipeRevisions(rid int64) error {
+	revisions, err := db.GetRecipeRevisions(rid)
+	if err != nil {
+		return err
+	}
+
+	for _, r := range revisions {
+		// First, remove the db entry.
+		if _, err := db.Exec("DELETE FROM revisions WHERE id == ?", r.Id); err != nil {
+			return err
+		}
+
+		// Then, remove the revision XML file.
+		if err := os.Remove(path.Join(db.rootdir, r.File)); err != nil {
+			return err
+		}
+
+		// Finally try removing its directory (if empty).
+		os.Remove(path.Dir(path.Join(db.rootdir, r.File)))
+	}
+
+	return nil
+}
@@ -0,0 +1,209 @@
+// Copyright (C) 2019 Antoine Tenart <antoine.tenart@ack.tf>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+
+package db
+
+import (
+	"strings"
+
+	"github.com/atenart/bubbles/beerxml"
+)
+
+// Full-text search index of the recipes, using their id as rowid.
+const ftsTable = `CREATE VIRTUAL TABLE IF NOT EXISTS recipes_fts USING fts5(name, notes, ingredients)`
+
+// Create the full-text search index, if SQLite was built with FTS5 (see the
+// README). Without it, searches only match the recipe names.
+func (db *DB) openFTS() error {
+	if err := db.QueryRow("SELECT sqlite_compileoption_used('ENABLE_FTS5')").Scan(&db.fts); err != nil {
+		return err
+	}
+	if !db.fts {
+		return nil
+	}
+
+	_, err := db.Exec(ftsTable)
+	return err
+}
+
+// Index a recipe for the full-text search, given its id.
+func (db *DB) indexRecipe(id int64, xml *beerxml.Recipe) error {
+	if !db.fts {
+		return nil
+	}
+
+	var ingredients []string
+	for _, f := range xml.Fermentables {
+		ingredients = append(ingredients, f.Name)
+	}
+	for _, h := range xml.Hops {
+		ingredients = append(ingredients, h.Name)
+	}
+	for _, y := range xml.Yeasts {
+		ingredients = append(ingredients, y.Name)
+	}
+	for _, m := range xml.Miscs {
+		ingredients = append(ingredients, m.Name)
+	}
+
+	if err := db.unindexRecipe(id); err != nil {
+		return err
+	}
+
+	_, err := db.Exec(`
+INSERT INTO recipes_fts (rowid, name, notes, ingredients)
+VALUES (?, ?, ?, ?)`, id, xml.Name, xml.Notes + "\n" + xml.TasteNotes,
+		strings.Join(ingredients, "\n"))
+	return err
+}
+
+// Remove a recipe from the full-text search index, given its id.
+func (db *DB) unindexRecipe(id int64) error {
+	if !db.fts {
+		return nil
+	}
+
+	_, err := db.Exec("DELETE FROM recipes_fts WHERE rowid == ?", id)
+	return err
+}
+
+// Index the recipes missing from the full-text search index.
+func (db *DB) indexRecipes() error {
+	if !db.fts {
+		return nil
+	}
+
+	recipes, err := db.getRecipes(`
+SELECT * FROM recipes WHERE id NOT IN (SELECT rowid FROM recipes_fts)`)
+	if err != nil {
+		return err
+	}
+
+	for _, r := range recipes {
+		if err := db.indexRecipe(r.Id, r.XML); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Convert an user search into a FTS5 query: all the words must be found, as
+// prefixes. Words are quoted so the FTS5 syntax can't be used.
+func ftsQuery(search string) string {
+	var words []string
+	for _, w := range strings.Fields(search) {
+		w = strings.Replace(w, `"`, "", -1)
+		if w != "" {
+			words = append(words, `"` + w + `"*`)
+		}
+	}
+	return strings.Join(words, " ")
+}
+
+// Convert an user search into LIKE patterns, one per word, for when full-text
+// search is not available.
+func likePatterns(search string) []string {
+	escape := strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`)
+
+	var patterns []string
+	for _, w := range strings.Fields(search) {
+		patterns = append(patterns, "%" + escape.Replace(w) + "%")
+	}
+	return patterns
+}
+
+// Search the recipes of a given owner. Returns the requested page of the
+// matching recipes, without their XML file, and the total number of matching
+// recipes. Full-text search results are ordered by relevance, others by id
+// (latest first). Without full-text search, all the words of the query must be
+// found in the recipe name.
+func (db *DB) SearchRecipes(o Owner, f *RecipeFilter) ([]*Recipe, int, error) {
+	from := " FROM recipes"
+	where := []string{ "recipes.user_id == ?", "recipes.organization_id == ?" }
+	args := []interface{}{ o.UserId, o.OrganizationId }
+	order := "recipes.id DESC"
+
+	if !db.fts {
+		for _, p := range likePatterns(f.Query) {
+			where = append(where, `recipes.name LIKE ? ESCAPE '\'`)
+			args = append(args, p)
+		}
+	} else if match := ftsQuery(f.Query); match != "" {
+		from += " JOIN recipes_fts ON recipes_fts.rowid == recipes.id"
+		where = append(where, "recipes_fts MATCH ?")
+		args = append(args, match)
+		order = "recipes_fts.rank"
+	}
+	if f.Folder != "" {
+		where = append(where, "recipes.folder == ?")
+		args = append(args, f.Folder)
+	}
+	if f.Tag != "" {
+		where = append(where, "recipes.id IN (SELECT recipe_id FROM recipe_tags WHERE tag == ?)")
+		args = append(args, f.Tag)
+	}
+	if f.Style != "" {
+		where = append(where, "recipes.style == ?")
+		args = append(args, f.Style)
+	}
+	if f.Type != "" {
+		where = append(where, "recipes.type == ?")
+		args = append(args, f.Type)
+	}
+	if f.AbvMin > 0 {
+		where = append(where, "recipes.abv >= ?")
+		args = append(args, f.AbvMin)
+	}
+	if f.AbvMax > 0 {
+		where = append(where, "recipes.abv <= ?")
+		args = append(args, f.AbvMax)
+	}
+	if f.IbuMin > 0 {
+		where = append(where, "recipes.ibu >= ?")
+		args = append(args, f.IbuMin)
+	}
+	if f.IbuMax > 0 {
+		where = append(where, "recipes.ibu <= ?")
+		args = append(args, f.IbuMax)
+	}
+	switch f.Brewed {
+	case "yes":
+		where = append(where, "EXISTS (SELECT 1 FROM brews WHERE brews.recipe_id == recipes.id)")
+	case "no":
+		where = append(where, "NOT EXISTS (SELECT 1 FROM brews WHERE brews.recipe_id == recipes.id)")
+	}
+	from += " WHERE " + strings.Join(where, " AND ")
+
+	var total int
+	if err := db.QueryRow("SELECT COUNT(*)" + from, args...).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+
+	// Only retrieve the requested page.
+	query := "SELECT recipes.*" + from + " ORDER BY " + order
+	if f.PerPage > 0 {
+		query += " LIMIT ? OFFSET ?"
+		args = append(args, f.PerPage, f.Page * f.PerPage)
+	}
+
+	recipes, err := db.getRecipeSummaries(query, args...)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return recipes, total, nil
+}
@@ -0,0 +1,77 @@
+// Copyright (C) 2019 Antoine Tenart <antoine.tenart@ack.tf>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+
+package db
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/atenart/bubbles/beerxml"
+)
+
+func TestLikePatterns(t *testing.T) {
+	got := likePatterns(` pale  100%_\ `)
+	want := []string{ "%pale%", `%100\%\_\\%` }
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("likePatterns = %q, want %q", got, want)
+	}
+}
+
+// Name searches give the same results, with or without full-text search.
+func TestSearchRecipes(t *testing.T) {
+	db := openTestDB(t)
+
+	for _, name := range []string{ "Pale Ale", "Imperial Stout", "Pale Lager" } {
+		r := &Recipe{ UserId: 1, Name: name, XML: &beerxml.Recipe{ Name: name } }
+		if _, err := db.AddRecipe(r); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		query string
+		want  []string
+	}{
+		{ "", []string{ "Pale Lager", "Imperial Stout", "Pale Ale" } },
+		{ "pale", []string{ "Pale Lager", "Pale Ale" } },
+		{ "lager pale", []string{ "Pale Lager" } },
+		{ "stout", []string{ "Imperial Stout" } },
+		{ "porter", nil },
+	}
+	for _, tt := range tests {
+		recipes, total, err := db.SearchRecipes(Owner{ UserId: 1 }, &RecipeFilter{ Query: tt.query })
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var names []string
+		for _, r := range recipes {
+			names = append(names, r.Name)
+		}
+		// Full-text search results are ordered by relevance.
+		want := tt.want
+		if db.fts && want != nil {
+			want = append([]string{}, want...)
+			sort.Strings(want)
+			sort.Strings(names)
+		}
+		if !reflect.DeepEqual(names, want) || total != len(want) {
+			t.Errorf("search %q = %q (%d), want %q", tt.query, names, total, want)
+		}
+	}
+}
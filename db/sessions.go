@@ -0,0 +1,99 @@
+// Copyright (C) 2019 Antoine Tenart <antoine.tenart@ack.tf>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+
+package db
+
+// Retrieve a list of sessions.
+func (db *DB) getSessions(query string, args ...interface{}) ([]*Session, error) {
+	row, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer row.Close()
+
+	var sessions []*Session
+	for row.Next() {
+		var s Session
+		err := row.Scan(&s.Id, &s.UserId, &s.Hash, &s.UserAgent, &s.IP, &s.Created, &s.LastSeen)
+		if err != nil {
+			return nil, err
+		}
+
+		sessions = append(sessions, &s)
+	}
+
+	return sessions, nil
+}
+
+// Retrieve the sessions of a given user, most recently used first.
+func (db *DB) GetUserSessions(uid int64) ([]*Session, error) {
+	return db.getSessions("SELECT * FROM sessions WHERE user_id == ? ORDER BY last_seen DESC", uid)
+}
+
+// Retrieve a session given its plaintext token.
+func (db *DB) GetSessionBySecret(secret string) (*Session, error) {
+	var s Session
+	err := db.QueryRow("SELECT * FROM sessions WHERE hash == ?", HashToken(secret)).
+		Scan(&s.Id, &s.UserId, &s.Hash, &s.UserAgent, &s.IP, &s.Created, &s.LastSeen)
+	if err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+// Add a new session. Returns its plaintext token, to be stored in the session
+// cookie only.
+func (db *DB) AddSession(s *Session) (string, error) {
+	secret, err := randomHex(32)
+	if err != nil {
+		return "", err
+	}
+
+	s.Hash = HashToken(secret)
+	result, err := db.Exec(`
+INSERT INTO sessions (user_id, hash, user_agent, ip, created, last_seen)
+VALUES (?, ?, ?, ?, ?, ?)`, s.UserId, s.Hash, s.UserAgent, s.IP, s.Created, s.LastSeen)
+	if err != nil {
+		return "", err
+	}
+
+	s.Id, err = result.LastInsertId()
+	return secret, err
+}
+
+// Update the last activity of a session.
+func (db *DB) TouchSession(s *Session) error {
+	_, err := db.Exec("UPDATE sessions SET last_seen = ?, ip = ? WHERE id == ?", s.LastSeen, s.IP, s.Id)
+	return err
+}
+
+// Delete a session of a given user.
+func (db *DB) DeleteSession(id, uid int64) error {
+	_, err := db.Exec("DELETE FROM sessions WHERE id == ? AND user_id == ?", id, uid)
+	return err
+}
+
+// Delete all the sessions of an user, but a given one (0 to delete them all).
+func (db *DB) DeleteUserSessions(uid, except int64) error {
+	_, err := db.Exec("DELETE FROM sessions WHERE user_id == ? AND id != ?", uid, except)
+	return err
+}
+
+// Delete the sessions idle since, or created before, given dates.
+func (db *DB) PruneSessions(idle, created string) error {
+	_, err := db.Exec("DELETE FROM sessions WHERE last_seen < ? OR created < ?", idle, created)
+	return err
+}
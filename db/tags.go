@@ -0,0 +1,86 @@
+// Copyright (C) 2019 Antoine Tenart <antoine.tenart@ack.tf>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+
+package db
+
+// Retrieve the tags of a given recipe, in alphabetical order.
+func (db *DB) GetRecipeTags(rid int64) ([]string, error) {
+	return db.getStrings("SELECT tag FROM recipe_tags WHERE recipe_id == ? ORDER BY tag", rid)
+}
+
+// Set the tags of a given recipe, replacing its previous ones.
+func (db *DB) SetRecipeTags(rid int64, tags []string) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec("DELETE FROM recipe_tags WHERE recipe_id == ?", rid); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	for _, tag := range tags {
+		_, err := tx.Exec("INSERT OR IGNORE INTO recipe_tags (recipe_id, tag) VALUES (?, ?)",
+				  rid, tag)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// Retrieve the tags used by the recipes of a given owner, in alphabetical
+// order.
+func (db *DB) GetTags(o Owner) ([]string, error) {
+	return db.getStrings(`
+SELECT DISTINCT recipe_tags.tag FROM recipe_tags
+JOIN recipes ON recipes.id == recipe_tags.recipe_id
+WHERE recipes.user_id == ? AND recipes.organization_id == ?
+ORDER BY recipe_tags.tag`, o.UserId, o.OrganizationId)
+}
+
+// Retrieve the folders used by the recipes of a given owner, in alphabetical
+// order.
+func (db *DB) GetFolders(o Owner) ([]string, error) {
+	return db.getStrings(`
+SELECT DISTINCT folder FROM recipes
+WHERE user_id == ? AND organization_id == ? AND folder != ""
+ORDER BY folder`, o.UserId, o.OrganizationId)
+}
+
+// Retrieve a list of strings.
+func (db *DB) getStrings(query string, args ...interface{}) ([]string, error) {
+	row, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer row.Close()
+
+	var list []string
+	for row.Next() {
+		var s string
+		if err := row.Scan(&s); err != nil {
+			return nil, err
+		}
+
+		list = append(list, s)
+	}
+
+	return list, nil
+}
@@ -0,0 +1,150 @@
+// Copyright (C) 2019 Antoine Tenart <antoine.tenart@ack.tf>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+
+package db
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+	"time"
+)
+
+// Prefix of the personal access tokens, to make them easy to recognize (e.g.
+// by secret scanners).
+const tokenPrefix = "bbl_"
+
+// Hashes a personal access token. Tokens are long random strings, a plain
+// SHA-256 is enough to protect them (unlike passwords).
+func HashToken(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// Generate a random hexadecimal string, out of a given number of bytes.
+func randomHex(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// Generate a new random token, with a given prefix.
+func genSecret(prefix string) (string, error) {
+	secret, err := randomHex(20)
+	if err != nil {
+		return "", err
+	}
+	return prefix + secret, nil
+}
+
+// Retrieve a list of tokens.
+func (db *DB) getTokens(query string, args ...interface{}) ([]*Token, error) {
+	row, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer row.Close()
+
+	var tokens []*Token
+	for row.Next() {
+		var t Token
+		var scopes string
+		err := row.Scan(&t.Id, &t.UserId, &t.Name, &t.Hash, &scopes, &t.Created,
+				&t.Expires, &t.LastUsed)
+		if err != nil {
+			return nil, err
+		}
+
+		t.Scopes = strings.Fields(scopes)
+		tokens = append(tokens, &t)
+	}
+
+	return tokens, nil
+}
+
+// Retrieve the tokens of a given user.
+func (db *DB) GetUserTokens(uid int64) ([]*Token, error) {
+	return db.getTokens("SELECT * FROM tokens WHERE user_id == ? ORDER BY created DESC, id DESC", uid)
+}
+
+// Retrieve a token given its plaintext value. Returns sql.ErrNoRows if the
+// token is unknown.
+func (db *DB) GetTokenBySecret(secret string) (*Token, error) {
+	var t Token
+	var scopes string
+	err := db.QueryRow("SELECT * FROM tokens WHERE hash == ?", HashToken(secret)).
+		Scan(&t.Id, &t.UserId, &t.Name, &t.Hash, &scopes, &t.Created, &t.Expires,
+		     &t.LastUsed)
+	if err != nil {
+		return nil, err
+	}
+
+	t.Scopes = strings.Fields(scopes)
+	return &t, nil
+}
+
+// Add a new token and return its plaintext value: this is the only time it is
+// known, as only its hash is stored.
+func (db *DB) AddToken(t *Token) (string, error) {
+	secret, err := genSecret(tokenPrefix)
+	if err != nil {
+		return "", err
+	}
+
+	t.Hash = HashToken(secret)
+	result, err := db.Exec(`
+INSERT INTO tokens (user_id, name, hash, scopes, expires)
+VALUES (?, ?, ?, ?, ?)`, t.UserId, t.Name, t.Hash, strings.Join(t.Scopes, " "), t.Expires)
+	if err != nil {
+		return "", err
+	}
+
+	if t.Id, err = result.LastInsertId(); err != nil {
+		return "", err
+	}
+	return secret, nil
+}
+
+// Record the last use of a token.
+func (db *DB) TouchToken(t *Token) error {
+	t.LastUsed = time.Now().UTC().Format(TimeFormat)
+	_, err := db.Exec("UPDATE tokens SET last_used = ? WHERE id == ?", t.LastUsed, t.Id)
+	return err
+}
+
+// Delete (revoke) a token of a given user.
+func (db *DB) DeleteToken(id, uid int64) error {
+	_, err := db.Exec("DELETE FROM tokens WHERE id == ? AND user_id == ?", id, uid)
+	return err
+}
+
+// Check a token has expired.
+func (t *Token) Expired() bool {
+	return t.Expires != "" && t.Expires < time.Now().Format("2006-01-02")
+}
+
+// Check a token has a given scope.
+func (t *Token) HasScope(scope string) bool {
+	for _, s := range t.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
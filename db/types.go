@@ -15,7 +15,11 @@
 
 package db
 
-import "github.com/atenart/bubbles/beerxml"
+import (
+	"time"
+
+	"github.com/atenart/bubbles/beerxml"
+)
 
 // Represents an user.
 type User struct {
@@ -26,40 +30,334 @@ type User struct {
 	Token            string
 	Enabled          bool
 	Lang             string
+	Currency         string
+	WaterPrice       float64  // Per m³.
+	EnergyPrice      float64  // Per kWh.
+	EnergyUse        float64  // kWh used per batch.
+	Workspace        int64    // Organization worked in, 0 for the personal space.
+	TOTPSecret       string   // Base32 TOTP secret, empty when 2FA is disabled.
+	TOTPStep         int64    // Last time step used, codes can't be replayed.
+	RecoveryCodes    []string // Hashes of the unused recovery codes.
 }
 
-// Represents a recipe and contains a path to its associated BeerXML file.
-type Recipe struct {
+// Represents a signed-in session. Only a hash of its token is stored.
+type Session struct {
+	Id        int64
+	UserId    int64
+	Hash      string
+	UserAgent string
+	IP        string
+	Created   string // YYYY-MM-DD HH:MM:SS, UTC.
+	LastSeen  string // YYYY-MM-DD HH:MM:SS, UTC.
+}
+
+// Represents an account of an OpenID Connect provider linked to an user.
+type Identity struct {
 	Id      int64
 	UserId  int64
-	Name    string
-	File    string
-	Public  bool
-	XML     *beerxml.Recipe
+	Issuer  string
+	Subject string
 }
 
-// Represents an ingredient (fermentable, hops, yeats, ...) in an user inventory
-// and contains a path to its associated BeerXML file.
-type Ingredient struct {
-	Id     int64
-	UserId int64
-	Name   string
-	Type   string
-	Link   string
-	File   string
-	XML    interface{}
+// Represents a password reset request. Only a hash of its token is stored.
+type Reset struct {
+	Id      int64
+	UserId  int64
+	Hash    string
+	Expires string // YYYY-MM-DD HH:MM:SS, UTC.
 }
 
-// Represents a brew.
-type Brew struct {
+// Represents a personal access token, used to authenticate to the API. Only a
+// hash of the token itself is stored.
+type Token struct {
 	Id       int64
-	RecipeId int64
 	UserId   int64
-	Step     int64
+	Name     string
+	Hash     string
+	Scopes   []string
+	Created  string
+	Expires  string // Date (YYYY-MM-DD) after which the token is refused, if any.
+	LastUsed string
+}
+
+// Scopes of the personal access tokens: the read-only one gives read access to
+// everything, the others read and write access to a given kind of data.
+const (
+	ScopeRead      = "read-only"
+	ScopeRecipes   = "recipes"
+	ScopeBrews     = "brews"
+	ScopeInventory = "inventory"
+)
+
+// Represents a hydrometer (e.g. an iSpindel or a Tilt) sending readings to the
+// brew in fermentation of its owner. Only a hash of its token is stored.
+type Device struct {
+	Id             int64
+	UserId         int64
+	OrganizationId int64
+	Name           string
+	Kind           string
+	Hash           string
+	Calibration    []float64 // Polynomial coefficients, highest degree first.
+	GravityUnit    string    // Of the reported, or calibrated, gravities.
+	BrewId         int64     // Brew the readings go to, 0 for the one in fermentation.
+	StableDays     float64   // Days of stable gravity before an alert, 0 to disable.
+	StableDelta    float64   // Gravity variation still considered stable.
+	AlertedBrew    int64     // Last brew whose gravity was reported stable.
+	LastSeen       string
+	Battery        float64
+}
+
+// Kinds of hydrometers, which defines the input of their calibration: iSpindels
+// are calibrated from their angle, Tilts from the gravity they measure.
+const (
+	DeviceISpindel = "ispindel"
+	DeviceTilt     = "tilt"
+)
+
+// Units of the gravities given by hydrometers: specific gravities, or °P (as
+// the iSpindel firmware and its usual calibration formulas do).
+const (
+	GravitySG    = "sg"
+	GravityPlato = "plato"
+)
+
+// Represents a webhook: events on the data of an user personal space, or of one
+// of its organizations, are POSTed to an URL.
+type Webhook struct {
+	Id             int64
+	UserId         int64
+	OrganizationId int64
+	URL            string
+	Secret         string // Key of the HMAC signature of the deliveries.
+	Events         []string
+	Organization   string
+}
+
+// Events which can be sent to webhooks.
+const (
+	EventBrewCreated   = "brew.created"
+	EventBrewStep      = "brew.step"
+	EventReading       = "reading.created"
+	EventRecipeCreated = "recipe.created"
+	EventRecipeUpdated = "recipe.updated"
+	EventRecipeDeleted = "recipe.deleted"
+	EventInventoryLow  = "inventory.low"
+	EventGravityStable = "gravity.stable"
+)
+
+// Represents the delivery of an event to a webhook. Failed deliveries are
+// retried until they succeed or are given up.
+type Delivery struct {
+	Id          int64
+	WebhookId   int64
+	Event       string
+	Payload     string
+	Created     string
+	Status      string
+	Attempts    int
+	NextAttempt string
+	Response    int // HTTP status of the last attempt, if any.
+	Error       string
+	URL         string
+}
+
+// Status of the deliveries.
+const (
+	DeliveryPending   = "pending"
+	DeliveryDelivered = "delivered"
+	DeliveryFailed    = "failed"
+)
+
+// Represents the owner of recipes, ingredients and brews: either an user (its
+// personal space) or an organization. Only one of the ids is set.
+type Owner struct {
+	UserId         int64
+	OrganizationId int64
+}
+
+// Represents an organization (e.g. a brewery) sharing recipes, ingredients and
+// brews between its members.
+type Organization struct {
+	Id   int64
+	Name string
+}
+
+// Roles of the members of an organization: owners manage the organization and
+// its members, brewers edit its recipes, ingredients and brews, viewers can
+// only see them.
+const (
+	RoleOwner  = "owner"
+	RoleBrewer = "brewer"
+	RoleViewer = "viewer"
+)
+
+// Represents the membership of an user in an organization. The e-mail and the
+// organization name are filled in when listing memberships, for display.
+type Member struct {
+	Id             int64
+	OrganizationId int64
+	UserId         int64
+	Role           string
+	Email          string
+	Organization   string
+}
+
+// Represents a recipe and contains a path to its associated BeerXML file.
+type Recipe struct {
+	Id             int64
+	UserId         int64
+	Name           string
+	File           string
+	Public         bool
+	Slug           string // Set once the recipe is made public.
+	// The recipe this one was forked from, and its owner and author label at
+	// that time. The label is only shown when the owner can't be.
+	ParentId       int64
+	ParentAuthor   string
+	ParentOwner    Owner
+	OrganizationId int64
+	Folder         string
+	Tags           []string // Stored in their own table.
+	// Summary of the BeerXML recipe, so listings don't need to import it.
+	Style          string
+	Type           string
+	Version        int32
+	OG             float64
+	FG             float64
+	ABV            float64
+	IBU            float64
+	Color          float64
+	XML            *beerxml.Recipe
+}
+
+// Parent id of the recipes forked from a recipe which was deleted since.
+const DeletedParent = -1
+
+// Represents the filters and the page of a recipes search. Zero values do not
+// filter.
+type RecipeFilter struct {
+	Query   string // Full-text search in names, notes and ingredient names.
+	Folder  string
+	Tag     string
+	Style   string
+	Type    string
+	AbvMin  float64
+	AbvMax  float64
+	IbuMin  float64
+	IbuMax  float64
+	Brewed  string // "yes", "no", or empty for all recipes.
+	Page    int    // Starting at 0.
+	PerPage int
+}
+
+// Represents a recipe revision: a snapshot of a recipe BeerXML file taken
+// after an action was performed on it.
+type Revision struct {
+	Id       int64
+	RecipeId int64
+	Date     string
+	Action   string
 	File     string
+	// Changes compared to the previous revision.
+	Changes  []beerxml.Change
+	// Summary of the XML, for the history.
+	OG       float64
+	FG       float64
+	ABV      float64
+	IBU      float64
+	Color    float64
 	XML      *beerxml.Recipe
 }
 
+// Represents an ingredient (fermentable, hops, yeats, ...) in an user inventory
+// and contains a path to its associated BeerXML file. The stock is the sum of
+// the ingredient lots amounts.
+type Ingredient struct {
+	Id             int64
+	UserId         int64
+	Name           string
+	Type           string
+	Link           string
+	File           string
+	Stock          float64
+	Unit           string
+	Price          float64
+	Package        float64 // Package size, in the stock unit.
+	OrganizationId int64
+	XML            interface{}
+}
+
+// Represents a lot of an inventory ingredient. Dates are formatted as
+// YYYY-MM-DD and are empty when unknown. The actual specs (alpha, color, yield)
+// override the ingredient values when non-zero. The harvest date and storage
+// conditions are used to estimate the degradation of hops.
+type Lot struct {
+	Id           int64
+	IngredientId int64
+	Amount       float64
+	Code         string
+	PurchaseDate string
+	BestBefore   string
+	Alpha        float64
+	Color        float64
+	Yield        float64
+	HarvestDate  string
+	StorageTemp  float64 // °C
+	Packaging    string  // "", "vacuum" or "nitrogen".
+	Supplier     string  // Overrides the ingredient one, e.g. another maltster.
+}
+
+// Represents the price paid for a package of an inventory ingredient, at a
+// given date.
+type Price struct {
+	Id           int64
+	IngredientId int64
+	Date         string
+	Price        float64
+	Package      float64
+}
+
+// Represents an amount of an inventory ingredient consumed by a brew. The
+// amount is expressed in the ingredient stock unit, and is taken from a given
+// lot.
+type Deduction struct {
+	Id           int64
+	BrewId       int64
+	IngredientId int64
+	Amount       float64
+	LotId        int64
+	Lots         []int64 // Lots to deduct from, any if empty. Not stored.
+}
+
+// Represents a brew.
+type Brew struct {
+	Id             int64
+	RecipeId       int64
+	UserId         int64
+	Step           int64
+	File           string
+	OrganizationId int64
+	// Summary of the BeerXML recipe, so listings don't need to import it.
+	Name           string
+	Date           string
+	XML            *beerxml.Recipe
+}
+
+// Represents a reading logged during a brew fermentation.
+type Reading struct {
+	Id          int64
+	BrewId      int64
+	Date        time.Time
+	Gravity     float64
+	Temperature *float64 // °C, nil when not measured.
+	Ph          float64
+	Note        string
+	Battery     float64  // Battery voltage of the hydrometer, if any.
+	Angle       float64  // Tilt angle of the hydrometer, in degrees, if any.
+	DeviceId    int64    // Hydrometer which sent the reading, if any.
+}
+
 // Brew steps.
 const (
 	StepPrepare = iota
@@ -17,14 +17,33 @@ package db
 
 import (
 	"bytes"
+	"database/sql"
 	"encoding/base64"
+	"errors"
+	"strings"
+	"time"
 	"golang.org/x/crypto/scrypt"
 
 	_ "github.com/mattn/go-sqlite3"
 )
 
+// Time to wait for a free slot to hash a password.
+const hashWait = 5 * time.Second
+
+// Returned when too many passwords are being hashed.
+var ErrBusy = errors.New("Too many requests, please try again later.")
+
 // Hashes a plaintext password.
 func (db *DB) HashPassword(user, password string) (string, error) {
+	// Each hash uses 32 MB and a CPU for about 100 ms: bound how many run
+	// concurrently.
+	select {
+	case db.hashes <- struct{}{}:
+		defer func() { <-db.hashes }()
+	case <-time.After(hashWait):
+		return "", ErrBusy
+	}
+
 	// Use the username as part of the salt.
 	var salt bytes.Buffer
 	salt.Write([]byte(user))
@@ -43,13 +62,30 @@ func (db *DB) HashPassword(user, password string) (string, error) {
 	return base64.StdEncoding.EncodeToString(dk), nil
 }
 
+// Columns of the users, in the order of the User fields.
+const userColumns = `
+id, email, password, registration_date, token, enabled, lang, currency, water_price, energy_price,
+energy_use, workspace, totp_secret, totp_step, recovery_codes`
+
+// Scan an user.
+func scanUser(row *sql.Row, u *User) error {
+	var codes string
+	err := row.Scan(&u.Id, &u.Email, &u.Password, &u.RegistrationDate, &u.Token, &u.Enabled,
+			&u.Lang, &u.Currency, &u.WaterPrice, &u.EnergyPrice, &u.EnergyUse,
+			&u.Workspace, &u.TOTPSecret, &u.TOTPStep, &codes)
+	if err != nil {
+		return err
+	}
+
+	u.RecoveryCodes = strings.Fields(codes)
+	return nil
+}
+
 // Retrieves an user information from the db, given an email.
 func (db *DB) GetUserByEmail(email string) (*User, error) {
 	var u User
-	err := db.QueryRow("SELECT * FROM users WHERE email == $1", email).
-		Scan(&u.Id, &u.Email, &u.Password, &u.RegistrationDate, &u.Token,
-		     &u.Enabled, &u.Lang)
-	if err != nil {
+	row := db.QueryRow("SELECT " + userColumns + " FROM users WHERE email == $1", email)
+	if err := scanUser(row, &u); err != nil {
 		return nil, err
 	}
 	return &u, nil
@@ -58,10 +94,8 @@ func (db *DB) GetUserByEmail(email string) (*User, error) {
 // Retrieves an user information from the db, given an uid.
 func (db *DB) GetUserById(uid int64) (*User, error) {
 	var u User
-	err := db.QueryRow("SELECT * FROM users WHERE id == $1", uid).
-		Scan(&u.Id, &u.Email, &u.Password, &u.RegistrationDate, &u.Token,
-		     &u.Enabled, &u.Lang)
-	if err != nil {
+	row := db.QueryRow("SELECT " + userColumns + " FROM users WHERE id == $1", uid)
+	if err := scanUser(row, &u); err != nil {
 		return nil, err
 	}
 	return &u, nil
@@ -85,15 +119,36 @@ func (db *DB) ActivateUser(token string) error {
 // Update an user info.
 func (db *DB) UpdateUser(u *User) error {
 	_, err := db.Exec(`
-REPLACE INTO users (id, email, password, token, enabled, lang)
-VALUES (?, ?, ?, ?, ?, ?)`, u.Id, u.Email, u.Password, u.Token, u.Enabled, u.Lang)
+REPLACE INTO users (id, email, password, token, enabled, lang, currency, water_price,
+		   energy_price, energy_use, workspace, totp_secret, totp_step, recovery_codes)
+VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`, u.Id, u.Email, u.Password, u.Token, u.Enabled,
+		u.Lang, u.Currency, u.WaterPrice, u.EnergyPrice, u.EnergyUse, u.Workspace, u.TOTPSecret,
+		u.TOTPStep, strings.Join(u.RecoveryCodes, " "))
 	return err
 }
 
-// Delete an user and all its data.
+// Returned when deleting the last owner of an organization.
+var ErrSoleOwner = errors.New("The account is the last owner of an organization: " +
+			      "transfer its ownership or delete it first.")
+
+// Delete an user and all its data. Users owning alone an organization can't be
+// deleted, as nobody could manage it anymore.
 func (db *DB) DeleteUser(u *User) error {
+	var owned int
+	err := db.QueryRow(`
+SELECT COUNT(*) FROM members AS m
+WHERE m.user_id == ? AND m.role == ? AND NOT EXISTS (
+	SELECT 1 FROM members AS o
+	WHERE o.organization_id == m.organization_id AND o.role == ? AND o.user_id != m.user_id)`,
+			   u.Id, RoleOwner, RoleOwner).Scan(&owned)
+	if err != nil {
+		return err
+	} else if owned > 0 {
+		return ErrSoleOwner
+	}
+
 	// Delete all the ingredients associated to the user.
-	ingredients, err := db.GetUserIngredients(u.Id)
+	ingredients, err := db.GetIngredients(Owner{ UserId: u.Id })
 	if err != nil {
 		return err
 	}
@@ -105,7 +160,7 @@ func (db *DB) DeleteUser(u *User) error {
 	}
 
 	// Delete all the recipes associated to the user.
-	recipes, err := db.GetUserRecipes(u.Id)
+	recipes, err := db.GetRecipes(Owner{ UserId: u.Id })
 	if err != nil {
 		return err
 	}
@@ -116,7 +171,7 @@ func (db *DB) DeleteUser(u *User) error {
 	}
 
 	// Delete all the ingredients associated to the user.
-	inventory, err := db.GetUserIngredients(u.Id)
+	inventory, err := db.GetIngredients(Owner{ UserId: u.Id })
 	if err != nil {
 		return err
 	}
@@ -126,6 +181,42 @@ func (db *DB) DeleteUser(u *User) error {
 		}
 	}
 
+	// Leave all organizations.
+	if _, err := db.Exec("DELETE FROM members WHERE user_id == ?", u.Id); err != nil {
+		return err
+	}
+
+	// Revoke all its personal access tokens.
+	if _, err := db.Exec("DELETE FROM tokens WHERE user_id == ?", u.Id); err != nil {
+		return err
+	}
+
+	// Sign it out everywhere.
+	if err := db.DeleteUserSessions(u.Id, 0); err != nil {
+		return err
+	}
+
+	// Unlink its OpenID Connect accounts.
+	if _, err := db.Exec("DELETE FROM identities WHERE user_id == ?", u.Id); err != nil {
+		return err
+	}
+
+	// Drop its pending password resets.
+	if _, err := db.Exec("DELETE FROM resets WHERE user_id == ?", u.Id); err != nil {
+		return err
+	}
+
+	// Delete its webhooks.
+	if err := db.deleteWebhooks("user_id == ?", u.Id); err != nil {
+		return err
+	}
+
+	// Delete its personal hydrometers.
+	_, err = db.Exec("DELETE FROM devices WHERE user_id == ? AND organization_id == 0", u.Id)
+	if err != nil {
+		return err
+	}
+
 	// Now, delete the user itself. Do this at the end: if something went
 	// bad, the user can still sign in to report the issue.
 	if _, err := db.Exec("DELETE FROM users WHERE id == ?", u.Id); err != nil {
@@ -0,0 +1,78 @@
+// Copyright (C) 2019 Antoine Tenart <antoine.tenart@ack.tf>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+
+package db
+
+import (
+	"testing"
+)
+
+func TestDeleteUserSoleOwner(t *testing.T) {
+	db := openTestDB(t)
+
+	var users []*User
+	for _, email := range []string{ "owner@example.com", "other@example.com" } {
+		if err := db.AddUser(email, "", GenToken(32), false); err != nil {
+			t.Fatal(err)
+		}
+		u, err := db.GetUserByEmail(email)
+		if err != nil {
+			t.Fatal(err)
+		}
+		users = append(users, u)
+	}
+	owner, other := users[0], users[1]
+
+	o := &Organization{ Name: "Brewery" }
+	if err := db.AddOrganization(o, owner.Id); err != nil {
+		t.Fatal(err)
+	}
+	m := &Member{ OrganizationId: o.Id, UserId: other.Id, Role: RoleBrewer }
+	if err := db.AddMember(m); err != nil {
+		t.Fatal(err)
+	}
+
+	// The organization would be left without an owner.
+	if err := db.DeleteUser(owner); err != ErrSoleOwner {
+		t.Fatalf("deleting the sole owner: %v, want %v", err, ErrSoleOwner)
+	}
+	if _, err := db.GetMember(o.Id, owner.Id); err != nil {
+		t.Errorf("sole owner left the organization: %v", err)
+	}
+
+	// Other members can be deleted.
+	if err := db.DeleteUser(other); err != nil {
+		t.Fatal(err)
+	}
+
+	// Once another owner exists, the user can go.
+	if err := db.AddUser("new@example.com", "", GenToken(32), false); err != nil {
+		t.Fatal(err)
+	}
+	u, err := db.GetUserByEmail("new@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.AddMember(&Member{ OrganizationId: o.Id, UserId: u.Id, Role: RoleOwner }); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.DeleteUser(owner); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.GetMember(o.Id, owner.Id); err == nil {
+		t.Error("deleted user still a member")
+	}
+}
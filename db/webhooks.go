@@ -0,0 +1,217 @@
+// Copyright (C) 2019 Antoine Tenart <antoine.tenart@ack.tf>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+
+package db
+
+import (
+	"database/sql"
+	"strings"
+)
+
+// Retrieve a list of webhooks.
+func (db *DB) getWebhooks(query string, args ...interface{}) ([]*Webhook, error) {
+	row, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer row.Close()
+
+	var webhooks []*Webhook
+	for row.Next() {
+		var w Webhook
+		var events string
+		err := row.Scan(&w.Id, &w.UserId, &w.OrganizationId, &w.URL, &w.Secret, &events,
+				&w.Organization)
+		if err != nil {
+			return nil, err
+		}
+
+		w.Events = strings.Fields(events)
+		webhooks = append(webhooks, &w)
+	}
+
+	return webhooks, nil
+}
+
+const webhooksQuery = `
+SELECT webhooks.*, IFNULL(organizations.name, "") FROM webhooks
+LEFT JOIN organizations ON organizations.id == webhooks.organization_id`
+
+// Retrieve the webhooks of a given user.
+func (db *DB) GetUserWebhooks(uid int64) ([]*Webhook, error) {
+	return db.getWebhooks(webhooksQuery + " WHERE webhooks.user_id == ? ORDER BY webhooks.id", uid)
+}
+
+// Retrieve the webhooks subscribed to an event on the data of a given owner.
+// Webhooks on an organization are only kept while their user is a member.
+func (db *DB) GetOwnerWebhooks(o Owner, event string) ([]*Webhook, error) {
+	var webhooks []*Webhook
+	var err error
+	if o.OrganizationId != 0 {
+		webhooks, err = db.getWebhooks(webhooksQuery + `
+WHERE webhooks.organization_id == ? AND webhooks.user_id IN
+	(SELECT user_id FROM members WHERE organization_id == ?)`, o.OrganizationId, o.OrganizationId)
+	} else {
+		webhooks, err = db.getWebhooks(webhooksQuery + `
+WHERE webhooks.organization_id == 0 AND webhooks.user_id == ?`, o.UserId)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var subscribed []*Webhook
+	for _, w := range webhooks {
+		if w.HasEvent(event) {
+			subscribed = append(subscribed, w)
+		}
+	}
+	return subscribed, nil
+}
+
+// Retrieve a webhook given its id.
+func (db *DB) GetWebhook(id int64) (*Webhook, error) {
+	webhooks, err := db.getWebhooks(webhooksQuery + " WHERE webhooks.id == ?", id)
+	if err != nil {
+		return nil, err
+	} else if len(webhooks) == 0 {
+		return nil, sql.ErrNoRows
+	}
+	return webhooks[0], nil
+}
+
+// Add a new webhook, generating the key of its signatures.
+func (db *DB) AddWebhook(w *Webhook) error {
+	secret, err := randomHex(32)
+	if err != nil {
+		return err
+	}
+
+	w.Secret = secret
+	result, err := db.Exec(`
+INSERT INTO webhooks (user_id, organization_id, url, secret, events)
+VALUES (?, ?, ?, ?, ?)`, w.UserId, w.OrganizationId, w.URL, w.Secret, strings.Join(w.Events, " "))
+	if err != nil {
+		return err
+	}
+
+	w.Id, err = result.LastInsertId()
+	return err
+}
+
+// Delete a webhook of a given user, and its deliveries.
+func (db *DB) DeleteWebhook(id, uid int64) error {
+	_, err := db.Exec(`
+DELETE FROM deliveries WHERE webhook_id IN
+	(SELECT id FROM webhooks WHERE id == ? AND user_id == ?)`, id, uid)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec("DELETE FROM webhooks WHERE id == ? AND user_id == ?", id, uid)
+	return err
+}
+
+// Delete the webhooks matching a condition, and their deliveries.
+func (db *DB) deleteWebhooks(cond string, args ...interface{}) error {
+	_, err := db.Exec("DELETE FROM deliveries WHERE webhook_id IN (SELECT id FROM webhooks WHERE " +
+			  cond + ")", args...)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec("DELETE FROM webhooks WHERE " + cond, args...)
+	return err
+}
+
+// Check a webhook is subscribed to an event.
+func (w *Webhook) HasEvent(event string) bool {
+	for _, e := range w.Events {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+// Retrieve a list of deliveries.
+func (db *DB) getDeliveries(query string, args ...interface{}) ([]*Delivery, error) {
+	row, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer row.Close()
+
+	var deliveries []*Delivery
+	for row.Next() {
+		var d Delivery
+		err := row.Scan(&d.Id, &d.WebhookId, &d.Event, &d.Payload, &d.Created, &d.Status,
+				&d.Attempts, &d.NextAttempt, &d.Response, &d.Error, &d.URL)
+		if err != nil {
+			return nil, err
+		}
+
+		deliveries = append(deliveries, &d)
+	}
+
+	return deliveries, nil
+}
+
+const deliveriesQuery = `
+SELECT deliveries.*, webhooks.url FROM deliveries
+JOIN webhooks ON webhooks.id == deliveries.webhook_id`
+
+// Retrieve the last deliveries to the webhooks of a given user.
+func (db *DB) GetUserDeliveries(uid int64, limit int) ([]*Delivery, error) {
+	return db.getDeliveries(deliveriesQuery + `
+WHERE webhooks.user_id == ? ORDER BY deliveries.id DESC LIMIT ?`, uid, limit)
+}
+
+// Retrieve the pending deliveries due at a given date (YYYY-MM-DD HH:MM:SS,
+// UTC).
+func (db *DB) GetDueDeliveries(now string) ([]*Delivery, error) {
+	return db.getDeliveries(deliveriesQuery + `
+WHERE deliveries.status == ? AND deliveries.next_attempt <= ? ORDER BY deliveries.id`,
+				DeliveryPending, now)
+}
+
+// Add a new delivery.
+func (db *DB) AddDelivery(d *Delivery) error {
+	result, err := db.Exec(`
+INSERT INTO deliveries (webhook_id, event, payload, created, status, next_attempt)
+VALUES (?, ?, ?, ?, ?, ?)`, d.WebhookId, d.Event, d.Payload, d.Created, d.Status, d.NextAttempt)
+	if err != nil {
+		return err
+	}
+
+	d.Id, err = result.LastInsertId()
+	return err
+}
+
+// Update a delivery, after an attempt.
+func (db *DB) UpdateDelivery(d *Delivery) error {
+	_, err := db.Exec(`
+UPDATE deliveries SET status = ?, attempts = ?, next_attempt = ?, response = ?, error = ?
+WHERE id == ?`, d.Status, d.Attempts, d.NextAttempt, d.Response, d.Error, d.Id)
+	return err
+}
+
+// Delete the deliveries created before a given date (YYYY-MM-DD HH:MM:SS, UTC)
+// and not pending anymore.
+func (db *DB) PruneDeliveries(before string) error {
+	_, err := db.Exec("DELETE FROM deliveries WHERE created < ? AND status != ?",
+			  before, DeliveryPending)
+	return err
+}
@@ -0,0 +1,89 @@
+// Copyright (C) 2019 Antoine Tenart <antoine.tenart@ack.tf>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package httpserver
+
+import (
+	"fmt"
+
+	"github.com/atenart/bubbles/db"
+)
+
+// Error returned when an user can't access an item.
+var errPermission = fmt.Errorf("Permission denied.")
+
+// Kind of access to recipes, ingredients and brews.
+const (
+	accessRead = iota
+	accessWrite
+)
+
+// Role of an user on the items of a given owner. Users own their personal
+// space, and have the role given by their membership in organizations. An empty
+// role means no access at all.
+func (s *Server) role(user *db.User, owner db.Owner) string {
+	if owner.OrganizationId == 0 {
+		if owner.UserId == user.Id {
+			return db.RoleOwner
+		}
+		return ""
+	}
+
+	member, err := s.db.GetMember(owner.OrganizationId, user.Id)
+	if err != nil {
+		return ""
+	}
+	return member.Role
+}
+
+// Check an user can access the items of a given owner: viewers can only read
+// them, brewers and owners can also modify them.
+func (s *Server) checkAccess(user *db.User, owner db.Owner, access int) error {
+	switch s.role(user, owner) {
+	case db.RoleOwner, db.RoleBrewer:
+		return nil
+	case db.RoleViewer:
+		if access == accessRead {
+			return nil
+		}
+	}
+
+	// TODO: give more feedback.
+	return errPermission
+}
+
+// Owner of the space an user is working in: its personal space or one of its
+// organizations. Listings show the items of this space, and new items are
+// created in it.
+func (s *Server) workspace(user *db.User) db.Owner {
+	if user.Workspace != 0 {
+		return db.Owner{ OrganizationId: user.Workspace }
+	}
+	return db.Owner{ UserId: user.Id }
+}
+
+// Name of the organization an user is working in, empty for its personal
+// space.
+func (s *Server) workspaceName(user *db.User) string {
+	if user.Workspace == 0 {
+		return ""
+	}
+
+	organization, err := s.db.GetOrganization(user.Workspace)
+	if err != nil {
+		return ""
+	}
+	return organization.Name
+}
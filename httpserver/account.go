@@ -19,6 +19,7 @@ import (
 	"fmt"
 	"html/template"
 	"net/http"
+	"strconv"
 	"strings"
 	"time"
 
@@ -29,16 +30,76 @@ import (
 
 // Account page (per-user).
 func (s *Server) account(w http.ResponseWriter, r *http.Request, user *db.User) {
+	s.accountPage(w, r, user, "")
+}
+
+// Display the account page. A newly created personal access token can be given,
+// to be shown once.
+func (s *Server) accountPage(w http.ResponseWriter, r *http.Request, user *db.User, secret string) {
+	tokens, err := s.db.GetUserTokens(user.Id)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+
+	webhooks, err := s.db.GetUserWebhooks(user.Id)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+
+	deliveries, err := s.db.GetUserDeliveries(user.Id, webhookLogSize)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+
+	memberships, err := s.db.GetUserMemberships(user.Id)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+
+	sessions, err := s.db.GetUserSessions(user.Id)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+
+	current, err := s.session(r)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+
 	s.executeTemplate(w, user, "account.html", struct{
 		CSRF	template.HTML
 		Title	string
-		User    *db.User
-		Tags    []string
+		User        *db.User
+		Tags        []string
+		Tokens      []*db.Token
+		Scopes      []string
+		Secret      string
+		Webhooks    []*db.Webhook
+		Deliveries  []*db.Delivery
+		Memberships []*db.Member
+		Events      []string
+		Sessions    []*db.Session
+		Current     int64
 	}{
 		csrf.TemplateField(r),
 		"Bubbles - account",
 		user,
 		s.i18n.Tags(),
+		tokens,
+		scopes,
+		secret,
+		webhooks,
+		deliveries,
+		memberships,
+		events,
+		sessions,
+		current.Id,
 	})
 }
 
@@ -55,12 +116,16 @@ func (s *Server) saveAccount(w http.ResponseWriter, r *http.Request, user *db.Us
 	confirmPassword := r.FormValue("confirm-password")
 
 	user.Lang = r.FormValue("lang")
+	user.Currency = r.FormValue("currency")
+	user.WaterPrice, _ = strconv.ParseFloat(r.FormValue("water-price"), 64)
+	user.EnergyPrice, _ = strconv.ParseFloat(r.FormValue("energy-price"), 64)
+	user.EnergyUse, _ = strconv.ParseFloat(r.FormValue("energy-use"), 64)
 
 	// Password udate
 	if currentPassword != "" && newPassword != "" && confirmPassword != "" {
 		currentHash, err := s.db.HashPassword(user.Email, currentPassword)
 		if err != nil {
-			http.Error(w, err.Error(), 500)
+			hashError(w, err)
 			return
 		}
 
@@ -76,6 +141,21 @@ func (s *Server) saveAccount(w http.ResponseWriter, r *http.Request, user *db.Us
 
 		user.Password, err = s.db.HashPassword(user.Email, newPassword)
 		if err != nil {
+			hashError(w, err)
+			return
+		}
+
+		// Sign out everywhere else.
+		current, err := s.session(r)
+		if err != nil {
+			http.Error(w, err.Error(), 500)
+			return
+		} else if current == nil {
+			// The session ended since the request was authenticated.
+			s.loginPage(w, r)
+			return
+		}
+		if err := s.db.DeleteUserSessions(user.Id, current.Id); err != nil {
 			http.Error(w, err.Error(), 500)
 			return
 		}
@@ -99,15 +179,17 @@ func (s *Server) deleteAccount(w http.ResponseWriter, r *http.Request, user *db.
 	s.logout(w, r)
 }
 
-// Export an user data (recipes & inventory) into a single BeerXML file.
+// Export an user personal data (recipes & inventory) into a single BeerXML
+// file.
 func (s *Server) exportData(w http.ResponseWriter, r *http.Request, user *db.User) {
-	recipes, err := s.db.GetUserRecipes(user.Id)
+	owner := db.Owner{ UserId: user.Id }
+	recipes, err := s.db.GetRecipes(owner)
 	if err != nil {
 		http.Error(w, err.Error(), 500)
 		return
 	}
 
-	ingredients, err := s.db.GetUserIngredients(user.Id)
+	ingredients, err := s.db.GetIngredients(owner)
 	if err != nil {
 		http.Error(w, err.Error(), 500)
 		return
@@ -118,6 +200,7 @@ func (s *Server) exportData(w http.ResponseWriter, r *http.Request, user *db.Use
 		beerxml.InsertToXML(&xml, r.XML)
 	}
 	for _, i := range ingredients {
+		setInventory(i)
 		beerxml.InsertToXML(&xml, i.XML)
 	}
 
@@ -132,7 +215,7 @@ func (s *Server) exportData(w http.ResponseWriter, r *http.Request, user *db.Use
 	}
 }
 
-// Import an user data (recipes & inventory) into the DB.
+// Import an user data (recipes & inventory) into its personal space.
 func (s *Server) importData(w http.ResponseWriter, r *http.Request, user *db.User) {
 	// Parse the POSTed form.
 	if err := r.ParseMultipartForm(s.uploadMax); err != nil {
@@ -163,7 +246,14 @@ func (s *Server) importData(w http.ResponseWriter, r *http.Request, user *db.Use
 			XML:    &r,
 		}
 
-		if _, err := s.db.AddRecipe(recipe); err != nil {
+		id, err := s.db.AddRecipe(recipe)
+		if err != nil {
+			http.Error(w, err.Error(), 500)
+			return
+		}
+
+		recipe.Id = id
+		if err := s.addRevision(recipe, "import"); err != nil {
 			http.Error(w, err.Error(), 500)
 			return
 		}
@@ -179,6 +269,7 @@ func (s *Server) importData(w http.ResponseWriter, r *http.Request, user *db.Use
 			Type:   "fermentable",
 			XML:    &f,
 		}
+		parseInventory(ingredient, f.Inventory)
 
 		s.db.AddIngredient(ingredient)
 	}
@@ -189,6 +280,7 @@ func (s *Server) importData(w http.ResponseWriter, r *http.Request, user *db.Use
 			Type:   "hop",
 			XML:    &h,
 		}
+		parseInventory(ingredient, h.Inventory)
 
 		s.db.AddIngredient(ingredient)
 	}
@@ -199,6 +291,7 @@ func (s *Server) importData(w http.ResponseWriter, r *http.Request, user *db.Use
 			Type:   "yeast",
 			XML:    &y,
 		}
+		parseInventory(ingredient, y.Inventory)
 
 		s.db.AddIngredient(ingredient)
 	}
@@ -0,0 +1,159 @@
+// Copyright (C) 2019 Antoine Tenart <antoine.tenart@ack.tf>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+
+package httpserver
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/csrf"
+	"github.com/gorilla/mux"
+	"github.com/atenart/bubbles/db"
+)
+
+// Error answered by the API, as JSON. Validation errors are described by field,
+// using their JSON name (e.g. "hops[1].alpha").
+type apiError struct {
+	status  int
+	Message string            `json:"error"`
+	Fields  map[string]string `json:"fields,omitempty"`
+}
+
+func (e *apiError) Error() string {
+	return e.Message
+}
+
+// HTTP handler wrapper for user session enforcement, in the API.
+func (s *Server) apiSessionHandler(fn func(http.ResponseWriter, *http.Request, *db.User)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// The API is exempted from CSRF tokens: requests which are not
+		// read-only must be JSON ones, which browsers can't send
+		// cross-site without a CORS preflight.
+		if r.Method != "GET" && r.Method != "HEAD" && !isJSON(r) {
+			apiFail(w, &apiError{ status: http.StatusUnsupportedMediaType,
+					      Message: "Requests must use the application/json content type." })
+			return
+		}
+
+		// Personal access tokens take precedence over the session
+		// cookie.
+		var user *db.User
+		var err error
+		if auth := r.Header.Get("Authorization"); auth != "" {
+			user, err = s.tokenUser(r, auth)
+		} else {
+			user, err = s.sessionUser(r)
+		}
+		if err != nil {
+			apiFail(w, err)
+			return
+		} else if user == nil {
+			apiFail(w, &apiError{ status: http.StatusUnauthorized,
+					      Message: "Authentication required." })
+			return
+		}
+
+		// Jump to the real handler.
+		fn(w, r, user)
+	}
+}
+
+// Check the body of a request is JSON.
+func isJSON(r *http.Request) bool {
+	media, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	return err == nil && media == "application/json"
+}
+
+// HTTP handler wrapper skipping the CSRF checks for the API, see
+// apiSessionHandler.
+func skipAPICSRF(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, "/api/") {
+			r = csrf.UnsafeSkipCheck(r)
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// Answer an API request with a given status and a value encoded as JSON. A nil
+// value gives an empty body.
+func apiWrite(w http.ResponseWriter, status int, v interface{}) {
+	if v == nil {
+		w.WriteHeader(status)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// Answer an API request with an error. Items not found and access denied are
+// reported with their own status, other errors are internal ones.
+func apiFail(w http.ResponseWriter, err error) {
+	e, ok := err.(*apiError)
+	if !ok {
+		switch err {
+		case sql.ErrNoRows:
+			e = &apiError{ status: http.StatusNotFound, Message: "Not found." }
+		case errPermission:
+			e = &apiError{ status: http.StatusForbidden, Message: err.Error() }
+		default:
+			e = &apiError{ status: http.StatusInternalServerError, Message: err.Error() }
+		}
+	}
+
+	apiWrite(w, e.status, e)
+}
+
+// Report an invalid field of an API request.
+func invalidField(field, msg string) error {
+	return &apiError{
+		status:  http.StatusUnprocessableEntity,
+		Message: "Validation failed.",
+		Fields:  map[string]string{ field: msg },
+	}
+}
+
+// Retrieve an id from the path of an API request.
+func apiVar(r *http.Request, name string) (int64, error) {
+	id, err := strconv.ParseInt(mux.Vars(r)[name], 10, 64)
+	if err != nil {
+		return 0, &apiError{ status: http.StatusNotFound, Message: "Not found." }
+	}
+	return id, nil
+}
+
+// Decode the JSON body of an API request, already validated against the schema
+// of its operation (see validateRequest). An empty body is decoded as an empty
+// object.
+func (s *Server) decodeJSON(w http.ResponseWriter, r *http.Request, v interface{}) error {
+	d := json.NewDecoder(http.MaxBytesReader(w, r.Body, s.uploadMax))
+	d.DisallowUnknownFields()
+	if err := d.Decode(v); err != nil && err != io.EOF {
+		return &apiError{ status: http.StatusBadRequest,
+				  Message: fmt.Sprintf("Invalid JSON body: %s.", err) }
+	}
+
+	return nil
+}
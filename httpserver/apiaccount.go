@@ -0,0 +1,105 @@
+// Copyright (C) 2019 Antoine Tenart <antoine.tenart@ack.tf>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+
+package httpserver
+
+import (
+	"net/http"
+
+	"github.com/atenart/bubbles/db"
+)
+
+// Account of the current user, in the API. Prices are in the account currency,
+// the water price is per m³, the energy price per kWh and the energy use in kWh
+// per batch. The workspace is the organization worked in, 0 for the personal
+// space. An empty language keeps the current one.
+type apiAccount struct {
+	Id               int64   `json:"id" readonly:"true"`
+	Email            string  `json:"email" readonly:"true"`
+	RegistrationDate string  `json:"registration_date" readonly:"true"`
+	Lang             string  `json:"lang"`
+	Currency         string  `json:"currency"`
+	WaterPrice       float64 `json:"water_price" min:"0"`
+	EnergyPrice      float64 `json:"energy_price" min:"0"`
+	EnergyUse        float64 `json:"energy_use" min:"0"`
+	Workspace        int64   `json:"workspace" min:"0"`
+}
+
+// Convert an user account for the API.
+func accountToJSON(user *db.User) *apiAccount {
+	return &apiAccount{
+		Id:               user.Id,
+		Email:            user.Email,
+		RegistrationDate: user.RegistrationDate,
+		Lang:             user.Lang,
+		Currency:         user.Currency,
+		WaterPrice:       user.WaterPrice,
+		EnergyPrice:      user.EnergyPrice,
+		EnergyUse:        user.EnergyUse,
+		Workspace:        user.Workspace,
+	}
+}
+
+// Retrieve the account of the current user.
+func (s *Server) apiAccount(w http.ResponseWriter, r *http.Request, user *db.User) {
+	apiWrite(w, http.StatusOK, accountToJSON(user))
+}
+
+// Update the account of the current user.
+func (s *Server) apiSaveAccount(w http.ResponseWriter, r *http.Request, user *db.User) {
+	var a apiAccount
+	if err := s.decodeJSON(w, r, &a); err != nil {
+		apiFail(w, err)
+		return
+	}
+
+	if a.Lang != "" {
+		found := false
+		for _, tag := range s.i18n.Tags() {
+			if a.Lang == tag {
+				found = true
+				break
+			}
+		}
+		if !found {
+			apiFail(w, invalidField("lang", "unknown language"))
+			return
+		}
+	}
+
+	if a.Workspace != 0 {
+		if _, err := s.db.GetMember(a.Workspace, user.Id); err != nil {
+			apiFail(w, invalidField("workspace", "not a member of this organization"))
+			return
+		}
+	}
+
+	if a.Lang != "" {
+		user.Lang = a.Lang
+	}
+	user.Currency = a.Currency
+	user.WaterPrice = a.WaterPrice
+	user.EnergyPrice = a.EnergyPrice
+	user.EnergyUse = a.EnergyUse
+	user.Workspace = a.Workspace
+
+	if err := s.db.UpdateUser(user); err != nil {
+		apiFail(w, err)
+		return
+	}
+
+	apiWrite(w, http.StatusOK, accountToJSON(user))
+}
@@ -0,0 +1,288 @@
+// Copyright (C) 2019 Antoine Tenart <antoine.tenart@ack.tf>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+
+package httpserver
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/atenart/bubbles/db"
+)
+
+// Brew, as listed in the API.
+type apiBrewSummary struct {
+	Id       int64  `json:"id"`
+	RecipeId int64  `json:"recipe_id"`
+	Name     string `json:"name"`
+	Date     string `json:"date"`
+	Step     int64  `json:"step"`
+	StepName string `json:"step_name"`
+}
+
+// Brew, in the API. Only the measured gravities and the notes can be updated,
+// the recipe is the snapshot taken when the brew was created.
+type apiBrew struct {
+	Id          int64     `json:"id" readonly:"true"`
+	RecipeId    int64     `json:"recipe_id" readonly:"true"`
+	Name        string    `json:"name" readonly:"true"`
+	Date        string    `json:"date" readonly:"true"`
+	Step        int64     `json:"step" readonly:"true"`
+	StepName    string    `json:"step_name" readonly:"true"`
+	OG          float64   `json:"og" min:"0"`
+	FG          float64   `json:"fg" min:"0"`
+	ABV         float64   `json:"abv" readonly:"true"`
+	Notes       string    `json:"notes"`
+	TasteNotes  string    `json:"taste_notes"`
+	Recipe      apiRecipe `json:"recipe" readonly:"true"`
+	// Inventory ingredients not deducted when starting the brew, as their
+	// units do not match.
+	NotDeducted []string  `json:"not_deducted,omitempty" readonly:"true"`
+}
+
+// New brew, in the API.
+type apiNewBrew struct {
+	RecipeId int64 `json:"recipe_id" required:"true"`
+}
+
+// Step transition of a brew, in the API. Starting a brew requires to choose
+// whether the ingredients used are deducted from the inventory.
+type apiBrewStep struct {
+	Stock string `json:"stock" enum:"deduct|keep"`
+}
+
+// Convert a brew summary for the API.
+func brewSummaryToJSON(brew *db.Brew) apiBrewSummary {
+	name, _ := db.StepName(brew.Step)
+	return apiBrewSummary{ brew.Id, brew.RecipeId, brew.Name, brew.Date, brew.Step, name }
+}
+
+// Convert a brew for the API.
+func brewToJSON(brew *db.Brew) *apiBrew {
+	name, _ := db.StepName(brew.Step)
+	return &apiBrew{
+		Id:         brew.Id,
+		RecipeId:   brew.RecipeId,
+		Name:       brew.XML.Name,
+		Date:       brew.XML.Date,
+		Step:       brew.Step,
+		StepName:   name,
+		OG:         finite(brew.XML.OG),
+		FG:         finite(brew.XML.FG),
+		ABV:        finite(brew.XML.ABV),
+		Notes:      brew.XML.Notes,
+		TasteNotes: brew.XML.TasteNotes,
+		Recipe:     *recipeToJSON(&db.Recipe{
+			Id:   brew.RecipeId,
+			Name: brew.XML.Name,
+			XML:  brew.XML,
+		}),
+	}
+}
+
+// List the brews of the current workspace.
+func (s *Server) apiBrews(w http.ResponseWriter, r *http.Request, user *db.User) {
+	brews, err := s.db.GetBrews(s.workspace(user))
+	if err != nil {
+		apiFail(w, err)
+		return
+	}
+
+	list := []apiBrewSummary{}
+	for _, b := range brews {
+		list = append(list, brewSummaryToJSON(b))
+	}
+
+	apiWrite(w, http.StatusOK, list)
+}
+
+// Create a new brew from a recipe. Brews belong to the recipe owner.
+func (s *Server) apiNewBrew(w http.ResponseWriter, r *http.Request, user *db.User) {
+	var a apiNewBrew
+	if err := s.decodeJSON(w, r, &a); err != nil {
+		apiFail(w, err)
+		return
+	}
+
+	recipe, err := s.getRecipe(a.RecipeId, user, accessWrite)
+	if err != nil {
+		apiFail(w, err)
+		return
+	}
+
+	brew := &db.Brew{
+		UserId:         recipe.UserId,
+		OrganizationId: recipe.OrganizationId,
+		RecipeId:       recipe.Id,
+		Step:           db.StepPrepare,
+		XML:            recipe.XML,
+	}
+
+	if brew.Id, err = s.db.AddBrew(brew); err != nil {
+		apiFail(w, err)
+		return
+	}
+	s.notify(brew.Owner(), db.EventBrewCreated, brewSummaryToJSON(brew))
+
+	w.Header().Set("Location", fmt.Sprintf("/api/v1/brews/%d", brew.Id))
+	apiWrite(w, http.StatusCreated, brewToJSON(brew))
+}
+
+// Retrieve the brew given in the path of an API request, checking the user has
+// the requested access to it.
+func (s *Server) apiGetBrew(r *http.Request, user *db.User, access int) (*db.Brew, error) {
+	id, err := apiVar(r, "Id")
+	if err != nil {
+		return nil, err
+	}
+
+	return s.getBrew(id, user, access)
+}
+
+// Retrieve a brew.
+func (s *Server) apiBrew(w http.ResponseWriter, r *http.Request, user *db.User) {
+	brew, err := s.apiGetBrew(r, user, accessRead)
+	if err != nil {
+		apiFail(w, err)
+		return
+	}
+
+	apiWrite(w, http.StatusOK, brewToJSON(brew))
+}
+
+// Update the measures and notes of a brew.
+func (s *Server) apiUpdateBrew(w http.ResponseWriter, r *http.Request, user *db.User) {
+	brew, err := s.apiGetBrew(r, user, accessWrite)
+	if err != nil {
+		apiFail(w, err)
+		return
+	}
+
+	var a apiBrew
+	if err := s.decodeJSON(w, r, &a); err != nil {
+		apiFail(w, err)
+		return
+	}
+
+	brew.XML.OG = a.OG
+	brew.XML.FG = a.FG
+	brew.XML.Notes = a.Notes
+	brew.XML.TasteNotes = a.TasteNotes
+
+	// Update calc params.
+	if brew.XML.OG > 0 && brew.XML.FG > 0 {
+		brew.XML.ABV = math.Round(brew.XML.CalcRealABV() * 10) / 10
+	}
+
+	if err := s.db.UpdateBrew(brew); err != nil {
+		apiFail(w, err)
+		return
+	}
+
+	apiWrite(w, http.StatusOK, brewToJSON(brew))
+}
+
+// Delete a brew.
+func (s *Server) apiDeleteBrew(w http.ResponseWriter, r *http.Request, user *db.User) {
+	brew, err := s.apiGetBrew(r, user, accessWrite)
+	if err != nil {
+		apiFail(w, err)
+		return
+	}
+
+	if err := s.db.DeleteBrew(brew); err != nil {
+		apiFail(w, err)
+		return
+	}
+
+	apiWrite(w, http.StatusNoContent, nil)
+}
+
+// Move a brew to its previous or next step.
+func (s *Server) apiBrewStep(w http.ResponseWriter, r *http.Request, user *db.User) {
+	brew, err := s.apiGetBrew(r, user, accessWrite)
+	if err != nil {
+		apiFail(w, err)
+		return
+	}
+
+	var a apiBrewStep
+	if err := s.decodeJSON(w, r, &a); err != nil {
+		apiFail(w, err)
+		return
+	}
+
+	if mux.Vars(r)["Action"] == "prev" {
+		if err := s.prevStep(brew); err != nil {
+			apiFail(w, err)
+			return
+		}
+
+		apiWrite(w, http.StatusOK, brewToJSON(brew))
+		return
+	}
+
+	// Starting the brew consumes its ingredients: deduct them from the
+	// inventory, unless asked not to.
+	var notDeducted []string
+	if brew.Step == db.StepPrepare {
+		var uses []StockUse
+		uses, err = s.stockUses(brew.Owner(), addUpIngredients(brew.XML))
+		if err != nil {
+			apiFail(w, err)
+			return
+		}
+
+		switch a.Stock {
+		case "deduct":
+			// Deduct the inventory ingredients it uses.
+		case "keep":
+			// Start the brew without modifying the inventory.
+			uses = nil
+		default:
+			// Only ask for a choice if the inventory is concerned.
+			for _, u := range uses {
+				if u.Ingredient != nil {
+					apiFail(w, &apiError{
+						status:  http.StatusConflict,
+						Message: "The brew uses inventory ingredients: 'stock' must be 'deduct' or 'keep'.",
+					})
+					return
+				}
+			}
+		}
+
+		notDeducted, err = s.startBrew(brew, uses)
+		if err == db.ErrBrewStarted {
+			err = &apiError{
+				status:  http.StatusConflict,
+				Message: err.Error(),
+			}
+		}
+	} else {
+		err = s.nextStep(brew)
+	}
+	if err != nil {
+		apiFail(w, err)
+		return
+	}
+
+	b := brewToJSON(brew)
+	b.NotDeducted = notDeducted
+	apiWrite(w, http.StatusOK, b)
+}
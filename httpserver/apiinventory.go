@@ -0,0 +1,281 @@
+// Copyright (C) 2019 Antoine Tenart <antoine.tenart@ack.tf>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+
+package httpserver
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/atenart/bubbles/beerxml"
+	"github.com/atenart/bubbles/db"
+)
+
+// Inventory ingredient, in the API. The details matching its type must be
+// given, the stock is the sum of the ingredient lots amounts.
+type apiIngredient struct {
+	Id          int64           `json:"id" readonly:"true"`
+	Type        string          `json:"type" required:"true" enum:"fermentable|hop|yeast|misc"`
+	Name        string          `json:"name" readonly:"true"`
+	Link        string          `json:"link"`
+	Stock       float64         `json:"stock" readonly:"true"`
+	Unit        string          `json:"unit" required:"true" enum:"kg|g|l|ml"`
+	Price       float64         `json:"price" min:"0"`
+	Package     float64         `json:"package" min:"0"`
+	Fermentable *apiFermentable `json:"fermentable,omitempty"`
+	Hop         *apiHop         `json:"hop,omitempty"`
+	Yeast       *apiYeast       `json:"yeast,omitempty"`
+	Misc        *apiMisc        `json:"misc,omitempty"`
+}
+
+// Convert an inventory ingredient for the API.
+func ingredientToJSON(i *db.Ingredient) *apiIngredient {
+	a := &apiIngredient{
+		Id:      i.Id,
+		Type:    i.Type,
+		Name:    i.Name,
+		Link:    i.Link,
+		Stock:   i.Stock,
+		Unit:    i.Unit,
+		Price:   i.Price,
+		Package: i.Package,
+	}
+
+	switch xml := i.XML.(type) {
+	case *beerxml.Fermentable:
+		f := fermentableToJSON(xml)
+		a.Fermentable = &f
+	case *beerxml.Hop:
+		h := hopToJSON(xml)
+		a.Hop = &h
+	case *beerxml.Yeast:
+		y := yeastToJSON(xml)
+		a.Yeast = &y
+	case *beerxml.Misc:
+		m := miscToJSON(xml)
+		a.Misc = &m
+	}
+
+	return a
+}
+
+// Convert an inventory ingredient from the API into a *db.Ingredient. The
+// BeerXML fields not exposed in the API are kept.
+func jsonToIngredient(a *apiIngredient, i *db.Ingredient) error {
+	if i.XML == nil {
+		switch a.Type {
+		case "fermentable":
+			i.XML = &beerxml.Fermentable{}
+		case "hop":
+			i.XML = &beerxml.Hop{}
+		case "yeast":
+			i.XML = &beerxml.Yeast{}
+		case "misc":
+			i.XML = &beerxml.Misc{}
+		}
+		i.Type = a.Type
+	} else if a.Type != i.Type {
+		return invalidField("type", "can't be changed")
+	}
+
+	switch xml := i.XML.(type) {
+	case *beerxml.Fermentable:
+		if a.Fermentable == nil {
+			return invalidField("fermentable", "required")
+		}
+		jsonToFermentable(a.Fermentable, xml)
+		i.Name = xml.Name
+	case *beerxml.Hop:
+		if a.Hop == nil {
+			return invalidField("hop", "required")
+		}
+		jsonToHop(a.Hop, xml)
+		i.Name = xml.Name
+	case *beerxml.Yeast:
+		if a.Yeast == nil {
+			return invalidField("yeast", "required")
+		}
+		jsonToYeast(a.Yeast, xml)
+		i.Name = xml.Name
+	case *beerxml.Misc:
+		if a.Misc == nil {
+			return invalidField("misc", "required")
+		}
+		jsonToMisc(a.Misc, xml)
+		i.Name = xml.Name
+	}
+
+	i.Link = a.Link
+	i.Unit = a.Unit
+	i.Price = a.Price
+	i.Package = a.Package
+
+	return nil
+}
+
+// Check no other ingredient of the same owner has the same type and name.
+func (s *Server) checkIngredientConflict(i *db.Ingredient) error {
+	ingredients, err := s.db.GetIngredients(i.Owner())
+	if err != nil {
+		return err
+	}
+
+	for _, other := range ingredients {
+		if other.Id != i.Id && other.Type == i.Type && other.Name == i.Name {
+			return &apiError{
+				status:  http.StatusConflict,
+				Message: fmt.Sprintf("The %s '%s' is already in the inventory.", i.Type, i.Name),
+			}
+		}
+	}
+
+	return nil
+}
+
+// List the inventory of the current workspace.
+func (s *Server) apiInventory(w http.ResponseWriter, r *http.Request, user *db.User) {
+	ingredients, err := s.db.GetIngredients(s.workspace(user))
+	if err != nil {
+		apiFail(w, err)
+		return
+	}
+
+	sort(ingredients)
+
+	list := []*apiIngredient{}
+	for _, i := range ingredients {
+		list = append(list, ingredientToJSON(i))
+	}
+
+	apiWrite(w, http.StatusOK, list)
+}
+
+// Add an ingredient to the inventory of the current workspace.
+func (s *Server) apiNewIngredient(w http.ResponseWriter, r *http.Request, user *db.User) {
+	owner := s.workspace(user)
+	if err := s.checkAccess(user, owner, accessWrite); err != nil {
+		apiFail(w, err)
+		return
+	}
+
+	var a apiIngredient
+	if err := s.decodeJSON(w, r, &a); err != nil {
+		apiFail(w, err)
+		return
+	}
+
+	i := &db.Ingredient{
+		UserId:         owner.UserId,
+		OrganizationId: owner.OrganizationId,
+	}
+	if err := jsonToIngredient(&a, i); err != nil {
+		apiFail(w, err)
+		return
+	}
+
+	if err := s.checkIngredientConflict(i); err != nil {
+		apiFail(w, err)
+		return
+	}
+
+	if err := s.db.AddIngredient(i); err != nil {
+		apiFail(w, err)
+		return
+	}
+
+	if err := s.recordPrice(i); err != nil {
+		apiFail(w, err)
+		return
+	}
+
+	w.Header().Set("Location", fmt.Sprintf("/api/v1/ingredients/%d", i.Id))
+	apiWrite(w, http.StatusCreated, ingredientToJSON(i))
+}
+
+// Retrieve the inventory ingredient given in the path of an API request,
+// checking the user has the requested access to it.
+func (s *Server) apiGetIngredient(r *http.Request, user *db.User, access int) (*db.Ingredient, error) {
+	id, err := apiVar(r, "Id")
+	if err != nil {
+		return nil, err
+	}
+
+	return s.getIngredient(id, user, access)
+}
+
+// Retrieve an inventory ingredient.
+func (s *Server) apiIngredient(w http.ResponseWriter, r *http.Request, user *db.User) {
+	i, err := s.apiGetIngredient(r, user, accessRead)
+	if err != nil {
+		apiFail(w, err)
+		return
+	}
+
+	apiWrite(w, http.StatusOK, ingredientToJSON(i))
+}
+
+// Update an inventory ingredient.
+func (s *Server) apiUpdateIngredient(w http.ResponseWriter, r *http.Request, user *db.User) {
+	i, err := s.apiGetIngredient(r, user, accessWrite)
+	if err != nil {
+		apiFail(w, err)
+		return
+	}
+
+	var a apiIngredient
+	if err := s.decodeJSON(w, r, &a); err != nil {
+		apiFail(w, err)
+		return
+	}
+
+	if err := jsonToIngredient(&a, i); err != nil {
+		apiFail(w, err)
+		return
+	}
+
+	if err := s.checkIngredientConflict(i); err != nil {
+		apiFail(w, err)
+		return
+	}
+
+	if err := s.db.UpdateIngredient(i); err != nil {
+		apiFail(w, err)
+		return
+	}
+
+	if err := s.recordPrice(i); err != nil {
+		apiFail(w, err)
+		return
+	}
+
+	apiWrite(w, http.StatusOK, ingredientToJSON(i))
+}
+
+// Delete an inventory ingredient.
+func (s *Server) apiDeleteIngredient(w http.ResponseWriter, r *http.Request, user *db.User) {
+	i, err := s.apiGetIngredient(r, user, accessWrite)
+	if err != nil {
+		apiFail(w, err)
+		return
+	}
+
+	if err := s.db.DeleteIngredient(i); err != nil {
+		apiFail(w, err)
+		return
+	}
+
+	apiWrite(w, http.StatusNoContent, nil)
+}
@@ -0,0 +1,856 @@
+// Copyright (C) 2019 Antoine Tenart <antoine.tenart@ack.tf>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+
+package httpserver
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/atenart/bubbles/beerxml"
+	"github.com/atenart/bubbles/db"
+)
+
+// Fermentable of a recipe, in the API. Amounts are in kg, yields in %.
+type apiFermentable struct {
+	Name   string  `json:"name" required:"true"`
+	Type   string  `json:"type" enum:"Grain|Sugar|Extract|Dry extract|Adjunct"`
+	Amount float64 `json:"amount" min:"0"`
+	Yield  float64 `json:"yield" min:"0" max:"100"`
+	Color  float64 `json:"color" min:"0"`
+}
+
+// Hop of a recipe, in the API. Amounts are in kg, times in minutes (days for
+// dry hops) and alpha acids in %.
+type apiHop struct {
+	Name        string  `json:"name" required:"true"`
+	Form        string  `json:"form" enum:"Pellet|Leaf|Plug"`
+	Use         string  `json:"use" enum:"Boil|Dry hop|Mash|First wort|Aroma"`
+	Alpha       float64 `json:"alpha" min:"0" max:"100"`
+	Amount      float64 `json:"amount" min:"0"`
+	Time        float64 `json:"time" min:"0"`
+	Hsi         float64 `json:"hsi" min:"0" max:"100"`
+	Substitutes string  `json:"substitutes"`
+}
+
+// Yeast of a recipe, in the API. Amounts are in kg, or in l if not a weight.
+type apiYeast struct {
+	Name           string  `json:"name" required:"true"`
+	Form           string  `json:"form" enum:"Liquid|Dry|Slant|Culture"`
+	Attenuation    float64 `json:"attenuation" min:"0" max:"100"`
+	Amount         float64 `json:"amount" min:"0"`
+	AmountIsWeight bool    `json:"amount_is_weight"`
+}
+
+// Misc of a recipe, in the API. Amounts are in kg, or in l if not a weight.
+type apiMisc struct {
+	Name           string  `json:"name" required:"true"`
+	Type           string  `json:"type" enum:"Spice|Fining|Water Agent|Herb|Flavor|Other"`
+	Use            string  `json:"use" enum:"Mash|Boil|Primary|Secondary|Bottling"`
+	Time           float64 `json:"time" min:"0"`
+	Amount         float64 `json:"amount" min:"0"`
+	AmountIsWeight bool    `json:"amount_is_weight"`
+}
+
+// Mash step of a recipe, in the API. Temperatures are in °C, times in minutes.
+type apiMashStep struct {
+	Name        string  `json:"name" required:"true"`
+	Type        string  `json:"type" enum:"Temperature|Infusion|Decoction"`
+	Temperature float64 `json:"temperature"`
+	Time        float64 `json:"time" min:"0"`
+}
+
+// Estimations of a recipe, computed from its ingredients. Volumes are in l.
+type apiEstimates struct {
+	OG          float64 `json:"og"`
+	FG          float64 `json:"fg"`
+	ABV         float64 `json:"abv"`
+	IBU         float64 `json:"ibu"`
+	Color       float64 `json:"color"`
+	IbuOg       float64 `json:"ibu_og"`
+	IbuRe       float64 `json:"ibu_re"`
+	BoilSize    float64 `json:"boil_size"`
+	TotalVolume float64 `json:"total_volume"`
+}
+
+// Recipe, in the API. On updates, the nested lists replace the current ones
+// when given, and are kept untouched otherwise. Volumes are in l, times in
+// minutes (days for ages) and temperatures in °C.
+type apiRecipe struct {
+	Id            int64            `json:"id" readonly:"true"`
+	Name          string           `json:"name" required:"true"`
+	Public        bool             `json:"public"`
+	Slug          string           `json:"slug" readonly:"true"`
+	Folder        string           `json:"folder"`
+	Tags          []string         `json:"tags"`
+	Type          string           `json:"type" enum:"All grain|Extract|Partial mash"`
+	Style         string           `json:"style"`
+	Brewer        string           `json:"brewer"`
+	Notes         string           `json:"notes"`
+	Version       int32            `json:"version" min:"0"`
+	BatchSize     float64          `json:"batch_size" min:"0"`
+	BoilTime      float64          `json:"boil_time" min:"0"`
+	Efficiency    float64          `json:"efficiency" min:"0" max:"100"`
+	PrimaryAge    float64          `json:"primary_age" min:"0"`
+	PrimaryTemp   float64          `json:"primary_temp"`
+	SecondaryAge  float64          `json:"secondary_age" min:"0"`
+	SecondaryTemp float64          `json:"secondary_temp"`
+	TertiaryAge   float64          `json:"tertiary_age" min:"0"`
+	TertiaryTemp  float64          `json:"tertiary_temp"`
+	Age           float64          `json:"age" min:"0"`
+	AgeTemp       float64          `json:"age_temp"`
+	Fermentables  []apiFermentable `json:"fermentables"`
+	Hops          []apiHop         `json:"hops"`
+	Yeasts        []apiYeast       `json:"yeasts"`
+	Miscs         []apiMisc        `json:"miscs"`
+	MashSteps     []apiMashStep    `json:"mash_steps"`
+	Estimates     apiEstimates     `json:"estimates" readonly:"true"`
+}
+
+// Recipe, as listed in the API.
+type apiRecipeSummary struct {
+	Id      int64    `json:"id"`
+	Name    string   `json:"name"`
+	Public  bool     `json:"public"`
+	Folder  string   `json:"folder"`
+	Tags    []string `json:"tags"`
+	Style   string   `json:"style"`
+	Type    string   `json:"type"`
+	Version int32    `json:"version"`
+	OG      float64  `json:"og"`
+	FG      float64  `json:"fg"`
+	ABV     float64  `json:"abv"`
+	IBU     float64  `json:"ibu"`
+	Color   float64  `json:"color"`
+}
+
+// Page of recipes, in the API. Pages start at 1.
+type apiRecipeList struct {
+	Recipes []apiRecipeSummary `json:"recipes"`
+	Total   int                `json:"total"`
+	Page    int                `json:"page"`
+	PerPage int                `json:"per_page"`
+}
+
+// Query parameters of the recipes listing, in the API. These are the filters of
+// the recipes page.
+type apiRecipeQuery struct {
+	Q       string  `json:"q"`
+	Folder  string  `json:"folder"`
+	Tag     string  `json:"tag"`
+	Style   string  `json:"style"`
+	Type    string  `json:"type" enum:"All grain|Extract|Partial mash"`
+	Brewed  string  `json:"brewed" enum:"yes|no"`
+	AbvMin  float64 `json:"abv-min" min:"0"`
+	AbvMax  float64 `json:"abv-max" min:"0"`
+	IbuMin  float64 `json:"ibu-min" min:"0"`
+	IbuMax  float64 `json:"ibu-max" min:"0"`
+	Page    int     `json:"page" min:"1"`
+	PerPage int     `json:"per_page" min:"1" max:"100"`
+}
+
+// Convert a beerxml.Fermentable for the API.
+func fermentableToJSON(f *beerxml.Fermentable) apiFermentable {
+	return apiFermentable{ f.Name, f.Type, f.Amount, f.Yield, f.Color }
+}
+
+// Apply a fermentable from the API onto a beerxml.Fermentable. Fields not in
+// the API are kept.
+func jsonToFermentable(a *apiFermentable, f *beerxml.Fermentable) {
+	f.Name = a.Name
+	f.Type = a.Type
+	f.Amount = a.Amount
+	f.Yield = a.Yield
+	f.Color = a.Color
+}
+
+// Convert a beerxml.Hop for the API.
+func hopToJSON(h *beerxml.Hop) apiHop {
+	return apiHop{ h.Name, h.Form, h.Use, h.Alpha, h.Amount, h.Time, h.Hsi, h.Substitutes }
+}
+
+// Apply a hop from the API onto a beerxml.Hop. Fields not in the API are kept.
+func jsonToHop(a *apiHop, h *beerxml.Hop) {
+	h.Name = a.Name
+	h.Form = a.Form
+	h.Use = a.Use
+	h.Alpha = a.Alpha
+	h.Amount = a.Amount
+	h.Time = a.Time
+	h.Hsi = a.Hsi
+	h.Substitutes = a.Substitutes
+}
+
+// Convert a beerxml.Yeast for the API.
+func yeastToJSON(y *beerxml.Yeast) apiYeast {
+	return apiYeast{ y.Name, y.Form, y.Attenuation, y.Amount, y.AmountIsWeight }
+}
+
+// Apply a yeast from the API onto a beerxml.Yeast. Fields not in the API are
+// kept.
+func jsonToYeast(a *apiYeast, y *beerxml.Yeast) {
+	y.Name = a.Name
+	y.Form = a.Form
+	y.Attenuation = a.Attenuation
+	y.Amount = a.Amount
+	y.AmountIsWeight = a.AmountIsWeight
+}
+
+// Convert a beerxml.Misc for the API.
+func miscToJSON(m *beerxml.Misc) apiMisc {
+	return apiMisc{ m.Name, m.Type, m.Use, m.Time, m.Amount, m.AmountIsWeight }
+}
+
+// Apply a misc from the API onto a beerxml.Misc. Fields not in the API are
+// kept.
+func jsonToMisc(a *apiMisc, m *beerxml.Misc) {
+	m.Name = a.Name
+	m.Type = a.Type
+	m.Use = a.Use
+	m.Time = a.Time
+	m.Amount = a.Amount
+	m.AmountIsWeight = a.AmountIsWeight
+}
+
+// Convert a beerxml.MashStep for the API.
+func mashStepToJSON(m *beerxml.MashStep) apiMashStep {
+	return apiMashStep{ m.Name, m.Type, m.StepTemp, m.StepTime }
+}
+
+// Apply a mash step from the API onto a beerxml.MashStep. Fields not in the
+// API are kept.
+func jsonToMashStep(a *apiMashStep, m *beerxml.MashStep) {
+	m.Name = a.Name
+	m.Type = a.Type
+	m.StepTemp = a.Temperature
+	m.StepTime = a.Time
+}
+
+// Estimations can't be computed for incomplete recipes (e.g. the FG without
+// yeasts) and are then NaN, which JSON can't represent: use 0 instead.
+func finite(v float64) float64 {
+	if math.IsNaN(v) || math.IsInf(v, 0) {
+		return 0
+	}
+	return v
+}
+
+// Convert a recipe for the API.
+func recipeToJSON(recipe *db.Recipe) *apiRecipe {
+	xml := recipe.XML
+	calc := calculations(xml)
+
+	a := &apiRecipe{
+		Id:            recipe.Id,
+		Name:          recipe.Name,
+		Public:        recipe.Public,
+		Slug:          recipe.Slug,
+		Folder:        recipe.Folder,
+		Tags:          recipe.Tags,
+		Type:          xml.Type,
+		Style:         xml.Style.Name,
+		Brewer:        xml.Brewer,
+		Notes:         xml.Notes,
+		Version:       xml.Version,
+		BatchSize:     xml.BatchSize,
+		BoilTime:      xml.BoilTime,
+		Efficiency:    xml.Efficiency,
+		PrimaryAge:    xml.PrimaryAge,
+		PrimaryTemp:   xml.PrimaryTemp,
+		SecondaryAge:  xml.SecondaryAge,
+		SecondaryTemp: xml.SecondaryTemp,
+		TertiaryAge:   xml.TertiaryAge,
+		TertiaryTemp:  xml.TertiaryTemp,
+		Age:           xml.Age,
+		AgeTemp:       xml.AgeTemp,
+		Fermentables:  []apiFermentable{},
+		Hops:          []apiHop{},
+		Yeasts:        []apiYeast{},
+		Miscs:         []apiMisc{},
+		MashSteps:     []apiMashStep{},
+		Estimates:     apiEstimates{
+			OG:          finite(xml.EstOG),
+			FG:          finite(xml.EstFG),
+			ABV:         finite(xml.EstABV),
+			IBU:         finite(xml.IBU),
+			Color:       finite(xml.EstColor),
+			IbuOg:       finite(calc.Cursors["IBU/OG"].Val),
+			IbuRe:       finite(calc.Cursors["IBU/RE"].Val),
+			BoilSize:    finite(calc.BoilSize),
+			TotalVolume: finite(calc.VolumeTot),
+		},
+	}
+	if a.Tags == nil {
+		a.Tags = []string{}
+	}
+
+	for k := range xml.Fermentables {
+		a.Fermentables = append(a.Fermentables, fermentableToJSON(&xml.Fermentables[k]))
+	}
+	for k := range xml.Hops {
+		a.Hops = append(a.Hops, hopToJSON(&xml.Hops[k]))
+	}
+	for k := range xml.Yeasts {
+		a.Yeasts = append(a.Yeasts, yeastToJSON(&xml.Yeasts[k]))
+	}
+	for k := range xml.Miscs {
+		a.Miscs = append(a.Miscs, miscToJSON(&xml.Miscs[k]))
+	}
+	for k := range xml.Mash.MashSteps {
+		a.MashSteps = append(a.MashSteps, mashStepToJSON(&xml.Mash.MashSteps[k]))
+	}
+
+	return a
+}
+
+// Convert a recipe summary for the API.
+func recipeSummaryToJSON(recipe *db.Recipe) apiRecipeSummary {
+	tags := recipe.Tags
+	if tags == nil {
+		tags = []string{}
+	}
+
+	return apiRecipeSummary{
+		Id:      recipe.Id,
+		Name:    recipe.Name,
+		Public:  recipe.Public,
+		Folder:  recipe.Folder,
+		Tags:    tags,
+		Style:   recipe.Style,
+		Type:    recipe.Type,
+		Version: recipe.Version,
+		OG:      recipe.OG,
+		FG:      recipe.FG,
+		ABV:     recipe.ABV,
+		IBU:     recipe.IBU,
+		Color:   recipe.Color,
+	}
+}
+
+// Convert a recipe from the API into a *db.Recipe. Read-only fields are
+// ignored, and nested lists are only replaced when given. Their items are
+// applied onto the current items at the same position, so the fields not in the
+// API are kept.
+func jsonToRecipe(a *apiRecipe, recipe *db.Recipe, styles *[]beerxml.Style) error {
+	if a.Style != "" {
+		found := false
+		for _, style := range *styles {
+			if a.Style == style.Name {
+				recipe.XML.Style = style
+				found = true
+			}
+		}
+		if !found {
+			return invalidField("style", "unknown style")
+		}
+	}
+
+	recipe.Name = a.Name
+	recipe.XML.Name = a.Name
+	recipe.Public = a.Public
+	recipe.Folder = strings.TrimSpace(a.Folder)
+	recipe.Tags = parseTags(strings.Join(a.Tags, ","))
+
+	recipe.XML.Type = a.Type
+	recipe.XML.Brewer = a.Brewer
+	recipe.XML.Notes = a.Notes
+	recipe.XML.Version = a.Version
+
+	recipe.XML.BatchSize = a.BatchSize
+	recipe.XML.BoilTime = a.BoilTime
+	recipe.XML.Efficiency = a.Efficiency
+
+	recipe.XML.PrimaryAge = a.PrimaryAge
+	recipe.XML.PrimaryTemp = a.PrimaryTemp
+	recipe.XML.SecondaryAge = a.SecondaryAge
+	recipe.XML.SecondaryTemp = a.SecondaryTemp
+	recipe.XML.TertiaryAge = a.TertiaryAge
+	recipe.XML.TertiaryTemp = a.TertiaryTemp
+	recipe.XML.Age = a.Age
+	recipe.XML.AgeTemp = a.AgeTemp
+
+	if a.Fermentables != nil {
+		current := recipe.XML.Fermentables
+		recipe.XML.Fermentables = nil
+		for k := range a.Fermentables {
+			var f beerxml.Fermentable
+			if k < len(current) {
+				f = current[k]
+			}
+			jsonToFermentable(&a.Fermentables[k], &f)
+			beerxml.InsertToRecipe(recipe.XML, &f)
+		}
+	}
+	if a.Hops != nil {
+		current := recipe.XML.Hops
+		recipe.XML.Hops = nil
+		for k := range a.Hops {
+			var h beerxml.Hop
+			if k < len(current) {
+				h = current[k]
+			}
+			jsonToHop(&a.Hops[k], &h)
+			beerxml.InsertToRecipe(recipe.XML, &h)
+		}
+	}
+	if a.Yeasts != nil {
+		current := recipe.XML.Yeasts
+		recipe.XML.Yeasts = nil
+		for k := range a.Yeasts {
+			var y beerxml.Yeast
+			if k < len(current) {
+				y = current[k]
+			}
+			jsonToYeast(&a.Yeasts[k], &y)
+			beerxml.InsertToRecipe(recipe.XML, &y)
+		}
+	}
+	if a.Miscs != nil {
+		current := recipe.XML.Miscs
+		recipe.XML.Miscs = nil
+		for k := range a.Miscs {
+			var m beerxml.Misc
+			if k < len(current) {
+				m = current[k]
+			}
+			jsonToMisc(&a.Miscs[k], &m)
+			beerxml.InsertToRecipe(recipe.XML, &m)
+		}
+	}
+	if a.MashSteps != nil {
+		current := recipe.XML.Mash.MashSteps
+		recipe.XML.Mash.MashSteps = nil
+		for k := range a.MashSteps {
+			var m beerxml.MashStep
+			if k < len(current) {
+				m = current[k]
+			}
+			jsonToMashStep(&a.MashSteps[k], &m)
+			beerxml.InsertToRecipe(recipe.XML, &m)
+		}
+	}
+
+	return nil
+}
+
+// List the recipes of the current workspace, using the same filters as the
+// recipes page.
+func (s *Server) apiRecipes(w http.ResponseWriter, r *http.Request, user *db.User) {
+	filter := formToRecipeFilter(r)
+	if v, err := strconv.Atoi(r.FormValue("per_page")); err == nil {
+		filter.PerPage = v
+	}
+
+	recipes, total, err := s.db.SearchRecipes(s.workspace(user), filter)
+	if err != nil {
+		apiFail(w, err)
+		return
+	}
+
+	list := apiRecipeList{
+		Recipes: []apiRecipeSummary{},
+		Total:   total,
+		Page:    filter.Page + 1,
+		PerPage: filter.PerPage,
+	}
+	for _, recipe := range recipes {
+		list.Recipes = append(list.Recipes, recipeSummaryToJSON(recipe))
+	}
+
+	apiWrite(w, http.StatusOK, list)
+}
+
+// Create a recipe in the current workspace.
+func (s *Server) apiNewRecipe(w http.ResponseWriter, r *http.Request, user *db.User) {
+	owner := s.workspace(user)
+	if err := s.checkAccess(user, owner, accessWrite); err != nil {
+		apiFail(w, err)
+		return
+	}
+
+	var a apiRecipe
+	if err := s.decodeJSON(w, r, &a); err != nil {
+		apiFail(w, err)
+		return
+	}
+
+	recipe := &db.Recipe{
+		UserId:         owner.UserId,
+		OrganizationId: owner.OrganizationId,
+		XML:            &beerxml.Recipe{},
+	}
+	if err := jsonToRecipe(&a, recipe, s.db.Styles); err != nil {
+		apiFail(w, err)
+		return
+	}
+
+	// Same defaults as the recipes created from the UI.
+	if recipe.XML.Version == 0 {
+		recipe.XML.Version = 1
+	}
+	if recipe.XML.Efficiency == 0 {
+		recipe.XML.Efficiency = 70
+	}
+	refreshRecipe(recipe.XML)
+
+	var err error
+	if recipe.Id, err = s.db.AddRecipe(recipe); err != nil {
+		apiFail(w, err)
+		return
+	}
+
+	// Start the recipe history.
+	if err := s.addRevision(recipe, "new"); err != nil {
+		apiFail(w, err)
+		return
+	}
+
+	w.Header().Set("Location", fmt.Sprintf("/api/v1/recipes/%d", recipe.Id))
+	apiWrite(w, http.StatusCreated, recipeToJSON(recipe))
+}
+
+// Retrieve the recipe given in the path of an API request, checking the user
+// has the requested access to it.
+func (s *Server) apiGetRecipe(r *http.Request, user *db.User, access int) (*db.Recipe, error) {
+	id, err := apiVar(r, "Id")
+	if err != nil {
+		return nil, err
+	}
+
+	return s.getRecipe(id, user, access)
+}
+
+// Retrieve a recipe.
+func (s *Server) apiRecipe(w http.ResponseWriter, r *http.Request, user *db.User) {
+	recipe, err := s.apiGetRecipe(r, user, accessRead)
+	if err != nil {
+		apiFail(w, err)
+		return
+	}
+
+	apiWrite(w, http.StatusOK, recipeToJSON(recipe))
+}
+
+// Update a recipe.
+func (s *Server) apiUpdateRecipe(w http.ResponseWriter, r *http.Request, user *db.User) {
+	recipe, err := s.apiGetRecipe(r, user, accessWrite)
+	if err != nil {
+		apiFail(w, err)
+		return
+	}
+
+	var a apiRecipe
+	if err := s.decodeJSON(w, r, &a); err != nil {
+		apiFail(w, err)
+		return
+	}
+
+	if err := s.initialRevision(recipe); err != nil {
+		apiFail(w, err)
+		return
+	}
+
+	if err := jsonToRecipe(&a, recipe, s.db.Styles); err != nil {
+		apiFail(w, err)
+		return
+	}
+
+	if err := s.updateRecipe(recipe, "save"); err != nil {
+		apiFail(w, err)
+		return
+	}
+
+	apiWrite(w, http.StatusOK, recipeToJSON(recipe))
+}
+
+// Delete a recipe.
+func (s *Server) apiDeleteRecipe(w http.ResponseWriter, r *http.Request, user *db.User) {
+	recipe, err := s.apiGetRecipe(r, user, accessWrite)
+	if err != nil {
+		apiFail(w, err)
+		return
+	}
+
+	if err := s.db.DeleteRecipe(recipe); err != nil {
+		apiFail(w, err)
+		return
+	}
+	s.notify(recipe.Owner(), db.EventRecipeDeleted, recipeSummaryToJSON(recipe))
+
+	apiWrite(w, http.StatusNoContent, nil)
+}
+
+// Nested lists of a recipe, as named in the API paths, and the name of the
+// recipe history actions modifying them.
+var recipeItemKinds = map[string]string{
+	"fermentables": "fermentable",
+	"hops":         "hop",
+	"yeasts":       "yeast",
+	"miscs":        "misc",
+	"mash-steps":   "mash-step",
+}
+
+// Nested lists of a recipe, as named in the API paths, and the type of their
+// items in the API.
+var recipeItemTypes = map[string]interface{}{
+	"fermentables": apiFermentable{},
+	"hops":         apiHop{},
+	"yeasts":       apiYeast{},
+	"miscs":        apiMisc{},
+	"mash-steps":   apiMashStep{},
+}
+
+// List a nested list of a recipe, given its kind.
+func recipeItems(a *apiRecipe, kind string) interface{} {
+	switch kind {
+	case "fermentables":
+		return a.Fermentables
+	case "hops":
+		return a.Hops
+	case "yeasts":
+		return a.Yeasts
+	case "miscs":
+		return a.Miscs
+	default:
+		return a.MashSteps
+	}
+}
+
+// Retrieve an item of a nested list of a recipe, given its kind and position.
+func recipeItem(a *apiRecipe, kind string, item int) (interface{}, bool) {
+	switch kind {
+	case "fermentables":
+		if item < len(a.Fermentables) {
+			return a.Fermentables[item], true
+		}
+	case "hops":
+		if item < len(a.Hops) {
+			return a.Hops[item], true
+		}
+	case "yeasts":
+		if item < len(a.Yeasts) {
+			return a.Yeasts[item], true
+		}
+	case "miscs":
+		if item < len(a.Miscs) {
+			return a.Miscs[item], true
+		}
+	case "mash-steps":
+		if item < len(a.MashSteps) {
+			return a.MashSteps[item], true
+		}
+	}
+	return nil, false
+}
+
+// Empty BeerXML element of a nested list of a recipe, given its kind.
+func recipeItemElement(kind string) interface{} {
+	switch kind {
+	case "fermentables":
+		return &beerxml.Fermentable{}
+	case "hops":
+		return &beerxml.Hop{}
+	case "yeasts":
+		return &beerxml.Yeast{}
+	case "miscs":
+		return &beerxml.Misc{}
+	default:
+		return &beerxml.MashStep{}
+	}
+}
+
+// Copy of an item of a nested list of a recipe, given its kind and position,
+// as a BeerXML element. New items (at position -1) are empty elements.
+func recipeElement(xml *beerxml.Recipe, kind string, item int) (interface{}, bool) {
+	if item < 0 {
+		return recipeItemElement(kind), true
+	}
+
+	switch kind {
+	case "fermentables":
+		if item < len(xml.Fermentables) {
+			f := xml.Fermentables[item]
+			return &f, true
+		}
+	case "hops":
+		if item < len(xml.Hops) {
+			h := xml.Hops[item]
+			return &h, true
+		}
+	case "yeasts":
+		if item < len(xml.Yeasts) {
+			y := xml.Yeasts[item]
+			return &y, true
+		}
+	case "miscs":
+		if item < len(xml.Miscs) {
+			m := xml.Miscs[item]
+			return &m, true
+		}
+	case "mash-steps":
+		if item < len(xml.Mash.MashSteps) {
+			m := xml.Mash.MashSteps[item]
+			return &m, true
+		}
+	}
+	return nil, false
+}
+
+// Decode an item of a nested list of a recipe from an API request, and apply it
+// onto a BeerXML element.
+func (s *Server) decodeRecipeItem(w http.ResponseWriter, r *http.Request, elmt interface{}) error {
+	switch e := elmt.(type) {
+	case *beerxml.Fermentable:
+		var f apiFermentable
+		if err := s.decodeJSON(w, r, &f); err != nil {
+			return err
+		}
+		jsonToFermentable(&f, e)
+	case *beerxml.Hop:
+		var h apiHop
+		if err := s.decodeJSON(w, r, &h); err != nil {
+			return err
+		}
+		jsonToHop(&h, e)
+	case *beerxml.Yeast:
+		var y apiYeast
+		if err := s.decodeJSON(w, r, &y); err != nil {
+			return err
+		}
+		jsonToYeast(&y, e)
+	case *beerxml.Misc:
+		var m apiMisc
+		if err := s.decodeJSON(w, r, &m); err != nil {
+			return err
+		}
+		jsonToMisc(&m, e)
+	case *beerxml.MashStep:
+		var m apiMashStep
+		if err := s.decodeJSON(w, r, &m); err != nil {
+			return err
+		}
+		jsonToMashStep(&m, e)
+	}
+	return nil
+}
+
+// List a nested list of a recipe.
+func (s *Server) apiRecipeItems(w http.ResponseWriter, r *http.Request, user *db.User) {
+	recipe, err := s.apiGetRecipe(r, user, accessRead)
+	if err != nil {
+		apiFail(w, err)
+		return
+	}
+
+	apiWrite(w, http.StatusOK, recipeItems(recipeToJSON(recipe), mux.Vars(r)["Kind"]))
+}
+
+// Retrieve an item of a nested list of a recipe.
+func (s *Server) apiRecipeItem(w http.ResponseWriter, r *http.Request, user *db.User) {
+	recipe, err := s.apiGetRecipe(r, user, accessRead)
+	if err != nil {
+		apiFail(w, err)
+		return
+	}
+
+	item, err := strconv.Atoi(mux.Vars(r)["Item"])
+	v, ok := recipeItem(recipeToJSON(recipe), mux.Vars(r)["Kind"], item)
+	if err != nil || !ok {
+		apiFail(w, &apiError{ status: http.StatusNotFound, Message: "Item not found." })
+		return
+	}
+
+	apiWrite(w, http.StatusOK, v)
+}
+
+// Add, edit or remove an item of a nested list of a recipe. Items are kept
+// sorted, so the whole recipe is returned for the new positions to be known.
+func (s *Server) apiSaveRecipeItem(w http.ResponseWriter, r *http.Request, user *db.User) {
+	recipe, err := s.apiGetRecipe(r, user, accessWrite)
+	if err != nil {
+		apiFail(w, err)
+		return
+	}
+
+	kind := mux.Vars(r)["Kind"]
+	item := -1
+	if v, ok := mux.Vars(r)["Item"]; ok {
+		if item, err = strconv.Atoi(v); err != nil {
+			apiFail(w, &apiError{ status: http.StatusNotFound, Message: "Item not found." })
+			return
+		}
+	}
+
+	// Decode the item first, so invalid requests do not modify the
+	// recipe history. Edits are applied onto the current item, to keep the
+	// fields not in the API.
+	var elmt interface{}
+	if r.Method != "DELETE" {
+		var ok bool
+		if elmt, ok = recipeElement(recipe.XML, kind, item); !ok {
+			apiFail(w, &apiError{ status: http.StatusNotFound, Message: "Item not found." })
+			return
+		}
+		if err := s.decodeRecipeItem(w, r, elmt); err != nil {
+			apiFail(w, err)
+			return
+		}
+	}
+
+	if err := s.initialRevision(recipe); err != nil {
+		apiFail(w, err)
+		return
+	}
+
+	var action string
+	switch r.Method {
+	case "POST":
+		action = "add-"
+	case "PUT":
+		action = "edit-"
+	default:
+		action = "del-"
+	}
+	action += recipeItemKinds[kind]
+
+	if item >= 0 {
+		if err := beerxml.RemoveFromRecipe(recipe.XML, recipeItemElement(kind), item); err != nil {
+			apiFail(w, &apiError{ status: http.StatusNotFound, Message: err.Error() })
+			return
+		}
+	}
+	if elmt != nil {
+		if err := beerxml.InsertToRecipe(recipe.XML, elmt); err != nil {
+			apiFail(w, err)
+			return
+		}
+	}
+
+	if err := s.updateRecipe(recipe, action); err != nil {
+		apiFail(w, err)
+		return
+	}
+
+	status := http.StatusOK
+	if r.Method == "POST" {
+		status = http.StatusCreated
+	}
+	apiWrite(w, status, recipeToJSON(recipe))
+}
@@ -0,0 +1,113 @@
+// Copyright (C) 2019 Antoine Tenart <antoine.tenart@ack.tf>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+
+package httpserver
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/atenart/bubbles/beerxml"
+	"github.com/atenart/bubbles/db"
+)
+
+// Recipe using BeerXML fields not exposed in the API.
+func testRecipe() *db.Recipe {
+	xml := &beerxml.Recipe{
+		Name:      "Pale Ale",
+		Type:      "All grain",
+		Version:   1,
+		BatchSize: 20,
+		BoilTime:  60,
+		Style:     beerxml.Style{ Name: "American Pale Ale" },
+		Fermentables: []beerxml.Fermentable{
+			{ Name: "Pale", Type: "Grain", Amount: 4, Yield: 80, Color: 3,
+			  Supplier: "Weyermann", Origin: "Germany", Moisture: 4 },
+		},
+		Hops: []beerxml.Hop{
+			{ Name: "Cascade", Form: "Pellet", Use: "Boil", Alpha: 6, Amount: 0.03,
+			  Time: 60, Beta: 5, Origin: "US", Myrcene: 50, Humulene: 12 },
+		},
+		Yeasts: []beerxml.Yeast{
+			{ Name: "US-05", Form: "Dry", Attenuation: 78, Amount: 0.0115,
+			  AmountIsWeight: true, Laboratory: "Fermentis", ProductId: "US-05" },
+		},
+		Miscs: []beerxml.Misc{
+			{ Name: "Irish moss", Type: "Fining", Use: "Boil", Time: 15,
+			  Amount: 0.005, AmountIsWeight: true, UseFor: "Clarity" },
+		},
+	}
+	xml.Mash.MashSteps = []beerxml.MashStep{
+		{ Name: "Saccharification", Type: "Infusion", StepTemp: 66, StepTime: 60,
+		  InfuseAmount: 15, EndTemp: 65 },
+	}
+
+	return &db.Recipe{ Name: xml.Name, Tags: []string{ "pale" }, XML: xml }
+}
+
+// Writing a recipe as read from the API must not lose any of its fields.
+func TestRecipeReadWrite(t *testing.T) {
+	recipe := testRecipe()
+	styles := []beerxml.Style{ recipe.XML.Style }
+
+	body, err := json.Marshal(recipeToJSON(recipe))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var a apiRecipe
+	if err := json.Unmarshal(body, &a); err != nil {
+		t.Fatal(err)
+	}
+	if err := jsonToRecipe(&a, recipe, &styles); err != nil {
+		t.Fatal(err)
+	}
+
+	if want := testRecipe(); !reflect.DeepEqual(recipe, want) {
+		t.Errorf("recipe = %+v, want %+v", recipe.XML, want.XML)
+	}
+}
+
+// Editing an item of a recipe keeps its fields not exposed in the API.
+func TestRecipeItemEdit(t *testing.T) {
+	recipe := testRecipe()
+
+	elmt, ok := recipeElement(recipe.XML, "hops", 0)
+	if !ok {
+		t.Fatal("hop not found")
+	}
+	hop := elmt.(*beerxml.Hop)
+
+	a := hopToJSON(hop)
+	a.Alpha = 7
+	jsonToHop(&a, hop)
+
+	want := testRecipe().XML.Hops[0]
+	want.Alpha = 7
+	if *hop != want {
+		t.Errorf("hop = %+v, want %+v", *hop, want)
+	}
+	if recipe.XML.Hops[0].Alpha != 6 {
+		t.Error("recipe modified before the edit is saved")
+	}
+
+	if _, ok := recipeElement(recipe.XML, "hops", 1); ok {
+		t.Error("found a hop out of the recipe")
+	}
+	if elmt, _ := recipeElement(recipe.XML, "yeasts", -1); !reflect.DeepEqual(elmt, &beerxml.Yeast{}) {
+		t.Errorf("new yeast = %+v, want an empty yeast", elmt)
+	}
+}
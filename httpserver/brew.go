@@ -20,7 +20,9 @@ import (
 	"html/template"
 	"math"
 	"net/http"
+	"net/url"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gorilla/csrf"
@@ -31,8 +33,8 @@ import (
 
 // Brews listing.
 func (s *Server) brews(w http.ResponseWriter, r *http.Request, user *db.User) {
-	// Retrieve all the user's brews.
-	brews, err := s.db.GetUserBrews(user.Id)
+	// Retrieve all the brews of the current workspace.
+	brews, err := s.db.GetBrews(s.workspace(user))
 	if err != nil {
 		http.Error(w, err.Error(), 500)
 		return
@@ -65,17 +67,12 @@ func (s *Server) brew(w http.ResponseWriter, r *http.Request, user *db.User) {
 		return
 	}
 
-	brew, err := s.db.GetBrew(id)
+	brew, err := s.getBrew(id, user, accessRead)
 	if err != nil {
 		http.Error(w, err.Error(), 500)
 		return
 	}
 
-	if brew.UserId != user.Id {
-		http.Error(w, "Permission denied to edit this brew.", 500)
-		return
-	}
-
 	ingredients := &beerxml.BeerXML{}
 	if brew.Step == db.StepPrepare {
 		ingredients = addUpIngredients(brew.XML)
@@ -95,6 +92,18 @@ func (s *Server) brew(w http.ResponseWriter, r *http.Request, user *db.User) {
 		}
 	}
 
+	log, err := s.fermentationLog(brew)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+
+	cost, err := s.recipeCost(user, brew.Owner(), brew.XML)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+
 	name, desc := db.StepName(brew.Step)
 	s.executeTemplate(w, user, "brew.html", struct{
 		CSRF        template.HTML
@@ -106,6 +115,10 @@ func (s *Server) brew(w http.ResponseWriter, r *http.Request, user *db.User) {
 		Calc        *Calculation
 		Ingredients *beerxml.BeerXML
 		Extra       bool
+		Log         []LogEntry
+		Now         string
+		Cost        *Cost
+		NotDeducted []string
 	}{
 		csrf.TemplateField(r),
 		fmt.Sprintf("Bubbles - brew/%s %s", brew.XML.Name, brew.XML.Date),
@@ -116,72 +129,114 @@ func (s *Server) brew(w http.ResponseWriter, r *http.Request, user *db.User) {
 		calculations(brew.XML),
 		ingredients,
 		extra,
+		log,
+		time.Now().UTC().Format(readingDateFormat),
+		cost,
+		r.URL.Query()["not-deducted"],
 	})
 }
 
+// Add up the ingredients of multiple recipes, each being brewed a given number
+// of batches.
+func addUpRecipes(recipes []*beerxml.Recipe, batches []float64) *beerxml.BeerXML {
+	var all beerxml.Recipe
+	for k, r := range recipes {
+		for _, f := range r.Fermentables {
+			f.Amount *= batches[k]
+			all.Fermentables = append(all.Fermentables, f)
+		}
+		for _, h := range r.Hops {
+			h.Amount *= batches[k]
+			all.Hops = append(all.Hops, h)
+		}
+		for _, y := range r.Yeasts {
+			y.Amount *= batches[k]
+			all.Yeasts = append(all.Yeasts, y)
+		}
+		for _, m := range r.Miscs {
+			m.Amount *= batches[k]
+			all.Miscs = append(all.Miscs, m)
+		}
+	}
+
+	return addUpIngredients(&all)
+}
+
+// Key identifying an ingredient when adding them up: same-named ingredients
+// from different suppliers are different.
+func ingredientKey(name string, xml interface{}) string {
+	return name + "/" + strings.ToLower(strings.TrimSpace(supplierOf(xml)))
+}
+
+// Add up the ingredients of a recipe, by name and supplier.
 func addUpIngredients(recipe *beerxml.Recipe) *beerxml.BeerXML {
 	var ingredients beerxml.BeerXML
 
 	// Add up fermentables.
 	seen := make(map[string]int)
 	for _, f := range recipe.Fermentables {
-		if _, ok := seen[f.Name]; !ok {
+		key := ingredientKey(f.Name, &f)
+		if _, ok := seen[key]; !ok {
 			ingredients.Fermentables = append(ingredients.Fermentables, f)
-			seen[f.Name] = len(ingredients.Fermentables) - 1
+			seen[key] = len(ingredients.Fermentables) - 1
 			continue
 		}
-		ingredients.Fermentables[seen[f.Name]].Amount += f.Amount
+		ingredients.Fermentables[seen[key]].Amount += f.Amount
 	}
 	sort(ingredients.Fermentables)
 
 	// Add up hops.
 	seen = make(map[string]int)
 	for _, h := range recipe.Hops {
-		if _, ok := seen[h.Name]; !ok {
+		key := ingredientKey(h.Name, &h)
+		if _, ok := seen[key]; !ok {
 			ingredients.Hops = append(ingredients.Hops, h)
-			seen[h.Name] = len(ingredients.Hops) - 1
+			seen[key] = len(ingredients.Hops) - 1
 			continue
 		}
-		ingredients.Hops[seen[h.Name]].Amount += h.Amount
+		ingredients.Hops[seen[key]].Amount += h.Amount
 	}
 	sort(ingredients.Hops)
 
 	// Add up yeasts.
 	seen = make(map[string]int)
 	for _, y := range recipe.Yeasts {
-		if _, ok := seen[y.Name]; !ok {
+		key := ingredientKey(y.Name, &y)
+		if _, ok := seen[key]; !ok {
 			ingredients.Yeasts = append(ingredients.Yeasts, y)
-			seen[y.Name] = len(ingredients.Yeasts) - 1
+			seen[key] = len(ingredients.Yeasts) - 1
 			continue
 		}
-		ingredients.Yeasts[seen[y.Name]].Amount += y.Amount
+		ingredients.Yeasts[seen[key]].Amount += y.Amount
 	}
 	sort(ingredients.Yeasts)
 
 	// Add up miscs.
 	seen = make(map[string]int)
 	for _, m := range recipe.Miscs {
-		if _, ok := seen[m.Name]; !ok {
+		key := ingredientKey(m.Name, &m)
+		if _, ok := seen[key]; !ok {
 			ingredients.Miscs = append(ingredients.Miscs, m)
-			seen[m.Name] = len(ingredients.Miscs) - 1
+			seen[key] = len(ingredients.Miscs) - 1
 			continue
 		}
-		ingredients.Miscs[seen[m.Name]].Amount += m.Amount
+		ingredients.Miscs[seen[key]].Amount += m.Amount
 	}
 	sort(ingredients.Miscs)
 
 	return &ingredients
 }
 
-func (s *Server) getBrew(id, uid int64) (*db.Brew, error) {
+// Retrieve a brew, checking the user has the requested access to it.
+func (s *Server) getBrew(id int64, user *db.User, access int) (*db.Brew, error) {
 	brew, err := s.db.GetBrew(id)
 	if err != nil {
 		return nil, err
 	}
 
 	// Check user rights.
-	if brew.UserId != uid {
-		return nil, fmt.Errorf("Permission denied to edit this brew")
+	if err := s.checkAccess(user, brew.Owner(), access); err != nil {
+		return nil, err
 	}
 
 	return brew, nil
@@ -195,7 +250,7 @@ func (s *Server) deleteBrew(w http.ResponseWriter, r *http.Request, user *db.Use
 		return
 	}
 
-	brew, err := s.getBrew(id, user.Id)
+	brew, err := s.getBrew(id, user, accessWrite)
 	if err != nil {
 		http.Error(w, err.Error(), 500)
 		return
@@ -217,7 +272,7 @@ func (s *Server) brewSave(w http.ResponseWriter, r *http.Request, user *db.User)
 		return
 	}
 
-	brew, err := s.getBrew(id, user.Id)
+	brew, err := s.getBrew(id, user, accessWrite)
 	if err != nil {
 		http.Error(w, err.Error(), 500)
 		return
@@ -262,18 +317,13 @@ func (s *Server) brewPrevStep(w http.ResponseWriter, r *http.Request, user *db.U
 	}
 
 	// Retrieve the brew info
-	brew, err := s.getBrew(id, user.Id)
+	brew, err := s.getBrew(id, user, accessWrite)
 	if err != nil {
 		http.Error(w, err.Error(), 500)
 		return
 	}
 
-	// Increment the brew step.
-	if brew.Step > 0 {
-		brew.Step--
-	}
-
-	if err := s.db.UpdateBrew(brew); err != nil {
+	if err := s.prevStep(brew); err != nil {
 		http.Error(w, err.Error(), 500)
 		return
 	}
@@ -290,30 +340,158 @@ func (s *Server) brewNextStep(w http.ResponseWriter, r *http.Request, user *db.U
 	}
 
 	// Retrieve the brew info
-	brew, err := s.getBrew(id, user.Id)
+	brew, err := s.getBrew(id, user, accessWrite)
 	if err != nil {
 		http.Error(w, err.Error(), 500)
 		return
 	}
 
-	// If we start the brew (moving from step 0 to 1), use the current date
-	// as the brew date.
-	if brew.Step == 0 && brew.XML.Date == "" {
+	// Starting the brew consumes its ingredients: once confirmed, deduct
+	// them from the inventory.
+	var notDeducted []string
+	if brew.Step == db.StepPrepare {
+		if err := r.ParseForm(); err != nil {
+			http.Error(w, "Couldn't parse form field.", 500)
+			return
+		}
+
+		var uses []StockUse
+		uses, err = s.stockUses(brew.Owner(), addUpIngredients(brew.XML))
+		if err != nil {
+			http.Error(w, err.Error(), 500)
+			return
+		}
+
+		switch r.FormValue("stock") {
+		case "deduct":
+			// Deduct the inventory ingredients it uses.
+		case "keep":
+			// Start the brew without modifying the inventory.
+			uses = nil
+		default:
+			// Only ask for a confirmation if the inventory is
+			// concerned.
+			for _, u := range uses {
+				if u.Ingredient != nil {
+					s.confirmDeduction(w, r, user, brew, uses)
+					return
+				}
+			}
+		}
+
+		// A resubmitted form finds the brew started already.
+		notDeducted, err = s.startBrew(brew, uses)
+		if err == db.ErrBrewStarted {
+			http.Redirect(w, r, fmt.Sprintf("/brew/%d", id), 302)
+			return
+		}
+	} else {
+		err = s.nextStep(brew)
+	}
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+
+	// Report the ingredients left in the inventory.
+	if len(notDeducted) > 0 {
+		query := url.Values{ "not-deducted": notDeducted }
+		http.Redirect(w, r, fmt.Sprintf("/brew/%d?%s", id, query.Encode()), 302)
+		return
+	}
+
+	http.Redirect(w, r, fmt.Sprintf("/brew/%d", id), 302)
+}
+
+// Move a brew to its previous step.
+func (s *Server) prevStep(brew *db.Brew) error {
+	// Moving back to the preparation step: give the ingredients deducted
+	// from the inventory back.
+	if brew.Step == db.StepMash {
+		if err := s.db.RevertBrewDeductions(brew.Id); err != nil {
+			return err
+		}
+	}
+
+	// Decrement the brew step.
+	if brew.Step == 0 {
+		return nil
+	}
+	brew.Step--
+
+	if err := s.db.UpdateBrew(brew); err != nil {
+		return err
+	}
+
+	s.notify(brew.Owner(), db.EventBrewStep, webhookBrewStep{ brewSummaryToJSON(brew), brew.Step + 1 })
+	return nil
+}
+
+// Start a brew (moving from step 0 to 1), deducting the ingredients it uses
+// from the inventory at the same time. Returns the names of the inventory
+// ingredients which couldn't be deducted. Fails with db.ErrBrewStarted when the
+// brew already started, e.g. on a resubmission.
+func (s *Server) startBrew(brew *db.Brew, uses []StockUse) ([]string, error) {
+	var deductions []*db.Deduction
+	for _, u := range uses {
+		if u.Ingredient == nil || !u.Convertible {
+			continue
+		}
+
+		deductions = append(deductions, &db.Deduction{
+			BrewId:       brew.Id,
+			IngredientId: u.Ingredient.Id,
+			Amount:       u.Amount,
+			Lots:         u.Lots,
+		})
+	}
+
+	// Use the current date as the brew date.
+	if brew.XML.Date == "" {
 		// TODO: local time?
 		brew.XML.Date = time.Now().UTC().Format("02 Jan 2006")
 	}
 
+	if err := s.db.StartBrew(brew, deductions); err != nil {
+		return nil, err
+	}
+
+	// Notify the ingredients whose stock became low.
+	for _, u := range uses {
+		if u.Ingredient == nil || !u.Convertible {
+			continue
+		}
+
+		left := u.Ingredient.Stock - u.Amount
+		if !lowStock(u.Ingredient, u.Ingredient.Stock) && lowStock(u.Ingredient, left) {
+			u.Ingredient.Stock = left
+			s.notify(brew.Owner(), db.EventInventoryLow, ingredientToJSON(u.Ingredient))
+		}
+	}
+
+	s.notify(brew.Owner(), db.EventBrewStep, webhookBrewStep{ brewSummaryToJSON(brew), db.StepPrepare })
+	return notDeductible(uses), nil
+}
+
+// Move a brew to its next step.
+func (s *Server) nextStep(brew *db.Brew) error {
+	if brew.Step == db.StepPrepare {
+		_, err := s.startBrew(brew, nil)
+		return err
+	}
+
 	// Increment the brew step.
-	if brew.Step < db.StepMax {
-		brew.Step++
+	if brew.Step >= db.StepMax {
+		return s.db.UpdateBrew(brew)
 	}
+	brew.Step++
 
 	if err := s.db.UpdateBrew(brew); err != nil {
-		http.Error(w, err.Error(), 500)
-		return
+		return err
 	}
 
-	http.Redirect(w, r, fmt.Sprintf("/brew/%d", id), 302)
+	s.notify(brew.Owner(), db.EventBrewStep, webhookBrewStep{ brewSummaryToJSON(brew), brew.Step - 1 })
+	return nil
 }
 
 // Create a new brew from a recipe.
@@ -324,18 +502,19 @@ func (s *Server) newBrew(w http.ResponseWriter, r *http.Request, user *db.User)
 		return
 	}
 
-	// Retrieve the recipe current info.
-	recipe, err := s.getRecipe(id, user.Id)
+	// Retrieve the recipe current info. Brews belong to the recipe owner.
+	recipe, err := s.getRecipe(id, user, accessWrite)
 	if err != nil {
 		http.Error(w, err.Error(), 500)
 		return
 	}
 
 	brew := &db.Brew{
-		UserId:   user.Id,
-		RecipeId: recipe.Id,
-		Step:     db.StepPrepare,
-		XML:      recipe.XML,
+		UserId:         recipe.UserId,
+		OrganizationId: recipe.OrganizationId,
+		RecipeId:       recipe.Id,
+		Step:           db.StepPrepare,
+		XML:            recipe.XML,
 	}
 
 	newId, err := s.db.AddBrew(brew)
@@ -343,6 +522,8 @@ func (s *Server) newBrew(w http.ResponseWriter, r *http.Request, user *db.User)
 		http.Error(w, err.Error(), 500)
 		return
 	}
+	brew.Id = newId
+	s.notify(brew.Owner(), db.EventBrewCreated, brewSummaryToJSON(brew))
 
 	http.Redirect(w, r, fmt.Sprintf("/brew/%d", newId), 302)
 }
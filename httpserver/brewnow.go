@@ -0,0 +1,307 @@
+// Copyright (C) 2019 Antoine Tenart <antoine.tenart@ack.tf>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package httpserver
+
+import (
+	"math"
+	"net/http"
+	s "sort"
+	"strconv"
+	"time"
+
+	"github.com/atenart/bubbles/beerxml"
+	"github.com/atenart/bubbles/db"
+)
+
+// Default tolerances when looking for substitutes: color in SRM and alpha acids
+// in percentage points.
+const (
+	defaultColorTolerance = 5
+	defaultAlphaTolerance = 2
+)
+
+// An ingredient of a recipe missing or short in the inventory, and the
+// inventory ingredient which could replace it.
+type MissingIngredient struct {
+	StockUse
+	Short      float64
+	Substitute *db.Ingredient
+	// Amount of the substitute needed, in its stock unit.
+	SubstituteAmount float64
+}
+
+// A recipe, and how well the inventory covers it.
+type BrewableRecipe struct {
+	Recipe      *db.Recipe
+	Own         bool
+	Total       int
+	Covered     int
+	Substituted int
+	Coverage    float64
+	Missing     []MissingIngredient
+}
+
+// "What can I brew now?" page: rank the recipes of the current workspace, and
+// public recipes, by how completely its inventory covers them.
+func (s *Server) brewNow(w http.ResponseWriter, r *http.Request, user *db.User) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Couldn't parse form field.", 500)
+		return
+	}
+
+	colorTolerance := formToTolerance(r, "color", defaultColorTolerance)
+	alphaTolerance := formToTolerance(r, "alpha", defaultAlphaTolerance)
+
+	owner := s.workspace(user)
+	recipes, err := s.db.GetRecipes(owner)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	own := len(recipes)
+
+	if r.FormValue("public") != "" {
+		public, err := s.db.GetPublicRecipes(owner)
+		if err != nil {
+			http.Error(w, err.Error(), 500)
+			return
+		}
+		recipes = append(recipes, public...)
+	}
+
+	// The inventory is only retrieved once, for all the recipes.
+	ingredients, err := s.db.GetIngredients(owner)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+
+	lots, err := s.db.GetLots(owner)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+
+	index := newStockIndex(ingredients, lots)
+	stock := stockIngredients(ingredients, lots)
+
+	var brewable []BrewableRecipe
+	for k, recipe := range recipes {
+		b := brewableRecipe(recipe, index, stock, colorTolerance, alphaTolerance)
+
+		// Nothing to brew.
+		if b.Total == 0 {
+			continue
+		}
+
+		b.Own = k < own
+		brewable = append(brewable, *b)
+	}
+
+	sortBrewable(brewable)
+
+	s.executeTemplate(w, user, "brew-now.html", struct{
+		Title          string
+		Recipes        []BrewableRecipe
+		ColorTolerance float64
+		AlphaTolerance float64
+		Public         bool
+	}{
+		"Bubbles - what can I brew now?",
+		brewable,
+		colorTolerance,
+		alphaTolerance,
+		r.FormValue("public") != "",
+	})
+}
+
+// Retrieve a substitution tolerance from a form, or its default value.
+func formToTolerance(r *http.Request, name string, def float64) float64 {
+	tolerance, err := strconv.ParseFloat(r.FormValue(name), 64)
+	if err != nil || tolerance < 0 {
+		return def
+	}
+	return tolerance
+}
+
+// Select the inventory ingredients in stock, with the actual specs of their
+// current lot.
+func stockIngredients(ingredients []*db.Ingredient, lots []*db.Lot) []*db.Ingredient {
+	current := currentLots(lots)
+
+	now := time.Now()
+	var stock []*db.Ingredient
+	for _, i := range ingredients {
+		if i.Stock <= 0 {
+			continue
+		}
+
+		applyLot(i.XML, current[i.Id])
+		if xml, ok := i.XML.(*beerxml.Hop); ok {
+			xml.Alpha = storedAlpha(xml, current[i.Id], now)
+		}
+
+		stock = append(stock, i)
+	}
+
+	return stock
+}
+
+// Compute how well an inventory covers a recipe, given the inventory ingredients
+// in stock. Missing or short ingredients are replaced, when possible, by
+// inventory ingredients of the same type within the color (fermentables) or
+// alpha acids (hops) tolerance.
+func brewableRecipe(recipe *db.Recipe, index *stockIndex, stock []*db.Ingredient,
+		    colorTolerance, alphaTolerance float64) *BrewableRecipe {
+	total := addUpIngredients(recipe.XML)
+	uses := index.uses(total)
+
+	b := BrewableRecipe{ Recipe: recipe, Total: len(uses) }
+
+	// Stock still available once the ingredients in stock are used.
+	available := make(map[int64]float64)
+	for _, i := range stock {
+		available[i.Id] = i.Stock
+	}
+
+	var missing []StockUse
+	for _, u := range uses {
+		if u.Ingredient != nil && u.Convertible && u.Remaining >= 0 {
+			available[u.Ingredient.Id] -= u.Amount
+			b.Covered++
+			continue
+		}
+		missing = append(missing, u)
+	}
+
+	for _, u := range missing {
+		// When units do not match, we can't tell how much is missing.
+		m := MissingIngredient{ StockUse: u, Short: u.Amount }
+		if u.Ingredient != nil && u.Convertible {
+			m.Short = -u.Remaining
+		}
+
+		// Find the closest inventory ingredient of the same type.
+		best := math.Inf(1)
+		for _, sub := range stock {
+			if sub.Type != u.Type || sub.Name == u.Name {
+				continue
+			}
+
+			var distance, amount float64
+			var isWeight bool
+			switch xml := sub.XML.(type) {
+			case *beerxml.Fermentable:
+				f := findFermentable(total, u.Name)
+				if f == nil || xml.Type != f.Type {
+					continue
+				}
+				distance = math.Abs(xml.Color - f.Color)
+				if distance > colorTolerance {
+					continue
+				}
+				amount, isWeight = f.Amount, true
+			case *beerxml.Hop:
+				h := findHop(total, u.Name)
+				if h == nil {
+					continue
+				}
+				distance = math.Abs(xml.Alpha - h.Alpha)
+				if distance > alphaTolerance {
+					continue
+				}
+				// Keep the same bitterness, addition by addition.
+				for _, addition := range recipe.XML.Hops {
+					if addition.Name == u.Name {
+						amount += addition.SubstituteAmount(xml)
+					}
+				}
+				isWeight = true
+			case *beerxml.Yeast:
+				y := findYeast(total, u.Name)
+				if y == nil || xml.Type != y.Type || xml.Form != y.Form {
+					continue
+				}
+				amount, isWeight = y.Amount, y.AmountIsWeight
+			default:
+				continue
+			}
+
+			needed, ok := toStockUnit(amount, isWeight, sub.Unit)
+			if !ok || needed > available[sub.Id] || distance >= best {
+				continue
+			}
+
+			best = distance
+			m.Substitute = sub
+			m.SubstituteAmount = math.Round(needed * 1000) / 1000
+		}
+
+		if m.Substitute != nil {
+			available[m.Substitute.Id] -= m.SubstituteAmount
+			b.Substituted++
+		}
+		b.Missing = append(b.Missing, m)
+	}
+
+	b.Coverage = math.Round(float64(b.Covered + b.Substituted) * 100 / float64(b.Total))
+	return &b
+}
+
+// Find a fermentable given its name.
+func findFermentable(xml *beerxml.BeerXML, name string) *beerxml.Fermentable {
+	for k := range xml.Fermentables {
+		if xml.Fermentables[k].Name == name {
+			return &xml.Fermentables[k]
+		}
+	}
+	return nil
+}
+
+// Find a hop given its name.
+func findHop(xml *beerxml.BeerXML, name string) *beerxml.Hop {
+	for k := range xml.Hops {
+		if xml.Hops[k].Name == name {
+			return &xml.Hops[k]
+		}
+	}
+	return nil
+}
+
+// Find a yeast given its name.
+func findYeast(xml *beerxml.BeerXML, name string) *beerxml.Yeast {
+	for k := range xml.Yeasts {
+		if xml.Yeasts[k].Name == name {
+			return &xml.Yeasts[k]
+		}
+	}
+	return nil
+}
+
+// Sort a slice of BrewableRecipe.
+func sortBrewable(recipes []BrewableRecipe) {
+	s.Slice(recipes, func(i, j int) bool {
+		// Best coverage first, or
+		// least substitutions first, or fallback to
+		// 'name' in alphabetical order.
+		if recipes[i].Coverage != recipes[j].Coverage {
+			return recipes[i].Coverage > recipes[j].Coverage
+		} else if recipes[i].Substituted != recipes[j].Substituted {
+			return recipes[i].Substituted < recipes[j].Substituted
+		}
+		return recipes[i].Recipe.Name < recipes[j].Recipe.Name
+	})
+}
@@ -0,0 +1,69 @@
+// Copyright (C) 2019 Antoine Tenart <antoine.tenart@ack.tf>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+
+package httpserver
+
+import (
+	"testing"
+
+	"github.com/atenart/bubbles/beerxml"
+	"github.com/atenart/bubbles/db"
+)
+
+func TestBrewableRecipe(t *testing.T) {
+	ingredients := []*db.Ingredient{
+		{ Id: 1, Type: "fermentable", Name: "Pilsner", Stock: 5, Unit: "kg",
+		  XML: &beerxml.Fermentable{ Name: "Pilsner", Type: "Grain", Color: 2 } },
+		{ Id: 2, Type: "fermentable", Name: "Vienna", Stock: 2, Unit: "kg",
+		  XML: &beerxml.Fermentable{ Name: "Vienna", Type: "Grain", Color: 4 } },
+		{ Id: 3, Type: "hop", Name: "Saaz", Stock: 20, Unit: "g",
+		  XML: &beerxml.Hop{ Name: "Saaz", Alpha: 3.5 } },
+	}
+	lots := []*db.Lot{
+		{ Id: 1, IngredientId: 1, Amount: 5 },
+		{ Id: 2, IngredientId: 2, Amount: 2 },
+		{ Id: 3, IngredientId: 3, Amount: 20, Alpha: 3.5, PurchaseDate: "2100-01-01" },
+	}
+	index := newStockIndex(ingredients, lots)
+	stock := stockIngredients(ingredients, lots)
+
+	recipe := &db.Recipe{ XML: &beerxml.Recipe{
+		Fermentables: []beerxml.Fermentable{
+			{ Name: "Pilsner", Type: "Grain", Color: 2, Amount: 4 },
+			{ Name: "Munich", Type: "Grain", Color: 6, Amount: 1 },
+		},
+		Hops: []beerxml.Hop{
+			{ Name: "Saaz", Alpha: 3.5, Amount: 0.05 },
+		},
+	} }
+
+	b := brewableRecipe(recipe, index, stock, defaultColorTolerance, defaultAlphaTolerance)
+	if b.Total != 3 || b.Covered != 1 || b.Substituted != 1 || b.Coverage != 67 {
+		t.Errorf("total %d, covered %d, substituted %d, coverage %g, want 3, 1, 1, 67",
+			 b.Total, b.Covered, b.Substituted, b.Coverage)
+	}
+
+	missing := make(map[string]MissingIngredient)
+	for _, m := range b.Missing {
+		missing[m.Name] = m
+	}
+	if m, ok := missing["Munich"]; !ok || m.Substitute == nil || m.Substitute.Name != "Vienna" {
+		t.Errorf("Munich not replaced by Vienna: %+v", m)
+	}
+	if m, ok := missing["Saaz"]; !ok || m.Short != 30 || m.Substitute != nil {
+		t.Errorf("Saaz short %g, substitute %v, want 30g short", m.Short, m.Substitute)
+	}
+}
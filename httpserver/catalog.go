@@ -0,0 +1,216 @@
+// Copyright (C) 2019 Antoine Tenart <antoine.tenart@ack.tf>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package httpserver
+
+import (
+	"fmt"
+	"html/template"
+	"net/http"
+	s "sort"
+	"strings"
+
+	"github.com/gorilla/csrf"
+	"github.com/atenart/bubbles/beerxml"
+	"github.com/atenart/bubbles/db"
+)
+
+// An ingredient of the catalog.
+type CatalogItem struct {
+	Type        string
+	Name        string
+	Origin      string
+	XML         interface{}
+	InInventory bool
+}
+
+// List the ingredients of the catalog.
+func catalogItems(xml *beerxml.BeerXML) []CatalogItem {
+	var items []CatalogItem
+	for k := range xml.Fermentables {
+		f := &xml.Fermentables[k]
+		items = append(items, CatalogItem{
+			Type:   "fermentable",
+			Name:   f.Name,
+			Origin: strings.TrimSpace(f.Supplier + " " + f.Origin),
+			XML:    f,
+		})
+	}
+	for k := range xml.Hops {
+		h := &xml.Hops[k]
+		items = append(items, CatalogItem{ Type: "hop", Name: h.Name, Origin: h.Origin, XML: h })
+	}
+	for k := range xml.Yeasts {
+		y := &xml.Yeasts[k]
+		items = append(items, CatalogItem{
+			Type:   "yeast",
+			Name:   y.Name,
+			Origin: strings.TrimSpace(y.Laboratory + " " + y.ProductId),
+			XML:    y,
+		})
+	}
+	for k := range xml.Miscs {
+		m := &xml.Miscs[k]
+		items = append(items, CatalogItem{ Type: "misc", Name: m.Name, XML: m })
+	}
+	return items
+}
+
+// Search the catalog, given a query matching the ingredients name or origin and
+// an optional ingredient type.
+func searchCatalog(xml *beerxml.BeerXML, query, kind string) []CatalogItem {
+	types := map[string]int{
+		"fermentable": 0,
+		"hop": 1,
+		"yeast": 2,
+		"misc": 3,
+	}
+
+	query = strings.ToLower(query)
+
+	var items []CatalogItem
+	for _, i := range catalogItems(xml) {
+		if kind != "" && i.Type != kind {
+			continue
+		}
+		if !strings.Contains(strings.ToLower(i.Name), query) &&
+		   !strings.Contains(strings.ToLower(i.Origin), query) {
+			continue
+		}
+		items = append(items, i)
+	}
+
+	// Smallest 'type' priority first, or fallback to
+	// 'name' in alphabetical order.
+	s.SliceStable(items, func(i, j int) bool {
+		if items[i].Type != items[j].Type {
+			return types[items[i].Type] < types[items[j].Type]
+		}
+		return items[i].Name < items[j].Name
+	})
+
+	return items
+}
+
+// Catalog page: search the instance-wide ingredients catalog.
+func (s *Server) catalog(w http.ResponseWriter, r *http.Request, user *db.User) {
+	xml, err := s.db.Catalog()
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+
+	ingredients, err := s.db.GetIngredients(s.workspace(user))
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+
+	inventory := make(map[string]bool)
+	for _, i := range ingredients {
+		inventory[i.Type + "/" + i.Name] = true
+	}
+
+	query := r.FormValue("q")
+	kind := r.FormValue("type")
+
+	items := searchCatalog(xml, query, kind)
+	for k := range items {
+		items[k].InInventory = inventory[items[k].Type + "/" + items[k].Name]
+	}
+
+	s.executeTemplate(w, user, "catalog.html", struct{
+		CSRF  template.HTML
+		Title string
+		Query string
+		Type  string
+		Items []CatalogItem
+	}{
+		csrf.TemplateField(r),
+		"Bubbles - catalog",
+		query,
+		kind,
+		items,
+	})
+}
+
+// Copy an ingredient of the catalog into the inventory of the current
+// workspace.
+func (s *Server) copyFromCatalog(w http.ResponseWriter, r *http.Request, user *db.User) {
+	owner := s.workspace(user)
+	if err := s.checkAccess(user, owner, accessWrite); err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Couldn't parse form field.", 500)
+		return
+	}
+
+	xml, err := s.db.Catalog()
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+
+	kind := r.FormValue("type")
+	name := r.FormValue("name")
+
+	var item *CatalogItem
+	for _, i := range catalogItems(xml) {
+		if i.Type == kind && i.Name == name {
+			item = &i
+			break
+		}
+	}
+	if item == nil {
+		http.Error(w, fmt.Sprintf("'%s' not found in the catalog.", name), 500)
+		return
+	}
+
+	// Copy the catalog element, as it is shared.
+	i := &db.Ingredient{
+		UserId:         owner.UserId,
+		OrganizationId: owner.OrganizationId,
+		Name:           item.Name,
+		Type:           item.Type,
+	}
+	switch elmt := item.XML.(type) {
+	case *beerxml.Fermentable:
+		f := *elmt
+		f.Inventory = ""
+		i.XML = &f
+	case *beerxml.Hop:
+		h := *elmt
+		h.Inventory = ""
+		i.XML = &h
+	case *beerxml.Yeast:
+		y := *elmt
+		y.Inventory = ""
+		i.XML = &y
+	case *beerxml.Misc:
+		m := *elmt
+		m.Inventory = ""
+		i.XML = &m
+	}
+
+	if err := s.db.AddIngredient(i); err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+
+	http.Redirect(w, r, "/inventory", 302)
+}
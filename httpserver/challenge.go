@@ -0,0 +1,135 @@
+// Copyright (C) 2019 Antoine Tenart <antoine.tenart@ack.tf>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+
+package httpserver
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"html/template"
+	"math/bits"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Time given to solve a proof of work and submit the sign-up form.
+const powValidity = 30 * time.Minute
+
+// A challenge sign-ups must pass, to slow down automated ones, e.g. a captcha
+// or a proof of work.
+type Challenge interface {
+	// HTML added to the sign-up form.
+	Form() (template.HTML, error)
+	// Check the answer of a submitted sign-up form.
+	Verify(r *http.Request) bool
+}
+
+// Proof of work: the browser looks for a nonce such as the SHA-256 hash of the
+// challenge and of the nonce starts with a number of zero bits.
+type proofOfWork struct {
+	bits  int
+	key   []byte
+	mutex sync.Mutex
+	used  map[string]time.Time // Challenges already used, and their expiry.
+}
+
+// Create a proof of work challenge, requiring a number of zero bits (each one
+// doubles the work).
+func NewProofOfWork(bits int) (Challenge, error) {
+	key, err := randomString(32)
+	if err != nil {
+		return nil, err
+	}
+
+	return &proofOfWork{
+		bits: bits,
+		key:  []byte(key),
+		used: make(map[string]time.Time),
+	}, nil
+}
+
+// Sign a challenge, so it can't be forged.
+func (p *proofOfWork) sign(challenge string) string {
+	mac := hmac.New(sha256.New, p.key)
+	mac.Write([]byte(challenge))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func (p *proofOfWork) Form() (template.HTML, error) {
+	seed, err := randomString(16)
+	if err != nil {
+		return "", err
+	}
+
+	challenge := fmt.Sprintf("%d:%d:%s", p.bits, time.Now().Unix(), seed)
+	challenge += ":" + p.sign(challenge)
+
+	return template.HTML(fmt.Sprintf(`
+<input type="hidden" name="pow-challenge" value="%s">
+<input type="hidden" name="pow-nonce" data-bits="%d">
+<script defer src="/static/js/pow.js"></script>`, template.HTMLEscapeString(challenge), p.bits)), nil
+}
+
+func (p *proofOfWork) Verify(r *http.Request) bool {
+	challenge, nonce := r.FormValue("pow-challenge"), r.FormValue("pow-nonce")
+
+	parts := strings.Split(challenge, ":")
+	if len(parts) != 4 || !hmac.Equal([]byte(parts[3]), []byte(p.sign(strings.Join(parts[:3], ":")))) {
+		return false
+	}
+	issued, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil || parts[0] != strconv.Itoa(p.bits) {
+		return false
+	}
+	expires := time.Unix(issued, 0).Add(powValidity)
+	if time.Now().After(expires) {
+		return false
+	}
+
+	// Count the leading zero bits of the hash.
+	hash := sha256.Sum256([]byte(challenge + ":" + nonce))
+	zeros := 0
+	for _, b := range hash {
+		zeros += bits.LeadingZeros8(b)
+		if b != 0 {
+			break
+		}
+	}
+	if nonce == "" || zeros < p.bits {
+		return false
+	}
+
+	// Each challenge can only be used once.
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	now := time.Now()
+	for c, t := range p.used {
+		if now.After(t) {
+			delete(p.used, c)
+		}
+	}
+	if _, ok := p.used[challenge]; ok {
+		return false
+	}
+	p.used[challenge] = expires
+	return true
+}
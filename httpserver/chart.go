@@ -0,0 +1,135 @@
+// Copyright (C) 2019 Antoine Tenart <antoine.tenart@ack.tf>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package httpserver
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+
+	"github.com/atenart/bubbles/beerxml"
+	"github.com/atenart/bubbles/db"
+)
+
+// Chart dimensions & margins (px).
+const (
+	chartWidth  = 640
+	chartHeight = 280
+	chartLeft   = 45
+	chartRight  = 45
+	chartTop    = 15
+	chartBottom = 30
+)
+
+// Render the fermentation log of a brew as a SVG chart: the apparent
+// attenuation (left axis, plain line) and the temperature (right axis, dashed
+// line) over time.
+func fermentationChart(recipe *beerxml.Recipe, readings []*db.Reading) []byte {
+	var b bytes.Buffer
+
+	w := float64(chartWidth - chartLeft - chartRight)
+	h := float64(chartHeight - chartTop - chartBottom)
+
+	fmt.Fprintf(&b, `<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 %d %d" font-family="sans-serif" font-size="10">`,
+		    chartWidth, chartHeight)
+
+	// Time boundaries, in days since the first reading.
+	var days float64 = 1
+	if len(readings) > 1 {
+		days = math.Max(1, math.Ceil(readings[len(readings)-1].Date.Sub(readings[0].Date).Hours() / 24))
+	}
+
+	// Temperature boundaries, rounded to 5°C.
+	tmin, tmax := math.Inf(1), math.Inf(-1)
+	for _, r := range readings {
+		if r.Temperature == nil {
+			continue
+		}
+		tmin = math.Min(tmin, *r.Temperature)
+		tmax = math.Max(tmax, *r.Temperature)
+	}
+	if math.IsInf(tmin, 0) {
+		tmin, tmax = 15, 25
+	}
+	tmin = math.Floor(tmin / 5) * 5
+	tmax = math.Ceil(tmax / 5) * 5
+	if tmax <= tmin {
+		tmax = tmin + 5
+	}
+
+	x := func(r *db.Reading) float64 {
+		d := r.Date.Sub(readings[0].Date).Hours() / 24
+		return chartLeft + d / days * w
+	}
+	yAtt := func(att float64) float64 {
+		att = math.Max(0, math.Min(100, att))
+		return chartTop + h - att / 100 * h
+	}
+	yTemp := func(t float64) float64 {
+		return chartTop + h - (t - tmin) / (tmax - tmin) * h
+	}
+
+	// Horizontal grid & attenuation axis.
+	for att := 0; att <= 100; att += 20 {
+		y := yAtt(float64(att))
+		fmt.Fprintf(&b, `<line x1="%d" y1="%.1f" x2="%d" y2="%.1f" stroke="#ddd"/>`,
+			    chartLeft, y, chartWidth - chartRight, y)
+		fmt.Fprintf(&b, `<text x="%d" y="%.1f" text-anchor="end" dy="3">%d%%</text>`,
+			    chartLeft - 5, y, att)
+	}
+
+	// Temperature axis.
+	for i := 0; i <= 5; i++ {
+		t := tmin + float64(i) * (tmax - tmin) / 5
+		fmt.Fprintf(&b, `<text x="%d" y="%.1f" dy="3" fill="#c0392b">%.1f°C</text>`,
+			    chartWidth - chartRight + 5, yTemp(t), t)
+	}
+
+	// Time axis.
+	step := math.Max(1, math.Ceil(days / 10))
+	for d := 0.; d <= days; d += step {
+		fmt.Fprintf(&b, `<text x="%.1f" y="%d" text-anchor="middle">d%d</text>`,
+			    chartLeft + d / days * w, chartHeight - chartBottom + 15, int(d))
+	}
+	fmt.Fprintf(&b, `<line x1="%d" y1="%d" x2="%d" y2="%d" stroke="#888"/>`,
+		    chartLeft, chartHeight - chartBottom, chartWidth - chartRight, chartHeight - chartBottom)
+
+	// Attenuation line & points.
+	var points bytes.Buffer
+	for _, r := range readings {
+		if r.Gravity == 0 {
+			continue
+		}
+		att := recipe.CalcApparentAttenuation(r.Gravity)
+		fmt.Fprintf(&points, "%.1f,%.1f ", x(r), yAtt(att))
+		fmt.Fprintf(&b, `<circle cx="%.1f" cy="%.1f" r="3" fill="#3273dc"><title>%s: %.3f (%.1f%%)</title></circle>`,
+			    x(r), yAtt(att), r.Date.Format("02 Jan 2006 15:04"), r.Gravity, att)
+	}
+	fmt.Fprintf(&b, `<polyline points="%s" fill="none" stroke="#3273dc" stroke-width="2"/>`, points.String())
+
+	// Temperature line.
+	points.Reset()
+	for _, r := range readings {
+		if r.Temperature == nil {
+			continue
+		}
+		fmt.Fprintf(&points, "%.1f,%.1f ", x(r), yTemp(*r.Temperature))
+	}
+	fmt.Fprintf(&b, `<polyline points="%s" fill="none" stroke="#c0392b" stroke-dasharray="4 3"/>`, points.String())
+
+	b.WriteString("</svg>")
+	return b.Bytes()
+}
@@ -0,0 +1,173 @@
+// Copyright (C) 2019 Antoine Tenart <antoine.tenart@ack.tf>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package httpserver
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"github.com/atenart/bubbles/beerxml"
+	"github.com/atenart/bubbles/db"
+)
+
+// Serving sizes, in litres.
+const (
+	Serving33cl = 0.33
+	Serving50cl = 0.5
+)
+
+// Cost of a recipe, broken down by category.
+type Cost struct {
+	Currency     string
+	Fermentables float64
+	Hops         float64
+	Yeasts       float64
+	Miscs        float64
+	Water        float64
+	Energy       float64
+	Total        float64
+	PerLitre     float64
+	Per33cl      float64
+	Per50cl      float64
+	// Ingredients which could not be priced (not in the inventory, no
+	// price or units not matching).
+	Unpriced     []string
+}
+
+// Round a price to the cent.
+func roundPrice(p float64) float64 {
+	return math.Round(p * 100) / 100
+}
+
+// Compute the cost of a recipe, given the prices of the inventory of a given
+// owner. Water and energy are priced with the user settings.
+func (s *Server) recipeCost(user *db.User, owner db.Owner, recipe *beerxml.Recipe) (*Cost, error) {
+	uses, err := s.stockUses(owner, addUpIngredients(recipe))
+	if err != nil {
+		return nil, err
+	}
+
+	cost := &Cost{ Currency: user.Currency }
+	for _, u := range uses {
+		if u.Ingredient == nil || !u.Convertible || u.Ingredient.Package <= 0 {
+			cost.Unpriced = append(cost.Unpriced, u.Name)
+			continue
+		}
+
+		price := u.Amount * u.Ingredient.Price / u.Ingredient.Package
+		switch u.Type {
+		case "fermentable":
+			cost.Fermentables += price
+		case "hop":
+			cost.Hops += price
+		case "yeast":
+			cost.Yeasts += price
+		case "misc":
+			cost.Miscs += price
+		}
+	}
+
+	// The water price is given per m³.
+	cost.Water = recipe.CalcVolumeTot() * user.WaterPrice / 1000
+	cost.Energy = user.EnergyUse * user.EnergyPrice
+
+	cost.Total = cost.Fermentables + cost.Hops + cost.Yeasts + cost.Miscs +
+		     cost.Water + cost.Energy
+	if recipe.BatchSize > 0 {
+		cost.PerLitre = cost.Total / recipe.BatchSize
+	}
+	cost.Per33cl = cost.PerLitre * Serving33cl
+	cost.Per50cl = cost.PerLitre * Serving50cl
+
+	for _, p := range []*float64{ &cost.Fermentables, &cost.Hops, &cost.Yeasts,
+				      &cost.Miscs, &cost.Water, &cost.Energy, &cost.Total,
+				      &cost.PerLitre, &cost.Per33cl, &cost.Per50cl } {
+		*p = roundPrice(*p)
+	}
+
+	return cost, nil
+}
+
+// Convert price elements POSTed from a form into an ingredient.
+func formToPrice(r *http.Request, i *db.Ingredient) error {
+	i.Price, _ = strconv.ParseFloat(r.FormValue("price"), 64)
+	i.Package, _ = strconv.ParseFloat(r.FormValue("package"), 64)
+
+	// Sanity checks.
+	if i.Price < 0 || i.Package < 0 {
+		return fmt.Errorf("Price and package size can't be negative.")
+	}
+
+	return nil
+}
+
+// Record the price of an ingredient in its history, if it changed.
+func (s *Server) recordPrice(i *db.Ingredient) error {
+	if i.Package <= 0 {
+		return nil
+	}
+
+	prices, err := s.db.GetIngredientPrices(i.Id)
+	if err != nil {
+		return err
+	}
+
+	if len(prices) > 0 && prices[0].Price == i.Price && prices[0].Package == i.Package {
+		return nil
+	}
+
+	return s.db.AddPrice(&db.Price{
+		IngredientId: i.Id,
+		Price:        i.Price,
+		Package:      i.Package,
+	})
+}
+
+// Display the price history of an inventory ingredient.
+func (s *Server) ingredientPrices(w http.ResponseWriter, r *http.Request, user *db.User) {
+	id, err := strconv.ParseInt(mux.Vars(r)["Item"], 10, 64)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+
+	ingredient, err := s.getIngredient(id, user, accessRead)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+
+	prices, err := s.db.GetIngredientPrices(ingredient.Id)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+
+	s.executeTemplate(w, user, "prices.html", struct{
+		Title      string
+		Currency   string
+		Ingredient *db.Ingredient
+		Prices     []*db.Price
+	}{
+		fmt.Sprintf("Bubbles - prices/%s", ingredient.Name),
+		user.Currency,
+		ingredient,
+		prices,
+	})
+}
@@ -0,0 +1,529 @@
+// Copyright (C) 2019 Antoine Tenart <antoine.tenart@ack.tf>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+
+package httpserver
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/csrf"
+	"github.com/gorilla/mux"
+	"github.com/atenart/bubbles/db"
+)
+
+// Maximum size of the body sent by the hydrometers.
+const hydrometerMaxBody = 4096
+
+// Body of the requests sent by the hydrometers. iSpindels send their angle and
+// gravity, Tilts (through a bridge) their specific gravity and temperature in
+// °F, sometimes as strings.
+type hydrometerReading struct {
+	Token       string      `json:"token"`
+	Angle       float64     `json:"angle"`
+	Temperature *float64    `json:"temperature"` // Nil when not sent.
+	TempUnits   string      `json:"temp_units"`
+	Battery     float64     `json:"battery"`
+	Gravity     float64     `json:"gravity"`
+	SG          json.Number `json:"SG"`
+	Temp        json.Number `json:"Temp"`
+}
+
+// Brew whose gravity is stable, in the deliveries.
+type webhookGravityStable struct {
+	Brew    apiBrewSummary `json:"brew"`
+	Device  string         `json:"device"`
+	Gravity float64        `json:"gravity"`
+	Days    float64        `json:"days"`
+}
+
+// A device, and the brew its readings currently go to.
+type DeviceInfo struct {
+	*db.Device
+	Brew   *db.Brew
+	Stable bool
+}
+
+// Receive a reading from a hydrometer, authenticated by the token of its device,
+// and add it to the fermentation log of its brew.
+func (s *Server) hydrometer(w http.ResponseWriter, r *http.Request) {
+	if !isJSON(r) {
+		apiFail(w, &apiError{ status: http.StatusUnsupportedMediaType,
+				      Message: "Requests must use the application/json content type." })
+		return
+	}
+
+	var in hydrometerReading
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, hydrometerMaxBody)).Decode(&in); err != nil {
+		apiFail(w, &apiError{ status: http.StatusBadRequest, Message: "Invalid JSON body." })
+		return
+	}
+
+	// The token can be given in the body (iSpindel), as a parameter (Tilt
+	// cloud URL) or as a bearer token.
+	secret := in.Token
+	if secret == "" {
+		secret = r.URL.Query().Get("token")
+	}
+	if secret == "" {
+		secret = strings.TrimSpace(strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer "))
+	}
+
+	device, err := s.db.GetDeviceBySecret(secret)
+	if err == sql.ErrNoRows {
+		apiFail(w, &apiError{ status: http.StatusUnauthorized, Message: "Invalid device token." })
+		return
+	} else if err != nil {
+		apiFail(w, err)
+		return
+	}
+
+	reading, err := deviceReading(device, &in)
+	if err != nil {
+		apiFail(w, err)
+		return
+	}
+
+	now := time.Now().UTC()
+	device.LastSeen = now.Format(db.TimeFormat)
+	device.Battery = reading.Battery
+
+	brew, err := s.deviceBrew(device)
+	if err != nil {
+		// Keep track of the device activity, even without a brew to
+		// log the reading to.
+		if err := s.db.UpdateDevice(device); err != nil {
+			log.Print(err)
+		}
+		apiFail(w, err)
+		return
+	}
+
+	reading.BrewId = brew.Id
+	reading.Date = now
+	if _, err := s.db.AddReading(reading); err != nil {
+		apiFail(w, err)
+		return
+	}
+	s.notify(brew.Owner(), db.EventReading, readingToJSON(brew, reading))
+
+	if err := s.checkStable(device, brew, now); err != nil {
+		log.Print(err)
+	}
+
+	if err := s.db.UpdateDevice(device); err != nil {
+		apiFail(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Convert the body sent by a hydrometer into a reading, applying the device
+// calibration. Gravities are converted to specific gravities from the unit of
+// the device, and temperatures to °C.
+func deviceReading(d *db.Device, in *hydrometerReading) (*db.Reading, error) {
+	reading := &db.Reading{
+		DeviceId: d.Id,
+		Battery:  in.Battery,
+		Angle:    in.Angle,
+	}
+
+	switch d.Kind {
+	case db.DeviceISpindel:
+		reading.Gravity = in.Gravity
+		if len(d.Calibration) > 0 {
+			reading.Gravity = d.Calibrate(in.Angle)
+		}
+
+		if in.Temperature != nil {
+			temp := *in.Temperature
+			switch strings.ToUpper(in.TempUnits) {
+			case "F":
+				temp = fahrenheitToCelsius(temp)
+			case "K":
+				temp -= 273.15
+			}
+			reading.Temperature = &temp
+		}
+	case db.DeviceTilt:
+		sg, err := in.SG.Float64()
+		if err != nil {
+			return nil, &apiError{ status: http.StatusUnprocessableEntity,
+					       Message: "Invalid reading.",
+					       Fields: map[string]string{ "SG": "must be a number" } }
+		}
+		reading.Gravity = d.Calibrate(sg)
+
+		if in.Temp != "" {
+			temp, err := in.Temp.Float64()
+			if err != nil {
+				return nil, &apiError{ status: http.StatusUnprocessableEntity,
+						       Message: "Invalid reading.",
+						       Fields: map[string]string{ "Temp": "must be a number" } }
+			}
+			temp = fahrenheitToCelsius(temp)
+			reading.Temperature = &temp
+		}
+	}
+
+	if d.GravityUnit == db.GravityPlato {
+		reading.Gravity = platoToSG(reading.Gravity)
+	}
+
+	reading.Gravity = math.Round(reading.Gravity * 10000) / 10000
+	if reading.Temperature != nil {
+		temp := math.Round(*reading.Temperature * 10) / 10
+		reading.Temperature = &temp
+	}
+
+	if reading.Gravity < 0.9 || reading.Gravity > 1.2 {
+		return nil, &apiError{ status: http.StatusUnprocessableEntity,
+				       Message: fmt.Sprintf("Gravity %g is out of range.", reading.Gravity) }
+	}
+
+	return reading, nil
+}
+
+// Convert a gravity in °P to a specific gravity.
+func platoToSG(p float64) float64 {
+	return 1 + p / (258.6 - p / 258.2 * 227.1)
+}
+
+// Convert a temperature in °F to °C.
+func fahrenheitToCelsius(f float64) float64 {
+	return (f - 32) * 5 / 9
+}
+
+// Retrieve the brew the readings of a device go to: the brew it is pinned to,
+// or the only brew of its owner in fermentation.
+func (s *Server) deviceBrew(d *db.Device) (*db.Brew, error) {
+	if d.BrewId != 0 {
+		brew, err := s.db.GetBrew(d.BrewId)
+		if err != nil && err != sql.ErrNoRows {
+			return nil, err
+		} else if err == nil && brew.Owner() == d.Owner() && brew.Step == db.StepFermentation {
+			return brew, nil
+		}
+		return nil, &apiError{ status: http.StatusConflict,
+				       Message: "The brew of the device is not in fermentation." }
+	}
+
+	brews, err := s.db.GetBrews(d.Owner())
+	if err != nil {
+		return nil, err
+	}
+
+	var fermenting []*db.Brew
+	for _, b := range brews {
+		if b.Step == db.StepFermentation {
+			fermenting = append(fermenting, b)
+		}
+	}
+
+	switch len(fermenting) {
+	case 0:
+		return nil, &apiError{ status: http.StatusConflict,
+				       Message: "No brew is in fermentation." }
+	case 1:
+		return fermenting[0], nil
+	}
+	return nil, &apiError{ status: http.StatusConflict,
+			       Message: "Several brews are in fermentation, the device must be pinned to one." }
+}
+
+// Alert the owner of a device once the gravity of its brew has been stable for
+// the configured number of days.
+func (s *Server) checkStable(d *db.Device, brew *db.Brew, now time.Time) error {
+	if d.StableDays <= 0 || d.AlertedBrew == brew.Id {
+		return nil
+	}
+
+	readings, err := s.db.GetBrewReadings(brew.Id)
+	if err != nil {
+		return err
+	}
+
+	gravity, ok := gravityStable(readings, now.Add(-time.Duration(d.StableDays * float64(24 * time.Hour))),
+				     d.StableDelta)
+	if !ok {
+		return nil
+	}
+
+	d.AlertedBrew = brew.Id
+	s.notify(brew.Owner(), db.EventGravityStable, webhookGravityStable{
+		Brew:    brewSummaryToJSON(brew),
+		Device:  d.Name,
+		Gravity: gravity,
+		Days:    d.StableDays,
+	})
+
+	recipients, err := s.ownerEmails(brew.Owner())
+	if err != nil {
+		return err
+	}
+
+	body := fmt.Sprintf(`Hello,
+
+The gravity of the brew '%s' has been stable at %g for %g days, as measured by
+the hydrometer '%s'. The fermentation is likely over:
+
+%s/brew/%d
+
+— Cheers`, brew.Name, gravity, d.StableDays, d.Name, s.URL, brew.Id)
+	go func() {
+		for _, email := range recipients {
+			err := s.sendmail.Send(email, "Bubbles: gravity stable for " + brew.Name, body)
+			if err != nil {
+				log.Print(err)
+			}
+		}
+	}()
+
+	return nil
+}
+
+// Check the gravity readings (sorted by date) did not vary more than a given
+// delta since a date, starting from the last reading before it. Returns the
+// last gravity.
+func gravityStable(readings []*db.Reading, since time.Time, delta float64) (float64, bool) {
+	var base *db.Reading
+	var gravities []float64
+	for _, r := range readings {
+		if r.Gravity == 0 {
+			continue
+		} else if !r.Date.After(since) {
+			base = r
+			continue
+		}
+		gravities = append(gravities, r.Gravity)
+	}
+	if base == nil {
+		return 0, false
+	}
+
+	min, max, last := base.Gravity, base.Gravity, base.Gravity
+	for _, g := range gravities {
+		min = math.Min(min, g)
+		max = math.Max(max, g)
+		last = g
+	}
+	return last, max - min <= delta
+}
+
+// E-mails of the users of a given owner.
+func (s *Server) ownerEmails(o db.Owner) ([]string, error) {
+	if o.OrganizationId == 0 {
+		user, err := s.db.GetUserById(o.UserId)
+		if err != nil {
+			return nil, err
+		}
+		return []string{ user.Email }, nil
+	}
+
+	members, err := s.db.GetOrganizationMembers(o.OrganizationId)
+	if err != nil {
+		return nil, err
+	}
+
+	var emails []string
+	for _, m := range members {
+		emails = append(emails, m.Email)
+	}
+	return emails, nil
+}
+
+// Retrieve a device, checking the user has the requested access to it.
+func (s *Server) getDevice(id int64, user *db.User, access int) (*db.Device, error) {
+	device, err := s.db.GetDevice(id)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.checkAccess(user, device.Owner(), access); err != nil {
+		return nil, err
+	}
+
+	return device, nil
+}
+
+// Devices listing.
+func (s *Server) devices(w http.ResponseWriter, r *http.Request, user *db.User) {
+	s.devicesPage(w, r, user, "")
+}
+
+// Render the devices page. The token of a new device, if any, is shown once.
+func (s *Server) devicesPage(w http.ResponseWriter, r *http.Request, user *db.User, secret string) {
+	devices, err := s.db.GetDevices(s.workspace(user))
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+
+	var infos []DeviceInfo
+	for _, d := range devices {
+		info := DeviceInfo{ Device: d }
+		if brew, err := s.deviceBrew(d); err == nil {
+			info.Brew = brew
+			info.Stable = d.AlertedBrew == brew.Id
+		}
+		infos = append(infos, info)
+	}
+
+	brews, err := s.db.GetBrews(s.workspace(user))
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+
+	s.executeTemplate(w, user, "devices.html", struct{
+		CSRF    template.HTML
+		Title   string
+		Devices []DeviceInfo
+		Brews   []*db.Brew
+		Secret  string
+		URL     string
+	}{
+		csrf.TemplateField(r),
+		"Bubbles - devices",
+		infos,
+		brews,
+		secret,
+		s.URL + "/api/hydrometer",
+	})
+}
+
+// Add a new device in the current workspace. Its token is shown once.
+func (s *Server) newDevice(w http.ResponseWriter, r *http.Request, user *db.User) {
+	owner := s.workspace(user)
+	if err := s.checkAccess(user, owner, accessWrite); err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Couldn't parse form field.", 500)
+		return
+	}
+
+	d := &db.Device{
+		UserId:         owner.UserId,
+		OrganizationId: owner.OrganizationId,
+		Kind:           r.FormValue("kind"),
+		StableDelta:    0.001,
+	}
+	if err := formToDevice(r, d); err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	if d.Kind != db.DeviceISpindel && d.Kind != db.DeviceTilt {
+		http.Error(w, fmt.Sprintf("Unknown device kind '%s'.", d.Kind), 500)
+		return
+	}
+
+	secret, err := s.db.AddDevice(d)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+
+	s.devicesPage(w, r, user, secret)
+}
+
+// Save or delete a device.
+func (s *Server) saveDevice(w http.ResponseWriter, r *http.Request, user *db.User) {
+	id, err := strconv.ParseInt(mux.Vars(r)["Id"], 10, 64)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+
+	device, err := s.getDevice(id, user, accessWrite)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+
+	switch mux.Vars(r)["Action"] {
+	case "save":
+		if err := r.ParseForm(); err != nil {
+			http.Error(w, "Couldn't parse form field.", 500)
+			return
+		}
+
+		if err := formToDevice(r, device); err != nil {
+			http.Error(w, err.Error(), 500)
+			return
+		}
+
+		// Pinned brews must belong to the device owner.
+		if device.BrewId != 0 {
+			brew, err := s.db.GetBrew(device.BrewId)
+			if err != nil || brew.Owner() != device.Owner() {
+				http.Error(w, "Brew not found.", 500)
+				return
+			}
+		}
+
+		err = s.db.UpdateDevice(device)
+	case "delete":
+		err = s.db.DeleteDevice(device.Id)
+	}
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+
+	http.Redirect(w, r, "/devices", 302)
+}
+
+// Convert elements POSTed from a form into a *db.Device.
+func formToDevice(r *http.Request, d *db.Device) error {
+	d.Name = strings.TrimSpace(r.FormValue("name"))
+	if d.Name == "" {
+		return fmt.Errorf("A device needs a name.")
+	}
+
+	d.Calibration = nil
+	for _, c := range strings.Fields(strings.Replace(r.FormValue("calibration"), ",", " ", -1)) {
+		f, err := strconv.ParseFloat(c, 64)
+		if err != nil {
+			return fmt.Errorf("Invalid calibration coefficient '%s'.", c)
+		}
+		d.Calibration = append(d.Calibration, f)
+	}
+
+	d.GravityUnit = r.FormValue("gravity-unit")
+	if d.GravityUnit != db.GravitySG && d.GravityUnit != db.GravityPlato {
+		return fmt.Errorf("Unknown gravity unit '%s'.", d.GravityUnit)
+	}
+
+	d.BrewId, _ = strconv.ParseInt(r.FormValue("brew"), 10, 64)
+	d.StableDays, _ = strconv.ParseFloat(r.FormValue("stable-days"), 64)
+	if delta, err := strconv.ParseFloat(r.FormValue("stable-delta"), 64); err == nil && delta >= 0 {
+		d.StableDelta = delta
+	}
+
+	return nil
+}
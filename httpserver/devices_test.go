@@ -0,0 +1,106 @@
+// Copyright (C) 2019 Antoine Tenart <antoine.tenart@ack.tf>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+
+package httpserver
+
+import (
+	"testing"
+
+	"github.com/atenart/bubbles/db"
+)
+
+// Pointer to a value, for the optional fields.
+func float(v float64) *float64 {
+	return &v
+}
+
+func TestDeviceReading(t *testing.T) {
+	tests := []struct {
+		name    string
+		device  db.Device
+		in      hydrometerReading
+		gravity float64
+		temp    *float64
+	}{
+		{ "ispindel plato",
+		  db.Device{ Kind: db.DeviceISpindel, GravityUnit: db.GravityPlato },
+		  hydrometerReading{ Gravity: 12, Temperature: float(20), TempUnits: "C" }, 1.0484, float(20) },
+		{ "ispindel low plato",
+		  db.Device{ Kind: db.DeviceISpindel, GravityUnit: db.GravityPlato },
+		  hydrometerReading{ Gravity: 1.5, Temperature: float(68), TempUnits: "F" }, 1.0058, float(20) },
+		{ "ispindel sg",
+		  db.Device{ Kind: db.DeviceISpindel, GravityUnit: db.GravitySG },
+		  hydrometerReading{ Gravity: 1.05, Temperature: float(293.15), TempUnits: "K" }, 1.05, float(20) },
+		{ "ispindel calibrated plato",
+		  db.Device{ Kind: db.DeviceISpindel, GravityUnit: db.GravityPlato,
+			     Calibration: []float64{ 0.5, -10 } },
+		  hydrometerReading{ Angle: 40, Gravity: 1.2, Temperature: float(20) }, 1.04, float(20) },
+		{ "ispindel calibrated sg",
+		  db.Device{ Kind: db.DeviceISpindel, GravityUnit: db.GravitySG,
+			     Calibration: []float64{ 0.001, 1 } },
+		  hydrometerReading{ Angle: 40, Gravity: 10, Temperature: float(20) }, 1.04, float(20) },
+		{ "tilt sg",
+		  db.Device{ Kind: db.DeviceTilt, GravityUnit: db.GravitySG,
+			     Calibration: []float64{ 1, 0.002 } },
+		  hydrometerReading{ SG: "1.048", Temp: "68" }, 1.05, float(20) },
+		{ "tilt plato",
+		  db.Device{ Kind: db.DeviceTilt, GravityUnit: db.GravityPlato },
+		  hydrometerReading{ SG: "10", Temp: "68" }, 1.04, float(20) },
+		{ "ispindel no temperature",
+		  db.Device{ Kind: db.DeviceISpindel, GravityUnit: db.GravitySG },
+		  hydrometerReading{ Gravity: 1.05, TempUnits: "K" }, 1.05, nil },
+		{ "tilt no temperature",
+		  db.Device{ Kind: db.DeviceTilt, GravityUnit: db.GravitySG },
+		  hydrometerReading{ SG: "1.05" }, 1.05, nil },
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reading, err := deviceReading(&tt.device, &tt.in)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if reading.Gravity != tt.gravity {
+				t.Errorf("gravity = %g, want %g", reading.Gravity, tt.gravity)
+			}
+			if (reading.Temperature == nil) != (tt.temp == nil) ||
+			   (tt.temp != nil && *reading.Temperature != *tt.temp) {
+				t.Errorf("temperature = %v, want %v", reading.Temperature, tt.temp)
+			}
+		})
+	}
+}
+
+func TestDeviceReadingErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		device db.Device
+		in     hydrometerReading
+	}{
+		{ "plato as sg", db.Device{ Kind: db.DeviceISpindel, GravityUnit: db.GravitySG },
+		  hydrometerReading{ Gravity: 12 } },
+		{ "out of range", db.Device{ Kind: db.DeviceTilt, GravityUnit: db.GravityPlato },
+		  hydrometerReading{ SG: "60" } },
+		{ "invalid sg", db.Device{ Kind: db.DeviceTilt, GravityUnit: db.GravitySG },
+		  hydrometerReading{ SG: "" } },
+	}
+
+	for _, tt := range tests {
+		if _, err := deviceReading(&tt.device, &tt.in); err == nil {
+			t.Errorf("%s: reading accepted", tt.name)
+		}
+	}
+}
@@ -0,0 +1,245 @@
+// Copyright (C) 2019 Antoine Tenart <antoine.tenart@ack.tf>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package httpserver
+
+import (
+	"encoding/csv"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/atenart/bubbles/db"
+)
+
+// Format used by the datetime-local HTML inputs.
+const readingDateFormat = "2006-01-02T15:04"
+
+// A fermentation log entry, along with its apparent attenuation.
+type LogEntry struct {
+	*db.Reading
+	Attenuation float64
+}
+
+// Retrieve the fermentation log of a brew.
+func (s *Server) fermentationLog(brew *db.Brew) ([]LogEntry, error) {
+	readings, err := s.db.GetBrewReadings(brew.Id)
+	if err != nil {
+		return nil, err
+	}
+
+	var log []LogEntry
+	for _, r := range readings {
+		att := brew.XML.CalcApparentAttenuation(r.Gravity)
+		log = append(log, LogEntry{ r, math.Round(att * 10) / 10 })
+	}
+
+	return log, nil
+}
+
+// Add a reading to the fermentation log of a brew.
+func (s *Server) addReading(w http.ResponseWriter, r *http.Request, user *db.User) {
+	id, err := strconv.ParseInt(mux.Vars(r)["Id"], 10, 64)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+
+	brew, err := s.getBrew(id, user, accessWrite)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Couldn't parse form field.", 500)
+		return
+	}
+
+	reading, err := formToReading(r)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	reading.BrewId = brew.Id
+
+	if _, err := s.db.AddReading(reading); err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	s.notify(brew.Owner(), db.EventReading, readingToJSON(brew, reading))
+
+	http.Redirect(w, r, fmt.Sprintf("/brew/%d#log", id), 302)
+}
+
+// Delete a reading from the fermentation log of a brew.
+func (s *Server) deleteReading(w http.ResponseWriter, r *http.Request, user *db.User) {
+	id, err := strconv.ParseInt(mux.Vars(r)["Id"], 10, 64)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+
+	item, err := strconv.ParseInt(mux.Vars(r)["Item"], 10, 64)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+
+	brew, err := s.getBrew(id, user, accessWrite)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+
+	reading, err := s.db.GetReading(item)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+
+	// Check the reading belongs to the brew.
+	if reading.BrewId != brew.Id {
+		http.Error(w, "Reading not found.", 500)
+		return
+	}
+
+	if err := s.db.DeleteReading(reading); err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+
+	http.Redirect(w, r, fmt.Sprintf("/brew/%d#log", id), 302)
+}
+
+// Render the fermentation log of a brew as a SVG chart.
+func (s *Server) fermentationLogSVG(w http.ResponseWriter, r *http.Request, user *db.User) {
+	id, err := strconv.ParseInt(mux.Vars(r)["Id"], 10, 64)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+
+	brew, err := s.getBrew(id, user, accessRead)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+
+	readings, err := s.db.GetBrewReadings(brew.Id)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+
+	w.Header().Set("Content-Type", "image/svg+xml")
+	w.Write(fermentationChart(brew.XML, readings))
+}
+
+// Export the fermentation log of a brew as a CSV file.
+func (s *Server) fermentationLogCSV(w http.ResponseWriter, r *http.Request, user *db.User) {
+	id, err := strconv.ParseInt(mux.Vars(r)["Id"], 10, 64)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+
+	brew, err := s.getBrew(id, user, accessRead)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+
+	readings, err := s.db.GetBrewReadings(brew.Id)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+
+	w.Header().Add("Content-Type", "text/csv")
+	w.Header().Set("Content-Disposition",
+		       fmt.Sprintf("attachment; filename=bubbles_brew%d_%s.csv", brew.Id,
+				   time.Now().UTC().Format("200601021504")))
+
+	c := csv.NewWriter(w)
+	c.Write([]string{"date", "gravity", "temperature", "ph", "attenuation", "note", "angle", "battery"})
+	for _, reading := range readings {
+		var temperature string
+		if reading.Temperature != nil {
+			temperature = strconv.FormatFloat(*reading.Temperature, 'f', -1, 64)
+		}
+
+		c.Write([]string{
+			reading.Date.Format(time.RFC3339),
+			strconv.FormatFloat(reading.Gravity, 'f', -1, 64),
+			temperature,
+			strconv.FormatFloat(reading.Ph, 'f', -1, 64),
+			strconv.FormatFloat(brew.XML.CalcApparentAttenuation(reading.Gravity), 'f', 1, 64),
+			reading.Note,
+			strconv.FormatFloat(reading.Angle, 'f', -1, 64),
+			strconv.FormatFloat(reading.Battery, 'f', -1, 64),
+		})
+	}
+	c.Flush()
+}
+
+// Convert elements POSTed from a form into a *db.Reading.
+func formToReading(r *http.Request) (*db.Reading, error) {
+	var reading db.Reading
+
+	// Default to the current time when no date is given.
+	reading.Date = time.Now().UTC()
+	if date := r.FormValue("date"); date != "" {
+		// The date is in the time zone of the client, which sends its
+		// offset to UTC in minutes (as getTimezoneOffset in JavaScript).
+		loc := time.UTC
+		if v := r.FormValue("tz-offset"); v != "" {
+			offset, err := strconv.Atoi(v)
+			if err != nil || offset < -14 * 60 || offset > 14 * 60 {
+				return nil, fmt.Errorf("Invalid time zone offset '%s'.", v)
+			}
+			loc = time.FixedZone("", -offset * 60)
+		}
+
+		d, err := time.ParseInLocation(readingDateFormat, date, loc)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid date '%s'.", date)
+		}
+		reading.Date = d.UTC()
+	}
+
+	reading.Gravity, _ = strconv.ParseFloat(r.FormValue("gravity"), 64)
+	reading.Ph, _ = strconv.ParseFloat(r.FormValue("ph"), 64)
+	reading.Note = r.FormValue("note")
+
+	// 0 °C is a valid temperature: only an empty field means no measure.
+	if v := r.FormValue("temperature"); v != "" {
+		t, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid temperature '%s'.", v)
+		}
+		reading.Temperature = &t
+	}
+
+	// Sanity checks.
+	if reading.Gravity == 0 && reading.Temperature == nil && reading.Ph == 0 && reading.Note == "" {
+		return nil, fmt.Errorf("Reading is empty.")
+	}
+
+	return &reading, nil
+}
@@ -0,0 +1,60 @@
+// Copyright (C) 2019 Antoine Tenart <antoine.tenart@ack.tf>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+
+package httpserver
+
+import (
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFormToReading(t *testing.T) {
+	tests := []struct {
+		name   string
+		date   string
+		offset string
+		want   time.Time
+		err    bool
+	}{
+		{ "utc", "2024-03-01T10:30", "", time.Date(2024, 3, 1, 10, 30, 0, 0, time.UTC), false },
+		{ "east", "2024-03-01T10:30", "-60", time.Date(2024, 3, 1, 9, 30, 0, 0, time.UTC), false },
+		{ "west", "2024-03-01T10:30", "300", time.Date(2024, 3, 1, 15, 30, 0, 0, time.UTC), false },
+		{ "invalid offset", "2024-03-01T10:30", "abc", time.Time{}, true },
+		{ "out of range offset", "2024-03-01T10:30", "6000", time.Time{}, true },
+		{ "invalid date", "01/03/2024", "", time.Time{}, true },
+	}
+	for _, tt := range tests {
+		form := url.Values{ "date": { tt.date }, "tz-offset": { tt.offset }, "gravity": { "1.050" } }
+		r := httptest.NewRequest("POST", "/", strings.NewReader(form.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+		reading, err := formToReading(r)
+		if tt.err {
+			if err == nil {
+				t.Errorf("%s: formToReading succeeded, want an error", tt.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: formToReading: %v", tt.name, err)
+		} else if !reading.Date.Equal(tt.want) || reading.Date.Location() != time.UTC {
+			t.Errorf("%s: date = %v, want %v", tt.name, reading.Date, tt.want)
+		}
+	}
+}
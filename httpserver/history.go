@@ -0,0 +1,117 @@
+// Copyright (C) 2019 Antoine Tenart <antoine.tenart@ack.tf>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package httpserver
+
+import (
+	"fmt"
+	"html/template"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/csrf"
+	"github.com/gorilla/mux"
+	"github.com/atenart/bubbles/beerxml"
+	"github.com/atenart/bubbles/db"
+)
+
+// A recipe revision and its changes compared to the previous one.
+type RevisionChanges struct {
+	Revision *db.Revision
+	Changes  []beerxml.Change
+	First    bool
+}
+
+// Display the history of a recipe.
+func (s *Server) recipeHistory(w http.ResponseWriter, r *http.Request, user *db.User) {
+	id, err := strconv.ParseInt(mux.Vars(r)["Id"], 10, 64)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+
+	// Retrieve the recipe current info.
+	recipe, err := s.getRecipe(id, user, accessRead)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+
+	// Retrieve the recipe revisions, latest first, with their changes
+	// compared to the one preceding them.
+	revisions, err := s.db.GetRecipeRevisions(recipe.Id)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+
+	var history []RevisionChanges
+	for i, rev := range revisions {
+		history = append(history, RevisionChanges{
+			rev,
+			rev.Changes,
+			i == len(revisions) - 1,
+		})
+	}
+
+	s.executeTemplate(w, user, "history.html", struct{
+		CSRF    template.HTML
+		Title   string
+		Recipe  *db.Recipe
+		History []RevisionChanges
+	}{
+		csrf.TemplateField(r),
+		fmt.Sprintf("Bubbles - recipe/%s/history", recipe.Name),
+		recipe,
+		history,
+	})
+}
+
+// Retrieve a revision, checking it belongs to the given recipe.
+func (s *Server) getRevision(id int64, recipe *db.Recipe) (*db.Revision, error) {
+	revision, err := s.db.GetRevision(id)
+	if err != nil {
+		return nil, err
+	}
+
+	if revision.RecipeId != recipe.Id {
+		return nil, fmt.Errorf("Revision not found.")
+	}
+
+	return revision, nil
+}
+
+// Record a new revision of a recipe, after an action was performed on it.
+func (s *Server) addRevision(recipe *db.Recipe, action string) error {
+	_, err := s.db.AddRevision(&db.Revision{
+		RecipeId: recipe.Id,
+		Action:   action,
+		XML:      recipe.XML,
+	})
+	if err != nil {
+		return err
+	}
+
+	// Revisions are recorded for each change of a recipe, notify them. The
+	// initial revision is the state of a recipe before a change.
+	switch action {
+	case "initial":
+	case "new", "clone", "branch", "import":
+		s.notify(recipe.Owner(), db.EventRecipeCreated, recipeSummaryToJSON(recipe))
+	default:
+		s.notify(recipe.Owner(), db.EventRecipeUpdated, recipeSummaryToJSON(recipe))
+	}
+	return nil
+}
@@ -27,9 +27,9 @@ import (
 	"github.com/atenart/bubbles/beerxml"
 )
 
-// Inventory page (per-user).
+// Inventory page (per-workspace).
 func (s *Server) inventory(w http.ResponseWriter, r *http.Request, user *db.User) {
-	ingredients, err := s.db.GetUserIngredients(user.Id)
+	ingredients, err := s.db.GetIngredients(s.workspace(user))
 	if err != nil {
 		http.Error(w, err.Error(), 500)
 		return
@@ -37,14 +37,24 @@ func (s *Server) inventory(w http.ResponseWriter, r *http.Request, user *db.User
 
 	sort(ingredients)
 
+	catalog, err := s.db.Catalog()
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+
 	s.executeTemplate(w, user, "inventory.html", struct{
 		CSRF        template.HTML
 		Title	    string
 		Ingredients []*db.Ingredient
+		Currency    string
+		Catalog     *beerxml.BeerXML
 	}{
 		csrf.TemplateField(r),
 		"Bubbles - inventory",
 		ingredients,
+		user.Currency,
+		catalog,
 	})
 }
 
@@ -59,18 +69,12 @@ func (s *Server) saveInventory(w http.ResponseWriter, r *http.Request, user *db.
 	action := mux.Vars(r)["Action"]
 	if v, err := strconv.ParseInt(mux.Vars(r)["Item"], 10, 32); err == nil {
 		// Retrieve the ingredient to modify.
-		ingredient, err := s.db.GetIngredient(int64(v))
+		ingredient, err := s.getIngredient(int64(v), user, accessWrite)
 		if err != nil {
 			http.Error(w, err.Error(), 500)
 			return
 		}
 
-		// Check the ingredient belongs to the current user.
-		if ingredient.UserId != user.Id {
-			http.Error(w, "Access to ingredient denied", 500)
-			return
-		}
-
 		// Perform the requested action.
 		switch action {
 		case "del":
@@ -102,10 +106,16 @@ func (s *Server) saveInventory(w http.ResponseWriter, r *http.Request, user *db.
 	http.Redirect(w, r, "/inventory", 302)
 }
 
-// Add a new ingredient to an user inventory.
+// Add a new ingredient to the inventory of the current workspace.
 func (s *Server) addIngredient(r *http.Request, user *db.User, action string) error {
+	owner := s.workspace(user)
+	if err := s.checkAccess(user, owner, accessWrite); err != nil {
+		return err
+	}
+
 	i := &db.Ingredient{
-		UserId: user.Id,
+		UserId:         owner.UserId,
+		OrganizationId: owner.OrganizationId,
 	}
 
 	switch action {
@@ -153,7 +163,18 @@ func (s *Server) addIngredient(r *http.Request, user *db.User, action string) er
 		return fmt.Errorf("Unknown ingredient")
 	}
 
-	return s.db.AddIngredient(i)
+	if err := formToStock(r, i); err != nil {
+		return err
+	}
+	if err := formToPrice(r, i); err != nil {
+		return err
+	}
+
+	if err := s.db.AddIngredient(i); err != nil {
+		return err
+	}
+
+	return s.recordPrice(i)
 }
 
 // Edit an ingredient.
@@ -176,6 +197,16 @@ func (s *Server) editIngredient(r *http.Request, user *db.User, i *db.Ingredient
 	}
 
 	i.Link = r.FormValue("link")
+	if err := formToStock(r, i); err != nil {
+		return err
+	}
+	if err := formToPrice(r, i); err != nil {
+		return err
+	}
+
+	if err := s.db.UpdateIngredient(i); err != nil {
+		return err
+	}
 
-	return s.db.UpdateIngredient(i)
+	return s.recordPrice(i)
 }
@@ -0,0 +1,176 @@
+// Copyright (C) 2019 Antoine Tenart <antoine.tenart@ack.tf>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+
+package httpserver
+
+import (
+	"log"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/atenart/bubbles/db"
+)
+
+const (
+	// Failed attempts are forgotten after this long without new ones.
+	limitForget = 24 * time.Hour
+	// Keys tracked by a limiter, so a flood of them can't exhaust the
+	// memory. Keys already tracked keep their backoff once it is reached.
+	limitKeys = 100000
+)
+
+// Rate limiting of failed attempts, e.g. logins, by key: once the free
+// attempts are used, each new failure doubles the delay before the next
+// attempt is allowed. Keys can also be locked out after too many failures.
+type limiter struct {
+	free     int           // Failures allowed before the backoff starts.
+	backoff  time.Duration // First delay, doubled on each failure.
+	max      time.Duration // Maximum delay, also used for lockouts.
+	lockout  int           // Failures locking the key out; 0 to disable.
+	mutex    sync.Mutex
+	attempts map[string]*attempts
+	pruned   time.Time
+}
+
+// Failed attempts of a key.
+type attempts struct {
+	failures int
+	last     time.Time
+	until    time.Time // No new attempt allowed before.
+}
+
+func newLimiter(free int, backoff, max time.Duration, lockout int) *limiter {
+	return &limiter{
+		free:     free,
+		backoff:  backoff,
+		max:      max,
+		lockout:  lockout,
+		attempts: make(map[string]*attempts),
+		pruned:   time.Now(),
+	}
+}
+
+// Return how long to wait before a new attempt is allowed for a key.
+func (l *limiter) wait(key string) time.Duration {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	now := time.Now()
+	l.prune(now)
+
+	if a, ok := l.attempts[key]; ok && a.until.After(now) {
+		return a.until.Sub(now)
+	}
+	return 0
+}
+
+// Record a failed attempt for a key. Returns true when it locked the key out.
+func (l *limiter) fail(key string) bool {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	now := time.Now()
+	a, ok := l.attempts[key]
+	if !ok {
+		if len(l.attempts) >= limitKeys {
+			l.pruned = time.Time{}
+			l.prune(now)
+		}
+		if len(l.attempts) >= limitKeys {
+			return false
+		}
+		a = &attempts{}
+		l.attempts[key] = a
+	}
+	a.failures++
+	a.last = now
+
+	if l.lockout > 0 && a.failures >= l.lockout {
+		a.until = now.Add(l.max)
+		return a.failures == l.lockout
+	}
+
+	if n := a.failures - l.free; n > 0 {
+		delay := l.max
+		if n <= 32 && l.backoff << uint(n - 1) < l.max {
+			delay = l.backoff << uint(n - 1)
+		}
+		a.until = now.Add(delay)
+	}
+	return false
+}
+
+// Forget the failed attempts of a key.
+func (l *limiter) reset(key string) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	delete(l.attempts, key)
+}
+
+// Drop the keys without recent failures. Called with the mutex held.
+func (l *limiter) prune(now time.Time) {
+	if now.Sub(l.pruned) < time.Minute {
+		return
+	}
+	l.pruned = now
+
+	for key, a := range l.attempts {
+		if now.Sub(a.last) > limitForget && now.After(a.until) {
+			delete(l.attempts, key)
+		}
+	}
+}
+
+// Key of the address of a request in the limiters. IPv6 clients usually get a
+// whole /64: they are limited as one.
+func ipKey(r *http.Request) string {
+	ip := net.ParseIP(remoteIP(r))
+	if ip == nil {
+		return remoteIP(r)
+	} else if ip.To4() == nil {
+		return ip.Mask(net.CIDRMask(64, 128)).String()
+	}
+	return ip.String()
+}
+
+// Check whether the address of a request or an account must wait for their
+// backoff to end. Accounts are keyed by their e-mail as is, as they are looked
+// up.
+func (s *Server) throttled(r *http.Request, email string) bool {
+	return s.limits.ips.wait(ipKey(r)) > 0 || s.limits.accounts.wait(email) > 0
+}
+
+// Record a failed login, for an address and an account.
+func (s *Server) loginFailed(r *http.Request, email string) {
+	s.limits.ips.fail(ipKey(r))
+	if s.limits.accounts.fail(email) {
+		log.Printf("Account %s locked for %v after %d failed logins.",
+			   email, s.limits.accounts.max, s.limits.accounts.lockout)
+	}
+}
+
+// Report an error hashing a password, which may be the server being too busy.
+func hashError(w http.ResponseWriter, err error) {
+	if err == db.ErrBusy {
+		w.Header().Set("Retry-After", "5")
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	http.Error(w, err.Error(), 500)
+}
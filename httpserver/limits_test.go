@@ -0,0 +1,151 @@
+// Copyright (C) 2019 Antoine Tenart <antoine.tenart@ack.tf>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+
+package httpserver
+
+import (
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestLimiterBackoff(t *testing.T) {
+	l := newLimiter(2, time.Hour, 5 * time.Hour, 0)
+
+	// Delay expected after each failure, with some slack for the time
+	// spent by the test.
+	tests := []time.Duration{ 0, 0, time.Hour, 2 * time.Hour, 4 * time.Hour, 5 * time.Hour, 5 * time.Hour }
+	for i, want := range tests {
+		if l.fail("key") {
+			t.Fatalf("failure %d locked the key out", i + 1)
+		}
+		if got := l.wait("key"); got > want || got < want - time.Minute {
+			t.Errorf("wait after %d failures = %v, want %v", i + 1, got, want)
+		}
+	}
+
+	// Keys are limited independently.
+	if got := l.wait("other"); got != 0 {
+		t.Errorf("wait of another key = %v, want 0", got)
+	}
+
+	l.reset("key")
+	if got := l.wait("key"); got != 0 {
+		t.Errorf("wait after reset = %v, want 0", got)
+	}
+}
+
+func TestLimiterOverflow(t *testing.T) {
+	l := newLimiter(0, time.Hour, 24 * time.Hour, 0)
+	for i := 0; i < 40; i++ {
+		l.fail("key")
+	}
+	if got := l.wait("key"); got > 24 * time.Hour || got < 23 * time.Hour {
+		t.Errorf("wait after 40 failures = %v, want the maximum", got)
+	}
+}
+
+func TestLimiterLockout(t *testing.T) {
+	l := newLimiter(3, time.Second, time.Hour, 5)
+	for i := 1; i <= 6; i++ {
+		if locked := l.fail("key"); locked != (i == 5) {
+			t.Errorf("failure %d: locked = %v", i, locked)
+		}
+	}
+	if got := l.wait("key"); got < time.Hour - time.Minute {
+		t.Errorf("wait when locked out = %v, want %v", got, time.Hour)
+	}
+}
+
+func TestLimiterPrune(t *testing.T) {
+	l := newLimiter(0, time.Minute, time.Minute, 0)
+	l.fail("old")
+	l.fail("recent")
+
+	// Make the failures of a key old enough to be forgotten.
+	l.attempts["old"].last = time.Now().Add(-limitForget - time.Hour)
+	l.attempts["old"].until = time.Now().Add(-time.Hour)
+	l.pruned = time.Now().Add(-time.Hour)
+
+	l.wait("recent")
+	if _, ok := l.attempts["old"]; ok {
+		t.Error("old key not pruned")
+	}
+	if _, ok := l.attempts["recent"]; !ok {
+		t.Error("recent key pruned")
+	}
+}
+
+func TestLimiterKeys(t *testing.T) {
+	l := newLimiter(0, time.Hour, time.Hour, 0)
+	for i := 0; i < limitKeys; i++ {
+		l.fail(strconv.Itoa(i))
+	}
+
+	// New keys aren't tracked once the limiter is full, while the keys
+	// already tracked keep their backoff.
+	l.fail("new")
+	if len(l.attempts) != limitKeys || l.wait("new") != 0 {
+		t.Errorf("%d keys tracked, want %d", len(l.attempts), limitKeys)
+	}
+	if l.wait("0") == 0 {
+		t.Error("tracked key lost its backoff")
+	}
+
+	// Room is made by forgetting the old keys.
+	for _, a := range l.attempts {
+		a.last = time.Now().Add(-limitForget - time.Hour)
+		a.until = time.Now().Add(-time.Hour)
+	}
+	l.fail("new")
+	if l.wait("new") == 0 {
+		t.Error("new key not tracked after pruning")
+	}
+}
+
+func TestIPKey(t *testing.T) {
+	tests := []struct {
+		addr string
+		key  string
+	}{
+		{ "192.0.2.1:1234", "192.0.2.1" },
+		{ "[2001:db8:1:2:3:4:5:6]:1234", "2001:db8:1:2::" },
+		{ "[2001:db8:1:2:ffff::1]:1234", "2001:db8:1:2::" },
+		{ "[::ffff:192.0.2.1]:1234", "192.0.2.1" },
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.RemoteAddr = tt.addr
+		if key := ipKey(r); key != tt.key {
+			t.Errorf("ipKey(%s) = %s, want %s", tt.addr, key, tt.key)
+		}
+	}
+}
+
+func TestForgotPasswordThrottled(t *testing.T) {
+	s := testServer()
+	s.limits.ips = newLimiter(0, time.Hour, time.Hour, 0)
+	s.limits.ips.fail("192.0.2.1")
+
+	r := httptest.NewRequest("POST", "/forgot-password", nil)
+	r.RemoteAddr = "192.0.2.1:1234"
+	w := httptest.NewRecorder()
+	s.forgotPassword(w, r)
+	if loc := w.Header().Get("Location"); w.Code != 302 || loc != "/#throttled" {
+		t.Errorf("forgotPassword: status %d, location %q", w.Code, loc)
+	}
+}
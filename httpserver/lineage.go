@@ -0,0 +1,176 @@
+// Copyright (C) 2019 Antoine Tenart <antoine.tenart@ack.tf>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package httpserver
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"github.com/atenart/bubbles/beerxml"
+	"github.com/atenart/bubbles/db"
+)
+
+// A recipe of a lineage, as seen by a given user. Recipes the user can't see
+// (private recipes it has no access to, or deleted ones) have no name nor link.
+type LineageItem struct {
+	Name   string
+	Author string
+	Link   string
+}
+
+// The ancestry (closest parent first) and the known forks of a recipe, and its
+// changes compared to its parent.
+type Lineage struct {
+	Ancestry []LineageItem
+	Forks    []LineageItem
+	Diffable bool
+	Changes  []beerxml.Change
+}
+
+// Author of a recipe as seen by a given user (nil when not logged in), derived
+// from its owner: the organization name, or the e-mail of an user to itself and
+// to the members of its organizations. The brewer given in the recipe can be
+// anything, it is only used as a label when the owner can't be shown.
+func (s *Server) author(owner db.Owner, label string, user *db.User) string {
+	if owner.OrganizationId != 0 {
+		if organization, err := s.db.GetOrganization(owner.OrganizationId); err == nil {
+			return organization.Name
+		}
+		return label
+	}
+	if owner.UserId == 0 || user == nil {
+		return label
+	}
+
+	author, err := s.db.GetUserById(owner.UserId)
+	if err != nil {
+		return label
+	}
+	if author.Id == user.Id {
+		return author.Email
+	}
+
+	memberships, err := s.db.GetUserMemberships(user.Id)
+	if err != nil {
+		return label
+	}
+	for _, m := range memberships {
+		if _, err := s.db.GetMember(m.OrganizationId, author.Id); err == nil {
+			return author.Email
+		}
+	}
+	return label
+}
+
+// Represent a recipe of a lineage, as seen by a given user (nil when not logged
+// in).
+func (s *Server) lineageItem(recipe *db.Recipe, user *db.User) LineageItem {
+	item := LineageItem{ Author: s.author(recipe.Owner(), recipe.XML.Brewer, user) }
+	if user != nil && s.checkAccess(user, recipe.Owner(), accessRead) == nil {
+		item.Link = fmt.Sprintf("/recipe/%d", recipe.Id)
+	} else if recipe.Public && recipe.Slug != "" {
+		item.Link = fmt.Sprintf("/r/%s", recipe.Slug)
+	}
+
+	if item.Link != "" {
+		item.Name = recipe.Name
+	}
+	return item
+}
+
+// Compute the lineage of a recipe, as seen by a given user (nil when not logged
+// in).
+func (s *Server) lineage(recipe *db.Recipe, user *db.User) (*Lineage, error) {
+	var l Lineage
+
+	// Walk up the ancestry, until a recipe which can't be seen.
+	seen := map[int64]bool{ recipe.Id: true }
+	for child := recipe; child.ParentId != 0; {
+		author := s.author(child.ParentOwner, child.ParentAuthor, user)
+		if child.ParentId == db.DeletedParent || seen[child.ParentId] {
+			l.Ancestry = append(l.Ancestry, LineageItem{ Author: author })
+			break
+		}
+		seen[child.ParentId] = true
+
+		parent, err := s.db.GetRecipe(child.ParentId)
+		if err != nil {
+			l.Ancestry = append(l.Ancestry, LineageItem{ Author: author })
+			break
+		}
+
+		item := s.lineageItem(parent, user)
+		item.Author = author
+		l.Ancestry = append(l.Ancestry, item)
+		if item.Link == "" {
+			break
+		}
+
+		// Compare the recipe to its direct parent.
+		if child == recipe {
+			l.Diffable = true
+			l.Changes = beerxml.Diff(parent.XML, recipe.XML)
+		}
+		child = parent
+	}
+
+	forks, err := s.db.GetRecipeForks(recipe.Id)
+	if err != nil {
+		return nil, err
+	}
+	for _, fork := range forks {
+		if item := s.lineageItem(fork, user); item.Link != "" {
+			l.Forks = append(l.Forks, item)
+		}
+	}
+
+	return &l, nil
+}
+
+// Display the lineage of a recipe: its ancestry, its forks and its changes
+// compared to its parent.
+func (s *Server) recipeLineage(w http.ResponseWriter, r *http.Request, user *db.User) {
+	id, err := strconv.ParseInt(mux.Vars(r)["Id"], 10, 64)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+
+	// Retrieve the recipe current info.
+	recipe, err := s.getRecipe(id, user, accessRead)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+
+	lineage, err := s.lineage(recipe, user)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+
+	s.executeTemplate(w, user, "lineage.html", struct{
+		Title   string
+		Recipe  *db.Recipe
+		Lineage *Lineage
+	}{
+		fmt.Sprintf("Bubbles - recipe/%s/lineage", recipe.Name),
+		recipe,
+		lineage,
+	})
+}
@@ -0,0 +1,70 @@
+// Copyright (C) 2019 Antoine Tenart <antoine.tenart@ack.tf>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+
+package httpserver
+
+import (
+	"testing"
+
+	"github.com/atenart/bubbles/db"
+)
+
+func TestAuthor(t *testing.T) {
+	s := dbTestServer(t)
+
+	var users []*db.User
+	for _, email := range []string{ "alice@bubbles.test", "bob@bubbles.test", "carol@bubbles.test" } {
+		if err := s.db.AddUser(email, "", "", false); err != nil {
+			t.Fatal(err)
+		}
+		user, err := s.db.GetUserByEmail(email)
+		if err != nil {
+			t.Fatal(err)
+		}
+		users = append(users, user)
+	}
+	alice, bob, carol := users[0], users[1], users[2]
+
+	// Alice and Bob share a brewery, Carol doesn't.
+	brewery := &db.Organization{ Name: "The Brewery" }
+	if err := s.db.AddOrganization(brewery, alice.Id); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.db.AddMember(&db.Member{ OrganizationId: brewery.Id, UserId: bob.Id, Role: db.RoleViewer }); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		owner db.Owner
+		user  *db.User
+		want  string
+	}{
+		{ "organization", db.Owner{ OrganizationId: brewery.Id }, carol, "The Brewery" },
+		{ "organization, logged out", db.Owner{ OrganizationId: brewery.Id }, nil, "The Brewery" },
+		{ "deleted organization", db.Owner{ OrganizationId: brewery.Id + 1 }, alice, "label" },
+		{ "itself", db.Owner{ UserId: alice.Id }, alice, alice.Email },
+		{ "same organization", db.Owner{ UserId: alice.Id }, bob, alice.Email },
+		{ "other user", db.Owner{ UserId: alice.Id }, carol, "label" },
+		{ "logged out", db.Owner{ UserId: alice.Id }, nil, "label" },
+		{ "unknown owner", db.Owner{}, alice, "label" },
+	}
+	for _, tt := range tests {
+		if got := s.author(tt.owner, "label", tt.user); got != tt.want {
+			t.Errorf("%s: author = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,312 @@
+// Copyright (C) 2019 Antoine Tenart <antoine.tenart@ack.tf>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package httpserver
+
+import (
+	"fmt"
+	"html/template"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/csrf"
+	"github.com/gorilla/mux"
+	"github.com/atenart/bubbles/beerxml"
+	"github.com/atenart/bubbles/db"
+)
+
+// Lot dates format.
+const lotDateFormat = "2006-01-02"
+
+// Default number of days for a lot to be considered expiring soon.
+const expiringDays = 30
+
+// A lot, and its estimated alpha acids (for hops).
+type LotEstimate struct {
+	*db.Lot
+	StoredAlpha float64
+}
+
+// A lot and the ingredient it belongs to.
+type IngredientLot struct {
+	*db.Lot
+	Ingredient *db.Ingredient
+	Expired    bool
+}
+
+// Retrieve an inventory ingredient, checking the user has the requested access
+// to it.
+func (s *Server) getIngredient(id int64, user *db.User, access int) (*db.Ingredient, error) {
+	ingredient, err := s.db.GetIngredient(id)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.checkAccess(user, ingredient.Owner(), access); err != nil {
+		return nil, err
+	}
+
+	return ingredient, nil
+}
+
+// Display the lots of an inventory ingredient.
+func (s *Server) lots(w http.ResponseWriter, r *http.Request, user *db.User) {
+	id, _ := strconv.ParseInt(mux.Vars(r)["Item"], 10, 64)
+	ingredient, err := s.getIngredient(id, user, accessRead)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+
+	lots, err := s.db.GetIngredientLots(ingredient.Id)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+
+	// Estimate the current alpha acids of hops.
+	now := time.Now()
+	var estimates []LotEstimate
+	for _, l := range lots {
+		hl := LotEstimate{ Lot: l }
+		if h, ok := ingredient.XML.(*beerxml.Hop); ok {
+			hop := *h
+			applyLot(&hop, l)
+			hl.StoredAlpha = storedAlpha(&hop, l, now)
+		}
+		estimates = append(estimates, hl)
+	}
+
+	s.executeTemplate(w, user, "lots.html", struct{
+		CSRF       template.HTML
+		Title      string
+		Ingredient *db.Ingredient
+		Lots       []LotEstimate
+		Today      string
+	}{
+		csrf.TemplateField(r),
+		fmt.Sprintf("Bubbles - lots/%s", ingredient.Name),
+		ingredient,
+		estimates,
+		now.Format(lotDateFormat),
+	})
+}
+
+// Add, edit or delete a lot of an inventory ingredient.
+func (s *Server) saveLot(w http.ResponseWriter, r *http.Request, user *db.User) {
+	id, _ := strconv.ParseInt(mux.Vars(r)["Item"], 10, 64)
+	ingredient, err := s.getIngredient(id, user, accessWrite)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+
+	// Retrieve elements from the POSTed form.
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Couldn't parse form field.", 500)
+		return
+	}
+
+	action := mux.Vars(r)["Action"]
+	if action == "add" {
+		lot := &db.Lot{ IngredientId: ingredient.Id }
+		if err := formToLot(r, lot); err != nil {
+			http.Error(w, err.Error(), 500)
+			return
+		}
+
+		if err := s.db.AddLot(lot); err != nil {
+			http.Error(w, err.Error(), 500)
+			return
+		}
+
+		http.Redirect(w, r, fmt.Sprintf("/inventory/lots/%d", ingredient.Id), 302)
+		return
+	}
+
+	// Retrieve the lot to modify.
+	lid, _ := strconv.ParseInt(mux.Vars(r)["Lot"], 10, 64)
+	lot, err := s.db.GetLot(lid)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+
+	if lot.IngredientId != ingredient.Id {
+		http.Error(w, "Access to lot denied", 500)
+		return
+	}
+
+	switch action {
+	case "edit":
+		if err := formToLot(r, lot); err != nil {
+			http.Error(w, err.Error(), 500)
+			return
+		}
+
+		if err := s.db.UpdateLot(lot); err != nil {
+			http.Error(w, err.Error(), 500)
+			return
+		}
+	case "del":
+		if err := s.db.DeleteLot(lot); err != nil {
+			http.Error(w, err.Error(), 500)
+			return
+		}
+	default:
+		http.Error(w, "Unknown action", 500)
+		return
+	}
+
+	http.Redirect(w, r, fmt.Sprintf("/inventory/lots/%d", ingredient.Id), 302)
+}
+
+// List the lots of the current workspace reaching their best-before date soon.
+func (s *Server) expiringLots(w http.ResponseWriter, r *http.Request, user *db.User) {
+	days, err := strconv.Atoi(r.FormValue("days"))
+	if err != nil || days < 0 {
+		days = expiringDays
+	}
+
+	now := time.Now()
+	lots, err := s.db.GetExpiringLots(s.workspace(user),
+		now.AddDate(0, 0, days).Format(lotDateFormat))
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+
+	ingredients, err := s.db.GetIngredients(s.workspace(user))
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+
+	byId := make(map[int64]*db.Ingredient)
+	for _, i := range ingredients {
+		byId[i.Id] = i
+	}
+
+	today := now.Format(lotDateFormat)
+	var expiring []IngredientLot
+	for _, l := range lots {
+		expiring = append(expiring, IngredientLot{
+			Lot:        l,
+			Ingredient: byId[l.IngredientId],
+			Expired:    l.BestBefore < today,
+		})
+	}
+
+	s.executeTemplate(w, user, "expiring.html", struct{
+		Title string
+		Days  int
+		Lots  []IngredientLot
+	}{
+		"Bubbles - expiring lots",
+		days,
+		expiring,
+	})
+}
+
+// Convert a lot POSTed from a form.
+func formToLot(r *http.Request, l *db.Lot) error {
+	l.Amount, _ = strconv.ParseFloat(r.FormValue("amount"), 64)
+	l.Code = r.FormValue("code")
+	l.Supplier = strings.TrimSpace(r.FormValue("supplier"))
+	l.PurchaseDate = r.FormValue("purchase-date")
+	l.BestBefore = r.FormValue("best-before")
+	l.Alpha, _ = strconv.ParseFloat(r.FormValue("alpha"), 64)
+	l.Color, _ = strconv.ParseFloat(r.FormValue("color"), 64)
+	l.Yield, _ = strconv.ParseFloat(r.FormValue("yield"), 64)
+	l.HarvestDate = r.FormValue("harvest-date")
+	l.StorageTemp, _ = strconv.ParseFloat(r.FormValue("storage-temp"), 64)
+	l.Packaging = r.FormValue("packaging")
+
+	// Sanity checks.
+	if _, ok := beerxml.HopPackagings[l.Packaging]; !ok {
+		return fmt.Errorf("Unknown packaging '%s'.", l.Packaging)
+	}
+	for _, date := range []string{ l.PurchaseDate, l.BestBefore, l.HarvestDate } {
+		if date == "" {
+			continue
+		}
+		if _, err := time.Parse(lotDateFormat, date); err != nil {
+			return fmt.Errorf("Invalid date '%s'.", date)
+		}
+	}
+	if l.Alpha < 0 || l.Color < 0 || l.Yield < 0 {
+		return fmt.Errorf("Specs can't be negative.")
+	}
+
+	return nil
+}
+
+// Retrieve the lot currently in use for each ingredient: the first one still in
+// stock, given lots listed in the order they are used.
+func currentLots(lots []*db.Lot) map[int64]*db.Lot {
+	current := make(map[int64]*db.Lot)
+	for _, l := range lots {
+		if _, ok := current[l.IngredientId]; ok || l.Amount <= 0 {
+			continue
+		}
+		current[l.IngredientId] = l
+	}
+	return current
+}
+
+// Override the specs of an ingredient with the actual specs of a lot.
+func applyLot(xml interface{}, l *db.Lot) {
+	if l == nil {
+		return
+	}
+
+	switch elmt := xml.(type) {
+	case *beerxml.Fermentable:
+		if l.Color > 0 {
+			elmt.Color = l.Color
+		}
+		if l.Yield > 0 {
+			elmt.Yield = l.Yield
+		}
+	case *beerxml.Hop:
+		if l.Alpha > 0 {
+			elmt.Alpha = l.Alpha
+		}
+	}
+}
+
+// Estimate the current alpha acids of a hop stored in a given lot, since its
+// harvest (or purchase) date.
+func storedAlpha(h *beerxml.Hop, l *db.Lot, now time.Time) float64 {
+	if l == nil {
+		return h.Alpha
+	}
+
+	date := l.HarvestDate
+	if date == "" {
+		date = l.PurchaseDate
+	}
+
+	t, err := time.Parse(lotDateFormat, date)
+	if err != nil {
+		return h.Alpha
+	}
+
+	days := now.Sub(t).Hours() / 24
+	return math.Round(h.CalcStoredAlpha(days, l.StorageTemp, l.Packaging) * 10) / 10
+}
@@ -0,0 +1,563 @@
+// Copyright (C) 2019 Antoine Tenart <antoine.tenart@ack.tf>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+
+package httpserver
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/atenart/bubbles/db"
+)
+
+const (
+	// Timeout of the requests to the OpenID Connect provider.
+	oidcTimeout = 10 * time.Second
+	// Time given to sign in at the provider.
+	oidcLoginTimeout = 10 * time.Minute
+	// Clock skew tolerated when checking the ID tokens.
+	oidcSkew = time.Minute
+)
+
+// Configuration of an OpenID Connect provider users can sign in with.
+type OIDCConfig struct {
+	Issuer       string
+	ClientId     string
+	ClientSecret string
+	Name         string // Shown on the login page.
+}
+
+// An OpenID Connect provider. Its configuration and keys are discovered on the
+// first login.
+type oidcProvider struct {
+	OIDCConfig
+	client    *http.Client
+	mutex     sync.Mutex
+	discovery *oidcDiscovery
+	keys      map[string]crypto.PublicKey
+}
+
+// Provider metadata, from its discovery document.
+type oidcDiscovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// Content of the cookie of a login in progress at the provider.
+type oidcState struct {
+	State    string
+	Nonce    string
+	Verifier string
+	Issued   int64
+}
+
+// Claims of an ID token used to sign in.
+type oidcClaims struct {
+	Issuer          string      `json:"iss"`
+	Subject         string      `json:"sub"`
+	Audience        interface{} `json:"aud"`
+	AuthorizedParty string      `json:"azp"`
+	Expires         float64     `json:"exp"`
+	IssuedAt        float64     `json:"iat"`
+	Nonce           string      `json:"nonce"`
+	Email           string      `json:"email"`
+	EmailVerified   interface{} `json:"email_verified"`
+}
+
+// Start a new OpenID Connect provider, or return nil when none is configured.
+func newOIDCProvider(config *OIDCConfig) *oidcProvider {
+	if config == nil || config.Issuer == "" {
+		return nil
+	}
+
+	p := &oidcProvider{
+		OIDCConfig: *config,
+		client:     &http.Client{ Timeout: oidcTimeout },
+	}
+	if p.Name == "" {
+		p.Name = "OpenID Connect"
+	}
+	return p
+}
+
+// Retrieve a JSON document from the provider.
+func (p *oidcProvider) getJSON(url string, v interface{}) error {
+	resp, err := p.client.Get(url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("Couldn't retrieve %s: %s", url, resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// Retrieve the provider metadata.
+func (p *oidcProvider) discover() (*oidcDiscovery, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if p.discovery != nil {
+		return p.discovery, nil
+	}
+
+	var d oidcDiscovery
+	issuer := strings.TrimSuffix(p.Issuer, "/")
+	if err := p.getJSON(issuer + "/.well-known/openid-configuration", &d); err != nil {
+		return nil, err
+	}
+	if strings.TrimSuffix(d.Issuer, "/") != issuer {
+		return nil, fmt.Errorf("Provider issuer '%s' doesn't match '%s'.", d.Issuer, p.Issuer)
+	}
+	if d.AuthorizationEndpoint == "" || d.TokenEndpoint == "" || d.JWKSURI == "" {
+		return nil, fmt.Errorf("Incomplete provider metadata.")
+	}
+
+	p.discovery = &d
+	return p.discovery, nil
+}
+
+// Retrieve a signing key of the provider, given its id. The keys are fetched
+// again when an unknown one is used, as providers rotate them.
+func (p *oidcProvider) key(kid string) (crypto.PublicKey, error) {
+	d, err := p.discover()
+	if err != nil {
+		return nil, err
+	}
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if key, ok := p.keys[kid]; ok {
+		return key, nil
+	}
+
+	var jwks struct {
+		Keys []struct {
+			Kid string `json:"kid"`
+			Kty string `json:"kty"`
+			Use string `json:"use"`
+			Crv string `json:"crv"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+			X   string `json:"x"`
+			Y   string `json:"y"`
+		} `json:"keys"`
+	}
+	if err := p.getJSON(d.JWKSURI, &jwks); err != nil {
+		return nil, err
+	}
+
+	p.keys = make(map[string]crypto.PublicKey)
+	for _, k := range jwks.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+
+		switch k.Kty {
+		case "RSA":
+			n, err1 := base64.RawURLEncoding.DecodeString(k.N)
+			e, err2 := base64.RawURLEncoding.DecodeString(k.E)
+			if err1 != nil || err2 != nil {
+				continue
+			}
+			p.keys[k.Kid] = &rsa.PublicKey{
+				N: new(big.Int).SetBytes(n),
+				E: int(new(big.Int).SetBytes(e).Int64()),
+			}
+		case "EC":
+			x, err1 := base64.RawURLEncoding.DecodeString(k.X)
+			y, err2 := base64.RawURLEncoding.DecodeString(k.Y)
+			if err1 != nil || err2 != nil || k.Crv != "P-256" {
+				continue
+			}
+			p.keys[k.Kid] = &ecdsa.PublicKey{
+				Curve: elliptic.P256(),
+				X:     new(big.Int).SetBytes(x),
+				Y:     new(big.Int).SetBytes(y),
+			}
+		}
+	}
+
+	key, ok := p.keys[kid]
+	if !ok {
+		return nil, fmt.Errorf("Unknown signing key '%s'.", kid)
+	}
+	return key, nil
+}
+
+// Exchange an authorization code for an ID token.
+func (p *oidcProvider) exchange(code, verifier, redirect string) (string, error) {
+	d, err := p.discover()
+	if err != nil {
+		return "", err
+	}
+
+	form := url.Values{
+		"grant_type":    { "authorization_code" },
+		"code":          { code },
+		"redirect_uri":  { redirect },
+		"code_verifier": { verifier },
+	}
+	req, err := http.NewRequest("POST", d.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth(url.QueryEscape(p.ClientId), url.QueryEscape(p.ClientSecret))
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	var token struct {
+		IdToken     string `json:"id_token"`
+		Error       string `json:"error"`
+		Description string `json:"error_description"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil {
+		return "", err
+	}
+	if token.Error != "" {
+		return "", fmt.Errorf("Token request failed: %s (%s)", token.Error, token.Description)
+	} else if token.IdToken == "" {
+		return "", fmt.Errorf("No ID token in the token response.")
+	}
+
+	return token.IdToken, nil
+}
+
+// Check an ID token (signature, issuer, audience, expiration and nonce) and
+// return its claims.
+func (p *oidcProvider) verify(raw, nonce string) (*oidcClaims, error) {
+	parts := strings.Split(raw, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("Malformed ID token.")
+	}
+
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, err
+	}
+
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := p.key(header.Kid)
+	if err != nil {
+		return nil, err
+	}
+
+	hash := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	switch k := key.(type) {
+	case *rsa.PublicKey:
+		if header.Alg != "RS256" {
+			return nil, fmt.Errorf("Unsupported ID token algorithm '%s'.", header.Alg)
+		}
+		if err := rsa.VerifyPKCS1v15(k, crypto.SHA256, hash[:], sig); err != nil {
+			return nil, fmt.Errorf("Invalid ID token signature.")
+		}
+	case *ecdsa.PublicKey:
+		if header.Alg != "ES256" {
+			return nil, fmt.Errorf("Unsupported ID token algorithm '%s'.", header.Alg)
+		}
+		if len(sig) != 64 || !ecdsa.Verify(k, hash[:], new(big.Int).SetBytes(sig[:32]),
+						   new(big.Int).SetBytes(sig[32:])) {
+			return nil, fmt.Errorf("Invalid ID token signature.")
+		}
+	}
+
+	var claims oidcClaims
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	switch {
+	case strings.TrimSuffix(claims.Issuer, "/") != strings.TrimSuffix(p.Issuer, "/"):
+		return nil, fmt.Errorf("Invalid ID token issuer '%s'.", claims.Issuer)
+	case !claims.hasAudience(p.ClientId):
+		return nil, fmt.Errorf("ID token not issued for this client.")
+	case time.Unix(int64(claims.Expires), 0).Before(now.Add(-oidcSkew)):
+		return nil, fmt.Errorf("Expired ID token.")
+	case time.Unix(int64(claims.IssuedAt), 0).After(now.Add(oidcSkew)):
+		return nil, fmt.Errorf("ID token issued in the future.")
+	case claims.Nonce != nonce:
+		return nil, fmt.Errorf("Invalid ID token nonce.")
+	case claims.Subject == "":
+		return nil, fmt.Errorf("No subject in the ID token.")
+	}
+
+	return &claims, nil
+}
+
+// Decode a base64url encoded JSON segment of a token.
+func decodeSegment(segment string, v interface{}) error {
+	b, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
+
+// Check the claims were issued for a client: it must be in the audience, and
+// be the authorized party when there are several audiences.
+func (c *oidcClaims) hasAudience(client string) bool {
+	switch aud := c.Audience.(type) {
+	case string:
+		return aud == client
+	case []interface{}:
+		found := false
+		for _, a := range aud {
+			if a == client {
+				found = true
+			}
+		}
+		return found && (len(aud) == 1 || c.AuthorizedParty == client)
+	}
+	return false
+}
+
+// Check the e-mail of the claims was verified by the provider.
+func (c *oidcClaims) emailVerified() bool {
+	switch v := c.EmailVerified.(type) {
+	case bool:
+		return v
+	case string:
+		return v == "true"
+	}
+	return false
+}
+
+// Generate a random url-safe string.
+func randomString(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// Redirect an user to the provider to sign in, using the authorization code
+// flow with PKCE.
+func (s *Server) oidcLogin(w http.ResponseWriter, r *http.Request) {
+	if s.oidc == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	d, err := s.oidc.discover()
+	if err != nil {
+		log.Printf("OIDC: %v", err)
+		http.Redirect(w, r, "/#oidc-error", 302)
+		return
+	}
+
+	var state oidcState
+	for _, v := range []*string{ &state.State, &state.Nonce, &state.Verifier } {
+		if *v, err = randomString(32); err != nil {
+			http.Error(w, err.Error(), 500)
+			return
+		}
+	}
+	state.Issued = time.Now().Unix()
+
+	payload, err := s.cookie.Encode("oidc", state)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+
+	s.setOIDCCookie(w, payload, int(oidcLoginTimeout / time.Second))
+
+	challenge := sha256.Sum256([]byte(state.Verifier))
+	query := url.Values{
+		"response_type":         { "code" },
+		"client_id":             { s.oidc.ClientId },
+		"redirect_uri":          { s.URL + "/login/oidc/callback" },
+		"scope":                 { "openid email" },
+		"state":                 { state.State },
+		"nonce":                 { state.Nonce },
+		"code_challenge":        { base64.RawURLEncoding.EncodeToString(challenge[:]) },
+		"code_challenge_method": { "S256" },
+	}
+
+	sep := "?"
+	if strings.Contains(d.AuthorizationEndpoint, "?") {
+		sep = "&"
+	}
+	http.Redirect(w, r, d.AuthorizationEndpoint + sep + query.Encode(), 302)
+}
+
+// Set the cookie of a login in progress at the provider. The provider
+// redirects back from another site: the cookie can't be strict.
+func (s *Server) setOIDCCookie(w http.ResponseWriter, value string, maxAge int) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     "oidc",
+		Value:    value,
+		Path:     "/login/oidc",
+		MaxAge:   maxAge,
+		Secure:   !s.flags.debug,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// Complete a login at the provider: exchange the authorization code, check the
+// ID token and sign the user in.
+func (s *Server) oidcCallback(w http.ResponseWriter, r *http.Request) {
+	if s.oidc == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	// The login state can't be used again, whether it succeeds or not.
+	s.setOIDCCookie(w, "", -1)
+
+	user, err := s.oidcCallbackUser(r)
+	if err != nil {
+		log.Printf("OIDC: %v", err)
+		http.Redirect(w, r, "/#oidc-error", 302)
+		return
+	}
+
+	// The second factor of users who enabled one is still required.
+	target := "/"
+	if user.TOTPSecret != "" {
+		err = s.setLoginStep(w, user)
+		target = "/login/2fa"
+	} else {
+		err = s.newSession(w, r, user)
+	}
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+
+	// Redirect from a page of this site, so the (strict) cookies are sent:
+	// the current navigation started at the provider.
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	fmt.Fprintf(w, `<!DOCTYPE html><meta http-equiv="refresh" content="0; url=%s"><a href="%s">Bubbles</a>`,
+		    target, target)
+}
+
+// Retrieve the user signing in through the provider.
+func (s *Server) oidcCallbackUser(r *http.Request) (*db.User, error) {
+	cookie, err := r.Cookie("oidc")
+	if err != nil {
+		return nil, fmt.Errorf("No login in progress.")
+	}
+
+	var state oidcState
+	if err := s.cookie.Decode("oidc", cookie.Value, &state); err != nil {
+		return nil, err
+	}
+
+	query := r.URL.Query()
+	switch {
+	case time.Since(time.Unix(state.Issued, 0)) > oidcLoginTimeout:
+		return nil, fmt.Errorf("Login expired.")
+	case query.Get("state") != state.State:
+		return nil, fmt.Errorf("Invalid state.")
+	case query.Get("error") != "":
+		return nil, fmt.Errorf("%s (%s)", query.Get("error"), query.Get("error_description"))
+	}
+
+	raw, err := s.oidc.exchange(query.Get("code"), state.Verifier, s.URL + "/login/oidc/callback")
+	if err != nil {
+		return nil, err
+	}
+
+	claims, err := s.oidc.verify(raw, state.Nonce)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.oidcUser(claims)
+}
+
+// Retrieve the user of an identity of the provider. Unknown identities are
+// linked to the account using the same e-mail, or get a new account, as long as
+// the provider verified the e-mail.
+func (s *Server) oidcUser(claims *oidcClaims) (*db.User, error) {
+	identity, err := s.db.GetIdentity(claims.Issuer, claims.Subject)
+	if err == nil {
+		return s.db.GetUserById(identity.UserId)
+	} else if err != sql.ErrNoRows {
+		return nil, err
+	}
+
+	if claims.Email == "" || !claims.emailVerified() {
+		return nil, fmt.Errorf("No verified e-mail for '%s'.", claims.Subject)
+	}
+
+	user, err := s.db.GetUserByEmail(claims.Email)
+	if err == sql.ErrNoRows {
+		// The account has no password: it can only be used through
+		// the provider, until one is set by a password reset.
+		if err := s.db.AddUser(claims.Email, "", db.GenToken(32), false); err != nil {
+			return nil, err
+		}
+		user, err = s.db.GetUserByEmail(claims.Email)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// The provider proved the e-mail is owned by the user. The password of
+	// an account not activated yet was chosen by whoever signed up, without
+	// proving it: it is removed, and can be set again by a password reset.
+	if !user.Enabled {
+		user.Enabled = true
+		user.Password = ""
+		if err := s.db.UpdateUser(user); err != nil {
+			return nil, err
+		}
+	}
+
+	err = s.db.AddIdentity(&db.Identity{
+		UserId:  user.Id,
+		Issuer:  claims.Issuer,
+		Subject: claims.Subject,
+	})
+	return user, err
+}
@@ -0,0 +1,411 @@
+// Copyright (C) 2019 Antoine Tenart <antoine.tenart@ack.tf>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+
+package httpserver
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/atenart/bubbles/db"
+)
+
+// Code issued by the mock provider, and what it was issued for.
+type mockCode struct {
+	nonce     string
+	challenge string
+	redirect  string
+}
+
+// OpenID Connect provider signing its ID tokens with an RSA key.
+type mockProvider struct {
+	*httptest.Server
+	key    *rsa.PrivateKey // Published in the JWKS.
+	signer *rsa.PrivateKey // Signs the ID tokens.
+	claims map[string]interface{}
+	mutex  sync.Mutex
+	codes  map[string]mockCode
+}
+
+func newMockProvider(t *testing.T) *mockProvider {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := &mockProvider{ key: key, signer: key, codes: make(map[string]mockCode) }
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", m.discovery)
+	mux.HandleFunc("/jwks", m.jwks)
+	mux.HandleFunc("/authorize", m.authorize)
+	mux.HandleFunc("/token", m.token)
+	m.Server = httptest.NewServer(mux)
+	t.Cleanup(m.Close)
+	return m
+}
+
+func (m *mockProvider) discovery(w http.ResponseWriter, r *http.Request) {
+	json.NewEncoder(w).Encode(map[string]string{
+		"issuer":                 m.URL,
+		"authorization_endpoint": m.URL + "/authorize",
+		"token_endpoint":         m.URL + "/token",
+		"jwks_uri":               m.URL + "/jwks",
+	})
+}
+
+func (m *mockProvider) jwks(w http.ResponseWriter, r *http.Request) {
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"keys": []map[string]string{{
+			"kid": "k1",
+			"kty": "RSA",
+			"use": "sig",
+			"n":   base64.RawURLEncoding.EncodeToString(m.key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(m.key.E)).Bytes()),
+		}},
+	})
+}
+
+// Sign the user in right away, and send them back to the client.
+func (m *mockProvider) authorize(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	if q.Get("response_type") != "code" || q.Get("client_id") != "bubbles" ||
+	   q.Get("code_challenge_method") != "S256" || q.Get("code_challenge") == "" {
+		http.Error(w, "invalid request", 400)
+		return
+	}
+
+	code, _ := randomString(16)
+	m.mutex.Lock()
+	m.codes[code] = mockCode{ q.Get("nonce"), q.Get("code_challenge"), q.Get("redirect_uri") }
+	m.mutex.Unlock()
+
+	back := url.Values{ "code": { code }, "state": { q.Get("state") } }
+	http.Redirect(w, r, q.Get("redirect_uri") + "?" + back.Encode(), 302)
+}
+
+func (m *mockProvider) token(w http.ResponseWriter, r *http.Request) {
+	fail := func(reason string) {
+		w.WriteHeader(400)
+		json.NewEncoder(w).Encode(map[string]string{ "error": reason })
+	}
+
+	if id, secret, ok := r.BasicAuth(); !ok || id != "bubbles" || secret != "s3cret" {
+		fail("invalid_client")
+		return
+	}
+
+	m.mutex.Lock()
+	code, ok := m.codes[r.PostFormValue("code")]
+	delete(m.codes, r.PostFormValue("code"))
+	m.mutex.Unlock()
+
+	challenge := sha256.Sum256([]byte(r.PostFormValue("code_verifier")))
+	switch {
+	case r.PostFormValue("grant_type") != "authorization_code" || !ok:
+		fail("invalid_grant")
+		return
+	case r.PostFormValue("redirect_uri") != code.redirect:
+		fail("invalid_grant")
+		return
+	case base64.RawURLEncoding.EncodeToString(challenge[:]) != code.challenge:
+		fail("invalid_grant")
+		return
+	}
+
+	now := time.Now().Unix()
+	claims := map[string]interface{}{
+		"iss":            m.URL,
+		"sub":            "user-1",
+		"aud":            "bubbles",
+		"exp":            now + 300,
+		"iat":            now,
+		"nonce":          code.nonce,
+		"email":          "oidc@example.com",
+		"email_verified": true,
+	}
+	for k, v := range m.claims {
+		claims[k] = v
+	}
+
+	json.NewEncoder(w).Encode(map[string]string{ "id_token": m.sign(claims) })
+}
+
+// Sign an ID token with RS256.
+func (m *mockProvider) sign(claims map[string]interface{}) string {
+	header, _ := json.Marshal(map[string]string{ "alg": "RS256", "kid": "k1" })
+	payload, _ := json.Marshal(claims)
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." +
+		  base64.RawURLEncoding.EncodeToString(payload)
+
+	hash := sha256.Sum256([]byte(signed))
+	sig, _ := rsa.SignPKCS1v15(rand.Reader, m.signer, crypto.SHA256, hash[:])
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+// Server using a new database.
+func dbTestServer(t *testing.T) *Server {
+	t.Helper()
+
+	dir := t.TempDir()
+	styles := `<?xml version="1.0" encoding="UTF-8"?><BEERXML><STYLES></STYLES></BEERXML>`
+	if err := os.WriteFile(path.Join(dir, "styles.xml"), []byte(styles), 0600); err != nil {
+		t.Fatal(err)
+	}
+	d, err := db.Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { d.Close() })
+
+	s := testServer()
+	s.db = d
+	return s
+}
+
+// Server using a new database and the mock provider.
+func oidcTestServer(t *testing.T, m *mockProvider) *Server {
+	t.Helper()
+
+	s := dbTestServer(t)
+	s.URL = "https://bubbles.test"
+	s.oidc = newOIDCProvider(&OIDCConfig{
+		Issuer:       m.URL,
+		ClientId:     "bubbles",
+		ClientSecret: "s3cret",
+	})
+	return s
+}
+
+// Sign in at the provider and complete the login. The callback request can be
+// altered before it is handled.
+func oidcSignIn(t *testing.T, s *Server, alter func(*Server, *http.Request)) *httptest.ResponseRecorder {
+	t.Helper()
+
+	w := httptest.NewRecorder()
+	s.oidcLogin(w, httptest.NewRequest("GET", "/login/oidc", nil))
+	if w.Code != 302 {
+		t.Fatalf("login: status %d", w.Code)
+	}
+
+	client := &http.Client{
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	resp, err := client.Get(w.Header().Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	callback := resp.Header.Get("Location")
+	if !strings.HasPrefix(callback, s.URL + "/login/oidc/callback?") {
+		t.Fatalf("provider redirected to %q", callback)
+	}
+
+	r := httptest.NewRequest("GET", callback, nil)
+	for _, c := range w.Result().Cookies() {
+		r.AddCookie(c)
+	}
+	if alter != nil {
+		alter(s, r)
+	}
+
+	cb := httptest.NewRecorder()
+	s.oidcCallback(cb, r)
+	return cb
+}
+
+// Replace a cookie of a request.
+func replaceCookie(r *http.Request, name, value string) {
+	cookies := r.Cookies()
+	r.Header.Del("Cookie")
+	for _, c := range cookies {
+		if c.Name == name {
+			c.Value = value
+		}
+		r.AddCookie(c)
+	}
+}
+
+// Retrieve a cookie set in a response.
+func responseCookie(w *httptest.ResponseRecorder, name string) *http.Cookie {
+	for _, c := range w.Result().Cookies() {
+		if c.Name == name {
+			return c
+		}
+	}
+	return nil
+}
+
+func TestOIDCLogin(t *testing.T) {
+	m := newMockProvider(t)
+	s := oidcTestServer(t, m)
+
+	w := oidcSignIn(t, s, nil)
+	if w.Code != 200 || !strings.Contains(w.Body.String(), "url=/\"") {
+		t.Fatalf("callback: status %d, body %q", w.Code, w.Body.String())
+	}
+	if c := responseCookie(w, "session"); c == nil || c.Value == "" {
+		t.Error("no session started")
+	}
+	if c := responseCookie(w, "oidc"); c == nil || c.MaxAge >= 0 {
+		t.Error("login state not deleted")
+	}
+
+	user, err := s.db.GetUserByEmail("oidc@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !user.Enabled || user.Password != "" {
+		t.Errorf("new user enabled %v, password %q", user.Enabled, user.Password)
+	}
+	identity, err := s.db.GetIdentity(m.URL, "user-1")
+	if err != nil || identity.UserId != user.Id {
+		t.Errorf("identity = %+v (%v), want user %d", identity, err, user.Id)
+	}
+
+	// Signing in again uses the same account.
+	if w := oidcSignIn(t, s, nil); w.Code != 200 {
+		t.Fatalf("second login: status %d", w.Code)
+	}
+	if again, err := s.db.GetUserByEmail("oidc@example.com"); err != nil || again.Id != user.Id {
+		t.Errorf("second login used user %+v (%v)", again, err)
+	}
+}
+
+func TestOIDCLinkUnactivated(t *testing.T) {
+	m := newMockProvider(t)
+	s := oidcTestServer(t, m)
+
+	// Signed up by someone who didn't prove owning the e-mail.
+	if err := s.db.AddUser("oidc@example.com", "chosen by someone else", db.GenToken(32), true); err != nil {
+		t.Fatal(err)
+	}
+
+	if w := oidcSignIn(t, s, nil); w.Code != 200 {
+		t.Fatalf("callback: status %d", w.Code)
+	}
+	user, err := s.db.GetUserByEmail("oidc@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !user.Enabled || user.Password != "" {
+		t.Errorf("linked user enabled %v, password %q", user.Enabled, user.Password)
+	}
+}
+
+func TestOIDCLoginErrors(t *testing.T) {
+	other, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		claims map[string]interface{}
+		signer *rsa.PrivateKey
+		alter  func(*Server, *http.Request)
+	}{
+		{ name: "nonce", claims: map[string]interface{}{ "nonce": "replayed" } },
+		{ name: "audience", claims: map[string]interface{}{ "aud": "other" } },
+		{ name: "audiences", claims: map[string]interface{}{ "aud": []string{ "bubbles", "other" } } },
+		{ name: "issuer", claims: map[string]interface{}{ "iss": "https://evil.test" } },
+		{ name: "expired", claims: map[string]interface{}{ "exp": time.Now().Add(-time.Hour).Unix() } },
+		{ name: "future", claims: map[string]interface{}{ "iat": time.Now().Add(time.Hour).Unix() } },
+		{ name: "no subject", claims: map[string]interface{}{ "sub": "" } },
+		{ name: "unverified e-mail", claims: map[string]interface{}{ "email_verified": false } },
+		{ name: "signature", signer: other },
+		{
+			name: "pkce",
+			alter: func(s *Server, r *http.Request) {
+				// The verifier doesn't match the challenge.
+				c, _ := r.Cookie("oidc")
+				var state oidcState
+				s.cookie.Decode("oidc", c.Value, &state)
+				state.Verifier = "other"
+				payload, _ := s.cookie.Encode("oidc", state)
+				replaceCookie(r, "oidc", payload)
+			},
+		},
+		{
+			name: "state",
+			alter: func(s *Server, r *http.Request) {
+				q := r.URL.Query()
+				q.Set("state", "forged")
+				r.URL.RawQuery = q.Encode()
+			},
+		},
+		{
+			name: "no cookie",
+			alter: func(s *Server, r *http.Request) {
+				r.Header.Del("Cookie")
+			},
+		},
+		{
+			name: "unsigned cookie",
+			alter: func(s *Server, r *http.Request) {
+				replaceCookie(r, "oidc", "forged")
+			},
+		},
+		{
+			name: "provider error",
+			alter: func(s *Server, r *http.Request) {
+				q := r.URL.Query()
+				q.Set("error", "access_denied")
+				r.URL.RawQuery = q.Encode()
+			},
+		},
+	}
+	for _, tt := range tests {
+		m := newMockProvider(t)
+		m.claims = tt.claims
+		if tt.signer != nil {
+			m.signer = tt.signer
+		}
+		s := oidcTestServer(t, m)
+
+		w := oidcSignIn(t, s, tt.alter)
+		if w.Code != 302 || w.Header().Get("Location") != "/#oidc-error" {
+			t.Errorf("%s: status %d, location %q", tt.name, w.Code, w.Header().Get("Location"))
+		}
+		if c := responseCookie(w, "session"); c != nil {
+			t.Errorf("%s: session started", tt.name)
+		}
+		if c := responseCookie(w, "oidc"); c == nil || c.MaxAge >= 0 {
+			t.Errorf("%s: login state not deleted", tt.name)
+		}
+		if _, err := s.db.GetUserByEmail("oidc@example.com"); err == nil {
+			t.Errorf("%s: user created", tt.name)
+		}
+	}
+}
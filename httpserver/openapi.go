@@ -0,0 +1,506 @@
+// Copyright (C) 2019 Antoine Tenart <antoine.tenart@ack.tf>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+
+package httpserver
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/atenart/bubbles/db"
+)
+
+// Operation of the API: the handler serving it, and the types of its query
+// parameters, request body and response. The OpenAPI document is generated out
+// of the operations, and requests are validated against it before reaching
+// the handlers.
+type apiOperation struct {
+	method   string
+	path     string
+	summary  string
+	handler  func(http.ResponseWriter, *http.Request, *db.User)
+	query    interface{} // Struct describing the query parameters, if any.
+	body     interface{} // Request body, if any.
+	response interface{} // Response body, if any.
+	status   int         // Defaults to 200, or 204 if there is no response.
+
+	querySchema *schema
+	bodySchema  *schema
+}
+
+// JSON schema, as used by OpenAPI 3.0.
+type schema struct {
+	Ref                  string             `json:"$ref,omitempty"`
+	AllOf                []*schema          `json:"allOf,omitempty"`
+	Type                 string             `json:"type,omitempty"`
+	Format               string             `json:"format,omitempty"`
+	Enum                 []string           `json:"enum,omitempty"`
+	Minimum              *float64           `json:"minimum,omitempty"`
+	Maximum              *float64           `json:"maximum,omitempty"`
+	MinLength            int                `json:"minLength,omitempty"`
+	Nullable             bool               `json:"nullable,omitempty"`
+	ReadOnly             bool               `json:"readOnly,omitempty"`
+	Items                *schema            `json:"items,omitempty"`
+	Properties           map[string]*schema `json:"properties,omitempty"`
+	Required             []string           `json:"required,omitempty"`
+	AdditionalProperties interface{}        `json:"additionalProperties,omitempty"`
+}
+
+// Prefix of the references to the schemas of the document components.
+const schemaRef = "#/components/schemas/"
+
+// Install an API operation.
+func (s *Server) apiHandle(op *apiOperation) {
+	if op.query != nil {
+		op.querySchema = s.structSchema(reflect.TypeOf(op.query))
+	}
+	if op.body != nil {
+		op.bodySchema = s.schemaOf(reflect.TypeOf(op.body))
+	}
+	if op.response != nil {
+		s.schemaOf(reflect.TypeOf(op.response))
+	}
+
+	s.apiOps = append(s.apiOps, op)
+	s.api.HandleFunc(op.path, s.apiSessionHandler(s.validateRequest(op))).Methods(op.method)
+}
+
+// Generate the schema of a Go type. Structs are added to the document
+// components, and referenced. Their fields are described using their JSON name
+// and the constraints set in their struct tags:
+// - required:"true": the field must be given, and strings can't be empty.
+// - enum:"a|b|c": strings must be one of the values (or empty, if not required).
+// - min:"x", max:"x": numbers must be within the bounds.
+// - readonly:"true": the field is only part of responses, and is ignored in
+//   requests.
+// Schemas are named after their struct, without the "api" prefix: two structs
+// can't have the same name.
+func (s *Server) schemaOf(t reflect.Type) *schema {
+	switch t.Kind() {
+	case reflect.Ptr:
+		return s.schemaOf(t.Elem())
+	case reflect.Slice:
+		return &schema{ Type: "array", Items: s.schemaOf(t.Elem()) }
+	case reflect.Map:
+		return &schema{ Type: "object", AdditionalProperties: s.schemaOf(t.Elem()) }
+	case reflect.Struct:
+		name := strings.TrimPrefix(t.Name(), "api")
+		if other, ok := s.apiTypes[name]; ok && other != t {
+			panic(fmt.Sprintf("JSON schema %s of type %s is already used by type %s.",
+					  name, t, other))
+		}
+		if _, ok := s.apiSchemas[name]; !ok {
+			// Register the name first, for recursive types.
+			s.apiTypes[name] = t
+			s.apiSchemas[name] = nil
+			s.apiSchemas[name] = s.structSchema(t)
+		}
+		return &schema{ Ref: schemaRef + name }
+	case reflect.String:
+		return &schema{ Type: "string" }
+	case reflect.Bool:
+		return &schema{ Type: "boolean" }
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32:
+		return &schema{ Type: "integer", Format: "int32" }
+	case reflect.Int64:
+		return &schema{ Type: "integer", Format: "int64" }
+	case reflect.Float32:
+		return &schema{ Type: "number", Format: "float" }
+	case reflect.Float64:
+		return &schema{ Type: "number", Format: "double" }
+	}
+	panic(fmt.Sprintf("No JSON schema for type %s.", t))
+}
+
+// Generate the schema of a struct, see schemaOf.
+func (s *Server) structSchema(t reflect.Type) *schema {
+	sc := &schema{
+		Type:                 "object",
+		Properties:           make(map[string]*schema),
+		AdditionalProperties: false,
+	}
+
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath != "" || f.Tag.Get("json") == "-" {
+			continue
+		}
+
+		name := jsonName(f)
+		p := s.schemaOf(f.Type)
+		k := f.Type.Kind()
+		nullable := k == reflect.Ptr || k == reflect.Slice || k == reflect.Map
+		readOnly := f.Tag.Get("readonly") == "true"
+
+		// Siblings of references are ignored, wrap them.
+		if p.Ref != "" && (nullable || readOnly) {
+			p = &schema{ AllOf: []*schema{ p } }
+		}
+		p.Nullable = nullable
+		p.ReadOnly = readOnly
+
+		required := f.Tag.Get("required") == "true"
+		if required {
+			sc.Required = append(sc.Required, name)
+			if p.Type == "string" {
+				p.MinLength = 1
+			}
+		}
+		if enum := f.Tag.Get("enum"); enum != "" {
+			p.Enum = strings.Split(enum, "|")
+			if !required {
+				p.Enum = append(p.Enum, "")
+			}
+		}
+		if v, err := strconv.ParseFloat(f.Tag.Get("min"), 64); err == nil {
+			p.Minimum = &v
+		}
+		if v, err := strconv.ParseFloat(f.Tag.Get("max"), 64); err == nil {
+			p.Maximum = &v
+		}
+
+		sc.Properties[name] = p
+	}
+
+	return sc
+}
+
+// Name of a struct field, in JSON.
+func jsonName(f reflect.StructField) string {
+	if name := strings.Split(f.Tag.Get("json"), ",")[0]; name != "" {
+		return name
+	}
+	return f.Name
+}
+
+// HTTP handler wrapper validating the query parameters and the body of the
+// requests against the schemas of an operation. Invalid requests are rejected
+// with the list of the invalid fields, and never reach the handler.
+func (s *Server) validateRequest(op *apiOperation) func(http.ResponseWriter, *http.Request, *db.User) {
+	return func(w http.ResponseWriter, r *http.Request, user *db.User) {
+		fields := make(map[string]string)
+
+		if op.querySchema != nil {
+			s.validateQuery(r, op.querySchema, fields)
+		}
+
+		if op.bodySchema != nil {
+			body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, s.uploadMax))
+			if err != nil {
+				apiFail(w, &apiError{ status: http.StatusRequestEntityTooLarge,
+						      Message: err.Error() })
+				return
+			}
+
+			// An empty body is an empty object.
+			var v interface{} = map[string]interface{}{}
+			if len(bytes.TrimSpace(body)) != 0 {
+				if err := json.Unmarshal(body, &v); err != nil {
+					apiFail(w, &apiError{ status: http.StatusBadRequest,
+							      Message: fmt.Sprintf("Invalid JSON body: %s.", err) })
+					return
+				}
+			}
+			s.validateValue(op.bodySchema, v, "", fields)
+
+			// Let the handler decode the body.
+			r.Body = ioutil.NopCloser(bytes.NewReader(body))
+		}
+
+		if len(fields) != 0 {
+			apiFail(w, &apiError{
+				status:  http.StatusUnprocessableEntity,
+				Message: "Validation failed.",
+				Fields:  fields,
+			})
+			return
+		}
+
+		// Jump to the real handler.
+		op.handler(w, r, user)
+	}
+}
+
+// Validate the query parameters of a request. Parameters not described are
+// ignored, as well as empty ones.
+func (s *Server) validateQuery(r *http.Request, sc *schema, fields map[string]string) {
+	query := r.URL.Query()
+	for name, p := range sc.Properties {
+		str := query.Get(name)
+		if str == "" {
+			continue
+		}
+
+		var v interface{} = str
+		if p.Type == "integer" || p.Type == "number" {
+			n, err := strconv.ParseFloat(str, 64)
+			if err != nil {
+				fields[name] = "must be a number"
+				continue
+			}
+			v = n
+		}
+		s.validateValue(p, v, name, fields)
+	}
+}
+
+// Resolve a schema referencing one of the document components.
+func (s *Server) resolve(sc *schema) *schema {
+	for sc.Ref != "" {
+		sc = s.apiSchemas[strings.TrimPrefix(sc.Ref, schemaRef)]
+	}
+	return sc
+}
+
+// Validate a value decoded from JSON against a schema, reporting the invalid
+// fields under a given name (e.g. "hops[1].alpha").
+func (s *Server) validateValue(sc *schema, v interface{}, name string, fields map[string]string) {
+	sc = s.resolve(sc)
+	field := name
+	if field == "" {
+		field = "(body)"
+	}
+
+	if v == nil {
+		if !sc.Nullable {
+			fields[field] = "can't be null"
+		}
+		return
+	}
+
+	for _, sub := range sc.AllOf {
+		s.validateValue(sub, v, name, fields)
+	}
+
+	switch sc.Type {
+	case "object":
+		obj, ok := v.(map[string]interface{})
+		if !ok {
+			fields[field] = "must be an object"
+			return
+		}
+
+		for key, value := range obj {
+			sub := key
+			if name != "" {
+				sub = name + "." + key
+			}
+
+			if p, ok := sc.Properties[key]; ok {
+				if !p.ReadOnly {
+					s.validateValue(p, value, sub, fields)
+				}
+			} else if extra, ok := sc.AdditionalProperties.(*schema); ok {
+				s.validateValue(extra, value, sub, fields)
+			} else if sc.Properties != nil {
+				fields[sub] = "unknown field"
+			}
+		}
+
+		for _, key := range sc.Required {
+			if _, ok := obj[key]; !ok {
+				sub := key
+				if name != "" {
+					sub = name + "." + key
+				}
+				fields[sub] = "required"
+			}
+		}
+	case "array":
+		list, ok := v.([]interface{})
+		if !ok {
+			fields[field] = "must be an array"
+			return
+		}
+
+		for i, item := range list {
+			s.validateValue(sc.Items, item, fmt.Sprintf("%s[%d]", name, i), fields)
+		}
+	case "string":
+		str, ok := v.(string)
+		if !ok {
+			fields[field] = "must be a string"
+			return
+		}
+
+		if len(str) < sc.MinLength {
+			fields[field] = "required"
+			return
+		}
+		if sc.Enum != nil {
+			found := false
+			for _, e := range sc.Enum {
+				if str == e {
+					found = true
+					break
+				}
+			}
+			if !found {
+				fields[field] = "must be one of: " + strings.TrimSuffix(strings.Join(sc.Enum, ", "), ", ")
+			}
+		}
+	case "boolean":
+		if _, ok := v.(bool); !ok {
+			fields[field] = "must be a boolean"
+		}
+	case "integer", "number":
+		n, ok := v.(float64)
+		if !ok {
+			fields[field] = "must be a number"
+			return
+		}
+
+		if sc.Type == "integer" && n != float64(int64(n)) {
+			fields[field] = "must be an integer"
+		} else if sc.Minimum != nil && n < *sc.Minimum {
+			fields[field] = fmt.Sprintf("must be at least %g", *sc.Minimum)
+		} else if sc.Maximum != nil && n > *sc.Maximum {
+			fields[field] = fmt.Sprintf("must be at most %g", *sc.Maximum)
+		}
+	}
+}
+
+// Variables of the mux paths, e.g. "{Id:[0-9]+}".
+var pathVar = regexp.MustCompile(`{([A-Za-z]+)(:[^}]*)?}`)
+
+// Literal patterns of the mux path variables, written in place of the variable
+// in the OpenAPI paths.
+var literalVar = regexp.MustCompile(`^:[a-z-]+$`)
+
+// Generate the OpenAPI document describing the API operations.
+func (s *Server) openAPIDocument() map[string]interface{} {
+	paths := make(map[string]map[string]interface{})
+	for _, op := range s.apiOps {
+		var params []interface{}
+		path := pathVar.ReplaceAllStringFunc(op.path, func(v string) string {
+			m := pathVar.FindStringSubmatch(v)
+			if literalVar.MatchString(m[2]) {
+				return m[2][1:]
+			}
+
+			t := "string"
+			if m[2] == ":[0-9]+" {
+				t = "integer"
+			}
+			params = append(params, map[string]interface{}{
+				"name":     m[1],
+				"in":       "path",
+				"required": true,
+				"schema":   &schema{ Type: t },
+			})
+			return "{" + m[1] + "}"
+		})
+
+		if op.querySchema != nil {
+			// Keep the order of the struct fields.
+			t := reflect.TypeOf(op.query)
+			for i := 0; i < t.NumField(); i++ {
+				name := jsonName(t.Field(i))
+				params = append(params, map[string]interface{}{
+					"name":   name,
+					"in":     "query",
+					"schema": op.querySchema.Properties[name],
+				})
+			}
+		}
+
+		operation := map[string]interface{}{
+			"summary":   op.summary,
+			"tags":      []string{ strings.Split(strings.TrimPrefix(path, "/"), "/")[0] },
+			"responses": map[string]interface{}{
+				"default": map[string]interface{}{
+					"description": "Error.",
+					"content":     jsonContent(s.schemaOf(reflect.TypeOf(apiError{}))),
+				},
+			},
+		}
+		if params != nil {
+			operation["parameters"] = params
+		}
+		if op.bodySchema != nil {
+			operation["requestBody"] = map[string]interface{}{
+				"required": true,
+				"content":  jsonContent(op.bodySchema),
+			}
+		}
+
+		status, response := op.status, map[string]interface{}{ "description": "Success." }
+		if op.response != nil {
+			response["content"] = jsonContent(s.schemaOf(reflect.TypeOf(op.response)))
+			if status == 0 {
+				status = http.StatusOK
+			}
+		} else if status == 0 {
+			status = http.StatusNoContent
+		}
+		operation["responses"].(map[string]interface{})[strconv.Itoa(status)] = response
+
+		if paths[path] == nil {
+			paths[path] = make(map[string]interface{})
+		}
+		paths[path][strings.ToLower(op.method)] = operation
+	}
+
+	return map[string]interface{}{
+		"openapi": "3.0.3",
+		"info": map[string]interface{}{
+			"title":   "Bubbles",
+			"version": "1",
+		},
+		"servers": []interface{}{
+			map[string]interface{}{ "url": "/api/v1" },
+		},
+		"paths": paths,
+		"components": map[string]interface{}{
+			"schemas": s.apiSchemas,
+			"securitySchemes": map[string]interface{}{
+				"session": map[string]interface{}{
+					"type": "apiKey",
+					"in":   "cookie",
+					"name": "session",
+				},
+				"token": map[string]interface{}{
+					"type":   "http",
+					"scheme": "bearer",
+				},
+			},
+		},
+		"security": []interface{}{
+			map[string]interface{}{ "session": []string{} },
+			map[string]interface{}{ "token": []string{} },
+		},
+	}
+}
+
+// Content of a JSON request or response, in the OpenAPI document.
+func jsonContent(sc *schema) map[string]interface{} {
+	return map[string]interface{}{
+		"application/json": map[string]interface{}{ "schema": sc },
+	}
+}
+
+// Serve the OpenAPI document describing the API. It is generated once all the
+// operations are installed.
+func (s *Server) openAPI(w http.ResponseWriter, r *http.Request) {
+	apiWrite(w, http.StatusOK, s.apiDocument)
+}
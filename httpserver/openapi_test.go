@@ -0,0 +1,147 @@
+// Copyright (C) 2019 Antoine Tenart <antoine.tenart@ack.tf>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+
+package httpserver
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/atenart/bubbles/beerxml"
+	"github.com/atenart/bubbles/db"
+)
+
+// Fill the fields of a value with distinct values, skipping read-only fields.
+// Lists get a single item.
+func fill(v reflect.Value, n *int) {
+	*n++
+	switch v.Kind() {
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			if v.Type().Field(i).Tag.Get("readonly") != "true" {
+				fill(v.Field(i), n)
+			}
+		}
+	case reflect.Slice:
+		v.Set(reflect.MakeSlice(v.Type(), 1, 1))
+		fill(v.Index(0), n)
+	case reflect.Ptr:
+		v.Set(reflect.New(v.Type().Elem()))
+		fill(v.Elem(), n)
+	case reflect.String:
+		v.SetString(fmt.Sprintf("v%d", *n))
+	case reflect.Bool:
+		v.SetBool(true)
+	case reflect.Int, reflect.Int32, reflect.Int64:
+		v.SetInt(int64(*n))
+	case reflect.Float64:
+		v.SetFloat(float64(*n) + 0.5)
+	}
+}
+
+// Reset the read-only fields of a struct.
+func clearReadOnly(v reflect.Value) {
+	for i := 0; i < v.NumField(); i++ {
+		if v.Type().Field(i).Tag.Get("readonly") == "true" {
+			v.Field(i).Set(reflect.Zero(v.Field(i).Type()))
+		}
+	}
+}
+
+// The API schema is generated from the API types: each of their fields must be
+// stored in, and read back from, the BeerXML types.
+func TestAPIMatchesBeerXML(t *testing.T) {
+	tests := []struct {
+		name      string
+		a         interface{}
+		roundTrip func(interface{}) interface{}
+	}{
+		{ "fermentable", &apiFermentable{}, func(a interface{}) interface{} {
+			var f beerxml.Fermentable
+			jsonToFermentable(a.(*apiFermentable), &f)
+			r := fermentableToJSON(&f)
+			return &r
+		} },
+		{ "hop", &apiHop{}, func(a interface{}) interface{} {
+			var h beerxml.Hop
+			jsonToHop(a.(*apiHop), &h)
+			r := hopToJSON(&h)
+			return &r
+		} },
+		{ "yeast", &apiYeast{}, func(a interface{}) interface{} {
+			var y beerxml.Yeast
+			jsonToYeast(a.(*apiYeast), &y)
+			r := yeastToJSON(&y)
+			return &r
+		} },
+		{ "misc", &apiMisc{}, func(a interface{}) interface{} {
+			var m beerxml.Misc
+			jsonToMisc(a.(*apiMisc), &m)
+			r := miscToJSON(&m)
+			return &r
+		} },
+		{ "mash step", &apiMashStep{}, func(a interface{}) interface{} {
+			var m beerxml.MashStep
+			jsonToMashStep(a.(*apiMashStep), &m)
+			r := mashStepToJSON(&m)
+			return &r
+		} },
+		{ "recipe", &apiRecipe{}, func(a interface{}) interface{} {
+			styles := []beerxml.Style{ { Name: a.(*apiRecipe).Style } }
+			recipe := &db.Recipe{ XML: &beerxml.Recipe{} }
+			if err := jsonToRecipe(a.(*apiRecipe), recipe, &styles); err != nil {
+				return err
+			}
+			return recipeToJSON(recipe)
+		} },
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var n int
+			fill(reflect.ValueOf(tt.a).Elem(), &n)
+
+			got := tt.roundTrip(tt.a)
+			if v := reflect.ValueOf(got).Elem(); v.Kind() == reflect.Struct {
+				clearReadOnly(v)
+			}
+			if !reflect.DeepEqual(got, tt.a) {
+				t.Errorf("got %+v, want %+v", got, tt.a)
+			}
+		})
+	}
+}
+
+type apiCollision struct{ A int }
+type Collision struct{ B int }
+
+func TestSchemaNameCollision(t *testing.T) {
+	s := &Server{
+		apiSchemas: make(map[string]*schema),
+		apiTypes:   make(map[string]reflect.Type),
+	}
+
+	s.schemaOf(reflect.TypeOf(apiCollision{}))
+	s.schemaOf(reflect.TypeOf(&apiCollision{}))
+
+	defer func() {
+		if recover() == nil {
+			t.Error("no panic on a schema name collision")
+		}
+	}()
+	s.schemaOf(reflect.TypeOf(Collision{}))
+}
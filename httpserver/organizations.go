@@ -0,0 +1,315 @@
+// Copyright (C) 2019 Antoine Tenart <antoine.tenart@ack.tf>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package httpserver
+
+import (
+	"fmt"
+	"html/template"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/csrf"
+	"github.com/gorilla/mux"
+	"github.com/atenart/bubbles/db"
+)
+
+// Roles which can be given to the members of an organization.
+var roles = []string{ db.RoleOwner, db.RoleBrewer, db.RoleViewer }
+
+// Organizations page: list the user's memberships, create organizations and
+// switch between workspaces.
+func (s *Server) organizations(w http.ResponseWriter, r *http.Request, user *db.User) {
+	memberships, err := s.db.GetUserMemberships(user.Id)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+
+	s.executeTemplate(w, user, "organizations.html", struct{
+		CSRF        template.HTML
+		Title       string
+		User        *db.User
+		Memberships []*db.Member
+	}{
+		csrf.TemplateField(r),
+		"Bubbles - organizations",
+		user,
+		memberships,
+	})
+}
+
+// Create a new organization, owned by the current user.
+func (s *Server) newOrganization(w http.ResponseWriter, r *http.Request, user *db.User) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Couldn't parse form field.", 500)
+		return
+	}
+
+	o := &db.Organization{ Name: strings.TrimSpace(r.FormValue("name")) }
+	if o.Name == "" {
+		http.Error(w, "An organization needs a name.", 500)
+		return
+	}
+
+	if err := s.db.AddOrganization(o, user.Id); err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+
+	http.Redirect(w, r, fmt.Sprintf("/organization/%d", o.Id), 302)
+}
+
+// Switch the workspace of the current user: its personal space (0) or one of
+// its organizations.
+func (s *Server) switchWorkspace(w http.ResponseWriter, r *http.Request, user *db.User) {
+	id, err := strconv.ParseInt(mux.Vars(r)["Id"], 10, 64)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+
+	if id != 0 {
+		if _, err := s.db.GetMember(id, user.Id); err != nil {
+			http.Error(w, "Not a member of this organization.", 500)
+			return
+		}
+	}
+
+	user.Workspace = id
+	if err := s.db.UpdateUser(user); err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+
+	http.Redirect(w, r, "/recipes", 302)
+}
+
+// Retrieve an organization and the membership of the current user in it.
+func (s *Server) getOrganization(r *http.Request, user *db.User) (*db.Organization, *db.Member, error) {
+	id, err := strconv.ParseInt(mux.Vars(r)["Id"], 10, 64)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	member, err := s.db.GetMember(id, user.Id)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Not a member of this organization.")
+	}
+
+	organization, err := s.db.GetOrganization(id)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return organization, member, nil
+}
+
+// Display an organization and its members.
+func (s *Server) organization(w http.ResponseWriter, r *http.Request, user *db.User) {
+	organization, member, err := s.getOrganization(r, user)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+
+	members, err := s.db.GetOrganizationMembers(organization.Id)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+
+	s.executeTemplate(w, user, "organization.html", struct{
+		CSRF         template.HTML
+		Title        string
+		Organization *db.Organization
+		Member       *db.Member
+		Members      []*db.Member
+		Roles        []string
+	}{
+		csrf.TemplateField(r),
+		fmt.Sprintf("Bubbles - organization/%s", organization.Name),
+		organization,
+		member,
+		members,
+		roles,
+	})
+}
+
+// Count the owners of an organization, given its members.
+func countOwners(members []*db.Member) int {
+	owners := 0
+	for _, m := range members {
+		if m.Role == db.RoleOwner {
+			owners++
+		}
+	}
+	return owners
+}
+
+// Manage an organization and its members. Leaving the organization is open to
+// all members, other actions are restricted to its owners. An organization
+// always keeps at least one owner.
+func (s *Server) saveOrganization(w http.ResponseWriter, r *http.Request, user *db.User) {
+	organization, member, err := s.getOrganization(r, user)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Couldn't parse form field.", 500)
+		return
+	}
+
+	members, err := s.db.GetOrganizationMembers(organization.Id)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+
+	action := mux.Vars(r)["Action"]
+	if action == "leave" {
+		if member.Role == db.RoleOwner && countOwners(members) == 1 {
+			http.Error(w, "The last owner can't leave the organization.", 500)
+			return
+		}
+
+		if err := s.db.DeleteMember(member); err != nil {
+			http.Error(w, err.Error(), 500)
+			return
+		}
+
+		http.Redirect(w, r, "/organizations", 302)
+		return
+	}
+
+	if member.Role != db.RoleOwner {
+		http.Error(w, "Permission denied.", 500)
+		return
+	}
+
+	// Retrieve the member to modify, if any.
+	var target *db.Member
+	if v, err := strconv.ParseInt(mux.Vars(r)["Item"], 10, 64); err == nil {
+		for _, m := range members {
+			if m.Id == v {
+				target = m
+				break
+			}
+		}
+		if target == nil {
+			http.Error(w, "Unknown member.", 500)
+			return
+		}
+	}
+
+	switch action {
+	case "rename":
+		organization.Name = strings.TrimSpace(r.FormValue("name"))
+		if organization.Name == "" {
+			http.Error(w, "An organization needs a name.", 500)
+			return
+		}
+
+		if err := s.db.UpdateOrganization(organization); err != nil {
+			http.Error(w, err.Error(), 500)
+			return
+		}
+	case "delete":
+		if err := s.db.DeleteOrganization(organization); err != nil {
+			http.Error(w, err.Error(), 500)
+			return
+		}
+
+		// Override the final redirect, as the organization do not
+		// exist anymore.
+		http.Redirect(w, r, "/organizations", 302)
+		return
+	case "add-member":
+		u, err := s.db.GetUserByEmail(strings.TrimSpace(r.FormValue("email")))
+		if err != nil {
+			http.Error(w, "Unknown user.", 500)
+			return
+		}
+
+		m := &db.Member{
+			OrganizationId: organization.Id,
+			UserId:         u.Id,
+			Role:           r.FormValue("role"),
+		}
+		if !validRole(m.Role) {
+			http.Error(w, fmt.Sprintf("Unknown role '%s'.", m.Role), 500)
+			return
+		}
+
+		if err := s.db.AddMember(m); err != nil {
+			http.Error(w, err.Error(), 500)
+			return
+		}
+	case "role":
+		if target == nil {
+			http.Error(w, "Unknown member.", 500)
+			return
+		}
+
+		role := r.FormValue("role")
+		if !validRole(role) {
+			http.Error(w, fmt.Sprintf("Unknown role '%s'.", role), 500)
+			return
+		}
+		if target.Role == db.RoleOwner && role != db.RoleOwner && countOwners(members) == 1 {
+			http.Error(w, "An organization needs at least one owner.", 500)
+			return
+		}
+
+		target.Role = role
+		if err := s.db.UpdateMember(target); err != nil {
+			http.Error(w, err.Error(), 500)
+			return
+		}
+	case "remove":
+		if target == nil {
+			http.Error(w, "Unknown member.", 500)
+			return
+		}
+
+		if target.Role == db.RoleOwner && countOwners(members) == 1 {
+			http.Error(w, "An organization needs at least one owner.", 500)
+			return
+		}
+
+		if err := s.db.DeleteMember(target); err != nil {
+			http.Error(w, err.Error(), 500)
+			return
+		}
+	default:
+		http.Error(w, "Unknown action.", 500)
+		return
+	}
+
+	http.Redirect(w, r, fmt.Sprintf("/organization/%d", organization.Id), 302)
+}
+
+// Check a role is known.
+func validRole(role string) bool {
+	for _, r := range roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
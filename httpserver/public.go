@@ -0,0 +1,190 @@
+// Copyright (C) 2019 Antoine Tenart <antoine.tenart@ack.tf>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package httpserver
+
+import (
+	"fmt"
+	"html/template"
+	"net/http"
+	s "sort"
+
+	"github.com/gorilla/csrf"
+	"github.com/gorilla/mux"
+	"github.com/atenart/bubbles/beerxml"
+	"github.com/atenart/bubbles/db"
+)
+
+// Public recipes index, filtered by style. Does not require to be logged in.
+func (s *Server) publicRecipes(w http.ResponseWriter, r *http.Request) {
+	user, err := s.sessionUser(r)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+
+	all, err := s.db.GetPublicRecipeSummaries()
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+
+	// Filter the recipes and list the styles used.
+	style := r.FormValue("style")
+	var recipes []*db.Recipe
+	seen := make(map[string]bool)
+	for _, recipe := range all {
+		if name := recipe.Style; name != "" {
+			seen[name] = true
+		}
+
+		if style == "" || recipe.Style == style {
+			recipes = append(recipes, recipe)
+		}
+	}
+
+	s.executeTemplate(w, user, "public-recipes.html", struct{
+		Title   string
+		User    *db.User
+		Recipes []*db.Recipe
+		Styles  []string
+		Style   string
+	}{
+		"Bubbles - public recipes",
+		user,
+		recipes,
+		sortedKeys(seen),
+		style,
+	})
+}
+
+// List the keys of a set, in alphabetical order.
+func sortedKeys(set map[string]bool) []string {
+	var keys []string
+	for k := range set {
+		keys = append(keys, k)
+	}
+	s.Strings(keys)
+	return keys
+}
+
+// Public, read-only, recipe page. Does not require to be logged in.
+func (s *Server) publicRecipe(w http.ResponseWriter, r *http.Request) {
+	user, err := s.sessionUser(r)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+
+	recipe, err := s.db.GetPublicRecipe(mux.Vars(r)["Slug"])
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	lineage, err := s.lineage(recipe, user)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+
+	s.executeTemplate(w, user, "public-recipe.html", struct{
+		CSRF    template.HTML
+		Title   string
+		User    *db.User
+		Recipe  *db.Recipe
+		Calc    *Calculation
+		CalcIdx []string
+		Lineage *Lineage
+	}{
+		csrf.TemplateField(r),
+		fmt.Sprintf("Bubbles - %s", recipe.Name),
+		user,
+		recipe,
+		calculations(recipe.XML),
+		[]string{"OG", "FG", "ABV", "IBU", "Color", "IBU/OG", "IBU/RE"},
+		lineage,
+	})
+}
+
+// Download a public recipe, as a BeerXML or a BeerJSON file.
+func (s *Server) downloadPublicRecipe(w http.ResponseWriter, r *http.Request) {
+	recipe, err := s.db.GetPublicRecipe(mux.Vars(r)["Slug"])
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	recipes := []beerxml.Recipe{ *recipe.XML }
+	switch mux.Vars(r)["Format"] {
+	case "beerjson":
+		w.Header().Add("Content-Type", "application/json")
+		w.Header().Set("Content-Disposition",
+			       fmt.Sprintf("attachment; filename=%s.json", recipe.Slug))
+
+		err = beerxml.ExportBeerJSON(recipes, w)
+	default:
+		w.Header().Add("Content-Type", "text/xml")
+		w.Header().Set("Content-Disposition",
+			       fmt.Sprintf("attachment; filename=%s.xml", recipe.Slug))
+
+		err = beerxml.Export(&beerxml.BeerXML{ Recipes: recipes }, w)
+	}
+
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+}
+
+// Clone a public recipe into the recipes of the current workspace.
+func (s *Server) clonePublicRecipe(w http.ResponseWriter, r *http.Request, user *db.User) {
+	recipe, err := s.db.GetPublicRecipe(mux.Vars(r)["Slug"])
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	owner := s.workspace(user)
+	if err := s.checkAccess(user, owner, accessWrite); err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+
+	clone := &db.Recipe{
+		Name:           fmt.Sprintf("%s (cloned)", recipe.Name),
+		UserId:         owner.UserId,
+		OrganizationId: owner.OrganizationId,
+		ParentId:       recipe.Id,
+		ParentAuthor:   recipe.XML.Brewer,
+		ParentOwner:    recipe.Owner(),
+		XML:            recipe.XML,
+	}
+	clone.XML.Name = clone.Name
+	clone.XML.Version += 1
+
+	if clone.Id, err = s.db.AddRecipe(clone); err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+
+	// Start the clone history.
+	if err := s.addRevision(clone, "clone"); err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+
+	http.Redirect(w, r, fmt.Sprintf("/recipe/%d", clone.Id), 302)
+}
@@ -21,6 +21,8 @@ import (
 	"math"
 	"net/http"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/gorilla/csrf"
 	"github.com/gorilla/mux"
@@ -28,37 +30,128 @@ import (
 	"github.com/atenart/bubbles/db"
 )
 
-// Recipes listing.
+// Number of recipes per page of the recipes listing.
+const recipesPerPage = 25
+
+// Recipes listing, filtered and paginated.
 func (s *Server) recipes(w http.ResponseWriter, r *http.Request, user *db.User) {
-	// Retrieve all the recipes authored by the current user.
-	recipes, err := s.db.GetUserRecipes(user.Id)
+	owner := s.workspace(user)
+	filter := formToRecipeFilter(r)
+
+	// Retrieve the matching recipes of the current workspace.
+	recipes, total, err := s.db.SearchRecipes(owner, filter)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+
+	folders, err := s.db.GetFolders(owner)
 	if err != nil {
 		http.Error(w, err.Error(), 500)
 		return
 	}
 
+	tags, err := s.db.GetTags(owner)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+
+	// Links to the previous and next pages, keeping the filters.
+	var prev, next string
+	pageURL := func(page int) string {
+		query := r.URL.Query()
+		query.Set("page", strconv.Itoa(page + 1))
+		return "/recipes?" + query.Encode()
+	}
+	if filter.Page > 0 {
+		prev = pageURL(filter.Page - 1)
+	}
+	if (filter.Page + 1) * filter.PerPage < total {
+		next = pageURL(filter.Page + 1)
+	}
+
 	s.executeTemplate(w, user, "recipes.html", struct{
-		Title   string
-		Recipes []*db.Recipe
+		Title    string
+		Recipes  []*db.Recipe
+		Total    int
+		Filter   *db.RecipeFilter
+		Filtered bool
+		Folders  []string
+		Tags     []string
+		Styles   *[]beerxml.Style
+		Page     int
+		Pages    int
+		Prev     string
+		Next     string
 	}{
 		"Bubbles - recipes",
 		recipes,
+		total,
+		filter,
+		r.URL.RawQuery != "",
+		folders,
+		tags,
+		s.db.Styles,
+		filter.Page + 1,
+		(total + filter.PerPage - 1) / filter.PerPage,
+		prev,
+		next,
 	})
 }
 
-// Return a *db.Recipe object, from the database if it exists or new if it
-// doesn't.
-func (s *Server) getRecipe(id, uid int64) (*db.Recipe, error) {
+// Convert the recipes filters and page requested into a db.RecipeFilter.
+func formToRecipeFilter(r *http.Request) *db.RecipeFilter {
+	f := &db.RecipeFilter{
+		Query:   r.FormValue("q"),
+		Folder:  r.FormValue("folder"),
+		Tag:     r.FormValue("tag"),
+		Style:   r.FormValue("style"),
+		Type:    r.FormValue("type"),
+		Brewed:  r.FormValue("brewed"),
+		PerPage: recipesPerPage,
+	}
+	f.AbvMin, _ = strconv.ParseFloat(r.FormValue("abv-min"), 64)
+	f.AbvMax, _ = strconv.ParseFloat(r.FormValue("abv-max"), 64)
+	f.IbuMin, _ = strconv.ParseFloat(r.FormValue("ibu-min"), 64)
+	f.IbuMax, _ = strconv.ParseFloat(r.FormValue("ibu-max"), 64)
+
+	// Pages start at 1 in URLs.
+	if page, err := strconv.Atoi(r.FormValue("page")); err == nil && page > 1 {
+		f.Page = page - 1
+	}
+
+	return f
+}
+
+// Convert a comma separated list of tags. Tags are trimmed and lower cased, and
+// empty ones are dropped.
+func parseTags(list string) []string {
+	var tags []string
+	seen := make(map[string]bool)
+	for _, tag := range strings.Split(list, ",") {
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if tag == "" || seen[tag] {
+			continue
+		}
+
+		seen[tag] = true
+		tags = append(tags, tag)
+	}
+	return tags
+}
+
+// Return a *db.Recipe object from the database, if the user has the requested
+// access to it.
+func (s *Server) getRecipe(id int64, user *db.User, access int) (*db.Recipe, error) {
 	// Retrive an existing recipe.
 	recipe, err := s.db.GetRecipe(id)
 	if err != nil {
 		return nil, err
 	}
 
-	// Check the recipe belongs to the current user.
-	if recipe.UserId != uid {
-		// TODO: give more feedback.
-		return nil, fmt.Errorf("Permission denied to edit this recipe.")
+	if err := s.checkAccess(user, recipe.Owner(), access); err != nil {
+		return nil, err
 	}
 
 	return recipe, nil
@@ -71,30 +164,41 @@ func (s *Server) recipe(w http.ResponseWriter, r *http.Request, user *db.User) {
 	id, _ := strconv.ParseInt(mux.Vars(r)["Id"], 10, 64)
 
 	// Retrieve the recipe current info.
-	recipe, err := s.getRecipe(id, user.Id)
+	recipe, err := s.getRecipe(id, user, accessRead)
 	if err != nil {
 		http.Error(w, err.Error(), 500)
 		return
 	}
 
-	// Retrieve the user's ingredients from its inventory.
-	ingredients, err := s.db.GetUserIngredients(user.Id)
+	// Retrieve the ingredients from the inventory of the recipe owner.
+	ingredients, err := s.db.GetIngredients(recipe.Owner())
 	if err != nil {
 		http.Error(w, err.Error(), 500)
 		return
 	}
 
+	// The actual specs of the lots in use override the ingredients ones.
+	lots, err := s.db.GetLots(recipe.Owner())
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	current := currentLots(lots)
+
 	// Sort ingredients.
+	now := time.Now()
 	var fermentables []*beerxml.Fermentable
-	var hops []*beerxml.Hop
+	var hops []InventoryHop
 	var yeasts []*beerxml.Yeast
 	var miscs []*beerxml.Misc
 	for _, i := range ingredients {
+		applyLot(i.XML, current[i.Id])
+
 		switch xml := i.XML.(type) {
 		case *beerxml.Fermentable:
 			fermentables = append(fermentables, xml)
 		case *beerxml.Hop:
-			hops = append(hops, xml)
+			hops = append(hops, InventoryHop{ xml, storedAlpha(xml, current[i.Id], now) })
 		case *beerxml.Yeast:
 			yeasts = append(yeasts, xml)
 		case *beerxml.Misc:
@@ -102,6 +206,24 @@ func (s *Server) recipe(w http.ResponseWriter, r *http.Request, user *db.User) {
 		}
 	}
 
+	cost, err := s.recipeCost(user, recipe.Owner(), recipe.XML)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+
+	catalog, err := s.db.Catalog()
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+
+	folders, err := s.db.GetFolders(recipe.Owner())
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+
 	s.executeTemplate(w, user, "recipe.html", struct{
 		CSRF         template.HTML
 		Title        string
@@ -109,10 +231,13 @@ func (s *Server) recipe(w http.ResponseWriter, r *http.Request, user *db.User) {
 		Styles       *[]beerxml.Style
 		Calc         *Calculation
 		CalcIdx      []string
+		Cost         *Cost
 		Fermentables []*beerxml.Fermentable
-		Hops         []*beerxml.Hop
+		Hops         []InventoryHop
 		Yeasts       []*beerxml.Yeast
 		Miscs        []*beerxml.Misc
+		Catalog      *beerxml.BeerXML
+		Folders      []string
 	}{
 		csrf.TemplateField(r),
 		fmt.Sprintf("Bubbles - recipe/%s", recipe.Name),
@@ -120,13 +245,22 @@ func (s *Server) recipe(w http.ResponseWriter, r *http.Request, user *db.User) {
 		s.db.Styles,
 		calculations(recipe.XML),
 		[]string{"OG", "FG", "ABV", "IBU", "Color", "IBU/OG", "IBU/RE"},
+		cost,
 		fermentables,
 		hops,
 		yeasts,
 		miscs,
+		catalog,
+		folders,
 	})
 }
 
+// An inventory hop, and its alpha acids estimated after storage.
+type InventoryHop struct {
+	*beerxml.Hop
+	StoredAlpha float64
+}
+
 type Cursor struct {
 	Val      float64
 	Min, Max float64
@@ -231,11 +365,19 @@ func calculations(r *beerxml.Recipe) *Calculation {
 
 // Add a new recipe,
 func (s *Server) newRecipe(w http.ResponseWriter, r *http.Request, user *db.User) {
+	// Recipes are created in the current workspace.
+	owner := s.workspace(user)
+	if err := s.checkAccess(user, owner, accessWrite); err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+
 	// Recipe does not exist yet.
 	recipe := &db.Recipe{
-		Name:   "New recipe",
-		UserId: user.Id,
-		XML:    &beerxml.Recipe{
+		Name:           "New recipe",
+		UserId:         owner.UserId,
+		OrganizationId: owner.OrganizationId,
+		XML:            &beerxml.Recipe{
 			Version:    1,
 			Efficiency: 70,
 		},
@@ -248,6 +390,13 @@ func (s *Server) newRecipe(w http.ResponseWriter, r *http.Request, user *db.User
 		return
 	}
 
+	// Start the recipe history.
+	recipe.Id = id
+	if err := s.addRevision(recipe, "new"); err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+
 	http.Redirect(w, r, fmt.Sprintf("/recipe/%d", id), 302)
 }
 
@@ -260,12 +409,29 @@ func (s *Server) cloneRecipe(w http.ResponseWriter, r *http.Request, user *db.Us
 	}
 
 	// Retrieve the recipe current info.
-	recipe, err := s.getRecipe(id, user.Id)
+	recipe, err := s.getRecipe(id, user, accessRead)
 	if err != nil {
 		http.Error(w, err.Error(), 500)
 		return
 	}
 
+	// Clones are created in the current workspace.
+	owner := s.workspace(user)
+	if err := s.checkAccess(user, owner, accessWrite); err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+
+	// Clones start private, and keep track of their parent.
+	recipe.Public = false
+	recipe.Slug = ""
+	recipe.ParentId = recipe.Id
+	recipe.ParentAuthor = recipe.XML.Brewer
+	recipe.ParentOwner = recipe.Owner()
+
+	recipe.UserId = owner.UserId
+	recipe.OrganizationId = owner.OrganizationId
+
 	// Update name & version.
 	recipe.Name = fmt.Sprintf("%s (cloned)", recipe.Name)
 	recipe.XML.Name = recipe.Name
@@ -278,6 +444,13 @@ func (s *Server) cloneRecipe(w http.ResponseWriter, r *http.Request, user *db.Us
 		return
 	}
 
+	// Start the clone history.
+	recipe.Id = clone
+	if err := s.addRevision(recipe, "clone"); err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+
 	http.Redirect(w, r, fmt.Sprintf("/recipe/%d", clone), 302)
 }
 
@@ -290,7 +463,7 @@ func (s *Server) saveRecipe(w http.ResponseWriter, r *http.Request, user *db.Use
 	}
 
 	// Retrieve the recipe current info.
-	recipe, err := s.getRecipe(id, user.Id)
+	recipe, err := s.getRecipe(id, user, accessWrite)
 	if err != nil {
 		http.Error(w, err.Error(), 500)
 		return
@@ -310,6 +483,14 @@ func (s *Server) saveRecipe(w http.ResponseWriter, r *http.Request, user *db.Use
 		item = -1
 	}
 
+	// Deleting or branching doesn't modify the recipe.
+	if action != "delete" && action != "branch" {
+		if err := s.initialRevision(recipe); err != nil {
+			http.Error(w, err.Error(), 500)
+			return
+		}
+	}
+
 	switch action {
 	// Update a recipe.
 	case "save":
@@ -325,10 +506,55 @@ func (s *Server) saveRecipe(w http.ResponseWriter, r *http.Request, user *db.Use
 			http.Error(w, err.Error(), 500)
 			return
 		}
+		s.notify(recipe.Owner(), db.EventRecipeDeleted, recipeSummaryToJSON(recipe))
+
 		// Override the final redirect, as the recipe do not exist
 		// anymore.
 		http.Redirect(w, r, "/", 302)
 		return
+	// Restore a previous revision of the recipe.
+	case "restore":
+		revision, err := s.getRevision(int64(item), recipe)
+		if err != nil {
+			http.Error(w, err.Error(), 500)
+			return
+		}
+
+		recipe.XML = revision.XML
+		recipe.Name = recipe.XML.Name
+	// Create a new recipe out of a previous revision.
+	case "branch":
+		revision, err := s.getRevision(int64(item), recipe)
+		if err != nil {
+			http.Error(w, err.Error(), 500)
+			return
+		}
+
+		branch := &db.Recipe{
+			Name:           fmt.Sprintf("%s (branched)", revision.XML.Name),
+			UserId:         recipe.UserId,
+			OrganizationId: recipe.OrganizationId,
+			ParentId:       recipe.Id,
+			ParentAuthor:   recipe.XML.Brewer,
+			ParentOwner:    recipe.Owner(),
+			XML:            revision.XML,
+		}
+		branch.XML.Name = branch.Name
+		branch.XML.Version += 1
+
+		if branch.Id, err = s.db.AddRecipe(branch); err != nil {
+			http.Error(w, err.Error(), 500)
+			return
+		}
+
+		if err := s.addRevision(branch, "branch"); err != nil {
+			http.Error(w, err.Error(), 500)
+			return
+		}
+
+		// Override the final redirect, to show the new recipe.
+		http.Redirect(w, r, fmt.Sprintf("/recipe/%d", branch.Id), 302)
+		return
 	case "add-fermentable":
 		var fermentable beerxml.Fermentable
 		if err := formToFermentable(r, &fermentable); err != nil {
@@ -464,6 +690,12 @@ func (s *Server) saveRecipe(w http.ResponseWriter, r *http.Request, user *db.Use
 			http.Error(w, err.Error(), 500)
 			return
 		}
+	case "substitute-hop":
+		with, _ := strconv.ParseInt(r.FormValue("with"), 10, 64)
+		if err := s.substituteHop(recipe.Owner(), recipe.XML, item, with); err != nil {
+			http.Error(w, err.Error(), 500)
+			return
+		}
 	case "del-fermentable":
 		if err := beerxml.RemoveFromRecipe(recipe.XML, &beerxml.Fermentable{}, item); err != nil {
 			http.Error(w, err.Error(), 500)
@@ -491,29 +723,56 @@ func (s *Server) saveRecipe(w http.ResponseWriter, r *http.Request, user *db.Use
 		}
 	default:
 		http.Error(w, fmt.Sprintf("Unknown action '%s'", action), 500)
+		return
 	}
 
+	if err := s.updateRecipe(recipe, action); err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+
+	http.Redirect(w, r, fmt.Sprintf("/recipe/%d", id), 302)
+}
+
+// Recipes created before revisions were introduced have no history: keep a
+// snapshot of their current state before modifying them.
+func (s *Server) initialRevision(recipe *db.Recipe) error {
+	count, err := s.db.CountRecipeRevisions(recipe.Id)
+	if err != nil || count > 0 {
+		return err
+	}
+	return s.addRevision(recipe, "initial")
+}
+
+// Sort the elements of a recipe and compute its estimations.
+func refreshRecipe(xml *beerxml.Recipe) {
 	// Sort elements.
-	sort(recipe.XML.Fermentables)
-	sort(recipe.XML.Hops)
-	sort(recipe.XML.Yeasts)
-	sort(recipe.XML.Miscs)
-	sort(recipe.XML.Mash.MashSteps)
+	sort(xml.Fermentables)
+	sort(xml.Hops)
+	sort(xml.Yeasts)
+	sort(xml.Miscs)
+	sort(xml.Mash.MashSteps)
 
 	// Compute estimations.
-	recipe.XML.EstOG = math.Round(recipe.XML.CalcOG() * 1000) / 1000
-	recipe.XML.EstFG = math.Round(recipe.XML.CalcFG() * 1000) / 1000
-	recipe.XML.EstABV = math.Round(recipe.XML.CalcABV() * 10) / 10
-	recipe.XML.IBU = math.Round(recipe.XML.CalcIBU() * 10) / 10
-	recipe.XML.EstColor = math.Round(recipe.XML.CalcColor() *  100) / 100
+	xml.EstOG = math.Round(xml.CalcOG() * 1000) / 1000
+	xml.EstFG = math.Round(xml.CalcFG() * 1000) / 1000
+	xml.EstABV = math.Round(xml.CalcABV() * 10) / 10
+	xml.IBU = math.Round(xml.CalcIBU() * 10) / 10
+	xml.EstColor = math.Round(xml.CalcColor() *  100) / 100
+}
+
+// Save a modified recipe, keeping track of the action performed in the recipe
+// history.
+func (s *Server) updateRecipe(recipe *db.Recipe, action string) error {
+	refreshRecipe(recipe.XML)
 
 	// Update recipe.
-	if err = s.db.UpdateRecipe(recipe); err != nil {
-		http.Error(w, err.Error(), 500)
-		return
+	if err := s.db.UpdateRecipe(recipe); err != nil {
+		return err
 	}
 
-	http.Redirect(w, r, fmt.Sprintf("/recipe/%d", id), 302)
+	// Keep track of the change in the recipe history.
+	return s.addRevision(recipe, action)
 }
 
 // Convert elements POSTed from a form into a *db.Recipe.
@@ -521,10 +780,14 @@ func formToRecipe(r *http.Request, recipe *db.Recipe) error {
 	// First convert elements going directly into the db.Recipe.
 	recipe.Name = r.FormValue("name")
 	recipe.XML.Name = recipe.Name
+	recipe.Public = r.FormValue("public") == "true"
+	recipe.Folder = strings.TrimSpace(r.FormValue("folder"))
+	recipe.Tags = parseTags(r.FormValue("tags"))
 
 	// Then take care of the BeerXML part.
 
 	recipe.XML.Type = r.FormValue("type")
+	recipe.XML.Brewer = r.FormValue("brewer")
 	recipe.XML.Notes = r.FormValue("notes")
 
 	if version, err := strconv.ParseInt(r.FormValue("version"), 10, 32); err == nil {
@@ -587,6 +850,12 @@ func formToHop(r *http.Request, hop *beerxml.Hop) error {
 	hop.Alpha, _ = strconv.ParseFloat(r.FormValue("alpha"), 64)
 	hop.Amount, _ = strconv.ParseFloat(r.FormValue("amount"), 64)
 	hop.Time, _ = strconv.ParseFloat(r.FormValue("time"), 64)
+	if _, ok := r.Form["hsi"]; ok {
+		hop.Hsi, _ = strconv.ParseFloat(r.FormValue("hsi"), 64)
+	}
+	if _, ok := r.Form["substitutes"]; ok {
+		hop.Substitutes = r.FormValue("substitutes")
+	}
 
 	// Sanity checks
 	if hop.Name == "" {
@@ -0,0 +1,187 @@
+// Copyright (C) 2019 Antoine Tenart <antoine.tenart@ack.tf>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+
+package httpserver
+
+import (
+	"database/sql"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/csrf"
+	"github.com/gorilla/mux"
+	"github.com/atenart/bubbles/db"
+)
+
+// Validity of the password reset links.
+const resetValidity = time.Hour
+
+// Send a password reset link by mail. The answer is the same whether the
+// account exists or not, not to disclose registered e-mails.
+func (s *Server) forgotPassword(w http.ResponseWriter, r *http.Request) {
+	// Every request counts against the address, as each sends an e-mail.
+	if s.limits.ips.wait(ipKey(r)) > 0 {
+		http.Redirect(w, r, "/#throttled", 302)
+		return
+	}
+	s.limits.ips.fail(ipKey(r))
+
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Couldn't parse form field.", 500)
+		return
+	}
+
+	now := time.Now().UTC()
+	if err := s.db.PruneResets(now.Format(db.TimeFormat)); err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+
+	user, err := s.db.GetUserByEmail(r.FormValue("email"))
+	if err == sql.ErrNoRows {
+		http.Redirect(w, r, "/#reset-sent", 302)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+
+	secret, err := s.db.AddReset(&db.Reset{
+		UserId:  user.Id,
+		Expires: now.Add(resetValidity).Format(db.TimeFormat),
+	})
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+
+	// Failures are only logged, for the same reason.
+	err = s.sendmail.Send(user.Email, "Bubbles password reset",
+			      fmt.Sprintf(`Hello,
+
+A password reset was requested for your Bubbles account. To choose a new
+password, please follow this link within the hour:
+
+%s/reset/%s
+
+If you did not request it, you can ignore this e-mail.
+
+— Cheers`, s.URL, secret))
+	if err != nil {
+		log.Print(err)
+	}
+
+	http.Redirect(w, r, "/#reset-sent", 302)
+}
+
+// Retrieve the password reset request of the current url, or nil if it does
+// not exist or expired.
+func (s *Server) getReset(r *http.Request) (*db.Reset, error) {
+	now := time.Now().UTC().Format(db.TimeFormat)
+	reset, err := s.db.GetResetBySecret(mux.Vars(r)["Token"], now)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return reset, err
+}
+
+// Display the password reset form.
+func (s *Server) resetPage(w http.ResponseWriter, r *http.Request) {
+	reset, err := s.getReset(r)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	} else if reset == nil {
+		http.Redirect(w, r, "/#reset-invalid", 302)
+		return
+	}
+
+	// Do not leak the token to other sites.
+	w.Header().Set("Referrer-Policy", "no-referrer")
+
+	s.executeTemplate(w, nil, "reset.html", struct{
+		CSRF  template.HTML
+		Token string
+	}{
+		csrf.TemplateField(r),
+		mux.Vars(r)["Token"],
+	})
+}
+
+// Set a new password using a password reset request. The request can't be used
+// again, and all the sessions of the user are invalidated.
+func (s *Server) resetPassword(w http.ResponseWriter, r *http.Request) {
+	reset, err := s.getReset(r)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	} else if reset == nil {
+		http.Redirect(w, r, "/#reset-invalid", 302)
+		return
+	}
+
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Couldn't parse form field.", 500)
+		return
+	}
+
+	password := r.FormValue("new-password")
+	if password == "" {
+		http.Error(w, "A password is required", 500)
+		return
+	} else if password != r.FormValue("confirm-password") {
+		http.Error(w, "New passwords do not match", 500)
+		return
+	}
+
+	user, err := s.db.GetUserById(reset.UserId)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+
+	user.Password, err = s.db.HashPassword(user.Email, password)
+	if err != nil {
+		hashError(w, err)
+		return
+	}
+	// The e-mail was proven to be owned by the user.
+	user.Enabled = true
+
+	if err := s.db.UpdateUser(user); err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+
+	if err := s.db.DeleteUserResets(user.Id); err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+
+	if err := s.db.DeleteUserSessions(user.Id, 0); err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+
+	// The new password can be used right away, even if the account was
+	// locked.
+	s.limits.accounts.reset(user.Email)
+
+	http.Redirect(w, r, "/#reset-done", 302)
+}
@@ -19,6 +19,9 @@ import (
 	"fmt"
 	"html/template"
 	"net/http"
+	"reflect"
+	"sync"
+	"time"
 
 	"github.com/gorilla/csrf"
 	"github.com/gorilla/mux"
@@ -30,15 +33,37 @@ import (
 
 // Represents a server instance (there is usually a single one).
 type Server struct {
-	URL          string
-	db           *db.DB
-	sendmail     *sendmail.Sendmail
-	i18n         *i18n.Bundle
-	mux          *mux.Router
-	templates    *template.Template
-	cookie       *securecookie.SecureCookie
-	uploadMax    int64
-	flags        struct {
+	URL           string
+	db            *db.DB
+	sendmail      *sendmail.Sendmail
+	i18n          *i18n.Bundle
+	mux           *mux.Router
+	api           *mux.Router
+	apiOps        []*apiOperation
+	apiSchemas    map[string]*schema
+	apiTypes      map[string]reflect.Type // Go types of the schemas.
+	apiDocument   map[string]interface{}
+	webhookClient *http.Client
+	webhookWake   chan struct{}
+	templates     *template.Template
+	cookie        *securecookie.SecureCookie
+	oidc          *oidcProvider
+	challenge     Challenge
+	loginSteps    struct {
+		mutex sync.Mutex
+		used  map[string]time.Time // Nonces of completed login steps, and their expiry.
+	}
+	enrolments    struct {
+		mutex   sync.Mutex
+		pending map[int64]totpEnrolment // Secrets being enrolled, by user.
+	}
+	limits        struct {
+		ips      *limiter // Failed logins, by address.
+		accounts *limiter // Failed logins, by account.
+		signUps  *limiter // Sign-ups, by address.
+	}
+	uploadMax     int64
+	flags         struct {
 		// Runtime options
 		signUp       bool
 		verification bool
@@ -49,7 +74,7 @@ type Server struct {
 
 // Starts a new server instance.
 func Serve(bind, url string, db *db.DB, sendmail *sendmail.Sendmail, i18n *i18n.Bundle,
-	   noSignUp, noVerification, debug  bool) error {
+	   oidc *OIDCConfig, challenge Challenge, noSignUp, noVerification, debug  bool) error {
 	s := &Server{
 		URL:          url,
 		db:           db,
@@ -58,12 +83,29 @@ func Serve(bind, url string, db *db.DB, sendmail *sendmail.Sendmail, i18n *i18n.
 		mux:          mux.NewRouter(),
 		templates:    template.New("templates"),
 		uploadMax:    10 << 20, // 10 MB
+		apiSchemas:   make(map[string]*schema),
+		apiTypes:     make(map[string]reflect.Type),
+		webhookWake:  make(chan struct{}, 1),
+		oidc:         newOIDCProvider(oidc),
+		challenge:    challenge,
 	}
 
 	s.flags.signUp = !noSignUp
 	s.flags.verification = !noVerification
 	s.flags.debug = debug
 
+	s.loginSteps.used = make(map[string]time.Time)
+	s.enrolments.pending = make(map[int64]totpEnrolment)
+
+	// Slow down brute-force attacks and automated sign-ups. Accounts are
+	// locked for 30 minutes after 10 failed logins.
+	s.limits.ips = newLimiter(10, time.Second, 15 * time.Minute, 0)
+	s.limits.accounts = newLimiter(3, time.Second, 30 * time.Minute, 10)
+	s.limits.signUps = newLimiter(3, time.Minute, 24 * time.Hour, 0)
+
+	// Webhooks can be delivered locally when debugging.
+	s.webhookClient = newWebhookClient(debug)
+
 	// i18n: add dummy L function, will be overriden before serving the
 	// templates.
 	s.templates.Funcs(template.FuncMap{
@@ -73,6 +115,9 @@ func Serve(bind, url string, db *db.DB, sendmail *sendmail.Sendmail, i18n *i18n.
 		"L": func(id string) string {
 			return id
 		},
+		"workspace": func() string {
+			return ""
+		},
 	})
 
 	// Parse the templates.
@@ -86,6 +131,9 @@ func Serve(bind, url string, db *db.DB, sendmail *sendmail.Sendmail, i18n *i18n.
 		}
 	}
 
+	// The JSON API is versioned, under its own prefix.
+	s.api = s.mux.PathPrefix("/api/v1").Subrouter()
+
 	// Install unauthenticated mux handlers.
 	s.mux.PathPrefix("/static/").Handler(
 		http.StripPrefix("/static/", http.FileServer(http.Dir("httpserver/ui/static"))))
@@ -93,7 +141,17 @@ func Serve(bind, url string, db *db.DB, sendmail *sendmail.Sendmail, i18n *i18n.
 	s.mux.HandleFunc("/sign-up", s.signUp).Methods("POST")
 	s.mux.HandleFunc("/activate/{Token:[a-zA-Z0-9]+}", s.activate)
 	s.mux.HandleFunc("/login", s.login).Methods("POST")
+	s.mux.HandleFunc("/login/2fa", s.loginStepPage).Methods("GET")
+	s.mux.HandleFunc("/login/2fa", s.loginStep).Methods("POST")
+	s.mux.HandleFunc("/login/oidc", s.oidcLogin).Methods("GET")
+	s.mux.HandleFunc("/login/oidc/callback", s.oidcCallback).Methods("GET")
 	s.mux.HandleFunc("/logout", s.logout)
+	s.mux.HandleFunc("/forgot-password", s.forgotPassword).Methods("POST")
+	s.mux.HandleFunc("/reset/{Token:[0-9a-f]+}", s.resetPage).Methods("GET")
+	s.mux.HandleFunc("/reset/{Token:[0-9a-f]+}", s.resetPassword).Methods("POST")
+	s.mux.HandleFunc("/r", s.publicRecipes)
+	s.mux.HandleFunc("/r/{Slug:[a-z0-9-]+}", s.publicRecipe)
+	s.mux.HandleFunc("/r/{Slug:[a-z0-9-]+}/download/{Format:beerxml|beerjson}", s.downloadPublicRecipe)
 
 	// Install authenticated mux handlers.
 	s.handleFunc("/", s.index)
@@ -101,6 +159,10 @@ func Serve(bind, url string, db *db.DB, sendmail *sendmail.Sendmail, i18n *i18n.
 	s.handleFunc("/recipe/new", s.newRecipe)
 	s.handleFunc("/recipe/clone/{Id:[0-9]+}", s.cloneRecipe)
 	s.handleFunc("/recipe/{Id:[0-9]+}", s.recipe)
+	s.handleFunc("/r/{Slug:[a-z0-9-]+}/clone", s.clonePublicRecipe).Methods("POST")
+	s.handleFunc("/recipe/{Id:[0-9]+}/history", s.recipeHistory)
+	s.handleFunc("/recipe/{Id:[0-9]+}/lineage", s.recipeLineage)
+	s.handleFunc("/recipe/{Id:[0-9]+}/substitutes/{Item:[0-9]+}", s.recipeHopSubstitutes)
 	s.handleFunc("/recipe/{Id:[0-9]+}/{Action:[a-z-]+}", s.saveRecipe).Methods("POST")
 	s.handleFunc("/recipe/{Id:[0-9]+}/{Action:[a-z-]+}/{Item:[0-9]+}", s.saveRecipe).Methods("POST")
 	s.handleFunc("/account", s.account)
@@ -108,9 +170,34 @@ func Serve(bind, url string, db *db.DB, sendmail *sendmail.Sendmail, i18n *i18n.
 	s.handleFunc("/account/delete", s.deleteAccount).Methods("POST")
 	s.handleFunc("/account/export", s.exportData)
 	s.handleFunc("/account/import", s.importData).Methods("POST")
+	s.handleFunc("/account/tokens/new", s.newToken).Methods("POST")
+	s.handleFunc("/account/tokens/{Id:[0-9]+}/delete", s.deleteToken).Methods("POST")
+	s.handleFunc("/account/2fa", s.twoFactor)
+	s.handleFunc("/account/2fa/enable", s.enableTwoFactor).Methods("POST")
+	s.handleFunc("/account/2fa/recovery", s.newRecoveryCodes).Methods("POST")
+	s.handleFunc("/account/2fa/disable", s.disableTwoFactor).Methods("POST")
+	s.handleFunc("/account/sessions/{Id:[0-9]+}/delete", s.deleteSession).Methods("POST")
+	s.handleFunc("/account/sessions/delete", s.deleteOtherSessions).Methods("POST")
+	s.handleFunc("/account/webhooks/new", s.newWebhook).Methods("POST")
+	s.handleFunc("/account/webhooks/{Id:[0-9]+}/delete", s.deleteWebhook).Methods("POST")
+	s.handleFunc("/organizations", s.organizations)
+	s.handleFunc("/organizations/new", s.newOrganization).Methods("POST")
+	s.handleFunc("/organizations/switch/{Id:[0-9]+}", s.switchWorkspace).Methods("POST")
+	s.handleFunc("/organization/{Id:[0-9]+}", s.organization)
+	s.handleFunc("/organization/{Id:[0-9]+}/{Action:[a-z-]+}", s.saveOrganization).Methods("POST")
+	s.handleFunc("/organization/{Id:[0-9]+}/{Action:[a-z-]+}/{Item:[0-9]+}", s.saveOrganization).Methods("POST")
 	s.handleFunc("/inventory", s.inventory)
 	s.handleFunc("/inventory/{Action:[a-z-]+}", s.saveInventory).Methods("POST")
 	s.handleFunc("/inventory/{Action:[a-z-]+}/{Item:[0-9]+}", s.saveInventory).Methods("POST")
+	s.handleFunc("/inventory/prices/{Item:[0-9]+}", s.ingredientPrices)
+	s.handleFunc("/inventory/lots/{Item:[0-9]+}", s.lots)
+	s.handleFunc("/inventory/lots/{Item:[0-9]+}/{Action:[a-z]+}", s.saveLot).Methods("POST")
+	s.handleFunc("/inventory/lots/{Item:[0-9]+}/{Action:[a-z]+}/{Lot:[0-9]+}", s.saveLot).Methods("POST")
+	s.handleFunc("/inventory/expiring", s.expiringLots)
+	s.handleFunc("/catalog", s.catalog)
+	s.handleFunc("/catalog/copy", s.copyFromCatalog).Methods("POST")
+	s.handleFunc("/shopping", s.shopping)
+	s.handleFunc("/brew-now", s.brewNow)
 	s.handleFunc("/brews", s.brews)
 	s.handleFunc("/brew/new/{Id:[0-9]+}", s.newBrew)
 	s.handleFunc("/brew/{Id:[0-9]+}", s.brew)
@@ -118,6 +205,81 @@ func Serve(bind, url string, db *db.DB, sendmail *sendmail.Sendmail, i18n *i18n.
 	s.handleFunc("/brew/{Id:[0-9]+}/prev", s.brewPrevStep).Methods("POST")
 	s.handleFunc("/brew/{Id:[0-9]+}/next", s.brewNextStep).Methods("POST")
 	s.handleFunc("/brew/{Id:[0-9]+}/save-{Action:[a-z-]+}", s.brewSave).Methods("POST")
+	s.handleFunc("/brew/{Id:[0-9]+}/reading", s.addReading).Methods("POST")
+	s.handleFunc("/brew/{Id:[0-9]+}/reading/{Item:[0-9]+}/delete", s.deleteReading).Methods("POST")
+	s.handleFunc("/brew/{Id:[0-9]+}/log.svg", s.fermentationLogSVG)
+	s.handleFunc("/brew/{Id:[0-9]+}/log.csv", s.fermentationLogCSV)
+	s.handleFunc("/devices", s.devices)
+	s.handleFunc("/devices/new", s.newDevice).Methods("POST")
+	s.handleFunc("/device/{Id:[0-9]+}/{Action:save|delete}", s.saveDevice).Methods("POST")
+
+	// Install the JSON API operations, and generate the document describing
+	// them.
+	s.apiHandle(&apiOperation{ method: "GET", path: "/account", handler: s.apiAccount,
+				   summary: "Retrieve the account.", response: apiAccount{} })
+	s.apiHandle(&apiOperation{ method: "PUT", path: "/account", handler: s.apiSaveAccount,
+				   summary: "Update the account.", body: apiAccount{}, response: apiAccount{} })
+	s.apiHandle(&apiOperation{ method: "GET", path: "/recipes", handler: s.apiRecipes,
+				   summary: "List the recipes.", query: apiRecipeQuery{}, response: apiRecipeList{} })
+	s.apiHandle(&apiOperation{ method: "POST", path: "/recipes", handler: s.apiNewRecipe,
+				   summary: "Create a recipe.", body: apiRecipe{}, response: apiRecipe{},
+				   status: http.StatusCreated })
+	s.apiHandle(&apiOperation{ method: "GET", path: "/recipes/{Id:[0-9]+}", handler: s.apiRecipe,
+				   summary: "Retrieve a recipe.", response: apiRecipe{} })
+	s.apiHandle(&apiOperation{ method: "PUT", path: "/recipes/{Id:[0-9]+}", handler: s.apiUpdateRecipe,
+				   summary: "Update a recipe.", body: apiRecipe{}, response: apiRecipe{} })
+	s.apiHandle(&apiOperation{ method: "DELETE", path: "/recipes/{Id:[0-9]+}", handler: s.apiDeleteRecipe,
+				   summary: "Delete a recipe." })
+	for kind, item := range recipeItemTypes {
+		path := fmt.Sprintf("/recipes/{Id:[0-9]+}/{Kind:%s}", kind)
+		list := reflect.Zero(reflect.SliceOf(reflect.TypeOf(item))).Interface()
+		s.apiHandle(&apiOperation{ method: "GET", path: path, handler: s.apiRecipeItems,
+					   summary: fmt.Sprintf("List the %s of a recipe.", kind), response: list })
+		s.apiHandle(&apiOperation{ method: "POST", path: path, handler: s.apiSaveRecipeItem,
+					   summary: fmt.Sprintf("Add to the %s of a recipe.", kind),
+					   body: item, response: apiRecipe{} })
+		path += "/{Item:[0-9]+}"
+		s.apiHandle(&apiOperation{ method: "GET", path: path, handler: s.apiRecipeItem,
+					   summary: fmt.Sprintf("Retrieve one of the %s of a recipe.", kind),
+					   response: item })
+		s.apiHandle(&apiOperation{ method: "PUT", path: path, handler: s.apiSaveRecipeItem,
+					   summary: fmt.Sprintf("Update one of the %s of a recipe.", kind),
+					   body: item, response: apiRecipe{} })
+		s.apiHandle(&apiOperation{ method: "DELETE", path: path, handler: s.apiSaveRecipeItem,
+					   summary: fmt.Sprintf("Remove one of the %s of a recipe.", kind),
+					   response: apiRecipe{} })
+	}
+	s.apiHandle(&apiOperation{ method: "GET", path: "/ingredients", handler: s.apiInventory,
+				   summary: "List the inventory.", response: []apiIngredient{} })
+	s.apiHandle(&apiOperation{ method: "POST", path: "/ingredients", handler: s.apiNewIngredient,
+				   summary: "Add an ingredient to the inventory.", body: apiIngredient{},
+				   response: apiIngredient{}, status: http.StatusCreated })
+	s.apiHandle(&apiOperation{ method: "GET", path: "/ingredients/{Id:[0-9]+}", handler: s.apiIngredient,
+				   summary: "Retrieve an ingredient.", response: apiIngredient{} })
+	s.apiHandle(&apiOperation{ method: "PUT", path: "/ingredients/{Id:[0-9]+}", handler: s.apiUpdateIngredient,
+				   summary: "Update an ingredient.", body: apiIngredient{}, response: apiIngredient{} })
+	s.apiHandle(&apiOperation{ method: "DELETE", path: "/ingredients/{Id:[0-9]+}", handler: s.apiDeleteIngredient,
+				   summary: "Remove an ingredient from the inventory." })
+	s.apiHandle(&apiOperation{ method: "GET", path: "/brews", handler: s.apiBrews,
+				   summary: "List the brews.", response: []apiBrewSummary{} })
+	s.apiHandle(&apiOperation{ method: "POST", path: "/brews", handler: s.apiNewBrew,
+				   summary: "Start a brew, from a recipe.", body: apiNewBrew{}, response: apiBrew{},
+				   status: http.StatusCreated })
+	s.apiHandle(&apiOperation{ method: "GET", path: "/brews/{Id:[0-9]+}", handler: s.apiBrew,
+				   summary: "Retrieve a brew.", response: apiBrew{} })
+	s.apiHandle(&apiOperation{ method: "PUT", path: "/brews/{Id:[0-9]+}", handler: s.apiUpdateBrew,
+				   summary: "Update a brew.", body: apiBrew{}, response: apiBrew{} })
+	s.apiHandle(&apiOperation{ method: "DELETE", path: "/brews/{Id:[0-9]+}", handler: s.apiDeleteBrew,
+				   summary: "Delete a brew." })
+	s.apiHandle(&apiOperation{ method: "POST", path: "/brews/{Id:[0-9]+}/{Action:prev}", handler: s.apiBrewStep,
+				   summary: "Go back to the previous step of a brew.", body: apiBrewStep{},
+				   response: apiBrew{} })
+	s.apiHandle(&apiOperation{ method: "POST", path: "/brews/{Id:[0-9]+}/{Action:next}", handler: s.apiBrewStep,
+				   summary: "Go to the next step of a brew.", body: apiBrewStep{},
+				   response: apiBrew{} })
+	s.apiDocument = s.openAPIDocument()
+	s.mux.HandleFunc("/api/openapi.json", s.openAPI)
+	s.mux.HandleFunc("/api/hydrometer", s.hydrometer).Methods("POST")
 
 	// Setup secure cookie.
 	s.cookie = securecookie.New(s.db.LoadKey("hash.securecookie", 64),
@@ -126,8 +288,11 @@ func Serve(bind, url string, db *db.DB, sendmail *sendmail.Sendmail, i18n *i18n.
 	// Instantiate the CSRF protection.
 	rf := csrf.Protect(s.db.LoadKey("csrf", 32), csrf.Secure(!s.flags.debug))
 
+	// Deliver the webhooks in the background.
+	go s.deliverWebhooks()
+
 	// Start serving over HTTP.
-	return http.ListenAndServe(bind, rf(s.mux))
+	return http.ListenAndServe(bind, skipAPICSRF(rf(s.mux)))
 }
 
 // Index.
@@ -143,46 +308,64 @@ func (s *Server) handleFunc(path string, handler func(http.ResponseWriter, *http
 // HTTP handler wrapper for user session enforcement.
 func (s *Server) sessionHandler(fn func(http.ResponseWriter, *http.Request, *db.User)) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		// Check if a session cookie is available.
-		cookie, err := r.Cookie("session")
+		user, err := s.sessionUser(r)
 		if err != nil {
-			s.loginPage(w, r)
+			http.Error(w, err.Error(), 500)
 			return
-		}
-
-		// Try getting the uid out of the session cookie.
-		var uid int64
-		if err = s.cookie.Decode("session", cookie.Value, &uid); err != nil {
+		} else if user == nil {
 			s.loginPage(w, r)
 			return
 		}
 
-		// Retrive the user info.
-		user, err := s.db.GetUserById(uid)
-		if err != nil {
-			http.Error(w, err.Error(), 500)
-			return
-		}
-
 		// Jump to the real handler.
 		fn(w, r, user)
 	}
 }
 
+// Retrieve the user of the current session. Returns a nil user when there is no
+// valid session.
+func (s *Server) sessionUser(r *http.Request) (*db.User, error) {
+	session, err := s.session(r)
+	if err != nil || session == nil {
+		return nil, err
+	}
+
+	// Retrive the user info.
+	return s.db.GetUserById(session.UserId)
+}
+
 func (s *Server) loginPage(w http.ResponseWriter, r *http.Request) {
 	if r.URL.Path != "/" {
 		http.Redirect(w, r, "/", 302)
 		return
 	}
 
+	oidc := ""
+	if s.oidc != nil {
+		oidc = s.oidc.Name
+	}
+
+	var challenge template.HTML
+	if s.flags.signUp && s.challenge != nil {
+		var err error
+		if challenge, err = s.challenge.Form(); err != nil {
+			http.Error(w, err.Error(), 500)
+			return
+		}
+	}
+
 	s.executeTemplate(w, nil, "login.html", struct{
 		CSRF         template.HTML
 		SignUp       bool
 		Verification bool
+		OIDC         string
+		Challenge    template.HTML
 	}{
 		csrf.TemplateField(r),
 		s.flags.signUp,
 		s.flags.verification,
+		oidc,
+		challenge,
 	})
 }
 
@@ -205,6 +388,9 @@ func (s *Server) executeTemplate(w http.ResponseWriter, user *db.User, name stri
 			"L": func(id string) string {
 				return l.Localize(id)
 			},
+			"workspace": func() string {
+				return s.workspaceName(user)
+			},
 		})
 	}
 
@@ -214,6 +400,9 @@ func (s *Server) executeTemplate(w http.ResponseWriter, user *db.User, name stri
 func (s *Server) robotstxt(w http.ResponseWriter, r *http.Request) {
 	fmt.Fprintf(w, `User-agent: *
 Allow: /$
+Allow: /r$
+Allow: /r/
+Disallow: /r/*/download/
 Disallow: /
 `)
 }
@@ -0,0 +1,159 @@
+// Copyright (C) 2019 Antoine Tenart <antoine.tenart@ack.tf>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+
+package httpserver
+
+import (
+	"database/sql"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/atenart/bubbles/db"
+)
+
+const (
+	// Sessions unused for this long are signed out.
+	sessionIdle = 7 * 24 * time.Hour
+	// Sessions are signed out after this long, even when used.
+	sessionLifetime = 30 * 24 * time.Hour
+	// Minimum interval between two updates of the last activity of a
+	// session, not to write to the database on every request.
+	sessionTouch = time.Minute
+	// Maximum length of the user agents kept.
+	sessionAgentMax = 256
+)
+
+// Start a new session for an user, and set its cookie.
+func (s *Server) newSession(w http.ResponseWriter, r *http.Request, user *db.User) error {
+	now := time.Now().UTC()
+	if err := s.db.PruneSessions(now.Add(-sessionIdle).Format(db.TimeFormat),
+				     now.Add(-sessionLifetime).Format(db.TimeFormat)); err != nil {
+		return err
+	}
+
+	agent := r.UserAgent()
+	if len(agent) > sessionAgentMax {
+		agent = agent[:sessionAgentMax]
+	}
+
+	secret, err := s.db.AddSession(&db.Session{
+		UserId:    user.Id,
+		UserAgent: agent,
+		IP:        remoteIP(r),
+		Created:   now.Format(db.TimeFormat),
+		LastSeen:  now.Format(db.TimeFormat),
+	})
+	if err != nil {
+		return err
+	}
+
+	// Create an encoded data for the session cookie and set it.
+	payload, err := s.cookie.Encode("session", secret)
+	if err != nil {
+		return err
+	}
+	s.setSecureCookie(w, "session", payload, 0)
+
+	return nil
+}
+
+// Retrieve the session of a request, updating its last activity. Returns a nil
+// session when there is no valid one.
+func (s *Server) session(r *http.Request) (*db.Session, error) {
+	// Check if a session cookie is available.
+	cookie, err := r.Cookie("session")
+	if err != nil {
+		return nil, nil
+	}
+
+	// Try getting the session token out of the session cookie.
+	var secret string
+	if err = s.cookie.Decode("session", cookie.Value, &secret); err != nil {
+		return nil, nil
+	}
+
+	session, err := s.db.GetSessionBySecret(secret)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	// Sign out idle or too old sessions.
+	now := time.Now().UTC()
+	if session.LastSeen < now.Add(-sessionIdle).Format(db.TimeFormat) ||
+	   session.Created < now.Add(-sessionLifetime).Format(db.TimeFormat) {
+		return nil, s.db.DeleteSession(session.Id, session.UserId)
+	}
+
+	if session.LastSeen < now.Add(-sessionTouch).Format(db.TimeFormat) {
+		session.LastSeen = now.Format(db.TimeFormat)
+		session.IP = remoteIP(r)
+		if err := s.db.TouchSession(session); err != nil {
+			return nil, err
+		}
+	}
+
+	return session, nil
+}
+
+// Address of the client of a request.
+func remoteIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// Revoke a session of the user.
+func (s *Server) deleteSession(w http.ResponseWriter, r *http.Request, user *db.User) {
+	id, err := strconv.ParseInt(mux.Vars(r)["Id"], 10, 64)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+
+	if err := s.db.DeleteSession(id, user.Id); err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+
+	http.Redirect(w, r, "/account#sessions", 302)
+}
+
+// Revoke all the sessions of the user, but the current one.
+func (s *Server) deleteOtherSessions(w http.ResponseWriter, r *http.Request, user *db.User) {
+	current, err := s.session(r)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	} else if current == nil {
+		// The session ended since the request was authenticated.
+		s.loginPage(w, r)
+		return
+	}
+
+	if err := s.db.DeleteUserSessions(user.Id, current.Id); err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+
+	http.Redirect(w, r, "/account#sessions", 302)
+}
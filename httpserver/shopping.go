@@ -0,0 +1,221 @@
+// Copyright (C) 2019 Antoine Tenart <antoine.tenart@ack.tf>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package httpserver
+
+import (
+	"encoding/csv"
+	"fmt"
+	"html/template"
+	"math"
+	"net/http"
+	s "sort"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"github.com/atenart/bubbles/beerxml"
+	"github.com/atenart/bubbles/db"
+)
+
+// An ingredient to buy. When its units do not match the inventory ones, the
+// stock can't be taken into account: the whole amount needed is listed.
+type ShoppingItem struct {
+	StockUse
+	ToBuy    float64
+	Mismatch bool
+}
+
+// Ingredients to buy, of a given type and from a given supplier.
+type ShoppingGroup struct {
+	Type     string
+	Supplier string
+	Items    []ShoppingItem
+}
+
+// A recipe which can be planned, and the number of batches planned.
+type PlannedRecipe struct {
+	Recipe  *db.Recipe
+	Batches float64
+}
+
+// Shopping list page: given planned recipes, list the ingredients to buy.
+func (s *Server) shopping(w http.ResponseWriter, r *http.Request, user *db.User) {
+	owner := s.workspace(user)
+	recipes, err := s.db.GetRecipes(owner)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Couldn't parse form field.", 500)
+		return
+	}
+
+	// Retrieve the planned recipes, and their number of batches.
+	var planned []PlannedRecipe
+	var xmls []*beerxml.Recipe
+	var batches []float64
+	for _, recipe := range recipes {
+		n, _ := strconv.ParseFloat(r.FormValue(fmt.Sprintf("batches-%d", recipe.Id)), 64)
+		planned = append(planned, PlannedRecipe{ recipe, n })
+
+		if n <= 0 {
+			continue
+		}
+		xmls = append(xmls, recipe.XML)
+		batches = append(batches, n)
+	}
+
+	uses, err := s.stockUses(owner, addUpRecipes(xmls, batches))
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	list := shoppingList(uses)
+
+	switch r.FormValue("format") {
+	case "csv":
+		w.Header().Add("Content-Type", "text/csv")
+		w.Header().Set("Content-Disposition",
+			       fmt.Sprintf("attachment; filename=bubbles_shopping_%s.csv",
+					   time.Now().UTC().Format("200601021504")))
+
+		c := csv.NewWriter(w)
+		c.Write([]string{"type", "supplier", "name", "needed", "in stock", "to buy", "unit", "note"})
+		for _, g := range list {
+			for _, i := range g.Items {
+				c.Write([]string{
+					g.Type,
+					g.Supplier,
+					i.Name,
+					strconv.FormatFloat(i.Amount, 'f', -1, 64),
+					i.stock(),
+					strconv.FormatFloat(i.ToBuy, 'f', -1, 64),
+					i.Unit,
+					i.note(),
+				})
+			}
+		}
+		c.Flush()
+	case "text":
+		w.Header().Add("Content-Type", "text/plain; charset=utf-8")
+
+		t := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+		for _, g := range list {
+			supplier := g.Supplier
+			if supplier == "" {
+				supplier = "Unknown supplier"
+			}
+			fmt.Fprintf(t, "# %s - %s\n", g.Type, supplier)
+			for _, i := range g.Items {
+				fmt.Fprintf(t, "[ ] %s\t%s%s\t%s\n", i.Name,
+					    strconv.FormatFloat(i.ToBuy, 'f', -1, 64), i.Unit, i.note())
+			}
+			fmt.Fprintln(t)
+		}
+		t.Flush()
+	default:
+		// Links to export the list, keeping the planned recipes.
+		query := r.Form
+		query.Set("format", "csv")
+		csvLink := template.URL("/shopping?" + query.Encode())
+		query.Set("format", "text")
+		textLink := template.URL("/shopping?" + query.Encode())
+
+		s.executeTemplate(w, user, "shopping.html", struct{
+			Title    string
+			Planned  []PlannedRecipe
+			List     []ShoppingGroup
+			CSVLink  template.URL
+			TextLink template.URL
+		}{
+			"Bubbles - shopping list",
+			planned,
+			list,
+			csvLink,
+			textLink,
+		})
+	}
+}
+
+// Amount in stock of the ingredient to buy, in the unit of the item.
+func (i *ShoppingItem) stock() string {
+	if i.Mismatch {
+		return ""
+	} else if i.Ingredient == nil {
+		return "0"
+	}
+	return strconv.FormatFloat(i.Stock, 'f', -1, 64)
+}
+
+// Note about an item, in the exports.
+func (i *ShoppingItem) note() string {
+	if i.Mismatch {
+		return "units don't match the inventory"
+	}
+	return ""
+}
+
+// Compute the shopping list given the planned uses of ingredients, grouped by
+// ingredient type and supplier.
+func shoppingList(uses []StockUse) []ShoppingGroup {
+	types := map[string]int{
+		"fermentable": 0,
+		"hop": 1,
+		"yeast": 2,
+		"misc": 3,
+	}
+
+	groups := make(map[string]*ShoppingGroup)
+	for _, u := range uses {
+		item := ShoppingItem{ StockUse: u, ToBuy: u.Amount }
+		if u.Ingredient != nil && u.Convertible {
+			item.ToBuy = -u.Remaining
+		} else if u.Ingredient != nil {
+			// Units do not match, we can't tell how much to buy.
+			item.Mismatch = true
+		}
+		item.ToBuy = math.Round(item.ToBuy * 1000) / 1000
+
+		// Enough in stock.
+		if item.ToBuy <= 0 {
+			continue
+		}
+
+		key := u.Type + "/" + u.Supplier
+		if _, ok := groups[key]; !ok {
+			groups[key] = &ShoppingGroup{ Type: u.Type, Supplier: u.Supplier }
+		}
+		groups[key].Items = append(groups[key].Items, item)
+	}
+
+	var list []ShoppingGroup
+	for _, g := range groups {
+		list = append(list, *g)
+	}
+
+	// Smallest 'type' priority first, or fallback to
+	// 'supplier' in alphabetical order.
+	s.Slice(list, func(i, j int) bool {
+		if list[i].Type != list[j].Type {
+			return types[list[i].Type] < types[list[j].Type]
+		}
+		return list[i].Supplier < list[j].Supplier
+	})
+
+	return list
+}
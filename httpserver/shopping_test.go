@@ -0,0 +1,70 @@
+// Copyright (C) 2019 Antoine Tenart <antoine.tenart@ack.tf>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+
+package httpserver
+
+import (
+	"testing"
+
+	"github.com/atenart/bubbles/db"
+)
+
+func TestShoppingList(t *testing.T) {
+	malt := &db.Ingredient{ Stock: 3, Unit: "kg" }
+	hop := &db.Ingredient{ Stock: 100, Unit: "g" }
+	yeast := &db.Ingredient{ Stock: 0.2, Unit: "kg" }
+
+	uses := []StockUse{
+		{ Type: "fermentable", Name: "Pilsner", Ingredient: malt, Amount: 5, Unit: "kg",
+		  Stock: 3, Remaining: -2, Convertible: true },
+		{ Type: "fermentable", Name: "Munich", Amount: 1, Unit: "kg" },
+		{ Type: "hop", Name: "Saaz", Ingredient: hop, Amount: 50, Unit: "g",
+		  Stock: 100, Remaining: 50, Convertible: true },
+		{ Type: "yeast", Name: "W-34/70", Ingredient: yeast, Amount: 0.5, Unit: "l", Stock: 0.2 },
+	}
+
+	tests := []struct {
+		name     string
+		toBuy    float64
+		unit     string
+		mismatch bool
+	}{
+		{ "Pilsner", 2, "kg", false },
+		{ "Munich", 1, "kg", false },
+		{ "W-34/70", 0.5, "l", true },
+	}
+
+	items := make(map[string]ShoppingItem)
+	for _, g := range shoppingList(uses) {
+		for _, i := range g.Items {
+			items[i.Name] = i
+		}
+	}
+	if len(items) != len(tests) {
+		t.Errorf("%d items to buy, want %d", len(items), len(tests))
+	}
+	for _, tt := range tests {
+		i, ok := items[tt.name]
+		if !ok {
+			t.Errorf("%s: not in the list", tt.name)
+			continue
+		}
+		if i.ToBuy != tt.toBuy || i.Unit != tt.unit || i.Mismatch != tt.mismatch {
+			t.Errorf("%s: to buy %g%s (mismatch %v), want %g%s (mismatch %v)", tt.name,
+				 i.ToBuy, i.Unit, i.Mismatch, tt.toBuy, tt.unit, tt.mismatch)
+		}
+	}
+}
@@ -0,0 +1,301 @@
+// Copyright (C) 2019 Antoine Tenart <antoine.tenart@ack.tf>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package httpserver
+
+import (
+	"fmt"
+	"html/template"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/csrf"
+	"github.com/atenart/bubbles/beerxml"
+	"github.com/atenart/bubbles/db"
+)
+
+// Stock units, and their factor from the BeerXML units (kg or l).
+var stockUnits = map[string]struct{
+	Factor float64
+	Weight bool
+}{
+	"kg": { 1, true },
+	"g":  { 1000, true },
+	"l":  { 1, false },
+	"ml": { 1000, false },
+}
+
+// Convert a BeerXML amount (kg or l) into a given stock unit. Returns false if
+// the conversion isn't possible (e.g. from a weight to a volume).
+func toStockUnit(amount float64, isWeight bool, unit string) (float64, bool) {
+	u, ok := stockUnits[unit]
+	if !ok || u.Weight != isWeight {
+		return 0, false
+	}
+	return amount * u.Factor, true
+}
+
+// Represents the use of an inventory ingredient by a recipe.
+type StockUse struct {
+	Type        string
+	Name        string
+	Supplier    string
+	Ingredient  *db.Ingredient
+	Amount      float64
+	Unit        string
+	Remaining   float64
+	Convertible bool
+	Stock       float64 // In the lots from the supplier, in the stock unit.
+	Lots        []int64 // Lots from the supplier, if not all.
+}
+
+// Supplier of an ingredient: the supplier of fermentables, the laboratory of
+// yeasts and the origin of hops. Miscs have none.
+func supplierOf(xml interface{}) string {
+	switch xml := xml.(type) {
+	case *beerxml.Fermentable:
+		return xml.Supplier
+	case *beerxml.Hop:
+		return xml.Origin
+	case *beerxml.Yeast:
+		return xml.Laboratory
+	}
+	return ""
+}
+
+// Supplier of a lot: its own one, or the one of its ingredient.
+func lotSupplier(l *db.Lot, xml interface{}) string {
+	if strings.TrimSpace(l.Supplier) != "" {
+		return l.Supplier
+	}
+	return supplierOf(xml)
+}
+
+// Check two suppliers can be the same, an empty one matching any.
+func sameSupplier(a, b string) bool {
+	a, b = strings.TrimSpace(a), strings.TrimSpace(b)
+	return a == "" || b == "" || strings.EqualFold(a, b)
+}
+
+// Retrieve the lots of an inventory ingredient from a supplier, an empty one
+// matching any: their ids (nil if all its lots are), the amount they hold and
+// the supplier they come from. Returns false when there is no such lot.
+func supplierLots(i *db.Ingredient, lots []*db.Lot, supplier string) ([]int64, float64, string, bool) {
+	if len(lots) == 0 {
+		return nil, i.Stock, supplierOf(i.XML), sameSupplier(supplier, supplierOf(i.XML))
+	}
+
+	var ids []int64
+	var stock float64
+	var from string
+	for _, l := range lots {
+		if s := lotSupplier(l, i.XML); sameSupplier(supplier, s) {
+			ids = append(ids, l.Id)
+			stock += l.Amount
+			if from == "" {
+				from = s
+			}
+		}
+	}
+
+	found := len(ids) > 0
+	if len(ids) == len(lots) {
+		ids = nil
+	}
+	return ids, stock, from, found
+}
+
+// An inventory, indexed to compute the uses of its ingredients.
+type stockIndex struct {
+	ingredients map[string]*db.Ingredient // By type & name.
+	lots        map[int64][]*db.Lot       // By ingredient.
+}
+
+// Index an inventory and its lots.
+func newStockIndex(ingredients []*db.Ingredient, lots []*db.Lot) *stockIndex {
+	x := &stockIndex{
+		ingredients: make(map[string]*db.Ingredient),
+		lots:        make(map[int64][]*db.Lot),
+	}
+	for _, i := range ingredients {
+		x.ingredients[i.Type + "/" + i.Name] = i
+	}
+	for _, l := range lots {
+		x.lots[l.IngredientId] = append(x.lots[l.IngredientId], l)
+	}
+	return x
+}
+
+// Retrieve and index the inventory of a given owner.
+func (s *Server) stockIndex(owner db.Owner) (*stockIndex, error) {
+	ingredients, err := s.db.GetIngredients(owner)
+	if err != nil {
+		return nil, err
+	}
+
+	lots, err := s.db.GetLots(owner)
+	if err != nil {
+		return nil, err
+	}
+
+	return newStockIndex(ingredients, lots), nil
+}
+
+// Compute the inventory ingredients of a given owner used, given a list of
+// ingredients added up by name and supplier.
+func (s *Server) stockUses(owner db.Owner, total *beerxml.BeerXML) ([]StockUse, error) {
+	x, err := s.stockIndex(owner)
+	if err != nil {
+		return nil, err
+	}
+	return x.uses(total), nil
+}
+
+// Compute the inventory ingredients used, given a list of ingredients added up
+// by name and supplier. Ingredients not found in the inventory, or without lots
+// from the supplier, are reported with a nil Ingredient.
+func (x *stockIndex) uses(total *beerxml.BeerXML) []StockUse {
+	var uses []StockUse
+	use := func(kind, name, supplier string, amount float64, isWeight bool) {
+		u := StockUse{
+			Type:     kind,
+			Name:     name,
+			Supplier: supplier,
+			Amount:   amount,
+			Unit:     "kg",
+		}
+		if !isWeight {
+			u.Unit = "l"
+		}
+
+		i, ok := x.ingredients[kind + "/" + name]
+		if !ok {
+			uses = append(uses, u)
+			return
+		}
+
+		if ids, stock, from, ok := supplierLots(i, x.lots[i.Id], supplier); ok {
+			// Prefer the supplier of the inventory lots.
+			if from != "" {
+				u.Supplier = from
+			}
+
+			// The recipe amount and unit are kept when they can't
+			// be converted.
+			u.Ingredient, u.Stock, u.Lots = i, stock, ids
+			if a, ok := toStockUnit(amount, isWeight, i.Unit); ok {
+				u.Amount, u.Unit, u.Convertible = a, i.Unit, true
+				u.Remaining = math.Round((stock - u.Amount) * 1000) / 1000
+			}
+		}
+
+		uses = append(uses, u)
+	}
+
+	for _, f := range total.Fermentables {
+		use("fermentable", f.Name, f.Supplier, f.Amount, true)
+	}
+	for _, h := range total.Hops {
+		use("hop", h.Name, h.Origin, h.Amount, true)
+	}
+	for _, y := range total.Yeasts {
+		use("yeast", y.Name, y.Laboratory, y.Amount, y.AmountIsWeight)
+	}
+	for _, m := range total.Miscs {
+		use("misc", m.Name, "", m.Amount, m.AmountIsWeight)
+	}
+
+	return uses
+}
+
+// Names of the inventory ingredients which can't be deducted, as their units do
+// not match the recipe ones (e.g. a volume of yeast kept by weight).
+func notDeductible(uses []StockUse) []string {
+	var names []string
+	for _, u := range uses {
+		if u.Ingredient != nil && !u.Convertible {
+			names = append(names, u.Name)
+		}
+	}
+	return names
+}
+
+// Display the confirmation page before deducting the ingredients used by a brew
+// from the inventory.
+func (s *Server) confirmDeduction(w http.ResponseWriter, r *http.Request, user *db.User,
+				  brew *db.Brew, uses []StockUse) {
+	s.executeTemplate(w, user, "deduct.html", struct{
+		CSRF          template.HTML
+		Title         string
+		Brew          *db.Brew
+		Uses          []StockUse
+		NotDeductible []string
+	}{
+		csrf.TemplateField(r),
+		fmt.Sprintf("Bubbles - brew/%s %s", brew.XML.Name, brew.XML.Date),
+		brew,
+		uses,
+		notDeductible(uses),
+	})
+}
+
+// Format the inventory level of an ingredient, as stored in the BeerXML
+// INVENTORY extension field.
+func inventoryString(i *db.Ingredient) string {
+	return fmt.Sprintf("%s %s", strconv.FormatFloat(i.Stock, 'f', -1, 64), i.Unit)
+}
+
+// Set the BeerXML inventory field of an ingredient.
+func setInventory(i *db.Ingredient) {
+	switch xml := i.XML.(type) {
+	case *beerxml.Fermentable:
+		xml.Inventory = inventoryString(i)
+	case *beerxml.Hop:
+		xml.Inventory = inventoryString(i)
+	case *beerxml.Yeast:
+		xml.Inventory = inventoryString(i)
+	case *beerxml.Misc:
+		xml.Inventory = inventoryString(i)
+	}
+}
+
+// Parse a BeerXML inventory field into an ingredient stock, if possible.
+func parseInventory(i *db.Ingredient, inventory string) {
+	var stock float64
+	var unit string
+	if _, err := fmt.Sscanf(inventory, "%g %s", &stock, &unit); err != nil {
+		return
+	}
+
+	if _, ok := stockUnits[unit]; ok {
+		i.Stock = stock
+		i.Unit = unit
+	}
+}
+
+// Convert stock elements POSTed from a form into an ingredient. The stock
+// itself is given by the ingredient lots.
+func formToStock(r *http.Request, i *db.Ingredient) error {
+	i.Unit = r.FormValue("stock-unit")
+
+	// Sanity checks.
+	if _, ok := stockUnits[i.Unit]; !ok {
+		return fmt.Errorf("Unknown unit '%s'.", i.Unit)
+	}
+
+	return nil
+}
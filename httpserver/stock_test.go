@@ -0,0 +1,67 @@
+// Copyright (C) 2019 Antoine Tenart <antoine.tenart@ack.tf>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+
+package httpserver
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/atenart/bubbles/beerxml"
+	"github.com/atenart/bubbles/db"
+)
+
+func TestSupplierLots(t *testing.T) {
+	// Two bags of Maris Otter from different maltsters, and one from the
+	// maltster of the ingredient.
+	i := &db.Ingredient{
+		Stock: 12,
+		XML:   &beerxml.Fermentable{ Name: "Maris Otter", Supplier: "Crisp" },
+	}
+	lots := []*db.Lot{
+		{ Id: 1, Amount: 5, Supplier: "Simpsons" },
+		{ Id: 2, Amount: 4, Supplier: "crisp " },
+		{ Id: 3, Amount: 3 },
+	}
+
+	tests := []struct {
+		supplier string
+		lots     []int64
+		stock    float64
+		from     string
+		ok       bool
+	}{
+		{ "", nil, 12, "Simpsons", true },
+		{ "Simpsons", []int64{ 1 }, 5, "Simpsons", true },
+		{ "Crisp", []int64{ 2, 3 }, 7, "crisp ", true },
+		{ "Warminster", nil, 0, "", false },
+	}
+	for _, tt := range tests {
+		ids, stock, from, ok := supplierLots(i, lots, tt.supplier)
+		if !reflect.DeepEqual(ids, tt.lots) || stock != tt.stock || from != tt.from || ok != tt.ok {
+			t.Errorf("supplierLots(%q) = %v, %g, %q, %v, want %v, %g, %q, %v", tt.supplier,
+				 ids, stock, from, ok, tt.lots, tt.stock, tt.from, tt.ok)
+		}
+	}
+
+	// Without lots, the supplier of the ingredient is used.
+	if _, stock, from, ok := supplierLots(i, nil, "Crisp"); !ok || stock != 12 || from != "Crisp" {
+		t.Errorf("supplierLots without lots = %g, %q, %v", stock, from, ok)
+	}
+	if _, _, _, ok := supplierLots(i, nil, "Simpsons"); ok {
+		t.Error("supplierLots without lots matched another supplier")
+	}
+}
@@ -0,0 +1,169 @@
+// Copyright (C) 2019 Antoine Tenart <antoine.tenart@ack.tf>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package httpserver
+
+import (
+	"fmt"
+	"html/template"
+	"math"
+	"net/http"
+	s "sort"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/csrf"
+	"github.com/gorilla/mux"
+	"github.com/atenart/bubbles/beerxml"
+	"github.com/atenart/bubbles/db"
+)
+
+// A hop of the inventory which can replace a recipe hop.
+type HopSubstitute struct {
+	Ingredient *db.Ingredient
+	// The hop to insert in the recipe, with an amount keeping the same
+	// bitterness.
+	Hop        beerxml.Hop
+	Listed     bool
+	Similarity float64
+	Enough     bool
+}
+
+// Find the hops in stock of a given owner which can replace a given hop. Hops
+// listed in the substitutes come first, then the most similar ones.
+func (s *Server) hopSubstitutes(owner db.Owner, hop *beerxml.Hop) ([]HopSubstitute, error) {
+	ingredients, err := s.db.GetIngredients(owner)
+	if err != nil {
+		return nil, err
+	}
+
+	lots, err := s.db.GetLots(owner)
+	if err != nil {
+		return nil, err
+	}
+	current := currentLots(lots)
+
+	now := time.Now()
+	var subs []HopSubstitute
+	for _, i := range ingredients {
+		xml, ok := i.XML.(*beerxml.Hop)
+		if !ok || i.Stock <= 0 || i.Name == hop.Name {
+			continue
+		}
+
+		// Use the actual specs of the hop in stock.
+		sub := *xml
+		applyLot(&sub, current[i.Id])
+		sub.Alpha = storedAlpha(&sub, current[i.Id], now)
+
+		sub.Use = hop.Use
+		sub.Time = hop.Time
+		sub.Amount = math.Round(hop.SubstituteAmount(&sub) * 10000) / 10000
+		sub.Inventory = ""
+
+		amount, ok := toStockUnit(sub.Amount, true, i.Unit)
+		subs = append(subs, HopSubstitute{
+			Ingredient: i,
+			Hop:        sub,
+			Listed:     hop.ListsSubstitute(sub.Name) || sub.ListsSubstitute(hop.Name),
+			Similarity: math.Round(hop.Similarity(&sub)),
+			Enough:     ok && amount <= i.Stock,
+		})
+	}
+
+	sortSubstitutes(subs)
+
+	return subs, nil
+}
+
+// Sort a slice of HopSubstitute.
+func sortSubstitutes(subs []HopSubstitute) {
+	s.Slice(subs, func(i, j int) bool {
+		// Listed substitutes first, or
+		// most similar first, or fallback to
+		// 'name' in alphabetical order.
+		if subs[i].Listed != subs[j].Listed {
+			return subs[i].Listed
+		} else if subs[i].Similarity != subs[j].Similarity {
+			return subs[i].Similarity > subs[j].Similarity
+		}
+		return subs[i].Hop.Name < subs[j].Hop.Name
+	})
+}
+
+// Display the substitutes of a recipe hop.
+func (s *Server) recipeHopSubstitutes(w http.ResponseWriter, r *http.Request, user *db.User) {
+	id, _ := strconv.ParseInt(mux.Vars(r)["Id"], 10, 64)
+	recipe, err := s.getRecipe(id, user, accessRead)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+
+	item, _ := strconv.Atoi(mux.Vars(r)["Item"])
+	if item >= len(recipe.XML.Hops) {
+		http.Error(w, "Unknown hop", 500)
+		return
+	}
+	hop := &recipe.XML.Hops[item]
+
+	subs, err := s.hopSubstitutes(recipe.Owner(), hop)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+
+	s.executeTemplate(w, user, "substitutes.html", struct{
+		CSRF        template.HTML
+		Title       string
+		Recipe      *db.Recipe
+		Item        int
+		Hop         *beerxml.Hop
+		Substitutes []HopSubstitute
+	}{
+		csrf.TemplateField(r),
+		fmt.Sprintf("Bubbles - recipe/%s", recipe.Name),
+		recipe,
+		item,
+		hop,
+		subs,
+	})
+}
+
+// Replace a recipe hop by one of its substitutes, given its inventory id in the
+// inventory of a given owner.
+func (s *Server) substituteHop(owner db.Owner, recipe *beerxml.Recipe, item int, iid int64) error {
+	if item < 0 || item >= len(recipe.Hops) {
+		return fmt.Errorf("Unknown hop")
+	}
+
+	subs, err := s.hopSubstitutes(owner, &recipe.Hops[item])
+	if err != nil {
+		return err
+	}
+
+	for _, sub := range subs {
+		if sub.Ingredient.Id != iid {
+			continue
+		}
+
+		if err := beerxml.RemoveFromRecipe(recipe, &beerxml.Hop{}, item); err != nil {
+			return err
+		}
+		return beerxml.InsertToRecipe(recipe, &sub.Hop)
+	}
+
+	return fmt.Errorf("Not a substitute of '%s'.", recipe.Hops[item].Name)
+}
@@ -0,0 +1,156 @@
+// Copyright (C) 2019 Antoine Tenart <antoine.tenart@ack.tf>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+
+package httpserver
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/atenart/bubbles/db"
+)
+
+// Scopes which can be given to the personal access tokens.
+var scopes = []string{ db.ScopeRead, db.ScopeRecipes, db.ScopeBrews, db.ScopeInventory }
+
+// Scopes giving write access to the API resources, by path prefix. Resources
+// not listed here are read-only when using a token.
+var resourceScopes = map[string]string{
+	"recipes":     db.ScopeRecipes,
+	"ingredients": db.ScopeInventory,
+	"brews":       db.ScopeBrews,
+}
+
+// Create a new personal access token. Its value is shown once, in the account
+// page.
+func (s *Server) newToken(w http.ResponseWriter, r *http.Request, user *db.User) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Couldn't parse form field.", 500)
+		return
+	}
+
+	t := &db.Token{
+		UserId:  user.Id,
+		Name:    strings.TrimSpace(r.FormValue("name")),
+		Expires: r.FormValue("expires"),
+	}
+	if t.Name == "" {
+		http.Error(w, "A token needs a name.", 500)
+		return
+	}
+
+	if t.Expires != "" {
+		if _, err := time.Parse("2006-01-02", t.Expires); err != nil {
+			http.Error(w, fmt.Sprintf("Invalid expiration date '%s'.", t.Expires), 500)
+			return
+		}
+	}
+
+	for _, scope := range r.Form["scope"] {
+		if !validScope(scope) {
+			http.Error(w, fmt.Sprintf("Unknown scope '%s'.", scope), 500)
+			return
+		}
+		t.Scopes = append(t.Scopes, scope)
+	}
+	if len(t.Scopes) == 0 {
+		http.Error(w, "A token needs at least one scope.", 500)
+		return
+	}
+
+	secret, err := s.db.AddToken(t)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+
+	s.accountPage(w, r, user, secret)
+}
+
+// Revoke a personal access token.
+func (s *Server) deleteToken(w http.ResponseWriter, r *http.Request, user *db.User) {
+	id, err := strconv.ParseInt(mux.Vars(r)["Id"], 10, 64)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+
+	if err := s.db.DeleteToken(id, user.Id); err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+
+	http.Redirect(w, r, "/account", 302)
+}
+
+// Check a scope is known.
+func validScope(scope string) bool {
+	for _, s := range scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// Retrieve the user authenticated by a personal access token, given in an
+// "Authorization: Bearer" header, and check the token scopes allow the request.
+func (s *Server) tokenUser(r *http.Request, auth string) (*db.User, error) {
+	unauthorized := &apiError{ status: http.StatusUnauthorized,
+				   Message: "Invalid or expired token." }
+
+	secret := strings.TrimPrefix(auth, "Bearer ")
+	if secret == auth {
+		return nil, unauthorized
+	}
+
+	t, err := s.db.GetTokenBySecret(strings.TrimSpace(secret))
+	if err == sql.ErrNoRows {
+		return nil, unauthorized
+	} else if err != nil {
+		return nil, err
+	} else if t.Expired() {
+		return nil, unauthorized
+	}
+
+	if !tokenAllows(t, r) {
+		return nil, &apiError{ status: http.StatusForbidden,
+				       Message: "The token scopes do not allow this request." }
+	}
+
+	if err := s.db.TouchToken(t); err != nil {
+		return nil, err
+	}
+
+	return s.db.GetUserById(t.UserId)
+}
+
+// Check the scopes of a token allow a request: read-only requests are allowed
+// with the read-only scope, others need the scope of the resource.
+func tokenAllows(t *db.Token, r *http.Request) bool {
+	if (r.Method == "GET" || r.Method == "HEAD") && t.HasScope(db.ScopeRead) {
+		return true
+	}
+
+	resource := strings.SplitN(strings.TrimPrefix(r.URL.Path, "/api/v1/"), "/", 2)[0]
+	scope, ok := resourceScopes[resource]
+	return ok && t.HasScope(scope)
+}
@@ -0,0 +1,492 @@
+// Copyright (C) 2019 Antoine Tenart <antoine.tenart@ack.tf>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+
+package httpserver
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gorilla/csrf"
+	"github.com/atenart/bubbles/db"
+	"github.com/atenart/bubbles/qrcode"
+)
+
+const (
+	// Duration of a TOTP time step, in seconds.
+	totpPeriod = 30
+	// Time steps accepted before and after the current one, for clock
+	// drifts.
+	totpSkew = 1
+	// Number of recovery codes generated.
+	recoveryCodes = 10
+	// Size of the TOTP secrets, in bytes (as recommended by RFC 4226).
+	totpSecretSize = 20
+	// Time given to enrol a new secret.
+	totpEnrolTimeout = 15 * time.Minute
+	// Time given to complete the second login step.
+	loginStepTimeout = 5 * time.Minute
+)
+
+// Encoding of the TOTP secrets, as expected by authenticator apps.
+var totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// Content of the cookie of a login waiting for its second step. Its nonce is
+// used once, so the cookie can't be replayed.
+type loginStep struct {
+	Uid    int64
+	Issued int64
+	Nonce  string
+}
+
+// Two-factor authentication enrolment, kept server-side: the new secret, until
+// a code confirms it was enrolled.
+type totpEnrolment struct {
+	Secret string
+	Issued time.Time
+}
+
+// Compute the 6 digits TOTP code of a secret at a given time step (RFC 6238,
+// using HMAC-SHA1 as RFC 4226).
+func totpCode(secret []byte, step int64) string {
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], uint64(step))
+
+	mac := hmac.New(sha1.New, secret)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum) - 1] & 0xf
+	code := binary.BigEndian.Uint32(sum[offset:offset + 4]) & 0x7fffffff
+	return fmt.Sprintf("%06d", code % 1000000)
+}
+
+// Check a TOTP code against a secret. Returns the time step it matched, which
+// must be after a given one so codes can't be used twice.
+func checkTOTP(secret, code string, after int64) (int64, bool) {
+	key, err := totpEncoding.DecodeString(secret)
+	if err != nil {
+		return 0, false
+	}
+
+	code = strings.Replace(code, " ", "", -1)
+	now := time.Now().Unix() / totpPeriod
+	for step := now - totpSkew; step <= now + totpSkew; step++ {
+		if step <= after {
+			continue
+		}
+		if subtle.ConstantTimeCompare([]byte(totpCode(key, step)), []byte(code)) == 1 {
+			return step, true
+		}
+	}
+	return 0, false
+}
+
+// Check a second factor of an user: a TOTP code, or one of its recovery codes
+// which is then consumed. The user must be saved afterwards.
+func checkSecondFactor(user *db.User, code string) bool {
+	if step, ok := checkTOTP(user.TOTPSecret, code, user.TOTPStep); ok {
+		user.TOTPStep = step
+		return true
+	}
+
+	hash := db.HashToken(strings.ToLower(strings.TrimSpace(code)))
+	for i, h := range user.RecoveryCodes {
+		if subtle.ConstantTimeCompare([]byte(h), []byte(hash)) == 1 {
+			user.RecoveryCodes = append(user.RecoveryCodes[:i], user.RecoveryCodes[i + 1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// Generate new recovery codes for an user. Returns them in plaintext, only
+// their hashes are stored.
+func newRecoveryCodes(user *db.User) ([]string, error) {
+	var codes, hashes []string
+	for i := 0; i < recoveryCodes; i++ {
+		b := make([]byte, 5)
+		if _, err := rand.Read(b); err != nil {
+			return nil, err
+		}
+
+		code := hex.EncodeToString(b)
+		code = code[:5] + "-" + code[5:]
+		codes = append(codes, code)
+		hashes = append(hashes, db.HashToken(code))
+	}
+
+	user.RecoveryCodes = hashes
+	return codes, nil
+}
+
+// Display the two-factor authentication enrolment, with a new secret to be
+// confirmed. The secret is kept server-side until then.
+func (s *Server) twoFactor(w http.ResponseWriter, r *http.Request, user *db.User) {
+	if user.TOTPSecret != "" {
+		http.Redirect(w, r, "/account#2fa", 302)
+		return
+	}
+
+	key := make([]byte, totpSecretSize)
+	if _, err := rand.Read(key); err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	secret := totpEncoding.EncodeToString(key)
+
+	s.startEnrolment(user, secret)
+	s.twoFactorPage(w, r, user, secret, nil)
+}
+
+// Start the enrolment of a secret by an user, replacing any previous one.
+func (s *Server) startEnrolment(user *db.User, secret string) {
+	s.enrolments.mutex.Lock()
+	defer s.enrolments.mutex.Unlock()
+
+	for uid, e := range s.enrolments.pending {
+		if time.Since(e.Issued) > totpEnrolTimeout {
+			delete(s.enrolments.pending, uid)
+		}
+	}
+	s.enrolments.pending[user.Id] = totpEnrolment{ secret, time.Now() }
+}
+
+// Retrieve the secret being enrolled by an user, or an empty string if there is
+// none or it expired.
+func (s *Server) enrolmentSecret(user *db.User) string {
+	s.enrolments.mutex.Lock()
+	defer s.enrolments.mutex.Unlock()
+
+	e, ok := s.enrolments.pending[user.Id]
+	if !ok || time.Since(e.Issued) > totpEnrolTimeout {
+		return ""
+	}
+	return e.Secret
+}
+
+// End the enrolment of an user, once its secret was confirmed.
+func (s *Server) endEnrolment(user *db.User) {
+	s.enrolments.mutex.Lock()
+	defer s.enrolments.mutex.Unlock()
+	delete(s.enrolments.pending, user.Id)
+}
+
+// Render the two-factor authentication page: the enrolment of a secret, or the
+// new recovery codes.
+func (s *Server) twoFactorPage(w http.ResponseWriter, r *http.Request, user *db.User, secret string,
+			       codes []string) {
+	var qr template.HTML
+	if secret != "" {
+		uri := fmt.Sprintf("otpauth://totp/%s?secret=%s&issuer=Bubbles",
+				   url.PathEscape("Bubbles:" + user.Email), secret)
+		code, err := qrcode.Encode(uri)
+		if err != nil {
+			http.Error(w, err.Error(), 500)
+			return
+		}
+		qr = template.HTML(code.SVG(200))
+	}
+
+	s.executeTemplate(w, user, "twofactor.html", struct{
+		CSRF     template.HTML
+		Title    string
+		Secret   string
+		Password bool
+		QR       template.HTML
+		Codes    []string
+	}{
+		csrf.TemplateField(r),
+		"Bubbles - two-factor authentication",
+		secret,
+		user.Password != "",
+		qr,
+		codes,
+	})
+}
+
+// Enable the two-factor authentication, once a code confirmed the secret was
+// enrolled. The user password is required, if it has one. The recovery codes
+// are shown once.
+func (s *Server) enableTwoFactor(w http.ResponseWriter, r *http.Request, user *db.User) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Couldn't parse form field.", 500)
+		return
+	}
+
+	secret := s.enrolmentSecret(user)
+	if secret == "" || user.TOTPSecret != "" {
+		http.Error(w, "The enrolment expired, please start again.", 500)
+		return
+	}
+
+	if user.Password != "" {
+		hash, err := s.db.HashPassword(user.Email, r.FormValue("password"))
+		if err != nil {
+			hashError(w, err)
+			return
+		} else if hash != user.Password {
+			http.Error(w, "Wrong password", 500)
+			return
+		}
+	}
+
+	step, ok := checkTOTP(secret, r.FormValue("code"), 0)
+	if !ok {
+		http.Error(w, "Invalid code, check the time of your device.", 500)
+		return
+	}
+
+	codes, err := newRecoveryCodes(user)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	user.TOTPSecret = secret
+	user.TOTPStep = step
+
+	if err := s.db.UpdateUser(user); err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	s.endEnrolment(user)
+
+	s.twoFactorPage(w, r, user, "", codes)
+}
+
+// Replace the recovery codes, given a current code.
+func (s *Server) newRecoveryCodes(w http.ResponseWriter, r *http.Request, user *db.User) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Couldn't parse form field.", 500)
+		return
+	}
+
+	if user.TOTPSecret == "" || !checkSecondFactor(user, r.FormValue("code")) {
+		http.Error(w, "Invalid code.", 500)
+		return
+	}
+
+	codes, err := newRecoveryCodes(user)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+
+	if err := s.db.UpdateUser(user); err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+
+	s.twoFactorPage(w, r, user, "", codes)
+}
+
+// Disable the two-factor authentication, given the user password. Users
+// without a password (e.g. signing in with OpenID Connect) give a code instead.
+func (s *Server) disableTwoFactor(w http.ResponseWriter, r *http.Request, user *db.User) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Couldn't parse form field.", 500)
+		return
+	}
+
+	if user.Password == "" {
+		if !checkSecondFactor(user, r.FormValue("code")) {
+			http.Error(w, "Invalid code.", 500)
+			return
+		}
+	} else {
+		hash, err := s.db.HashPassword(user.Email, r.FormValue("password"))
+		if err != nil {
+			hashError(w, err)
+			return
+		} else if hash != user.Password {
+			http.Error(w, "Wrong password", 500)
+			return
+		}
+	}
+
+	user.TOTPSecret = ""
+	user.TOTPStep = 0
+	user.RecoveryCodes = nil
+	if err := s.db.UpdateUser(user); err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+
+	http.Redirect(w, r, "/account#2fa", 302)
+}
+
+// Start the second step of a login, for users with two-factor authentication.
+func (s *Server) startLoginStep(w http.ResponseWriter, r *http.Request, user *db.User) {
+	if err := s.setLoginStep(w, user); err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+
+	http.Redirect(w, r, "/login/2fa", 302)
+}
+
+// Set the cookie of a login waiting for its second step.
+func (s *Server) setLoginStep(w http.ResponseWriter, user *db.User) error {
+	nonce, err := randomString(16)
+	if err != nil {
+		return err
+	}
+
+	payload, err := s.cookie.Encode("2fa", loginStep{ user.Id, time.Now().Unix(), nonce })
+	if err != nil {
+		return err
+	}
+	s.setSecureCookie(w, "2fa", payload, int(loginStepTimeout / time.Second))
+	return nil
+}
+
+// Retrieve a login waiting for its second step, or nil if there is none, it
+// expired or it was completed.
+func (s *Server) pendingLoginStep(r *http.Request) *loginStep {
+	cookie, err := r.Cookie("2fa")
+	if err != nil {
+		return nil
+	}
+
+	var step loginStep
+	if err := s.cookie.Decode("2fa", cookie.Value, &step); err != nil {
+		return nil
+	}
+	if time.Since(time.Unix(step.Issued, 0)) > loginStepTimeout {
+		return nil
+	}
+
+	s.loginSteps.mutex.Lock()
+	defer s.loginSteps.mutex.Unlock()
+	if _, ok := s.loginSteps.used[step.Nonce]; ok {
+		return nil
+	}
+	return &step
+}
+
+// Mark a login step completed, so its cookie can't be used again. Returns false
+// if it already was.
+func (s *Server) completeLoginStep(step *loginStep) bool {
+	s.loginSteps.mutex.Lock()
+	defer s.loginSteps.mutex.Unlock()
+
+	now := time.Now()
+	for n, t := range s.loginSteps.used {
+		if now.After(t) {
+			delete(s.loginSteps.used, n)
+		}
+	}
+	if _, ok := s.loginSteps.used[step.Nonce]; ok {
+		return false
+	}
+	s.loginSteps.used[step.Nonce] = time.Unix(step.Issued, 0).Add(loginStepTimeout)
+	return true
+}
+
+// Retrieve the user of a login waiting for its second step, or nil if there is
+// none.
+func (s *Server) loginStepUser(r *http.Request) (*loginStep, *db.User, error) {
+	step := s.pendingLoginStep(r)
+	if step == nil {
+		return nil, nil, nil
+	}
+
+	user, err := s.db.GetUserById(step.Uid)
+	return step, user, err
+}
+
+// Display the second login step.
+func (s *Server) loginStepPage(w http.ResponseWriter, r *http.Request) {
+	_, user, err := s.loginStepUser(r)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	} else if user == nil {
+		http.Redirect(w, r, "/", 302)
+		return
+	}
+
+	s.executeTemplate(w, nil, "login-2fa.html", struct{
+		CSRF      template.HTML
+		Invalid   bool
+		Throttled bool
+	}{
+		csrf.TemplateField(r),
+		r.URL.Query().Get("invalid") != "",
+		r.URL.Query().Get("throttled") != "",
+	})
+}
+
+// Complete a login with a TOTP or recovery code.
+func (s *Server) loginStep(w http.ResponseWriter, r *http.Request) {
+	step, user, err := s.loginStepUser(r)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	} else if user == nil {
+		http.Redirect(w, r, "/", 302)
+		return
+	}
+
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Couldn't parse form field.", 500)
+		return
+	}
+
+	// Codes are short: slow down brute-force attacks, as for passwords.
+	if s.throttled(r, user.Email) {
+		http.Redirect(w, r, "/login/2fa?throttled=1", 302)
+		return
+	}
+
+	if !checkSecondFactor(user, r.FormValue("code")) {
+		s.loginFailed(r, user.Email)
+		http.Redirect(w, r, "/login/2fa?invalid=1", 302)
+		return
+	}
+	s.limits.accounts.reset(user.Email)
+
+	// Concurrent requests could use the same cookie.
+	if !s.completeLoginStep(step) {
+		http.Redirect(w, r, "/", 302)
+		return
+	}
+
+	// Save the used time step or recovery code.
+	if err := s.db.UpdateUser(user); err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+
+	s.setSecureCookie(w, "2fa", "", -1)
+	if err := s.newSession(w, r, user); err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+
+	http.Redirect(w, r, "/", 302)
+}
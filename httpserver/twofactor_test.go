@@ -0,0 +1,168 @@
+// Copyright (C) 2019 Antoine Tenart <antoine.tenart@ack.tf>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+
+package httpserver
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/securecookie"
+	"github.com/atenart/bubbles/db"
+)
+
+func TestTOTPCode(t *testing.T) {
+	// RFC 6238 test vectors (SHA-1), truncated to 6 digits.
+	secret := []byte("12345678901234567890")
+	tests := []struct {
+		time int64
+		code string
+	}{
+		{ 59, "287082" },
+		{ 1111111109, "081804" },
+		{ 1111111111, "050471" },
+		{ 1234567890, "005924" },
+		{ 2000000000, "279037" },
+		{ 20000000000, "353130" },
+	}
+	for _, tt := range tests {
+		if code := totpCode(secret, tt.time / totpPeriod); code != tt.code {
+			t.Errorf("totpCode(%d) = %s, want %s", tt.time, code, tt.code)
+		}
+	}
+}
+
+func TestCheckTOTP(t *testing.T) {
+	key := []byte("12345678901234567890")
+	secret := totpEncoding.EncodeToString(key)
+	now := time.Now().Unix() / totpPeriod
+	code := totpCode(key, now)
+
+	tests := []struct {
+		name   string
+		secret string
+		code   string
+		after  int64
+		ok     bool
+	}{
+		{ "current", secret, code, 0, true },
+		{ "spaces", secret, code[:3] + " " + code[3:], 0, true },
+		{ "previous", secret, totpCode(key, now - 1), 0, true },
+		{ "too old", secret, totpCode(key, now - 3), 0, false },
+		{ "already used", secret, code, now + totpSkew, false },
+		{ "not a code", secret, "abcdef", 0, false },
+		{ "empty", secret, "", 0, false },
+		{ "invalid secret", "!", code, 0, false },
+	}
+	for _, tt := range tests {
+		if _, ok := checkTOTP(tt.secret, tt.code, tt.after); ok != tt.ok {
+			t.Errorf("%s: checkTOTP = %v, want %v", tt.name, ok, tt.ok)
+		}
+	}
+
+	// Codes can't be used twice.
+	step, ok := checkTOTP(secret, code, 0)
+	if !ok {
+		t.Fatal("code not accepted")
+	}
+	if _, ok := checkTOTP(secret, code, step); ok {
+		t.Error("code accepted twice")
+	}
+}
+
+// Server able to sign cookies.
+func testServer() *Server {
+	s := &Server{
+		cookie: securecookie.New(securecookie.GenerateRandomKey(64),
+					 securecookie.GenerateRandomKey(32)),
+	}
+	s.loginSteps.used = make(map[string]time.Time)
+	s.enrolments.pending = make(map[int64]totpEnrolment)
+	return s
+}
+
+// Request carrying the cookies set in a response.
+func withCookies(w *httptest.ResponseRecorder) *http.Request {
+	r := httptest.NewRequest("POST", "/", nil)
+	for _, c := range w.Result().Cookies() {
+		r.AddCookie(c)
+	}
+	return r
+}
+
+func TestEnrolmentSecret(t *testing.T) {
+	s := testServer()
+	user := &db.User{ Id: 1 }
+	other := &db.User{ Id: 2 }
+	secret := totpEncoding.EncodeToString([]byte("12345678901234567890"))
+
+	if got := s.enrolmentSecret(user); got != "" {
+		t.Errorf("enrolmentSecret = %q before the enrolment, want none", got)
+	}
+
+	s.startEnrolment(user, secret)
+	if got := s.enrolmentSecret(user); got != secret {
+		t.Errorf("enrolmentSecret = %q, want %q", got, secret)
+	}
+	if got := s.enrolmentSecret(other); got != "" {
+		t.Errorf("enrolmentSecret = %q for another user, want none", got)
+	}
+
+	// Enrolments expire.
+	s.enrolments.pending[user.Id] = totpEnrolment{ secret, time.Now().Add(-totpEnrolTimeout - time.Second) }
+	if got := s.enrolmentSecret(user); got != "" {
+		t.Errorf("expired enrolmentSecret = %q, want none", got)
+	}
+
+	// Expired enrolments are pruned when a new one starts.
+	s.startEnrolment(other, secret)
+	if _, ok := s.enrolments.pending[user.Id]; ok {
+		t.Error("expired enrolment kept")
+	}
+
+	s.endEnrolment(other)
+	if got := s.enrolmentSecret(other); got != "" {
+		t.Errorf("enrolmentSecret = %q after the enrolment, want none", got)
+	}
+}
+
+func TestLoginStepNonce(t *testing.T) {
+	s := testServer()
+
+	w := httptest.NewRecorder()
+	if err := s.setLoginStep(w, &db.User{ Id: 1 }); err != nil {
+		t.Fatal(err)
+	}
+	r := withCookies(w)
+
+	step := s.pendingLoginStep(r)
+	if step == nil || step.Uid != 1 {
+		t.Fatalf("pending login step = %+v, want user 1", step)
+	}
+	if !s.completeLoginStep(step) {
+		t.Fatal("login step not completed")
+	}
+
+	// The cookie can't be replayed.
+	if step := s.pendingLoginStep(r); step != nil {
+		t.Error("completed login step still pending")
+	}
+	if s.completeLoginStep(step) {
+		t.Error("login step completed twice")
+	}
+}
@@ -0,0 +1,49 @@
+// Copyright (C) 2019 Antoine Tenart <antoine.tenart@ack.tf>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+
+// Solve the sign-up proof of work in the background: find a nonce such as the
+// SHA-256 hash of "<challenge>:<nonce>" starts with the given number of zero
+// bits. Signing up is possible once it is found.
+$(async function() {
+  var input = $("input[name=pow-nonce]");
+  if (input.length == 0)
+    return;
+
+  var button = $("button[formaction='/sign-up']");
+  button.prop("disabled", true);
+
+  var challenge = $("input[name=pow-challenge]").val();
+  var bits = input.data("bits");
+  var encoder = new TextEncoder();
+
+  for (var nonce = 0; ; nonce++) {
+    var data = encoder.encode(`${challenge}:${nonce}`);
+    var hash = new Uint8Array(await crypto.subtle.digest("SHA-256", data));
+
+    var zeros = 0;
+    for (var b of hash) {
+      zeros += b == 0 ? 8 : Math.clz32(b) - 24;
+      if (b != 0)
+        break;
+    }
+
+    if (zeros >= bits) {
+      input.val(nonce);
+      button.prop("disabled", false);
+      return;
+    }
+  }
+})
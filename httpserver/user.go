@@ -55,9 +55,17 @@ func (s *Server) login(w http.ResponseWriter, r *http.Request) {
 	email := r.FormValue("email")
 	password := r.FormValue("password")
 
-	// Try retrieving an (enabled) existing user.
+	// Slow down brute-force attacks, from an address or on an account.
+	if s.throttled(r, email) {
+		http.Redirect(w, r, "/#throttled", 302)
+		return
+	}
+
+	// Try retrieving an (enabled) existing user. Unknown accounts are only
+	// limited by address: tracking them would let anyone grow the limiter.
 	user, err := s.db.GetUserByEmail(email)
 	if err != nil {
+		s.limits.ips.fail(ipKey(r))
 		http.Redirect(w, r, "/", 302)
 		return
 	}
@@ -71,30 +79,48 @@ func (s *Server) login(w http.ResponseWriter, r *http.Request) {
 	// Generate an hash out of the provided plaintext password.
 	hash, err := s.db.HashPassword(user.Email, password)
 	if err != nil {
-		http.Error(w, err.Error(), 500)
+		hashError(w, err)
 		return
 	}
 
 	// Check the provided password matches the one we have.
 	if strings.Compare(user.Password, hash) != 0 {
+		s.loginFailed(r, user.Email)
 		// TODO: give a meaningfull feedback to the user.
 		http.Redirect(w, r, "/", 302)
 		return
 	}
 
-	// Create an encoded data for the session cookie and set it.
-	payload, err := s.cookie.Encode("session", user.Id)
-	if err != nil {
+	// Users with two-factor authentication need a second step. Their
+	// failed attempts are only forgotten once it is passed.
+	if user.TOTPSecret != "" {
+		s.startLoginStep(w, r, user)
+		return
+	}
+	s.limits.accounts.reset(user.Email)
+
+	// Start a new session and set its cookie.
+	if err := s.newSession(w, r, user); err != nil {
 		http.Error(w, err.Error(), 500)
 		return
 	}
-	s.setSecureCookie(w, "session", payload, 0)
 
 	http.Redirect(w, r, "/", 302)
 }
 
-// Logout an user by setting an empty session cookie.
+// Logout an user by revoking its session and setting an empty session cookie.
 func (s *Server) logout(w http.ResponseWriter, r *http.Request) {
+	session, err := s.session(r)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	} else if session != nil {
+		if err := s.db.DeleteSession(session.Id, session.UserId); err != nil {
+			http.Error(w, err.Error(), 500)
+			return
+		}
+	}
+
 	// Delete the session cookie by settings its max age to -1.
 	s.setSecureCookie(w, "session", "", -1)
 
@@ -105,6 +131,13 @@ func (s *Server) logout(w http.ResponseWriter, r *http.Request) {
 func (s *Server) signUp(w http.ResponseWriter, r *http.Request) {
 	if !s.flags.signUp {
 		http.Redirect(w, r, "/", 302)
+		return
+	}
+
+	// Limit the sign-ups from an address.
+	if s.limits.signUps.wait(ipKey(r)) > 0 {
+		http.Redirect(w, r, "/#throttled", 302)
+		return
 	}
 
 	// Get the user & password from the POSTed form.
@@ -120,10 +153,19 @@ func (s *Server) signUp(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Check the optional challenge (e.g. proof of work) was passed.
+	if s.challenge != nil && !s.challenge.Verify(r) {
+		http.Redirect(w, r, "/#challenge", 302)
+		return
+	}
+
+	// Every sign-up counts against the address.
+	s.limits.signUps.fail(ipKey(r))
+
 	// Compute the hash password out of the provided plaintext one.
 	hash, err := s.db.HashPassword(email, password)
 	if err != nil {
-		http.Error(w, err.Error(), 500)
+		hashError(w, err)
 		return
 	}
 
@@ -0,0 +1,427 @@
+// Copyright (C) 2019 Antoine Tenart <antoine.tenart@ack.tf>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+
+package httpserver
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/atenart/bubbles/db"
+)
+
+// Events which can be subscribed to by webhooks.
+var events = []string{
+	db.EventBrewCreated,
+	db.EventBrewStep,
+	db.EventReading,
+	db.EventRecipeCreated,
+	db.EventRecipeUpdated,
+	db.EventRecipeDeleted,
+	db.EventInventoryLow,
+	db.EventGravityStable,
+}
+
+const (
+	// Timeout of a delivery attempt.
+	webhookTimeout = 10 * time.Second
+	// Interval between two checks of the pending deliveries.
+	webhookPoll = 30 * time.Second
+	// Delay before retrying a failed delivery, doubled after each attempt.
+	webhookBackoff = 30 * time.Second
+	// Number of attempts before giving up a delivery.
+	webhookAttempts = 8
+	// Duration the deliveries are kept in the log.
+	webhookRetention = 30 * 24 * time.Hour
+	// Number of deliveries shown in the account page.
+	webhookLogSize = 25
+	// Number of webhooks delivered at the same time.
+	webhookWorkers = 4
+)
+
+// Body of the deliveries.
+type webhookPayload struct {
+	Event string      `json:"event"`
+	Date  string      `json:"date"`
+	Data  interface{} `json:"data"`
+}
+
+// Brew whose step changed, in the deliveries.
+type webhookBrewStep struct {
+	apiBrewSummary
+	PreviousStep int64 `json:"previous_step"`
+}
+
+// Reading of the fermentation log of a brew, in the deliveries.
+type webhookReading struct {
+	Brew        apiBrewSummary `json:"brew"`
+	Date        string         `json:"date"`
+	Gravity     float64        `json:"gravity"`
+	Temperature *float64       `json:"temperature"`
+	Ph          float64        `json:"ph"`
+	Note        string         `json:"note"`
+}
+
+// Send an event on the data of an owner to the webhooks subscribed to it. The
+// deliveries are queued, errors are only logged: they must not fail the action
+// which triggered the event.
+func (s *Server) notify(owner db.Owner, event string, data interface{}) {
+	webhooks, err := s.db.GetOwnerWebhooks(owner, event)
+	if err != nil {
+		log.Print(err)
+		return
+	} else if len(webhooks) == 0 {
+		return
+	}
+
+	now := time.Now().UTC()
+	payload, err := json.Marshal(webhookPayload{ event, now.Format(time.RFC3339), data })
+	if err != nil {
+		log.Print(err)
+		return
+	}
+
+	for _, w := range webhooks {
+		err := s.db.AddDelivery(&db.Delivery{
+			WebhookId:   w.Id,
+			Event:       event,
+			Payload:     string(payload),
+			Created:     now.Format(db.TimeFormat),
+			Status:      db.DeliveryPending,
+			NextAttempt: now.Format(db.TimeFormat),
+		})
+		if err != nil {
+			log.Print(err)
+		}
+	}
+
+	// Wake the deliveries up, unless they already are.
+	select {
+	case s.webhookWake <- struct{}{}:
+	default:
+	}
+}
+
+// Deliveries in progress: a bounded number of workers, each delivering the
+// pending events of one webhook in order. A slow endpoint only holds its worker.
+type deliveryPool struct {
+	workers chan struct{}
+	done    chan int64     // Webhooks whose worker finished.
+	busy    map[int64]bool // Webhooks being delivered.
+}
+
+func newDeliveryPool(size int) *deliveryPool {
+	return &deliveryPool{
+		workers: make(chan struct{}, size),
+		done:    make(chan int64, size),
+		busy:    make(map[int64]bool),
+	}
+}
+
+// Deliver the pending events to the webhooks, forever. Meant to run in its own
+// goroutine.
+func (s *Server) deliverWebhooks() {
+	pool := newDeliveryPool(webhookWorkers)
+	ticker := time.NewTicker(webhookPoll)
+	for {
+		now := time.Now().UTC()
+		deliveries, err := s.db.GetDueDeliveries(now.Format(db.TimeFormat))
+		if err != nil {
+			log.Print(err)
+		}
+		s.dispatch(pool, deliveries)
+
+		err = s.db.PruneDeliveries(now.Add(-webhookRetention).Format(db.TimeFormat))
+		if err != nil {
+			log.Print(err)
+		}
+
+		// Look for the deliveries left behind once a worker is free.
+		select {
+		case <-s.webhookWake:
+		case id := <-pool.done:
+			delete(pool.busy, id)
+		case <-ticker.C:
+		}
+	}
+}
+
+// Give the due deliveries of the webhooks not being delivered to free workers.
+// The others are left for later.
+func (s *Server) dispatch(pool *deliveryPool, deliveries []*db.Delivery) {
+	var webhooks []int64
+	queues := make(map[int64][]*db.Delivery)
+	for _, d := range deliveries {
+		if pool.busy[d.WebhookId] {
+			continue
+		}
+		if queues[d.WebhookId] == nil {
+			webhooks = append(webhooks, d.WebhookId)
+		}
+		queues[d.WebhookId] = append(queues[d.WebhookId], d)
+	}
+
+	for _, id := range webhooks {
+		select {
+		case pool.workers <- struct{}{}:
+		default:
+			return
+		}
+
+		pool.busy[id] = true
+		go func(id int64, queue []*db.Delivery) {
+			for _, d := range queue {
+				s.deliver(d)
+			}
+			<-pool.workers
+			pool.done <- id
+		}(id, queues[id])
+	}
+}
+
+// Attempt a delivery, and schedule its next attempt if it fails.
+func (s *Server) deliver(d *db.Delivery) {
+	w, err := s.db.GetWebhook(d.WebhookId)
+	if err != nil {
+		log.Print(err)
+		return
+	}
+
+	d.Attempts++
+	d.Response, d.Error = 0, ""
+
+	req, err := http.NewRequest("POST", w.URL, strings.NewReader(d.Payload))
+	if err == nil {
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("User-Agent", "Bubbles-Webhook")
+		req.Header.Set("X-Bubbles-Event", d.Event)
+		req.Header.Set("X-Bubbles-Delivery", strconv.FormatInt(d.Id, 10))
+		req.Header.Set("X-Bubbles-Signature", "sha256=" + sign(w.Secret, d.Payload))
+
+		var resp *http.Response
+		if resp, err = s.webhookClient.Do(req); err == nil {
+			resp.Body.Close()
+			d.Response = resp.StatusCode
+			if resp.StatusCode < 200 || resp.StatusCode > 299 {
+				err = fmt.Errorf("Unexpected status '%s'.", resp.Status)
+			}
+		}
+	}
+
+	if err == nil {
+		d.Status = db.DeliveryDelivered
+	} else {
+		d.Error = err.Error()
+		if d.Attempts >= webhookAttempts {
+			d.Status = db.DeliveryFailed
+		} else {
+			backoff := webhookBackoff << uint(d.Attempts - 1)
+			d.NextAttempt = time.Now().UTC().Add(backoff).Format(db.TimeFormat)
+		}
+	}
+
+	if err := s.db.UpdateDelivery(d); err != nil {
+		log.Print(err)
+	}
+}
+
+// Sign a payload: hex encoded HMAC-SHA256, keyed by the webhook secret.
+func sign(secret, payload string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(payload))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Networks the webhooks can't be delivered to, as it would give access to the
+// local network of the server (or to special purpose addresses).
+var privateNetworks []*net.IPNet
+
+func init() {
+	for _, cidr := range []string{
+		"0.0.0.0/8", "10.0.0.0/8", "100.64.0.0/10", "127.0.0.0/8", "169.254.0.0/16",
+		"172.16.0.0/12", "192.0.0.0/24", "192.168.0.0/16", "198.18.0.0/15",
+		"224.0.0.0/4", "240.0.0.0/4", "::/128", "::1/128", "fc00::/7", "fe80::/10",
+		"ff00::/8",
+	} {
+		_, network, _ := net.ParseCIDR(cidr)
+		privateNetworks = append(privateNetworks, network)
+	}
+}
+
+// Check webhooks can be delivered to an address. IPv4-mapped IPv6 addresses
+// (e.g. ::ffff:127.0.0.1) are checked as the IPv4 address they map to.
+func publicAddress(ip net.IP) bool {
+	if ip == nil {
+		return false
+	}
+	if v4 := ip.To4(); v4 != nil {
+		ip = v4
+	}
+
+	for _, network := range privateNetworks {
+		if network.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+// HTTP client delivering the webhooks. Redirections are not followed, and
+// unless allowed, private addresses are refused. Proxies are not used, as the
+// addresses are checked when connecting.
+func newWebhookClient(allowPrivate bool) *http.Client {
+	dialer := &net.Dialer{
+		Timeout: webhookTimeout,
+		Control: func(network, address string, c syscall.RawConn) error {
+			if allowPrivate {
+				return nil
+			}
+
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+
+			if !publicAddress(net.ParseIP(host)) {
+				return fmt.Errorf("Address %s is not allowed.", host)
+			}
+			return nil
+		},
+	}
+
+	return &http.Client{
+		Timeout: webhookTimeout,
+		Transport: &http.Transport{
+			Proxy: nil,
+			DialContext: func(ctx context.Context, network, address string) (net.Conn, error) {
+				return dialer.DialContext(ctx, network, address)
+			},
+		},
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+// Create a new webhook, on the personal space or on one of the organizations of
+// the current user.
+func (s *Server) newWebhook(w http.ResponseWriter, r *http.Request, user *db.User) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Couldn't parse form field.", 500)
+		return
+	}
+
+	webhook := &db.Webhook{
+		UserId: user.Id,
+		URL:    strings.TrimSpace(r.FormValue("url")),
+	}
+
+	if u, err := url.Parse(webhook.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") ||
+	   u.Host == "" {
+		http.Error(w, fmt.Sprintf("Invalid URL '%s'.", webhook.URL), 500)
+		return
+	}
+
+	// Organization webhooks receive the events of all its members: only
+	// the ones allowed to modify its data can add them.
+	webhook.OrganizationId, _ = strconv.ParseInt(r.FormValue("workspace"), 10, 64)
+	if webhook.OrganizationId != 0 {
+		owner := db.Owner{ OrganizationId: webhook.OrganizationId }
+		if err := s.checkAccess(user, owner, accessWrite); err != nil {
+			http.Error(w, err.Error(), 500)
+			return
+		}
+	}
+
+	for _, event := range r.Form["event"] {
+		if !validEvent(event) {
+			http.Error(w, fmt.Sprintf("Unknown event '%s'.", event), 500)
+			return
+		}
+		webhook.Events = append(webhook.Events, event)
+	}
+	if len(webhook.Events) == 0 {
+		http.Error(w, "A webhook needs at least one event.", 500)
+		return
+	}
+
+	if err := s.db.AddWebhook(webhook); err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+
+	http.Redirect(w, r, "/account#webhooks", 302)
+}
+
+// Delete a webhook.
+func (s *Server) deleteWebhook(w http.ResponseWriter, r *http.Request, user *db.User) {
+	id, err := strconv.ParseInt(mux.Vars(r)["Id"], 10, 64)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+
+	if err := s.db.DeleteWebhook(id, user.Id); err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+
+	http.Redirect(w, r, "/account#webhooks", 302)
+}
+
+// Check an event is known.
+func validEvent(event string) bool {
+	for _, e := range events {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+// Check a stock is low: less than a package left, or nothing if the ingredient
+// has no package size.
+func lowStock(i *db.Ingredient, stock float64) bool {
+	if i.Package > 0 {
+		return stock < i.Package
+	}
+	return stock <= 0
+}
+
+// Convert a reading for the deliveries.
+func readingToJSON(brew *db.Brew, reading *db.Reading) webhookReading {
+	return webhookReading{
+		Brew:        brewSummaryToJSON(brew),
+		Date:        reading.Date.Format(time.RFC3339),
+		Gravity:     reading.Gravity,
+		Temperature: reading.Temperature,
+		Ph:          reading.Ph,
+		Note:        reading.Note,
+	}
+}
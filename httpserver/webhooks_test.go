@@ -0,0 +1,182 @@
+// Copyright (C) 2019 Antoine Tenart <antoine.tenart@ack.tf>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+
+package httpserver
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/atenart/bubbles/db"
+)
+
+func TestSign(t *testing.T) {
+	// RFC 4231, test case 2.
+	got := sign("Jefe", "what do ya want for nothing?")
+	want := "5bdcc146bf60754e6a042426089575c75a003f089d2739839dec58b964ec3843"
+	if got != want {
+		t.Errorf("sign = %s, want %s", got, want)
+	}
+}
+
+func TestPublicAddress(t *testing.T) {
+	tests := []struct {
+		ip   string
+		want bool
+	}{
+		{ "93.184.216.34", true },
+		{ "2606:2800:220:1:248:1893:25c8:1946", true },
+		{ "::ffff:93.184.216.34", true },
+		{ "0.0.0.0", false },
+		{ "10.1.2.3", false },
+		{ "100.64.0.1", false },
+		{ "127.0.0.1", false },
+		{ "169.254.169.254", false },
+		{ "172.16.0.1", false },
+		{ "192.0.0.8", false },
+		{ "192.168.1.1", false },
+		{ "198.18.0.1", false },
+		{ "224.0.0.1", false },
+		{ "255.255.255.255", false },
+		{ "::", false },
+		{ "::1", false },
+		{ "::ffff:127.0.0.1", false },
+		{ "::ffff:169.254.169.254", false },
+		{ "fd00::1", false },
+		{ "fe80::1", false },
+		{ "ff02::1", false },
+	}
+	for _, tt := range tests {
+		if got := publicAddress(net.ParseIP(tt.ip)); got != tt.want {
+			t.Errorf("publicAddress(%s) = %v, want %v", tt.ip, got, tt.want)
+		}
+	}
+
+	if publicAddress(nil) {
+		t.Error("publicAddress(nil) = true, want false")
+	}
+}
+
+func TestWebhookClient(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/elsewhere", 302)
+	}))
+	defer ts.Close()
+
+	if _, err := newWebhookClient(false).Get(ts.URL); err == nil {
+		t.Error("delivered to a private address")
+	}
+
+	resp, err := newWebhookClient(true).Get(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != 302 {
+		t.Errorf("status = %d, redirections must not be followed", resp.StatusCode)
+	}
+
+	// Proxies would connect to the webhooks in place of the client, and
+	// bypass the address checks.
+	if newWebhookClient(false).Transport.(*http.Transport).Proxy != nil {
+		t.Error("webhook client uses a proxy")
+	}
+}
+
+func TestDispatch(t *testing.T) {
+	s := dbTestServer(t)
+	s.webhookClient = newWebhookClient(true)
+
+	release := make(chan struct{})
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer slow.Close()
+	delivered := make(chan string, 10)
+	fast := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		delivered <- r.Header.Get("X-Bubbles-Delivery")
+	}))
+	defer fast.Close()
+
+	if err := s.db.AddUser("a@example.com", "", "", false); err != nil {
+		t.Fatal(err)
+	}
+	user, err := s.db.GetUserByEmail("a@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now().UTC().Format(db.TimeFormat)
+	var webhooks []int64
+	for _, url := range []string{ slow.URL, fast.URL } {
+		w := &db.Webhook{ UserId: user.Id, URL: url, Events: []string{ db.EventReading } }
+		if err := s.db.AddWebhook(w); err != nil {
+			t.Fatal(err)
+		}
+		webhooks = append(webhooks, w.Id)
+
+		for i := 0; i < 2; i++ {
+			err := s.db.AddDelivery(&db.Delivery{ WebhookId: w.Id, Event: db.EventReading, Payload: "{}",
+							      Created: now, Status: db.DeliveryPending,
+							      NextAttempt: now })
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	due := func() []*db.Delivery {
+		deliveries, err := s.db.GetDueDeliveries(now)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return deliveries
+	}
+
+	// With a single free worker, the slow endpoint holds it and the other
+	// webhook waits.
+	pool := newDeliveryPool(2)
+	pool.workers <- struct{}{}
+	s.dispatch(pool, due())
+	if !pool.busy[webhooks[0]] || pool.busy[webhooks[1]] {
+		t.Fatalf("busy webhooks = %v, want only %d", pool.busy, webhooks[0])
+	}
+
+	// Once another worker is free, the fast endpoint is delivered meanwhile,
+	// and the busy webhook isn't delivered twice.
+	<-pool.workers
+	s.dispatch(pool, due())
+	for i := 0; i < 2; i++ {
+		select {
+		case <-delivered:
+		case <-time.After(5 * time.Second):
+			t.Fatal("fast endpoint delayed by the slow one")
+		}
+	}
+	if id := <-pool.done; id != webhooks[1] {
+		t.Errorf("done = %d, want %d", id, webhooks[1])
+	}
+
+	close(release)
+	if id := <-pool.done; id != webhooks[0] {
+		t.Errorf("done = %d, want %d", id, webhooks[0])
+	}
+	if deliveries := due(); len(deliveries) != 0 {
+		t.Errorf("%d deliveries left pending", len(deliveries))
+	}
+}
@@ -18,15 +18,18 @@ package main
 import (
 	"flag"
 	"fmt"
+	"io/ioutil"
 	"log"
 
 	"github.com/atenart/bubbles/db"
 	"github.com/atenart/bubbles/httpserver"
 	"github.com/atenart/bubbles/i18n"
 	"github.com/atenart/bubbles/sendmail"
+	yaml "gopkg.in/yaml.v2"
 )
 
 var (
+	config         = flag.String("config", "", "Path to a YAML configuration file, setting flags by name.")
 	bind           = flag.String("bind", ":8000", "Address and port to bind to.")
 	url            = flag.String("url", "", "Website URL (with protocol).")
 	data           = flag.String("data", "data/", "Path to the data (will contain the db file as well).")
@@ -34,6 +37,15 @@ var (
 	noVerification = flag.Bool("no-verification", false, "Disable verification of sign-up (no email will be sent).")
 	smtpServer     = flag.String("smtp-server", "localhost:587", "SMTP server address and port.")
 	sender         = flag.String("email-from", "no-reply@bubbles", "Sender e-mail to use.")
+	// OpenID Connect
+	oidcIssuer     = flag.String("oidc-issuer", "", "OpenID Connect issuer URL; enables signing in with the provider.")
+	oidcClientId   = flag.String("oidc-client-id", "", "OpenID Connect client id.")
+	oidcSecret     = flag.String("oidc-client-secret", "", "OpenID Connect client secret.")
+	oidcName       = flag.String("oidc-name", "", "Name of the OpenID Connect provider, shown on the login page.")
+	// Abuse protection
+	signUpPoW      = flag.Int("signup-pow", 0, "Require a proof of work of this many bits to sign up (e.g. 18); 0 to disable.")
+	// Administration
+	disable2FA     = flag.String("disable-2fa", "", "Disable the two-factor authentication of an user (by e-mail), and exit.")
 	// Development options
 	debug          = flag.Bool("debug", false, "Launch in debug mode.")
 )
@@ -41,6 +53,12 @@ var (
 func main() {
 	flag.Parse()
 
+	if *config != "" {
+		if err := loadConfig(*config); err != nil {
+			log.Fatal(err)
+		}
+	}
+
 	if *url == "" {
 		*url = fmt.Sprintf("http://%s", *bind)
 	}
@@ -51,6 +69,13 @@ func main() {
 	}
 	defer db.Close()
 
+	if *disable2FA != "" {
+		if err := disableTwoFactor(db, *disable2FA); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
 	sendmail := sendmail.Init(*smtpServer, *sender)
 
 	i18n, err := i18n.Init()
@@ -58,6 +83,77 @@ func main() {
 		log.Fatal(err)
 	}
 
-	log.Fatal(httpserver.Serve(*bind, *url, db, sendmail, i18n,
+	var oidc *httpserver.OIDCConfig
+	if *oidcIssuer != "" {
+		oidc = &httpserver.OIDCConfig{
+			Issuer:       *oidcIssuer,
+			ClientId:     *oidcClientId,
+			ClientSecret: *oidcSecret,
+			Name:         *oidcName,
+		}
+	}
+
+	var challenge httpserver.Challenge
+	if *signUpPoW > 0 {
+		if challenge, err = httpserver.NewProofOfWork(*signUpPoW); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	log.Fatal(httpserver.Serve(*bind, *url, db, sendmail, i18n, oidc, challenge,
 				   *noSignUp, *noVerification, *debug))
 }
+
+// Set the flags from a YAML configuration file, mapping flag names to their
+// value. Flags given on the command line take precedence.
+func loadConfig(path string) error {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	var values map[string]interface{}
+	if err := yaml.Unmarshal(content, &values); err != nil {
+		return fmt.Errorf("Couldn't parse %s: %v", path, err)
+	}
+
+	set := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) {
+		set[f.Name] = true
+	})
+
+	for name, value := range values {
+		if set[name] {
+			continue
+		}
+		if name == "config" || flag.Lookup(name) == nil {
+			return fmt.Errorf("%s: unknown option '%s'.", path, name)
+		}
+		if err := flag.Set(name, fmt.Sprint(value)); err != nil {
+			return fmt.Errorf("%s: invalid value for '%s': %v", path, name, err)
+		}
+	}
+	return nil
+}
+
+// Disable the two-factor authentication of an user locked out of its account,
+// and sign it out everywhere.
+func disableTwoFactor(db *db.DB, email string) error {
+	user, err := db.GetUserByEmail(email)
+	if err != nil {
+		return fmt.Errorf("Couldn't find user '%s': %v", email, err)
+	}
+
+	user.TOTPSecret = ""
+	user.TOTPStep = 0
+	user.RecoveryCodes = nil
+	if err := db.UpdateUser(user); err != nil {
+		return err
+	}
+	if err := db.DeleteUserSessions(user.Id, 0); err != nil {
+		return err
+	}
+
+	log.Printf("Two-factor authentication disabled for %s.", email)
+	return nil
+}
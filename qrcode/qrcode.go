@@ -0,0 +1,510 @@
+// Copyright (C) 2019 Antoine Tenart <antoine.tenart@ack.tf>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+
+// Package qrcode encodes short texts (such as otpauth:// URIs) into QR codes
+// (ISO/IEC 18004), in byte mode with a medium error correction level, and
+// renders them as SVG images. Only versions 1 to 10 are supported.
+package qrcode
+
+import (
+	"fmt"
+	"math"
+	"strings"
+)
+
+// Error correction blocks of a version, at the medium level.
+type blocks struct {
+	ec     int   // EC codewords per block.
+	data   []int // Data codewords of each block.
+}
+
+var versions = []blocks{
+	{ 10, []int{ 16 } },
+	{ 16, []int{ 28 } },
+	{ 26, []int{ 44 } },
+	{ 18, []int{ 32, 32 } },
+	{ 24, []int{ 43, 43 } },
+	{ 16, []int{ 27, 27, 27, 27 } },
+	{ 18, []int{ 31, 31, 31, 31 } },
+	{ 22, []int{ 38, 38, 39, 39 } },
+	{ 22, []int{ 36, 36, 36, 37, 37 } },
+	{ 26, []int{ 43, 43, 43, 43, 44 } },
+}
+
+// Centers of the alignment patterns, by version.
+var alignments = [][]int{
+	{},
+	{ 6, 18 },
+	{ 6, 22 },
+	{ 6, 26 },
+	{ 6, 30 },
+	{ 6, 34 },
+	{ 6, 22, 38 },
+	{ 6, 24, 42 },
+	{ 6, 26, 46 },
+	{ 6, 28, 50 },
+}
+
+// Number of remainder bits after the codewords, by version.
+var remainders = []int{ 0, 7, 7, 7, 7, 7, 0, 0, 0, 0 }
+
+// A QR code.
+type Code struct {
+	Size     int
+	modules  [][]bool
+	function [][]bool
+}
+
+// Encode a text into the smallest QR code which can hold it.
+func Encode(text string) (*Code, error) {
+	version := 0
+	for v, b := range versions {
+		if capacity(v + 1, b) >= len(text) {
+			version = v + 1
+			break
+		}
+	}
+	if version == 0 {
+		return nil, fmt.Errorf("Text too long to be encoded (%d bytes).", len(text))
+	}
+
+	c := &Code{ Size: 17 + 4 * version }
+	c.modules = make([][]bool, c.Size)
+	c.function = make([][]bool, c.Size)
+	for i := range c.modules {
+		c.modules[i] = make([]bool, c.Size)
+		c.function[i] = make([]bool, c.Size)
+	}
+
+	c.drawPatterns(version)
+	c.drawCodewords(codewords(version, text), remainders[version - 1])
+
+	// Keep the mask with the lowest penalty.
+	best, penalty := 0, math.MaxInt32
+	for mask := 0; mask < 8; mask++ {
+		c.applyMask(mask)
+		c.drawFormat(mask)
+		if p := c.penalty(); p < penalty {
+			best, penalty = mask, p
+		}
+		c.applyMask(mask)
+	}
+	c.applyMask(best)
+	c.drawFormat(best)
+
+	return c, nil
+}
+
+// Number of bytes a version can hold.
+func capacity(version int, b blocks) int {
+	data := 0
+	for _, d := range b.data {
+		data += d
+	}
+
+	// Minus the mode indicator and the character count.
+	header := 12
+	if version >= 10 {
+		header = 20
+	}
+	return (data * 8 - header) / 8
+}
+
+// Check a module is dark.
+func (c *Code) Dark(x, y int) bool {
+	return c.modules[y][x]
+}
+
+// Render a QR code as a SVG image, with a quiet zone around it. Each module is
+// a unit of the view box.
+func (c *Code) SVG(size int) string {
+	var path strings.Builder
+	for y := 0; y < c.Size; y++ {
+		for x := 0; x < c.Size; x++ {
+			if c.modules[y][x] {
+				fmt.Fprintf(&path, "M%d,%dh1v1h-1z", x + 4, y + 4)
+			}
+		}
+	}
+
+	return fmt.Sprintf(`<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d" shape-rendering="crispEdges"><rect width="100%%" height="100%%" fill="#fff"/><path d="%s" fill="#000"/></svg>`,
+			   size, size, c.Size + 8, c.Size + 8, path.String())
+}
+
+// Set a function module, which is not part of the data.
+func (c *Code) set(x, y int, dark bool) {
+	c.modules[y][x] = dark
+	c.function[y][x] = true
+}
+
+// Draw the finder, timing and alignment patterns, and reserve the format and
+// version areas.
+func (c *Code) drawPatterns(version int) {
+	for i := 0; i < c.Size; i++ {
+		c.set(6, i, i % 2 == 0)
+		c.set(i, 6, i % 2 == 0)
+	}
+
+	// Finder patterns and their separators.
+	for _, center := range [][2]int{ { 3, 3 }, { c.Size - 4, 3 }, { 3, c.Size - 4 } } {
+		for dy := -4; dy <= 4; dy++ {
+			for dx := -4; dx <= 4; dx++ {
+				x, y := center[0] + dx, center[1] + dy
+				if x < 0 || x >= c.Size || y < 0 || y >= c.Size {
+					continue
+				}
+				dist := max(abs(dx), abs(dy))
+				c.set(x, y, dist != 2 && dist != 4)
+			}
+		}
+	}
+
+	// Alignment patterns, but where they overlap the finder patterns.
+	pos := alignments[version - 1]
+	last := len(pos) - 1
+	for i := range pos {
+		for j := range pos {
+			if (i == 0 && j == 0) || (i == 0 && j == last) || (i == last && j == 0) {
+				continue
+			}
+			for dy := -2; dy <= 2; dy++ {
+				for dx := -2; dx <= 2; dx++ {
+					c.set(pos[i] + dx, pos[j] + dy, max(abs(dx), abs(dy)) != 1)
+				}
+			}
+		}
+	}
+
+	// Reserve the format areas, drawn once the mask is chosen.
+	c.drawFormat(0)
+
+	if version >= 7 {
+		rem := version
+		for i := 0; i < 12; i++ {
+			rem = (rem << 1) ^ ((rem >> 11) * 0x1f25)
+		}
+		bits := version << 12 | rem
+		for i := 0; i < 18; i++ {
+			dark := (bits >> uint(i)) & 1 != 0
+			a, b := c.Size - 11 + i % 3, i / 3
+			c.set(a, b, dark)
+			c.set(b, a, dark)
+		}
+	}
+}
+
+// Draw the format information: error correction level and mask.
+func (c *Code) drawFormat(mask int) {
+	// The medium level is 0b00.
+	data := mask
+	rem := data
+	for i := 0; i < 10; i++ {
+		rem = (rem << 1) ^ ((rem >> 9) * 0x537)
+	}
+	bits := (data << 10 | rem) ^ 0x5412
+	bit := func(i int) bool {
+		return (bits >> uint(i)) & 1 != 0
+	}
+
+	// Around the top left finder pattern.
+	for i := 0; i <= 5; i++ {
+		c.set(8, i, bit(i))
+	}
+	c.set(8, 7, bit(6))
+	c.set(8, 8, bit(7))
+	c.set(7, 8, bit(8))
+	for i := 9; i < 15; i++ {
+		c.set(14 - i, 8, bit(i))
+	}
+
+	// Along the other finder patterns.
+	for i := 0; i < 8; i++ {
+		c.set(c.Size - 1 - i, 8, bit(i))
+	}
+	for i := 8; i < 15; i++ {
+		c.set(8, c.Size - 15 + i, bit(i))
+	}
+	c.set(8, c.Size - 8, true)
+}
+
+// Build the codewords of a text: data codewords split in blocks, followed by
+// their error correction codewords, interleaved.
+func codewords(version int, text string) []byte {
+	b := versions[version - 1]
+	total := 0
+	for _, d := range b.data {
+		total += d
+	}
+
+	// Byte mode, character count, data and terminator.
+	var bits []bool
+	add := func(v, n int) {
+		for i := n - 1; i >= 0; i-- {
+			bits = append(bits, (v >> uint(i)) & 1 != 0)
+		}
+	}
+	add(0x4, 4)
+	if version >= 10 {
+		add(len(text), 16)
+	} else {
+		add(len(text), 8)
+	}
+	for i := 0; i < len(text); i++ {
+		add(int(text[i]), 8)
+	}
+	add(0, min(4, total * 8 - len(bits)))
+	add(0, (8 - len(bits) % 8) % 8)
+
+	data := make([]byte, 0, total)
+	for i := 0; i < len(bits); i += 8 {
+		var v byte
+		for j := 0; j < 8; j++ {
+			v <<= 1
+			if bits[i + j] {
+				v |= 1
+			}
+		}
+		data = append(data, v)
+	}
+	for pad := byte(0xec); len(data) < total; pad ^= 0xec ^ 0x11 {
+		data = append(data, pad)
+	}
+
+	// Split the data in blocks and compute their error correction.
+	divisor := rsDivisor(b.ec)
+	var dataBlocks, ecBlocks [][]byte
+	for _, d := range b.data {
+		dataBlocks = append(dataBlocks, data[:d])
+		ecBlocks = append(ecBlocks, rsRemainder(data[:d], divisor))
+		data = data[d:]
+	}
+
+	// Interleave the blocks.
+	var result []byte
+	for i := 0; i < b.data[len(b.data) - 1]; i++ {
+		for _, block := range dataBlocks {
+			if i < len(block) {
+				result = append(result, block[i])
+			}
+		}
+	}
+	for i := 0; i < b.ec; i++ {
+		for _, block := range ecBlocks {
+			result = append(result, block[i])
+		}
+	}
+
+	return result
+}
+
+// Draw the codewords in the data modules, in a zigzag from the bottom right
+// corner.
+func (c *Code) drawCodewords(data []byte, remainder int) {
+	i := 0
+	for right := c.Size - 1; right >= 1; right -= 2 {
+		// Skip the vertical timing pattern.
+		if right == 6 {
+			right = 5
+		}
+
+		for vert := 0; vert < c.Size; vert++ {
+			for j := 0; j < 2; j++ {
+				x := right - j
+				y := vert
+				if (right + 1) & 2 == 0 {
+					y = c.Size - 1 - vert
+				}
+
+				if c.function[y][x] || i >= len(data) * 8 {
+					continue
+				}
+				c.modules[y][x] = (data[i / 8] >> uint(7 - i % 8)) & 1 != 0
+				i++
+			}
+		}
+	}
+}
+
+// XOR the data modules with a mask pattern.
+func (c *Code) applyMask(mask int) {
+	for y := 0; y < c.Size; y++ {
+		for x := 0; x < c.Size; x++ {
+			var invert bool
+			switch mask {
+			case 0:
+				invert = (x + y) % 2 == 0
+			case 1:
+				invert = y % 2 == 0
+			case 2:
+				invert = x % 3 == 0
+			case 3:
+				invert = (x + y) % 3 == 0
+			case 4:
+				invert = (x / 3 + y / 2) % 2 == 0
+			case 5:
+				invert = x * y % 2 + x * y % 3 == 0
+			case 6:
+				invert = (x * y % 2 + x * y % 3) % 2 == 0
+			case 7:
+				invert = ((x + y) % 2 + x * y % 3) % 2 == 0
+			}
+			if !c.function[y][x] && invert {
+				c.modules[y][x] = !c.modules[y][x]
+			}
+		}
+	}
+}
+
+// Penalty of the current masking, the lower the easier to scan.
+func (c *Code) penalty() int {
+	penalty, dark := 0, 0
+	finder := []bool{ true, false, true, true, true, false, true }
+
+	for i := 0; i < c.Size; i++ {
+		// Runs of the same color, in rows and columns.
+		for _, horizontal := range []bool{ true, false } {
+			at := func(j int) bool {
+				if horizontal {
+					return c.modules[i][j]
+				}
+				return c.modules[j][i]
+			}
+
+			run := 1
+			for j := 1; j <= c.Size; j++ {
+				if j < c.Size && at(j) == at(j - 1) {
+					run++
+					continue
+				}
+				if run >= 5 {
+					penalty += run - 2
+				}
+				run = 1
+			}
+
+			// Patterns looking like finders, with 4 light modules on
+			// one side.
+			for j := 0; j + 7 <= c.Size; j++ {
+				match := true
+				for k, d := range finder {
+					if at(j + k) != d {
+						match = false
+						break
+					}
+				}
+				if !match {
+					continue
+				}
+
+				before, after := true, true
+				for k := 1; k <= 4; k++ {
+					if j - k >= 0 && at(j - k) {
+						before = false
+					}
+					if j + 6 + k < c.Size && at(j + 6 + k) {
+						after = false
+					}
+				}
+				if before || after {
+					penalty += 40
+				}
+			}
+		}
+
+		for j := 0; j < c.Size; j++ {
+			if c.modules[i][j] {
+				dark++
+			}
+
+			// Blocks of 2x2 modules of the same color.
+			if i > 0 && j > 0 {
+				m := c.modules[i][j]
+				if m == c.modules[i - 1][j] && m == c.modules[i][j - 1] &&
+				   m == c.modules[i - 1][j - 1] {
+					penalty += 3
+				}
+			}
+		}
+	}
+
+	// Balance of dark and light modules.
+	total := c.Size * c.Size
+	penalty += (abs(dark * 20 - total * 10) + total - 1) / total * 10 - 10
+	return penalty
+}
+
+// Compute the Reed-Solomon divisor polynomial of a given degree.
+func rsDivisor(degree int) []byte {
+	result := make([]byte, degree)
+	result[degree - 1] = 1
+
+	root := byte(1)
+	for i := 0; i < degree; i++ {
+		for j := range result {
+			result[j] = gfMul(result[j], root)
+			if j + 1 < degree {
+				result[j] ^= result[j + 1]
+			}
+		}
+		root = gfMul(root, 0x02)
+	}
+	return result
+}
+
+// Compute the Reed-Solomon error correction codewords of data.
+func rsRemainder(data, divisor []byte) []byte {
+	result := make([]byte, len(divisor))
+	for _, b := range data {
+		factor := b ^ result[0]
+		copy(result, result[1:])
+		result[len(result) - 1] = 0
+		for i := range result {
+			result[i] ^= gfMul(divisor[i], factor)
+		}
+	}
+	return result
+}
+
+// Multiply two elements of GF(2^8), modulo x^8 + x^4 + x^3 + x^2 + 1.
+func gfMul(x, y byte) byte {
+	z := 0
+	for i := 7; i >= 0; i-- {
+		z = (z << 1) ^ ((z >> 7) * 0x11d)
+		z ^= int((y >> uint(i)) & 1) * int(x)
+	}
+	return byte(z)
+}
+
+func abs(x int) int {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
+
+func max(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+func min(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
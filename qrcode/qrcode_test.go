@@ -0,0 +1,134 @@
+// Copyright (C) 2019 Antoine Tenart <antoine.tenart@ack.tf>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+
+package qrcode
+
+import (
+	"strings"
+	"testing"
+)
+
+// Known answers, checked against an independent encoder (using the same mask):
+// dark modules are '#'.
+var knownCodes = []struct {
+	text    string
+	modules []string
+}{
+	{ "otpauth://x", []string{
+		`#######....##.#######`,
+		`#.....#.#####.#.....#`,
+		`#.###.#....#..#.###.#`,
+		`#.###.#...#.#.#.###.#`,
+		`#.###.#.##..#.#.###.#`,
+		`#.....#...#.#.#.....#`,
+		`#######.#.#.#.#######`,
+		`..........###........`,
+		`#.#.#.#....#....#..#.`,
+		`..####.......##.#...#`,
+		`.#....###...#...#.###`,
+		`###..#....#..#.#....#`,
+		`#..#####.#..#....#.#.`,
+		`........##.#..####.##`,
+		`#######..###.#.##.###`,
+		`#.....#....###.#....#`,
+		`#.###.#.##.#...##....`,
+		`#.###.#...##..#.##.#.`,
+		`#.###.#.#...##.####.#`,
+		`#.....#..#.#.......#.`,
+		`#######.###..#.###.##`,
+	} },
+	// Version 7, with version information.
+	{ "otpauth://totp/Bubbles:someone.with.a.long.name@example.com?secret=JBSWY3DPEHPK3PXPJBSWY3DPEHPK3PXP&issuer=Bubbles", []string{
+		`#######.##.....###.###....#...###...#.#######`,
+		`#.....#..#..##..###.##.##.#....###.#..#.....#`,
+		`#.###.#...##.....#.####.####.#.###.#..#.###.#`,
+		`#.###.#.#.##.###....##.#....#......##.#.###.#`,
+		`#.###.#.##......###.#####.#..###.####.#.###.#`,
+		`#.....#.###..##.##.##...##..#..###....#.....#`,
+		`#######.#.#.#.#.#.#.#.#.#.#.#.#.#.#.#.#######`,
+		`........#.#...#.#####...####.......##........`,
+		`#...#.###..####..#..########.##.##########..#`,
+		`.#.#...#####.###..#....##.#.#####..##.##.##..`,
+		`##...######..###..##...#..####...#..###.#.##.`,
+		`..#.....#.#.#.#.###...#....#..#.#..#.##.#..##`,
+		`...##.###.##.##.###.##..#.##.##.###..#.#.....`,
+		`...#.......##.#....######.#.####.#.##.#...#.#`,
+		`##.#..#...#.#.#...#..#....####.###.#..###.##.`,
+		`....##..##.##..##.##...##.#.#.###.#.###......`,
+		`...#..#.######..###.....####.#..###...#..#.#.`,
+		`#..#...######..#.#.####...########..#.#...##.`,
+		`#.##.####..##.##.#..#....#.###.#..###...##.#.`,
+		`.........#.###...#.#..##.#....#######.##...#.`,
+		`#...######..#######.#####.##.##.###.#####.#.#`,
+		`#.#.#...######.##.#.#...#.##.##.##.##...###..`,
+		`#..##.#.##...###.#.##.#.###.####....#.#.##.#.`,
+		`.#..#...##..###....##...##.#.###...##...#...#`,
+		`.##.#####.#....##.#######.##..#.###.######...`,
+		`#..#.#...#####..###...##..#.######.#.##....#.`,
+		`###.###.#.##.#...#..#...#####..#...###.##..#.`,
+		`##.#...#.#.##.###.#.##...###.#..#..###.#....#`,
+		`...#..#######..##..###....##....#.#.#.####.##`,
+		`###.#..###.##.#..###..#...#.####.#.#####..###`,
+		`..#...#...######..##..##.####.####.#.#..####.`,
+		`#......#..#####....##...#....#.###..#.###..#.`,
+		`#..#..#..##...#####..#...##.....##..####....#`,
+		`###..#.######.#.#.#.##.#..#####....#..##...#.`,
+		`....#.#..#...##...#.####...#..###...#.....##.`,
+		`.####..#.##.#.#.###...#....#....###...####.##`,
+		`#..##.##..#....##...#####..#.##.###.######.##`,
+		`........##.#..####.##...###..##.##..#...#.##.`,
+		`#######.##.#.####..##.#.#..###.#...##.#.##...`,
+		`#.....#...#####.#..##...#..#.#....###...#..#.`,
+		`#.###.#.##......#..######..#..#.#..######...#`,
+		`#.###.#..#..###..#.......###.##..#...#.####..`,
+		`#.###.#..#..###..##...#####.##...#.#..#.##.#.`,
+		`#.....#....#.....#..#..##..#....#.##.#..#....`,
+		`#######.###....#..#.##.#####.######.####....#`,
+	} },
+}
+
+func TestEncode(t *testing.T) {
+	for _, known := range knownCodes {
+		c, err := Encode(known.text)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if c.Size != len(known.modules) {
+			t.Errorf("%q: size = %d, want %d", known.text, c.Size, len(known.modules))
+			continue
+		}
+
+		for y, row := range known.modules {
+			var got strings.Builder
+			for x := 0; x < c.Size; x++ {
+				if c.Dark(x, y) {
+					got.WriteByte('#')
+				} else {
+					got.WriteByte('.')
+				}
+			}
+			if got.String() != row {
+				t.Errorf("%q: row %d = %s, want %s", known.text, y, got.String(), row)
+			}
+		}
+	}
+}
+
+func TestEncodeTooLong(t *testing.T) {
+	if _, err := Encode(strings.Repeat("x", 300)); err == nil {
+		t.Error("encoded a text too long for version 10")
+	}
+}